@@ -0,0 +1,98 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"strconv"
+	"time"
+)
+
+var breakGlassTableName string
+
+const breakGlassKey = "TokenHash"
+
+const BreakGlassTokenInvalid venafiError = "break-glass token is invalid, expired or already used"
+
+// BreakGlassToken allows a single request to bypass designated rules of a zone. Only the token hash is stored.
+type BreakGlassToken struct {
+	TokenHash string
+	Zone      string
+	// Rules lists the rules the token bypasses.
+	Rules     []string
+	Reason    string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// TTL is ExpiresAt in Unix seconds, used by DynamoDB to delete expired tokens.
+	TTL       int64
+	UsedBy    string
+	UsedAt    time.Time
+	RequestID string
+}
+
+func init() {
+	breakGlassTableName = os.Getenv("DYNAMODB_BREAK_GLASS_TABLE")
+	if breakGlassTableName == "" {
+		breakGlassTableName = "VenafiBreakGlassTokens"
+	}
+}
+
+func SaveBreakGlassToken(t BreakGlassToken) error {
+	t.TTL = t.ExpiresAt.Unix()
+	av, err := dynamodbattribute.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(breakGlassTableName),
+		ConditionExpression: aws.String("attribute_not_exists(" + breakGlassKey + ")"),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+// UseBreakGlassToken marks an unused, unexpired token of the zone as used and returns it. The update is conditional, so a
+// token can't be used by two concurrent requests, and a request for another zone doesn't use up the token.
+func UseBreakGlassToken(tokenHash, zone, usedBy, requestID string, now time.Time) (t BreakGlassToken, err error) {
+	usedAt, err := dynamodbattribute.Marshal(now.UTC())
+	if err != nil {
+		return
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(breakGlassTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			breakGlassKey: {
+				S: aws.String(tokenHash),
+			},
+		},
+		UpdateExpression:    aws.String("SET UsedBy = :by, UsedAt = :at, RequestID = :req"),
+		ConditionExpression: aws.String("attribute_exists(" + breakGlassKey + ") AND attribute_not_exists(UsedBy) AND #ttl > :now AND #zone = :zone"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl":  "TTL",
+			"#zone": "Zone",
+		},
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":by":   {S: aws.String(usedBy)},
+			":at":   *usedAt,
+			":req":  {S: aws.String(requestID)},
+			":now":  {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			":zone": {S: aws.String(zone)},
+		},
+		ReturnValues: dynamodb.ReturnValueAllNew,
+	}
+	result, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		err = BreakGlassTokenInvalid
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &t)
+	return
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"os"
+	"strconv"
+)
+
+var budgetTableName string
+
+const budgetKey = "AccountMonth"
+
+const BudgetExceeded venafiError = "monthly issuance budget exceeded"
+
+func init() {
+	budgetTableName = os.Getenv("DYNAMODB_BUDGET_TABLE")
+	if budgetTableName == "" {
+		budgetTableName = "VenafiIssuanceBudget"
+	}
+}
+
+func budgetItemKey(account, month string) map[string]dynamodb.AttributeValue {
+	return map[string]dynamodb.AttributeValue{
+		budgetKey: {
+			S: aws.String(account + "#" + month),
+		},
+	}
+}
+
+// IncrementIssuanceCount counts a certificate issued for the account in the month (e.g. 2026-10) and returns the new
+// count. With a positive limit the count is not incremented past it and BudgetExceeded is returned instead.
+func IncrementIssuanceCount(account, month string, limit int64) (count int64, err error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(budgetTableName),
+		Key:              budgetItemKey(account, month),
+		UpdateExpression: aws.String("ADD IssuedCount :one"),
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+		},
+		ReturnValues: dynamodb.ReturnValueUpdatedNew,
+	}
+	if limit > 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(IssuedCount) OR IssuedCount < :limit")
+		input.ExpressionAttributeValues[":limit"] = dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(limit, 10))}
+	}
+	result, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		err = BudgetExceeded
+		return
+	}
+	if err != nil {
+		return
+	}
+	count, err = strconv.ParseInt(aws.StringValue(result.Attributes["IssuedCount"].N), 10, 64)
+	return
+}
+
+// DecrementIssuanceCount takes back a count of a certificate which was not issued after all.
+func DecrementIssuanceCount(account, month string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(budgetTableName),
+		Key:              budgetItemKey(account, month),
+		UpdateExpression: aws.String("ADD IssuedCount :minus"),
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":minus": {N: aws.String("-1")},
+		},
+	}
+	_, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	return err
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"sync"
+	"time"
+)
+
+const assumeRoleSessionName = "VenafiLambda"
+
+// Role chaining from the Lambda role limits assumed role sessions to one hour.
+const assumeRoleDuration = time.Hour
+
+// assumeRoleExpiryWindow makes credentials refresh a bit before they expire, so in-flight requests don't fail.
+const assumeRoleExpiryWindow = 5 * time.Minute
+
+var assumedRoles = struct {
+	sync.Mutex
+	providers map[string]*stscreds.AssumeRoleProvider
+}{providers: make(map[string]*stscreds.AssumeRoleProvider)}
+
+// AssumeRoleCredentials returns credentials provider of the role. Providers are cached per role for the life of
+// the Lambda container, so sts:AssumeRole is only called when the cached credentials are about to expire.
+func AssumeRoleCredentials(cfg aws.Config, roleArn string) *stscreds.AssumeRoleProvider {
+	assumedRoles.Lock()
+	defer assumedRoles.Unlock()
+	if p, ok := assumedRoles.providers[roleArn]; ok {
+		return p
+	}
+	p := stscreds.NewAssumeRoleProvider(sts.New(cfg), roleArn)
+	p.RoleSessionName = assumeRoleSessionName
+	p.Duration = assumeRoleDuration
+	p.ExpiryWindow = assumeRoleExpiryWindow
+	assumedRoles.providers[roleArn] = p
+	return p
+}
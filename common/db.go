@@ -2,12 +2,21 @@ package common
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var tableName string
@@ -22,6 +31,11 @@ func (e venafiError) Error() string {
 
 const PolicyNotFound venafiError = "policy not found"
 const PolicyFoundButEmpty venafiError = "policy found but empty"
+const CertificateNotFound venafiError = "certificate not found in inventory"
+
+// PolicyWriteConflict is returned when the policy of a zone was written by someone else since it was read, e.g. by a
+// scheduled and an on-demand sync running at the same time.
+const PolicyWriteConflict venafiError = "policy was changed by a concurrent write"
 
 func init() {
 	tableName = os.Getenv("DYNAMODB_ZONES_TABLE")
@@ -32,13 +46,109 @@ func init() {
 	if err != nil {
 		panic("unable to load SDK config, " + err.Error())
 	}
+	localDB = dynamodb.New(cfg)
+	// In hub-and-spoke deployments the tables live in the central account and are read through a role from there.
+	tableRoleArn = os.Getenv("POLICY_TABLE_ROLE_ARN")
+	if tableRoleArn != "" {
+		cfg.Credentials = AssumeRoleCredentials(cfg, tableRoleArn)
+	}
+	if region := os.Getenv("POLICY_TABLE_REGION"); region != "" {
+		cfg.Region = region
+	}
 	db = dynamodb.New(cfg)
+	policyTableConsistentRead = os.Getenv("POLICY_TABLE_CONSISTENT_READ") == "true"
+	if v := os.Getenv("POLICY_TABLE_REPLICATION_WAIT"); v != "" {
+		wait, err := time.ParseDuration(v)
+		if err != nil || wait < 0 {
+			log.Printf("Invalid POLICY_TABLE_REPLICATION_WAIT %q, missing policies are not retried", v)
+		} else {
+			policyReplicationWait = wait
+		}
+	}
+	policyBucket = os.Getenv("POLICY_BUCKET")
+	policyPrefix = os.Getenv("POLICY_BUCKET_PREFIX")
+	if policyPrefix == "" {
+		policyPrefix = "policies/"
+	}
+	if policyBucket != "" {
+		policyS3 = s3.New(cfg)
+	}
+	if os.Getenv("POLICY_BACKEND") == "file" {
+		policyDir = os.Getenv("POLICY_DIR")
+		if policyDir == "" {
+			policyDir = "policies"
+		}
+	}
+}
+
+var tableRoleArn string
+
+// IsRemotePolicyTable reports whether policies are read from another account. Such tables are treated as read-only.
+func IsRemotePolicyTable() bool {
+	return tableRoleArn != ""
 }
 
+// db reads the policy, zone config, policy history and zone mapping tables, through the role of the central account
+// in hub-and-spoke deployments.
 var db *dynamodb.Client
 
+// localDB uses the credentials and region of the Lambda for the tables of its own account, e.g. the inventory, which
+// are never in the central account.
+var localDB *dynamodb.Client
+
+// policyTableConsistentRead reads policies with strongly consistent reads, so a sync is seen by the next request.
+var policyTableConsistentRead bool
+
+// policyReplicationWait is how long a missing or empty policy is read again, so zones added to a global table in
+// another region are found once they are replicated instead of being rejected. Zero doesn't retry.
+var policyReplicationWait time.Duration
+
+const policyReplicationBackoff = 100 * time.Millisecond
+
 func GetPolicy(name string) (p endpoint.Policy, err error) {
+	if UsesPolicyFiles() {
+		f, err := getPolicyFile(name)
+		if err != nil {
+			return p, err
+		}
+		return f.policy()
+	}
+	if UsesPolicyBucket() {
+		o, err := getPolicyObject(name)
+		if err != nil {
+			return p, err
+		}
+		return o.policy()
+	}
+	return readPolicyWithRetries(func(consistent bool) (endpoint.Policy, error) {
+		return getPolicyItem(name, consistent)
+	}, policyTableConsistentRead, policyReplicationWait, time.Sleep)
+}
+
+// readPolicyWithRetries reads a policy with the consistency. A failed strongly consistent read, e.g. while the region
+// of the table is impaired, is retried as an eventually consistent one. Missing and empty policies are polled with
+// eventually consistent reads until the replication wait elapses.
+func readPolicyWithRetries(read func(consistent bool) (endpoint.Policy, error), consistent bool, wait time.Duration, sleep func(time.Duration)) (p endpoint.Policy, err error) {
+	backoff := policyReplicationBackoff
+	var waited time.Duration
+	for {
+		p, err = read(consistent)
+		if err != nil && err != PolicyNotFound && err != PolicyFoundButEmpty && consistent {
+			log.Printf("Strongly consistent policy read failed, reading eventually consistent: %s", err)
+			consistent = false
+			p, err = read(consistent)
+		}
+		if (err != PolicyNotFound && err != PolicyFoundButEmpty) || waited+backoff > wait {
+			return p, err
+		}
+		sleep(backoff)
+		waited += backoff
+		consistent = false
+		backoff *= 2
+	}
+}
 
+func getPolicyItem(name string, consistent bool) (p endpoint.Policy, err error) {
 	input := &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]dynamodb.AttributeValue{
@@ -46,6 +156,7 @@ func GetPolicy(name string) (p endpoint.Policy, err error) {
 				S: aws.String(name),
 			},
 		},
+		ConsistentRead: aws.Bool(consistent),
 	}
 
 	result, err := db.GetItemRequest(input).Send(context.Background())
@@ -68,33 +179,286 @@ func GetPolicy(name string) (p endpoint.Policy, err error) {
 	return
 }
 
+// CreateEmptyPolicy adds a zone whose policy is retrieved by the next sync. A zone which exists already is kept.
 func CreateEmptyPolicy(name string) error {
+	defer forgetSharedCachedPolicy(name)
+	if UsesPolicyFiles() {
+		return createPolicyFile(name)
+	}
+	if UsesPolicyBucket() {
+		return putPolicyObject(policyObject{PolicyID: name})
+	}
 	av := make(map[string]dynamodb.AttributeValue)
 	av[primaryKey] = dynamodb.AttributeValue{S: aws.String(name)}
 	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(" + primaryKey + ")"),
 	}
 	_, err := db.PutItemRequest(input).Send(context.Background())
+	if isConditionalCheckFailed(err) {
+		return nil
+	}
 	return err
 }
 
-func SavePolicy(name string, p endpoint.Policy) error {
-	av, err := dynamodbattribute.MarshalMap(p)
+// SavePolicy saves the policy of a zone synced from Venafi. A changed policy is added to the history of the zone as a
+// new revision and activated, unless the zone is pinned to a revision, in which case PolicyPinned is returned.
+// itemVersion is the version of the zone item read with PolicyItemVersion before the policy was read from Venafi. If
+// the item was written since, e.g. by a rollback, PolicyWriteConflict is returned and the newer write is kept.
+func SavePolicy(name string, p endpoint.Policy, itemVersion int64) error {
+	version, err := PolicyVersion(p)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	state, err := getPolicyState(name)
+	if err != nil {
+		return err
+	}
+	if state.itemVersion != itemVersion {
+		return PolicyWriteConflict
+	}
+	latest, err := latestPolicyRevision(name)
+	if err != nil && err != PolicyRevisionNotFound {
+		return err
+	}
+	changed := err == PolicyRevisionNotFound || latest.PolicyVersion != version
+	if changed {
+		latest = PolicyRevision{PolicyID: name, Revision: latest.Revision + 1, PolicyVersion: version, SyncedAt: now, Policy: p}
+	}
+	if state.pinned {
+		// Syncs of pinned zones only add the policy to the history.
+		if changed {
+			err = savePolicyRevision(latest)
+			if err != nil {
+				return err
+			}
+		}
+		return PolicyPinned
+	}
+	// The revision is recorded once the conditional write succeeded, so rejected writes leave no revision behind.
+	err = putPolicy(name, latest, false, now, itemVersion)
+	if err != nil || !changed {
+		return err
+	}
+	return savePolicyRevision(latest)
+}
+
+// PolicyItemVersion returns the version of the item of the zone policy, which SavePolicy writes are conditional on.
+func PolicyItemVersion(name string) (int64, error) {
+	state, err := getPolicyState(name)
+	return state.itemVersion, err
+}
+
+// putPolicy makes the revision the active policy of the zone, if the item of the zone still has the version it was read
+// with, and returns PolicyWriteConflict otherwise. The shared cache is updated right away, so containers don't serve
+// the previous policy until it expires.
+func putPolicy(name string, r PolicyRevision, pinned bool, lastSync time.Time, itemVersion int64) (err error) {
+	defer func() {
+		if err == nil {
+			SetSharedCachedPolicy(name, r.Policy)
+		}
+	}()
+	// The S3 API of this SDK has no conditional writes, the last write of a policy object wins. Files aren't shared.
+	if UsesPolicyFiles() || UsesPolicyBucket() {
+		policy := r.Policy
+		o := policyObject{
+			PolicyID:       name,
+			Policy:         &policy,
+			PolicyVersion:  r.PolicyVersion,
+			PolicyRevision: r.Revision,
+			LastSync:       lastSync.UTC().Truncate(time.Second),
+			Pinned:         pinned,
+		}
+		if UsesPolicyFiles() {
+			return putPolicyFileObject(o)
+		}
+		return putPolicyObject(o)
+	}
+	av, err := dynamodbattribute.MarshalMap(r.Policy)
 	if err != nil {
 		return err
 	}
 	av[primaryKey] = dynamodb.AttributeValue{S: aws.String(name)}
+	av[policyVersionAttribute] = dynamodb.AttributeValue{S: aws.String(r.PolicyVersion)}
+	av[policyRevisionAttribute] = dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(r.Revision, 10))}
+	av[lastSyncAttribute] = dynamodb.AttributeValue{S: aws.String(lastSync.UTC().Format(time.RFC3339))}
+	if pinned {
+		av[pinnedAttribute] = dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	av[itemVersionAttribute] = dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(itemVersion+1, 10))}
 	input := &dynamodb.PutItemInput{
 		Item:      av,
 		TableName: aws.String(tableName),
 	}
+	// Items written before versioning and empty zones have no version yet.
+	if itemVersion == 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(" + itemVersionAttribute + ")")
+	} else {
+		input.ConditionExpression = aws.String(itemVersionAttribute + " = :v")
+		input.ExpressionAttributeValues = map[string]dynamodb.AttributeValue{
+			":v": {N: aws.String(strconv.FormatInt(itemVersion, 10))},
+		}
+	}
 
 	_, err = db.PutItemRequest(input).Send(context.Background())
+	if isConditionalCheckFailed(err) {
+		return PolicyWriteConflict
+	}
 	return err
 }
 
+// policyState is the state of the item of a zone policy conditional writes depend on.
+type policyState struct {
+	pinned      bool
+	itemVersion int64
+}
+
+func getPolicyState(name string) (s policyState, err error) {
+	if UsesPolicyFiles() {
+		f, err := getPolicyFile(name)
+		if err == PolicyNotFound {
+			return s, nil
+		}
+		return policyState{pinned: f.Pinned}, err
+	}
+	if UsesPolicyBucket() {
+		o, err := getPolicyObject(name)
+		if err == PolicyNotFound {
+			return s, nil
+		}
+		return policyState{pinned: o.Pinned}, err
+	}
+	result, err := db.GetItemRequest(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]dynamodb.AttributeValue{
+			primaryKey: {S: aws.String(name)},
+		},
+		ProjectionExpression: aws.String(pinnedAttribute + ", " + itemVersionAttribute),
+		ConsistentRead:       aws.Bool(true),
+	}).Send(context.Background())
+	if err != nil {
+		return s, err
+	}
+	if v, ok := result.Item[pinnedAttribute]; ok {
+		s.pinned = aws.BoolValue(v.BOOL)
+	}
+	if v, ok := result.Item[itemVersionAttribute]; ok {
+		s.itemVersion, err = strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+	}
+	return s, err
+}
+
+func isConditionalCheckFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// Sync metadata stored with a policy
+const (
+	policyVersionAttribute  = "PolicyVersion"
+	policyRevisionAttribute = "PolicyRevision"
+	lastSyncAttribute       = "LastSync"
+	// pinnedAttribute is set on zones rolled back to a previous revision.
+	pinnedAttribute = "Pinned"
+	// itemVersionAttribute is incremented by every policy write, which is conditional on the version it read.
+	itemVersionAttribute = "ItemVersion"
+)
+
+// ZoneStatus describes the sync state of a zone policy.
+type ZoneStatus struct {
+	Name string
+	// PolicyVersion identifies the policy content, it changes only when the policy in Venafi changes.
+	PolicyVersion string
+	// PolicyRevision is the number of the active revision in the policy history of the zone.
+	PolicyRevision int64
+	LastSync       time.Time
+	// Synced is false for zones created from requests whose policy was not retrieved from Venafi yet.
+	Synced bool
+	// Pinned is true for zones rolled back to a previous revision, syncs don't change their policy.
+	Pinned bool
+}
+
+// PolicyVersion returns a short hash of the policy content.
+func PolicyVersion(p endpoint.Policy) (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:12], nil
+}
+
+// ListZones returns sync status of all zones in the policy table.
+func ListZones() ([]ZoneStatus, error) {
+	var zones []ZoneStatus
+	if UsesPolicyFiles() {
+		names, err := listPolicyFileNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			f, err := getPolicyFile(name)
+			if err == PolicyNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			zones = append(zones, f.zoneStatus())
+		}
+		return zones, nil
+	}
+	if UsesPolicyBucket() {
+		names, err := listPolicyObjectNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			o, err := getPolicyObject(name)
+			if err == PolicyNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			zones = append(zones, o.zoneStatus())
+		}
+		return zones, nil
+	}
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String(strings.Join([]string{primaryKey, policyVersionAttribute, policyRevisionAttribute, lastSyncAttribute, pinnedAttribute}, ", ")),
+	}
+	p := dynamodb.NewScanPaginator(db.ScanRequest(input))
+	for p.Next(context.Background()) {
+		for _, item := range p.CurrentPage().Items {
+			z := ZoneStatus{Name: aws.StringValue(item[primaryKey].S)}
+			if v, ok := item[policyVersionAttribute]; ok {
+				z.PolicyVersion = aws.StringValue(v.S)
+			}
+			if v, ok := item[policyRevisionAttribute]; ok {
+				z.PolicyRevision, _ = strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+			}
+			if v, ok := item[pinnedAttribute]; ok {
+				z.Pinned = aws.BoolValue(v.BOOL)
+			}
+			if v, ok := item[lastSyncAttribute]; ok {
+				z.LastSync, _ = time.Parse(time.RFC3339, aws.StringValue(v.S))
+			}
+			z.Synced = z.PolicyVersion != ""
+			zones = append(zones, z)
+		}
+	}
+	return zones, p.Err()
+}
+
 func GetAllPoliciesNames() (names []string, err error) {
+	if UsesPolicyFiles() {
+		return listPolicyFileNames()
+	}
+	if UsesPolicyBucket() {
+		return listPolicyObjectNames()
+	}
 	var t = db
 	result, err := t.ScanRequest(&dynamodb.ScanInput{TableName: &tableName}).Send(context.Background())
 	if err != nil {
@@ -109,6 +473,13 @@ func GetAllPoliciesNames() (names []string, err error) {
 }
 
 func DeletePolicy(name string) error {
+	defer forgetSharedCachedPolicy(name)
+	if UsesPolicyFiles() {
+		return deletePolicyFile(name)
+	}
+	if UsesPolicyBucket() {
+		return deletePolicyObject(name)
+	}
 	input := &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]dynamodb.AttributeValue{
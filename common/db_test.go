@@ -1,6 +1,7 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
@@ -26,11 +27,19 @@ var testPolicy = endpoint.Policy{
 	true,
 }
 
+// skipIntegration skips tests which need a live DynamoDB table in short mode, so `go test -short` runs unit tests only.
+func skipIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("needs DynamoDB")
+	}
+}
+
 func randSeq() string {
 	rand.Seed(time.Now().UnixNano())
 	return fmt.Sprintf("%d", rand.Int63())
 }
 func TestGetAllPoliciesNames(t *testing.T) {
+	skipIntegration(t)
 	names, err := GetAllPoliciesNames()
 	if err != nil {
 		t.Fatal(err)
@@ -42,7 +51,7 @@ func TestGetAllPoliciesNames(t *testing.T) {
 		t.Fatal("policy already exists")
 	}
 
-	err = SavePolicy(policyName, testPolicy)
+	err = SavePolicy(policyName, testPolicy, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -56,8 +65,9 @@ func TestGetAllPoliciesNames(t *testing.T) {
 }
 
 func TestDeletePolicy(t *testing.T) {
+	skipIntegration(t)
 	policyName := fmt.Sprintf("policy%stest", randSeq())
-	err := SavePolicy(policyName, testPolicy)
+	err := SavePolicy(policyName, testPolicy, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -88,6 +98,7 @@ func strInSlice(s string, sl []string) bool {
 }
 
 func TestGetEmptyPolicy(t *testing.T) {
+	skipIntegration(t)
 	name := fmt.Sprintf("not_existed_%s", randSeq())
 	err := CreateEmptyPolicy(name)
 	if err != nil {
@@ -98,3 +109,118 @@ func TestGetEmptyPolicy(t *testing.T) {
 		t.Fatal("policy should be empty")
 	}
 }
+
+func TestSavePolicyConflict(t *testing.T) {
+	skipIntegration(t)
+	policyName := fmt.Sprintf("policy%stest", randSeq())
+	err := SavePolicy(policyName, testPolicy, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeletePolicy(policyName)
+	stale, err := getPolicyState(policyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := latestPolicyRevision(policyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = putPolicy(policyName, r, false, time.Now(), stale.itemVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = putPolicy(policyName, r, false, time.Now(), stale.itemVersion)
+	if err != PolicyWriteConflict {
+		t.Fatalf("write with a stale version should conflict, got %v", err)
+	}
+	changed := testPolicy
+	changed.AllowWildcards = false
+	err = SavePolicy(policyName, changed, stale.itemVersion)
+	if err != PolicyWriteConflict {
+		t.Fatalf("sync with a stale version should conflict, got %v", err)
+	}
+	if latest, err := latestPolicyRevision(policyName); err != nil || latest.Revision != r.Revision {
+		t.Fatalf("conflicting sync should not add a revision, got %+v %v", latest, err)
+	}
+	err = CreateEmptyPolicy(policyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetPolicy(policyName); err != nil {
+		t.Fatal("creating an empty policy should keep the existing one")
+	}
+}
+
+func TestReadPolicyWithRetries(t *testing.T) {
+	var reads []bool
+	var slept time.Duration
+	sleep := func(d time.Duration) { slept += d }
+	replicatedAfter := func(n int) func(bool) (endpoint.Policy, error) {
+		return func(consistent bool) (endpoint.Policy, error) {
+			reads = append(reads, consistent)
+			if len(reads) <= n {
+				return endpoint.Policy{}, PolicyNotFound
+			}
+			return endpoint.Policy{AllowWildcards: true}, nil
+		}
+	}
+
+	p, err := readPolicyWithRetries(replicatedAfter(2), true, time.Second, sleep)
+	if err != nil || !p.AllowWildcards {
+		t.Fatalf("replicated policy should be read, got %v", err)
+	}
+	if len(reads) != 3 || !reads[0] || reads[1] || reads[2] || slept != 300*time.Millisecond {
+		t.Errorf("unexpected reads %v after %s", reads, slept)
+	}
+
+	reads, slept = nil, 0
+	_, err = readPolicyWithRetries(replicatedAfter(10), false, time.Second, sleep)
+	if err != PolicyNotFound || slept > time.Second {
+		t.Errorf("missing policy should be retried for the wait only, got %v after %s", err, slept)
+	}
+
+	reads, slept = nil, 0
+	_, err = readPolicyWithRetries(replicatedAfter(1), false, 0, sleep)
+	if err != PolicyNotFound || len(reads) != 1 {
+		t.Errorf("missing policy should not be retried without wait, got %v after %d reads", err, len(reads))
+	}
+
+	reads = nil
+	p, err = readPolicyWithRetries(func(consistent bool) (endpoint.Policy, error) {
+		reads = append(reads, consistent)
+		if consistent {
+			return endpoint.Policy{}, errors.New("InternalServerError")
+		}
+		return endpoint.Policy{AllowWildcards: true}, nil
+	}, true, 0, sleep)
+	if err != nil || !p.AllowWildcards || len(reads) != 2 {
+		t.Errorf("failed consistent read should be retried eventually consistent, got %v after %v", err, reads)
+	}
+}
+
+func TestImportZone(t *testing.T) {
+	skipIntegration(t)
+	name := fmt.Sprintf("policy%stest", randSeq())
+	defer DeletePolicy(name)
+	version, err := PolicyVersion(testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := testPolicy
+	err = ImportZone(ZoneExport{Zone: name, Policy: &policy, PolicyVersion: version, PolicyRevision: 3, Pinned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetPolicyRevision(name, 3)
+	if err != nil || r.PolicyVersion != version {
+		t.Fatalf("imported revision should be added to the history, got %v", err)
+	}
+	state, err := getPolicyState(name)
+	if err != nil || !state.pinned {
+		t.Errorf("imported zone should be pinned, got %+v %v", state, err)
+	}
+	if _, err = UnpinPolicy(name); err != nil {
+		t.Errorf("imported zone should be unpinned, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"time"
+)
+
+var dedupTableName string
+
+const dedupKey = "RequestHash"
+
+const IssuedRequestNotFound venafiError = "issued request not found"
+
+// IssuedRequest remembers the certificate issued for a request, so identical resubmissions return it instead of
+// issuing a duplicate.
+type IssuedRequest struct {
+	RequestHash             string
+	CertificateArn          string
+	CertificateAuthorityArn string
+	CreatedAt               time.Time
+	// TTL is the end of the deduplication window in Unix seconds, used by DynamoDB to delete old records.
+	TTL int64
+}
+
+func init() {
+	dedupTableName = os.Getenv("DYNAMODB_DEDUP_TABLE")
+	if dedupTableName == "" {
+		dedupTableName = "VenafiRequestDedup"
+	}
+}
+
+func SaveIssuedRequest(r IssuedRequest) error {
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(dedupTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func GetIssuedRequest(requestHash string) (r IssuedRequest, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(dedupTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			dedupKey: {
+				S: aws.String(requestHash),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	result, err := localDB.GetItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	if result.Item == nil {
+		err = IssuedRequestNotFound
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &r)
+	return
+}
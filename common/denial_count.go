@@ -0,0 +1,49 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"os"
+	"strconv"
+	"time"
+)
+
+var denialCountTableName string
+
+const denialCountKey = "DenialKey"
+
+func init() {
+	denialCountTableName = os.Getenv("DYNAMODB_DENIAL_COUNT_TABLE")
+	if denialCountTableName == "" {
+		denialCountTableName = "VenafiDenialCounts"
+	}
+}
+
+// IncrementDenialCount counts a denied request under the key (e.g. requester, zone and hour) and returns the new
+// count. The counter is deleted by DynamoDB after expiresAt.
+func IncrementDenialCount(key string, expiresAt time.Time) (count int64, err error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(denialCountTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			denialCountKey: {
+				S: aws.String(key),
+			},
+		},
+		UpdateExpression: aws.String("ADD DenialCount :one SET #ttl = :ttl"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl": "TTL",
+		},
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":ttl": {N: aws.String(strconv.FormatInt(expiresAt.Unix(), 10))},
+		},
+		ReturnValues: dynamodb.ReturnValueUpdatedNew,
+	}
+	result, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	count, err = strconv.ParseInt(aws.StringValue(result.Attributes["DenialCount"].N), 10, 64)
+	return
+}
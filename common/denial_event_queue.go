@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"time"
+)
+
+var denialEventQueueTableName string
+
+const denialEventQueueKey = "RequestID"
+
+// DenialEvent is a certificate request rejected by policy. The request Lambda queues denials and the policy Lambda,
+// which holds the Venafi connection, writes them to the TPP log of the zone.
+type DenialEvent struct {
+	RequestID     string
+	Target        string
+	Zone          string
+	CallerArn     string
+	SourceAccount string
+	StatusCode    int
+	Message       string
+	CreatedAt     time.Time
+	// TTL gives up on events which couldn't be logged in time, in Unix seconds.
+	TTL int64
+}
+
+func init() {
+	denialEventQueueTableName = os.Getenv("DYNAMODB_DENIAL_EVENT_QUEUE_TABLE")
+	if denialEventQueueTableName == "" {
+		denialEventQueueTableName = "VenafiDenialEventQueue"
+	}
+}
+
+func SaveDenialEvent(e DenialEvent) error {
+	av, err := dynamodbattribute.MarshalMap(e)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(denialEventQueueTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func ListDenialEvents() ([]DenialEvent, error) {
+	var events []DenialEvent
+	p := dynamodb.NewScanPaginator(localDB.ScanRequest(&dynamodb.ScanInput{TableName: aws.String(denialEventQueueTableName)}))
+	for p.Next(context.Background()) {
+		for _, item := range p.CurrentPage().Items {
+			var e DenialEvent
+			err := dynamodbattribute.UnmarshalMap(item, &e)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, e)
+		}
+	}
+	return events, p.Err()
+}
+
+func DeleteDenialEvent(requestID string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(denialEventQueueTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			denialEventQueueKey: {
+				S: aws.String(requestID),
+			},
+		},
+	}
+	_, err := localDB.DeleteItemRequest(input).Send(context.Background())
+	return err
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"time"
+)
+
+var dnsValidationTableName string
+
+const dnsValidationKey = "CertificateArn"
+
+// DNSValidation is an ACM certificate requested through the proxy whose DNS validation records are managed in
+// Route 53. Records are created while the certificate is pending validation and deleted when it's issued or fails.
+type DNSValidation struct {
+	CertificateArn string
+	// Region and RoleArn are used to describe the certificate, as when it was requested.
+	Region  string
+	RoleArn string `dynamodbav:",omitempty"`
+	// Records are the validation records created so far.
+	Records   []DNSValidationRecord `dynamodbav:",omitempty"`
+	CreatedAt time.Time
+	// TTL is the expiration of the record in Unix seconds, used by DynamoDB to delete old records.
+	TTL int64
+}
+
+type DNSValidationRecord struct {
+	Name         string
+	Value        string
+	Account      string
+	HostedZoneID string
+}
+
+func init() {
+	dnsValidationTableName = os.Getenv("DYNAMODB_DNS_VALIDATION_TABLE")
+	if dnsValidationTableName == "" {
+		dnsValidationTableName = "VenafiDNSValidations"
+	}
+}
+
+func SaveDNSValidation(v DNSValidation) error {
+	av, err := dynamodbattribute.MarshalMap(v)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(dnsValidationTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func ListDNSValidations() ([]DNSValidation, error) {
+	var validations []DNSValidation
+	p := dynamodb.NewScanPaginator(localDB.ScanRequest(&dynamodb.ScanInput{TableName: aws.String(dnsValidationTableName)}))
+	for p.Next(context.Background()) {
+		for _, item := range p.CurrentPage().Items {
+			var v DNSValidation
+			err := dynamodbattribute.UnmarshalMap(item, &v)
+			if err != nil {
+				return nil, err
+			}
+			validations = append(validations, v)
+		}
+	}
+	return validations, p.Err()
+}
+
+func DeleteDNSValidation(certificateArn string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(dnsValidationTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			dnsValidationKey: {
+				S: aws.String(certificateArn),
+			},
+		},
+	}
+	_, err := localDB.DeleteItemRequest(input).Send(context.Background())
+	return err
+}
@@ -0,0 +1,141 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// policyDir is the local directory zone policies are read from instead of the policy table, when set. It's meant for
+// development: sam local invoke and unit tests run without a policy table or a Venafi connection.
+var policyDir string
+
+// policyFile is the content of the file of a zone. Unlike with the policy table the zone config is kept in the same
+// file, so a zone is described by a single file.
+type policyFile struct {
+	policyObject
+	ZoneConfig *ZoneConfig `json:",omitempty"`
+}
+
+// UsesPolicyFiles reports whether zone policies are read from a local directory (POLICY_BACKEND=file).
+func UsesPolicyFiles() bool {
+	return policyDir != ""
+}
+
+// SetPolicyDir makes zone policies and configs read from and written to files in the directory, e.g. fixtures in unit
+// tests. An empty directory switches back to the configured backend.
+func SetPolicyDir(dir string) {
+	policyDir = dir
+}
+
+// policyFilePath escapes the zone name like policy object keys, e.g. Amazon%5CPCA+Policy.json.
+func policyFilePath(name string) string {
+	return filepath.Join(policyDir, url.QueryEscape(name)+".json")
+}
+
+func getPolicyFile(name string) (f policyFile, err error) {
+	b, err := ioutil.ReadFile(policyFilePath(name))
+	if os.IsNotExist(err) {
+		return f, PolicyNotFound
+	} else if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(b, &f)
+	// Files written by hand may leave out the zone.
+	f.PolicyID = name
+	return f, err
+}
+
+// putPolicyFile replaces the file of the zone. The file is renamed into place, so a reader never sees it half written.
+func putPolicyFile(f policyFile) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(policyDir, ".policy")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), policyFilePath(f.PolicyID))
+}
+
+// putPolicyFileObject writes the policy of the zone, keeping the zone config of the file.
+func putPolicyFileObject(o policyObject) error {
+	f, err := getPolicyFile(o.PolicyID)
+	if err != nil && err != PolicyNotFound {
+		return err
+	}
+	f.policyObject = o
+	return putPolicyFile(f)
+}
+
+// createPolicyFile adds an empty file for the zone, a zone which has a file already is kept.
+func createPolicyFile(name string) error {
+	_, err := getPolicyFile(name)
+	if err != PolicyNotFound {
+		return err
+	}
+	return putPolicyFile(policyFile{policyObject: policyObject{PolicyID: name}})
+}
+
+func deletePolicyFile(name string) error {
+	err := os.Remove(policyFilePath(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// listPolicyFileNames returns the zones with a file in the directory.
+func listPolicyFileNames() ([]string, error) {
+	files, err := ioutil.ReadDir(policyDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		name, err := url.QueryUnescape(strings.TrimSuffix(file.Name(), ".json"))
+		if err == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// getPolicyFileZoneConfig returns the zone config of the file of the zone. Empty config is returned when the zone has
+// no file or the file has no config.
+func getPolicyFileZoneConfig(name string) (c ZoneConfig, err error) {
+	f, err := getPolicyFile(name)
+	if err == PolicyNotFound {
+		return c, nil
+	}
+	if f.ZoneConfig != nil {
+		c = *f.ZoneConfig
+	}
+	return c, err
+}
+
+// savePolicyFileZoneConfig sets the zone config of the file of the zone, adding an empty zone without a file.
+func savePolicyFileZoneConfig(name string, c ZoneConfig) error {
+	f, err := getPolicyFile(name)
+	if err != nil && err != PolicyNotFound {
+		return err
+	}
+	f.PolicyID = name
+	f.ZoneConfig = &c
+	return putPolicyFile(f)
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPolicyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetPolicyDir(dir)
+	defer SetPolicyDir("")
+
+	zone := "Business App\\Enterprise CIT"
+	if _, err = GetPolicy(zone); err != PolicyNotFound {
+		t.Fatalf("missing zone should not be found, got %v", err)
+	}
+	err = CreateEmptyPolicy(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetPolicy(zone); err != PolicyFoundButEmpty {
+		t.Fatalf("created zone should be empty, got %v", err)
+	}
+	err = SaveZoneConfig(zone, ZoneConfig{MaxValidityDays: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := PolicyRevision{PolicyID: zone, Revision: 2, PolicyVersion: "abc", Policy: testPolicy}
+	err = putPolicy(zone, r, true, time.Now(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := GetPolicy(zone)
+	if err != nil || p.SubjectCNRegexes[0] != testPolicy.SubjectCNRegexes[0] {
+		t.Fatalf("saved policy should be read, got %v", err)
+	}
+	c, err := GetZoneConfig(zone)
+	if err != nil || c.MaxValidityDays != 90 {
+		t.Errorf("zone config should be kept when the policy is written, got %+v %v", c, err)
+	}
+	state, err := getPolicyState(zone)
+	if err != nil || !state.pinned {
+		t.Errorf("zone should be pinned, got %+v %v", state, err)
+	}
+	err = CreateEmptyPolicy(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, err := ListZones()
+	if err != nil || len(zones) != 1 || zones[0].Name != zone || zones[0].PolicyRevision != 2 || !zones[0].Synced {
+		t.Fatalf("creating an empty policy should keep the existing one, got %+v %v", zones, err)
+	}
+	err = DeletePolicy(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := GetAllPoliciesNames()
+	if err != nil || len(names) != 0 {
+		t.Errorf("deleted zone should not be listed, got %v %v", names, err)
+	}
+}
+
+func TestPolicyFileFixtures(t *testing.T) {
+	SetPolicyDir("../fixtures/policies")
+	defer SetPolicyDir("")
+	names, err := GetAllPoliciesNames()
+	if err != nil || len(names) != 2 {
+		t.Fatalf("unexpected zones %v %v", names, err)
+	}
+	p, err := GetPolicy("Default")
+	if err != nil || len(p.AllowedKeyConfigurations) != 1 {
+		t.Errorf("unexpected default policy %+v %v", p, err)
+	}
+	if _, err = GetPolicy("Amazon\\PCA Policy"); err != PolicyFoundButEmpty {
+		t.Errorf("zone without policy should be empty, got %v", err)
+	}
+}
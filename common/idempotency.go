@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"strconv"
+	"time"
+)
+
+var idempotencyTableName string
+
+const idempotencyKey = "IdempotencyKey"
+
+// Statuses of stored responses
+const (
+	ResponseInProgress = "IN_PROGRESS"
+	ResponseCompleted  = "COMPLETED"
+)
+
+// StoredResponse is the response to a request with an idempotency key. Retries of the request get the stored
+// response instead of being processed again.
+type StoredResponse struct {
+	IdempotencyKey string
+	// Status is ResponseInProgress while the first request is processed.
+	Status          string
+	StatusCode      int               `dynamodbav:",omitempty"`
+	Headers         map[string]string `dynamodbav:",omitempty"`
+	Body            string            `dynamodbav:",omitempty"`
+	IsBase64Encoded bool              `dynamodbav:",omitempty"`
+	CreatedAt       time.Time
+	// TTL is the expiration of the response in Unix seconds, used by DynamoDB to delete old records. A request in
+	// progress can be claimed again after it, e.g. after the Lambda timed out.
+	TTL int64
+}
+
+func init() {
+	idempotencyTableName = os.Getenv("DYNAMODB_IDEMPOTENCY_TABLE")
+	if idempotencyTableName == "" {
+		idempotencyTableName = "VenafiIdempotency"
+	}
+}
+
+// ClaimIdempotencyKey saves the response as in progress unless a live response is stored under its key. It returns
+// the stored response and false if the key is taken.
+func ClaimIdempotencyKey(r StoredResponse, now time.Time) (stored StoredResponse, claimed bool, err error) {
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return
+	}
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(idempotencyTableName),
+		ConditionExpression: aws.String("attribute_not_exists(" + idempotencyKey + ") OR #ttl < :now"),
+		// TTL is a DynamoDB reserved word.
+		ExpressionAttributeNames: map[string]string{"#ttl": "TTL"},
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		stored, err = getStoredResponse(r.IdempotencyKey)
+		return stored, false, err
+	}
+	return r, err == nil, err
+}
+
+func getStoredResponse(key string) (r StoredResponse, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(idempotencyTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			idempotencyKey: {
+				S: aws.String(key),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+	result, err := localDB.GetItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &r)
+	return
+}
+
+func SaveStoredResponse(r StoredResponse) error {
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(idempotencyTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func DeleteStoredResponse(key string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(idempotencyTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			idempotencyKey: {
+				S: aws.String(key),
+			},
+		},
+	}
+	_, err := localDB.DeleteItemRequest(input).Send(context.Background())
+	return err
+}
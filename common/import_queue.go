@@ -0,0 +1,80 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"time"
+)
+
+var importQueueTableName string
+
+const importQueueKey = "CertificateArn"
+
+// VenafiImport is a certificate issued through the proxy which still has to be imported into its Venafi zone, so it
+// shows up in the Venafi inventory. The request Lambda queues issued certificates and the policy Lambda, which holds
+// the Venafi connection, imports them once ACM or ACM PCA returns them.
+type VenafiImport struct {
+	CertificateArn string
+	Zone           string
+	// AccountID, Region, CertificateAuthorityArn and RequestedBy are set as custom fields of the Venafi certificate, so
+	// Venafi operators can trace where it came from.
+	AccountID               string `dynamodbav:",omitempty"`
+	Region                  string `dynamodbav:",omitempty"`
+	CertificateAuthorityArn string `dynamodbav:",omitempty"`
+	RequestedBy             string `dynamodbav:",omitempty"`
+	CreatedAt               time.Time
+	// TTL gives up on certificates which weren't issued or imported in time, in Unix seconds.
+	TTL int64
+}
+
+func init() {
+	importQueueTableName = os.Getenv("DYNAMODB_IMPORT_QUEUE_TABLE")
+	if importQueueTableName == "" {
+		importQueueTableName = "VenafiImportQueue"
+	}
+}
+
+func SaveVenafiImport(i VenafiImport) error {
+	av, err := dynamodbattribute.MarshalMap(i)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(importQueueTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func ListVenafiImports() ([]VenafiImport, error) {
+	var imports []VenafiImport
+	p := dynamodb.NewScanPaginator(localDB.ScanRequest(&dynamodb.ScanInput{TableName: aws.String(importQueueTableName)}))
+	for p.Next(context.Background()) {
+		for _, item := range p.CurrentPage().Items {
+			var i VenafiImport
+			err := dynamodbattribute.UnmarshalMap(item, &i)
+			if err != nil {
+				return nil, err
+			}
+			imports = append(imports, i)
+		}
+	}
+	return imports, p.Err()
+}
+
+func DeleteVenafiImport(certificateArn string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(importQueueTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			importQueueKey: {
+				S: aws.String(certificateArn),
+			},
+		},
+	}
+	_, err := localDB.DeleteItemRequest(input).Send(context.Background())
+	return err
+}
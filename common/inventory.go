@@ -0,0 +1,273 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var inventoryTableName string
+
+const inventoryKey = "CertificateArn"
+
+// Inventory record sources
+const (
+	InventorySourceDiscovery = "discovery"
+	InventorySourceProxy     = "proxy"
+)
+
+// InventoryStatusRevoked is the status of revoked certificates, as reported by ACM.
+const InventoryStatusRevoked = "REVOKED"
+
+// InventoryRecord describes a certificate found in ACM or issued through the proxy.
+type InventoryRecord struct {
+	CertificateArn          string
+	AccountID               string
+	Region                  string
+	DomainName              string
+	SubjectAlternativeNames []string
+	Serial                  string
+	Status                  string
+	Type                    string
+	NotAfter                time.Time
+	Source                  string
+	// CertificateAuthorityArn is the private CA of private certificates.
+	CertificateAuthorityArn string `dynamodbav:",omitempty"`
+	// Zone, SourceAccount and RequestedBy are set for certificates issued through the proxy.
+	Zone          string
+	SourceAccount string
+	RequestedBy   string
+	// PolicyVersion is the version of the zone policy the request was validated against, see ZoneStatus.
+	PolicyVersion string `dynamodbav:",omitempty"`
+	// Thumbprint is the SHA-1 hash of the certificate, known once the certificate was retrieved through the proxy.
+	Thumbprint string
+	Subject    string
+	// Tags of the request which issued the certificate, used to find owners of certificates issued through the proxy.
+	Tags map[string]string `dynamodbav:",omitempty"`
+	// NotifiedDays is the last expiration notification threshold sent to the owners, 0 if none was sent.
+	NotifiedDays int
+	UpdatedAt    time.Time
+	// BaseDomain and ExpiryMonth are keys of the domain and expiry indexes, set on save.
+	BaseDomain  string `dynamodbav:",omitempty"`
+	ExpiryMonth string `dynamodbav:",omitempty"`
+}
+
+// Secondary indexes of the inventory table
+const (
+	inventoryDomainIndex = "DomainIndex"
+	inventoryExpiryIndex = "ExpiryIndex"
+)
+
+// InventoryMixedDomains is the base domain of certificates for names in several base domains.
+const InventoryMixedDomains = "*"
+
+const expiryMonthFormat = "2006-01"
+
+func init() {
+	inventoryTableName = os.Getenv("DYNAMODB_INVENTORY_TABLE")
+	if inventoryTableName == "" {
+		inventoryTableName = "VenafiCertInventory"
+	}
+}
+
+func SaveInventoryRecord(r InventoryRecord) error {
+	r.UpdatedAt = time.Now().UTC()
+	r.BaseDomain = inventoryBaseDomain(append([]string{r.DomainName}, r.SubjectAlternativeNames...))
+	r.ExpiryMonth = ""
+	if !r.NotAfter.IsZero() {
+		// Whole seconds keep the RFC 3339 index values in time order.
+		r.NotAfter = r.NotAfter.UTC().Truncate(time.Second)
+		r.ExpiryMonth = r.NotAfter.Format(expiryMonthFormat)
+	}
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(inventoryTableName),
+	}
+
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func GetInventoryRecord(certificateArn string) (r InventoryRecord, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(inventoryTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			inventoryKey: {
+				S: aws.String(certificateArn),
+			},
+		},
+	}
+
+	result, err := localDB.GetItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	if result.Item == nil {
+		err = CertificateNotFound
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &r)
+	return
+}
+
+// ListInventoryRecords returns inventory records accepted by the filter.
+func ListInventoryRecords(filter func(InventoryRecord) bool) ([]InventoryRecord, error) {
+	var records []InventoryRecord
+	p := dynamodb.NewScanPaginator(localDB.ScanRequest(&dynamodb.ScanInput{TableName: aws.String(inventoryTableName)}))
+	for p.Next(context.Background()) {
+		for _, item := range p.CurrentPage().Items {
+			var r InventoryRecord
+			err := dynamodbattribute.UnmarshalMap(item, &r)
+			if err != nil {
+				return nil, err
+			}
+			if filter(r) {
+				records = append(records, r)
+			}
+		}
+	}
+	return records, p.Err()
+}
+
+// SetInventoryThumbprint sets the thumbprint of a certificate already in the inventory.
+func SetInventoryThumbprint(certificateArn, thumbprint string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(inventoryTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			inventoryKey: {
+				S: aws.String(certificateArn),
+			},
+		},
+		UpdateExpression:    aws.String("SET Thumbprint = :t"),
+		ConditionExpression: aws.String("attribute_exists(" + inventoryKey + ")"),
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":t": {S: aws.String(thumbprint)},
+		},
+	}
+	_, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return CertificateNotFound
+	}
+	return err
+}
+
+// SetInventoryNotifiedDays records the expiration notification threshold sent for a certificate.
+func SetInventoryNotifiedDays(certificateArn string, days int) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(inventoryTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			inventoryKey: {
+				S: aws.String(certificateArn),
+			},
+		},
+		UpdateExpression: aws.String("SET NotifiedDays = :d"),
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":d": {N: aws.String(strconv.Itoa(days))},
+		},
+	}
+	_, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	return err
+}
+
+// BaseDomain returns the last two labels of a domain name, e.g. example.com for www.example.com.
+func BaseDomain(name string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// inventoryBaseDomain returns the common base domain of certificate names or InventoryMixedDomains.
+func inventoryBaseDomain(names []string) string {
+	base := ""
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		b := BaseDomain(name)
+		if b == "" || (base != "" && b != base) {
+			return InventoryMixedDomains
+		}
+		base = b
+	}
+	return base
+}
+
+// ListInventoryRecordsByDomain returns inventory records which may have names in the base domain of the name. These
+// are records of the base domain and records for several base domains.
+func ListInventoryRecordsByDomain(name string) ([]InventoryRecord, error) {
+	var records []InventoryRecord
+	for _, base := range []string{BaseDomain(name), InventoryMixedDomains} {
+		r, err := queryInventory(&dynamodb.QueryInput{
+			TableName:              aws.String(inventoryTableName),
+			IndexName:              aws.String(inventoryDomainIndex),
+			KeyConditionExpression: aws.String("BaseDomain = :b"),
+			ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+				":b": {S: aws.String(base)},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r...)
+	}
+	return records, nil
+}
+
+// ListInventoryRecordsByExpiry returns inventory records expiring between from and to.
+func ListInventoryRecordsByExpiry(from, to time.Time) ([]InventoryRecord, error) {
+	from, to = from.UTC().Truncate(time.Second), to.UTC().Truncate(time.Second)
+	fromValue, err := dynamodbattribute.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+	toValue, err := dynamodbattribute.Marshal(to)
+	if err != nil {
+		return nil, err
+	}
+	var records []InventoryRecord
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(to); m = m.AddDate(0, 1, 0) {
+		r, err := queryInventory(&dynamodb.QueryInput{
+			TableName:              aws.String(inventoryTableName),
+			IndexName:              aws.String(inventoryExpiryIndex),
+			KeyConditionExpression: aws.String("ExpiryMonth = :m AND NotAfter BETWEEN :from AND :to"),
+			ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+				":m":    {S: aws.String(m.Format(expiryMonthFormat))},
+				":from": *fromValue,
+				":to":   *toValue,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r...)
+	}
+	return records, nil
+}
+
+func queryInventory(input *dynamodb.QueryInput) ([]InventoryRecord, error) {
+	var records []InventoryRecord
+	p := dynamodb.NewQueryPaginator(localDB.QueryRequest(input))
+	for p.Next(context.Background()) {
+		for _, item := range p.CurrentPage().Items {
+			var r InventoryRecord
+			err := dynamodbattribute.UnmarshalMap(item, &r)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, r)
+		}
+	}
+	return records, p.Err()
+}
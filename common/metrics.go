@@ -0,0 +1,97 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// MetricNamespace is the CloudWatch namespace of the proxy metrics.
+const MetricNamespace = "VenafiCertificateProxy"
+
+// Metric is a value of a CloudWatch metric.
+type Metric struct {
+	Name  string
+	Unit  cloudwatch.StandardUnit
+	Value float64
+}
+
+// Dimension is a CloudWatch metric dimension. Empty values are reported as "-", CloudWatch rejects them.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// metricsEnabled is false when EMIT_METRICS is "false".
+var metricsEnabled bool
+
+// metricsOutput is where metric records are written, the Lambda log.
+var metricsOutput = struct {
+	sync.Mutex
+	w io.Writer
+}{w: os.Stdout}
+
+func init() {
+	metricsEnabled = os.Getenv("EMIT_METRICS") != "false"
+}
+
+// PutMetrics writes the metrics with the dimensions in the CloudWatch embedded metric format to the Lambda log,
+// CloudWatch extracts them from there without a PutMetricData call. The metrics are also aggregated by the first
+// dimension alone, e.g. by zone across targets.
+func PutMetrics(dimensions []Dimension, metrics ...Metric) {
+	if !metricsEnabled || len(metrics) == 0 {
+		return
+	}
+	b, err := metricRecord(dimensions, metrics, time.Now())
+	if err != nil {
+		log.Println("Can't put metrics:", err)
+		return
+	}
+	metricsOutput.Lock()
+	defer metricsOutput.Unlock()
+	fmt.Fprintln(metricsOutput.w, string(b))
+}
+
+type emfMetric struct {
+	Name string
+	Unit cloudwatch.StandardUnit `json:",omitempty"`
+}
+
+type emfDirective struct {
+	Namespace  string
+	Dimensions [][]string
+	Metrics    []emfMetric
+}
+
+func metricRecord(dimensions []Dimension, metrics []Metric, now time.Time) ([]byte, error) {
+	record := map[string]interface{}{}
+	names := make([]string, 0, len(dimensions))
+	for _, d := range dimensions {
+		if d.Value == "" {
+			d.Value = "-"
+		}
+		record[d.Name] = d.Value
+		names = append(names, d.Name)
+	}
+	directive := emfDirective{Namespace: MetricNamespace, Dimensions: [][]string{names}}
+	if len(names) > 1 {
+		directive.Dimensions = append(directive.Dimensions, names[:1])
+	}
+	for _, m := range metrics {
+		if _, ok := record[m.Name]; ok {
+			return nil, fmt.Errorf("metric %s has the name of a dimension", m.Name)
+		}
+		record[m.Name] = m.Value
+		directive.Metrics = append(directive.Metrics, emfMetric{Name: m.Name, Unit: m.Unit})
+	}
+	record["_aws"] = struct {
+		Timestamp         int64
+		CloudWatchMetrics []emfDirective
+	}{now.UnixNano() / int64(time.Millisecond), []emfDirective{directive}}
+	return json.Marshal(record)
+}
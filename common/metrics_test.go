@@ -0,0 +1,47 @@
+package common
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetricRecord(t *testing.T) {
+	now := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	b, err := metricRecord([]Dimension{{"Target", "ACMPrivateCAIssueCertificate"}, {"Zone", ""}},
+		[]Metric{{"Requests", cloudwatch.StandardUnitCount, 1}, {"Latency", cloudwatch.StandardUnitMilliseconds, 12.5}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []emfDirective
+		} `json:"_aws"`
+		Target   string
+		Zone     string
+		Requests float64
+		Latency  float64
+	}
+	err = json.Unmarshal(b, &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Target != "ACMPrivateCAIssueCertificate" || record.Zone != "-" || record.Requests != 1 || record.Latency != 12.5 {
+		t.Errorf("unexpected record %s", b)
+	}
+	if record.AWS.Timestamp != now.Unix()*1000 || len(record.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("unexpected metadata %s", b)
+	}
+	d := record.AWS.CloudWatchMetrics[0]
+	if d.Namespace != MetricNamespace || !reflect.DeepEqual(d.Dimensions, [][]string{{"Target", "Zone"}, {"Target"}}) || len(d.Metrics) != 2 {
+		t.Errorf("unexpected directive %+v", d)
+	}
+
+	_, err = metricRecord([]Dimension{{"Zone", "Default"}}, []Metric{{Name: "Zone"}}, now)
+	if err == nil {
+		t.Error("metric named like a dimension should be rejected")
+	}
+}
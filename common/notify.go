@@ -0,0 +1,49 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"strings"
+)
+
+// SendEmail sends a plain text email with SES.
+func SendEmail(ctx context.Context, cfg aws.Config, from string, to []string, subject, body string) error {
+	_, err := ses.New(cfg).SendEmailRequest(&ses.SendEmailInput{
+		Source:      aws.String(from),
+		Destination: &ses.Destination{ToAddresses: to},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(subject)},
+			Body:    &ses.Body{Text: &ses.Content{Data: aws.String(body)}},
+		},
+	}).Send(ctx)
+	return err
+}
+
+// PublishNotification publishes a message to an SNS topic.
+func PublishNotification(ctx context.Context, cfg aws.Config, topicArn, subject, message string) error {
+	_, err := sns.New(cfg).PublishRequest(&sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(message),
+	}).Send(ctx)
+	return err
+}
+
+// EmailDomainAllowed reports whether the address is in one of the domains or their subdomains. Requesters choose the
+// addresses of their owner tags, so only addresses of allowed domains may get emails.
+func EmailDomainAllowed(address string, domains []string) bool {
+	i := strings.LastIndex(address, "@")
+	if i <= 0 || strings.ContainsAny(address, " ,;<>") {
+		return false
+	}
+	domain := strings.ToLower(address[i+1:])
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "@"))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package common
+
+import "testing"
+
+func TestEmailDomainAllowed(t *testing.T) {
+	domains := []string{"example.com", "@Example.org"}
+	cases := map[string]bool{
+		"owner@example.com":          true,
+		"owner@team.example.com":     true,
+		"owner@EXAMPLE.ORG":          true,
+		"owner@badexample.com":       false,
+		"owner@example.com.evil.net": false,
+		"owner@evil.net":             false,
+		"example.com":                false,
+		"@example.com":               false,
+		"a@evil.net,b@example.com":   false,
+	}
+	for address, want := range cases {
+		if got := EmailDomainAllowed(address, domains); got != want {
+			t.Errorf("EmailDomainAllowed(%q) = %v, want %v", address, got, want)
+		}
+	}
+	if EmailDomainAllowed("owner@example.com", nil) {
+		t.Error("no address is allowed without domains")
+	}
+}
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backends monitored for failure streaks
+const (
+	BackendACM      = "ACM"
+	BackendACMPCA   = "ACM PCA"
+	BackendDynamoDB = "DynamoDB"
+	BackendVenafi   = "Venafi"
+	// BackendVenafiToken is the TPP access token refresh of the policy Lambda.
+	BackendVenafiToken = "Venafi token refresh"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+const defaultFailureThreshold = 3
+
+// BackendMonitor counts consecutive failures of backends and triggers a PagerDuty event when a streak reaches the
+// threshold. The event is resolved by the next success. Streaks are counted per Lambda container.
+type BackendMonitor struct {
+	mu         sync.Mutex
+	streaks    map[string]int
+	threshold  int
+	routingKey string
+	url        string
+	client     *http.Client
+}
+
+// Monitor is the backend monitor of the Lambda, configured by PAGERDUTY_ROUTING_KEY and PAGERDUTY_FAILURE_THRESHOLD.
+var Monitor *BackendMonitor
+
+func init() {
+	threshold, err := strconv.Atoi(os.Getenv("PAGERDUTY_FAILURE_THRESHOLD"))
+	if err != nil || threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	Monitor = NewBackendMonitor(os.Getenv("PAGERDUTY_ROUTING_KEY"), threshold)
+}
+
+// NewBackendMonitor returns a monitor sending PagerDuty events with the routing key. Without a key failure streaks
+// are only logged.
+func NewBackendMonitor(routingKey string, threshold int) *BackendMonitor {
+	return &BackendMonitor{
+		streaks:    make(map[string]int),
+		threshold:  threshold,
+		routingKey: routingKey,
+		url:        pagerDutyEventsURL,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Record records the result of a backend call. Errors caused by the request, like validation errors, count as
+// successful calls since the backend is available.
+func (m *BackendMonitor) Record(backend string, err error) {
+	if !isBackendFailure(err) {
+		err = nil
+	}
+	m.mu.Lock()
+	streak := m.streaks[backend]
+	if err == nil {
+		m.streaks[backend] = 0
+	} else {
+		streak++
+		m.streaks[backend] = streak
+	}
+	m.mu.Unlock()
+
+	switch {
+	case err == nil && streak >= m.threshold:
+		log.Printf("ALERT: %s recovered after %d consecutive failures", backend, streak)
+		m.sendEvent("resolve", backend, fmt.Sprintf("%s calls succeed again", backend))
+	case err != nil && streak == m.threshold:
+		summary := fmt.Sprintf("%d consecutive %s failures in Venafi certificate proxy, last error: %s", streak, backend, err)
+		log.Println("ALERT:", summary)
+		m.sendEvent("trigger", backend, summary)
+	}
+}
+
+func (m *BackendMonitor) sendEvent(action, backend, summary string) {
+	if m.routingKey == "" {
+		return
+	}
+	source := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	if source == "" {
+		source = "venafi-certificate-proxy"
+	}
+	event := map[string]interface{}{
+		"routing_key":  m.routingKey,
+		"event_action": action,
+		// Events of a backend in one function are deduplicated into one incident.
+		"dedup_key": source + "/" + backend,
+	}
+	if action == "trigger" {
+		event["payload"] = map[string]string{
+			"summary":   truncate(summary, 1024),
+			"source":    source,
+			"severity":  "critical",
+			"component": backend,
+		}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Can't marshal PagerDuty event:", err)
+		return
+	}
+	resp, err := m.client.Post(m.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Println("Can't send PagerDuty event:", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		log.Printf("PagerDuty rejected event: %s", resp.Status)
+	}
+}
+
+// isBackendFailure reports whether an error means the backend is unavailable: a network error, a server error or
+// throttling.
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.StatusCode() >= 500 || strings.Contains(aerr.Code(), "Throttl") ||
+			aerr.Code() == "RequestLimitExceeded" || aerr.Code() == "ProvisionedThroughputExceededException"
+	}
+	return true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackendMonitor(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			RoutingKey  string `json:"routing_key"`
+			EventAction string `json:"event_action"`
+			DedupKey    string `json:"dedup_key"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		if event.RoutingKey != "key" || event.DedupKey == "" {
+			t.Errorf("unexpected event %+v", event)
+		}
+		actions = append(actions, event.EventAction)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	m := NewBackendMonitor("key", 3)
+	m.url = server.URL
+	failure := fmt.Errorf("connection refused")
+	m.Record(BackendDynamoDB, failure)
+	m.Record(BackendDynamoDB, failure)
+	m.Record(BackendDynamoDB, nil)
+	if len(actions) != 0 {
+		t.Fatalf("streak shorter than threshold must not alert, got %v", actions)
+	}
+	for i := 0; i < 4; i++ {
+		m.Record(BackendDynamoDB, failure)
+	}
+	m.Record(BackendACMPCA, nil)
+	m.Record(BackendDynamoDB, nil)
+	m.Record(BackendDynamoDB, nil)
+	if len(actions) != 2 || actions[0] != "trigger" || actions[1] != "resolve" {
+		t.Errorf("expected trigger and resolve, got %v", actions)
+	}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"errors"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"reflect"
+	"time"
+)
+
+// PolicyExport is a backup of the zones of the policy store, e.g. to migrate them to another account.
+type PolicyExport struct {
+	ExportedAt time.Time
+	Zones      []ZoneExport
+}
+
+// ZoneExport is the active policy of a zone with its sync state and zone config. Policy is nil for zones whose policy
+// was not retrieved from Venafi yet, ZoneConfig is nil for zones without settings.
+type ZoneExport struct {
+	Zone           string
+	Policy         *endpoint.Policy `json:",omitempty"`
+	PolicyVersion  string           `json:",omitempty"`
+	PolicyRevision int64            `json:",omitempty"`
+	LastSync       time.Time
+	Pinned         bool        `json:",omitempty"`
+	ZoneConfig     *ZoneConfig `json:",omitempty"`
+}
+
+// ExportPolicies returns the active policies of all zones, as the request Lambda enforces them.
+func ExportPolicies() (e PolicyExport, err error) {
+	e.ExportedAt = time.Now().UTC().Truncate(time.Second)
+	zones, err := ListZones()
+	if err != nil {
+		return e, err
+	}
+	for _, z := range zones {
+		ze := ZoneExport{
+			Zone:           z.Name,
+			PolicyVersion:  z.PolicyVersion,
+			PolicyRevision: z.PolicyRevision,
+			LastSync:       z.LastSync,
+			Pinned:         z.Pinned,
+		}
+		p, err := GetPolicy(z.Name)
+		if err == nil {
+			ze.Policy = &p
+		} else if err == PolicyNotFound {
+			// Deleted since it was listed.
+			continue
+		} else if err != PolicyFoundButEmpty {
+			return e, err
+		}
+		c, err := GetZoneConfig(z.Name)
+		if err != nil {
+			return e, err
+		}
+		if !reflect.DeepEqual(c, ZoneConfig{}) {
+			ze.ZoneConfig = &c
+		}
+		e.Zones = append(e.Zones, ze)
+	}
+	return e, nil
+}
+
+// ImportZone writes an exported zone to the policy store. The policy replaces the active policy of the zone and is
+// added to its policy history unless the history has the revision already, so the zone can be rolled back and unpinned
+// as before. The zone config replaces the config of the zone, zones without one keep their config.
+func ImportZone(z ZoneExport) error {
+	if z.Zone == "" {
+		return errors.New("zone name is missing")
+	}
+	if IsRemotePolicyTable() {
+		return errors.New("policy table of another account is read-only")
+	}
+	if z.Policy == nil {
+		err := CreateEmptyPolicy(z.Zone)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := importPolicy(z)
+		if err != nil {
+			return err
+		}
+	}
+	if z.ZoneConfig != nil {
+		return SaveZoneConfig(z.Zone, *z.ZoneConfig)
+	}
+	return nil
+}
+
+func importPolicy(z ZoneExport) error {
+	version, err := PolicyVersion(*z.Policy)
+	if err != nil {
+		return err
+	}
+	if z.PolicyVersion != "" && z.PolicyVersion != version {
+		return errors.New("policy version doesn't match the policy of zone " + z.Zone)
+	}
+	syncedAt := z.LastSync
+	if syncedAt.IsZero() {
+		syncedAt = time.Now().UTC()
+	}
+	r := PolicyRevision{PolicyID: z.Zone, Revision: z.PolicyRevision, PolicyVersion: version, SyncedAt: syncedAt, Policy: *z.Policy}
+	err = importPolicyRevision(&r)
+	if err != nil {
+		return err
+	}
+	state, err := getPolicyState(z.Zone)
+	if err != nil {
+		return err
+	}
+	return putPolicy(z.Zone, r, z.Pinned, syncedAt, state.itemVersion)
+}
+
+// importPolicyRevision adds the revision to the policy history. A revision number the history has with another policy,
+// e.g. one synced in the target account before, is replaced by the next free number.
+func importPolicyRevision(r *PolicyRevision) error {
+	if r.Revision > 0 {
+		existing, err := GetPolicyRevision(r.PolicyID, r.Revision)
+		if err == PolicyRevisionNotFound {
+			return savePolicyRevision(*r)
+		} else if err != nil {
+			return err
+		}
+		if existing.PolicyVersion == r.PolicyVersion {
+			return nil
+		}
+	}
+	latest, err := latestPolicyRevision(r.PolicyID)
+	if err != nil && err != PolicyRevisionNotFound {
+		return err
+	}
+	r.Revision = latest.Revision + 1
+	return savePolicyRevision(*r)
+}
@@ -0,0 +1,24 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestExportPolicies(t *testing.T) {
+	SetPolicyDir("../fixtures/policies")
+	defer SetPolicyDir("")
+	e, err := ExportPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones := map[string]ZoneExport{}
+	for _, z := range e.Zones {
+		zones[z.Zone] = z
+	}
+	if z := zones["Default"]; len(zones) != 2 || z.Policy == nil || z.ZoneConfig == nil || z.ZoneConfig.MaxValidityDays != 398 {
+		t.Errorf("unexpected export %+v", e)
+	}
+	if z := zones["Amazon\\PCA Policy"]; z.Policy != nil || z.ZoneConfig != nil {
+		t.Errorf("zone without policy and config should be exported empty, got %+v", z)
+	}
+}
@@ -0,0 +1,141 @@
+package common
+
+import (
+	"context"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"strconv"
+	"time"
+)
+
+var policyHistoryTableName string
+
+const policyRevisionKey = "Revision"
+
+const PolicyRevisionNotFound venafiError = "policy revision not found"
+
+// PolicyPinned is returned when a synced policy is not activated because the zone is pinned to another revision.
+const PolicyPinned venafiError = "zone is pinned to a policy revision"
+
+// PolicyRevision is a policy of a zone as it was synced from Venafi. Revisions of a zone are numbered from 1, a new
+// revision is added whenever the synced policy changes.
+type PolicyRevision struct {
+	PolicyID      string
+	Revision      int64
+	PolicyVersion string
+	SyncedAt      time.Time
+	Policy        endpoint.Policy
+}
+
+func init() {
+	policyHistoryTableName = os.Getenv("DYNAMODB_POLICY_HISTORY_TABLE")
+	if policyHistoryTableName == "" {
+		policyHistoryTableName = "VenafiPolicyHistory"
+	}
+}
+
+// GetPolicyRevision returns a revision of the policy of a zone.
+func GetPolicyRevision(name string, revision int64) (r PolicyRevision, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(policyHistoryTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			primaryKey:        {S: aws.String(name)},
+			policyRevisionKey: {N: aws.String(strconv.FormatInt(revision, 10))},
+		},
+	}
+	result, err := db.GetItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	if result.Item == nil {
+		err = PolicyRevisionNotFound
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &r)
+	return
+}
+
+// ListPolicyRevisions returns revisions of the policy of a zone, the latest first.
+func ListPolicyRevisions(name string, limit int64) ([]PolicyRevision, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(policyHistoryTableName),
+		KeyConditionExpression: aws.String(primaryKey + " = :p"),
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":p": {S: aws.String(name)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(limit)
+	}
+	result, err := db.QueryRequest(input).Send(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]PolicyRevision, 0, len(result.Items))
+	for _, item := range result.Items {
+		var r PolicyRevision
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+// latestPolicyRevision returns the last synced revision of the policy of a zone.
+func latestPolicyRevision(name string) (PolicyRevision, error) {
+	revisions, err := ListPolicyRevisions(name, 1)
+	if err != nil {
+		return PolicyRevision{}, err
+	}
+	if len(revisions) == 0 {
+		return PolicyRevision{}, PolicyRevisionNotFound
+	}
+	return revisions[0], nil
+}
+
+func savePolicyRevision(r PolicyRevision) error {
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	// A revision is never overwritten, concurrent syncs of the zone can't both add the same revision.
+	_, err = db.PutItemRequest(&dynamodb.PutItemInput{
+		TableName:           aws.String(policyHistoryTableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(" + policyRevisionKey + ")"),
+	}).Send(context.Background())
+	return err
+}
+
+// RollbackPolicy activates a previous revision of the policy of a zone and pins the zone to it, so syncs from Venafi
+// only add revisions to the history until the zone is unpinned.
+func RollbackPolicy(name string, revision int64) (PolicyRevision, error) {
+	r, err := GetPolicyRevision(name, revision)
+	if err != nil {
+		return r, err
+	}
+	state, err := getPolicyState(name)
+	if err != nil {
+		return r, err
+	}
+	return r, putPolicy(name, r, true, r.SyncedAt, state.itemVersion)
+}
+
+// UnpinPolicy activates the latest revision of the policy of a zone and lets syncs activate new revisions again.
+func UnpinPolicy(name string) (PolicyRevision, error) {
+	r, err := latestPolicyRevision(name)
+	if err != nil {
+		return r, err
+	}
+	state, err := getPolicyState(name)
+	if err != nil {
+		return r, err
+	}
+	return r, putPolicy(name, r, false, r.SyncedAt, state.itemVersion)
+}
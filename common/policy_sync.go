@@ -0,0 +1,14 @@
+package common
+
+// PolicySyncRequest is the payload of the policy Lambda. Scheduled runs sync the policies of all zones, the request
+// Lambda invokes it with VenafiZone to sync one zone on demand.
+type PolicySyncRequest struct {
+	VenafiZone string `json:",omitempty"`
+}
+
+// PolicySyncResult lists the zones whose policies were synced and the zones deleted because they don't exist in
+// Venafi.
+type PolicySyncResult struct {
+	Synced  []string
+	Deleted []string
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redisTimeout = time.Second
+
+// redisNil is the nil reply, e.g. to GET of a missing key or to SET NX of an existing one.
+var redisNil = errors.New("redis: nil")
+
+// redisClient is a minimal client of the Redis protocol (RESP) over one connection, which is enough for the few
+// commands of the shared policy cache. A failed command closes the connection and the next one reconnects.
+type redisClient struct {
+	mu       sync.Mutex
+	addr     string
+	tls      bool
+	password string
+	conn     net.Conn
+	r        *bufio.Reader
+}
+
+// newRedisClient returns a client of a redis:// or rediss:// (TLS, e.g. ElastiCache in-transit encryption) URL. The
+// password of the URL is sent with AUTH, e.g. the ElastiCache auth token.
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "redis" && u.Scheme != "rediss") {
+		return nil, fmt.Errorf("invalid Redis URL %q: use redis://host:port or rediss://host:port", rawURL)
+	}
+	c := &redisClient{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	return c, nil
+}
+
+func (c *redisClient) connect() error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var err error
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.addr)
+		c.conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, &tls.Config{ServerName: host})
+	} else {
+		c.conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.r = bufio.NewReader(c.conn)
+	if c.password != "" {
+		_, err = c.roundTrip("AUTH", c.password)
+		if err != nil {
+			c.close()
+			return fmt.Errorf("redis AUTH failed: %s", err)
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// do sends the command and returns its reply, a string for simple and bulk strings or an int64 for integers.
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		err := c.connect()
+		if err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(args...)
+	if _, isReplyErr := err.(redisError); err != nil && err != redisNil && !isReplyErr {
+		c.close()
+	}
+	return reply, err
+}
+
+func (c *redisClient) roundTrip(args ...string) (interface{}, error) {
+	err := c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err = io.WriteString(c.conn, b.String())
+	if err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (c *redisClient) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, redisNil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	}
+	return nil, fmt.Errorf("redis: unsupported reply %q", line)
+}
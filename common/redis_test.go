@@ -0,0 +1,161 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis serves GET, SET, DEL and AUTH from a map.
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	password string
+	commands []string
+}
+
+func (f *fakeRedis) serve(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, _ = r.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			buf := make([]byte, size+2)
+			_, _ = io.ReadFull(r, buf)
+			args[i] = string(buf[:size])
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		switch {
+		case args[0] == "AUTH":
+			authenticated = args[1] == f.password
+			if authenticated {
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case !authenticated:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "GET":
+			if v, ok := f.values[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case args[0] == "SET":
+			if _, ok := f.values[args[1]]; ok && args[len(args)-1] == "NX" {
+				fmt.Fprint(conn, "$-1\r\n")
+				break
+			}
+			f.values[args[1]] = args[2]
+			fmt.Fprint(conn, "+OK\r\n")
+		case args[0] == "DEL":
+			_, ok := f.values[args[1]]
+			delete(f.values, args[1])
+			if ok {
+				fmt.Fprint(conn, ":1\r\n")
+			} else {
+				fmt.Fprint(conn, ":0\r\n")
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestSharedPolicyCache(t *testing.T) {
+	f := &fakeRedis{values: map[string]string{}, password: "token"}
+	addr := f.serve(t)
+	c, err := newRedisClient("redis://:token@" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedPolicyCache = c
+	defer func() { sharedPolicyCache = nil }()
+
+	if _, ok := GetSharedCachedPolicy("Default"); ok {
+		t.Fatal("empty cache should miss")
+	}
+	SetSharedCachedPolicy("Default", endpoint.Policy{SubjectCNRegexes: []string{".*\\.example\\.com"}})
+	p, ok := GetSharedCachedPolicy("Default")
+	if !ok || len(p.SubjectCNRegexes) != 1 || p.SubjectCNRegexes[0] != ".*\\.example\\.com" {
+		t.Errorf("unexpected cached policy %+v", p)
+	}
+	FillSharedCachedPolicy("Default", endpoint.Policy{SubjectCNRegexes: []string{".*"}})
+	p, _ = GetSharedCachedPolicy("Default")
+	if len(p.SubjectCNRegexes) != 1 || p.SubjectCNRegexes[0] != ".*\\.example\\.com" {
+		t.Errorf("filling the cache must not replace a cached policy, got %+v", p)
+	}
+	forgetSharedCachedPolicy("Default")
+	if _, ok := GetSharedCachedPolicy("Default"); ok {
+		t.Error("forgotten policy should miss")
+	}
+	FillSharedCachedPolicy("Default", endpoint.Policy{SubjectCNRegexes: []string{".*"}})
+	if p, ok = GetSharedCachedPolicy("Default"); !ok || p.SubjectCNRegexes[0] != ".*" {
+		t.Errorf("filling an empty cache should cache the policy, got %+v", p)
+	}
+	if f.commands[0] != "AUTH" {
+		t.Errorf("client should authenticate first, sent %v", f.commands)
+	}
+	if _, err := c.do("PING"); err == nil {
+		t.Error("error reply should fail")
+	}
+}
+
+func TestRedisWrongPassword(t *testing.T) {
+	f := &fakeRedis{values: map[string]string{}, password: "token"}
+	c, err := newRedisClient("redis://:wrong@" + f.serve(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.do("GET", "key"); err == nil {
+		t.Error("wrong password should fail")
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	c, err := newRedisClient("rediss://:token@master.venafi.abc123.use1.cache.amazonaws.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.tls || c.addr != "master.venafi.abc123.use1.cache.amazonaws.com:6379" || c.password != "token" {
+		t.Errorf("unexpected client %+v", c)
+	}
+	for _, u := range []string{"master.venafi.cache.amazonaws.com:6379", "http://localhost:6379", "redis://"} {
+		if _, err := newRedisClient(u); err == nil {
+			t.Errorf("URL %q should be invalid", u)
+		}
+	}
+}
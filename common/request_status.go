@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"time"
+)
+
+var requestStatusTableName string
+
+const requestStatusKey = "RequestID"
+
+const RequestStatusNotFound venafiError = "request status not found"
+
+// Request statuses
+const (
+	RequestSucceeded = "SUCCEEDED"
+	// RequestRejected means the request was refused by a policy or a check, a client error.
+	RequestRejected = "REJECTED"
+	RequestFailed   = "FAILED"
+	// RequestQueued means the request was throttled and waits in the retry queue.
+	RequestQueued = "QUEUED"
+)
+
+// RequestStatus records what happened to a request received by the proxy.
+type RequestStatus struct {
+	RequestID      string
+	Target         string
+	Zone           string
+	CallerArn      string
+	SourceAccount  string
+	Status         string
+	StatusCode     int
+	Message        string
+	CertificateArn string
+	CreatedAt      time.Time
+	// TTL is the expiration of the record in Unix seconds, used by DynamoDB to delete old records.
+	TTL int64
+}
+
+func init() {
+	requestStatusTableName = os.Getenv("DYNAMODB_REQUEST_STATUS_TABLE")
+	if requestStatusTableName == "" {
+		requestStatusTableName = "VenafiRequestStatus"
+	}
+}
+
+func SaveRequestStatus(r RequestStatus) error {
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(requestStatusTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func GetRequestStatus(requestID string) (r RequestStatus, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(requestStatusTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			requestStatusKey: {
+				S: aws.String(requestID),
+			},
+		},
+	}
+
+	result, err := localDB.GetItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	if result.Item == nil {
+		err = RequestStatusNotFound
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &r)
+	return
+}
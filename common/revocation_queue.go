@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"time"
+)
+
+var revocationQueueTableName string
+
+const revocationQueueKey = "CertificateArn"
+
+// VenafiRevocation is a revocation done through the proxy which still has to be applied to the Venafi record of the
+// certificate. The request Lambda queues revocations and the policy Lambda, which holds the Venafi connection,
+// applies them.
+type VenafiRevocation struct {
+	CertificateArn string
+	// Thumbprint is the SHA-1 hash of the certificate, Venafi looks the certificate up by it.
+	Thumbprint string
+	// Reason is the Venafi revocation reason mapped from AWSReason.
+	Reason    string
+	AWSReason string
+	Comments  string
+	// Zone is the Venafi zone the certificate was issued in, empty for certificates not issued through the proxy.
+	Zone string `dynamodbav:",omitempty"`
+	// RevokedBy and SourceAccount identify the caller who revoked the certificate through the proxy.
+	RevokedBy     string `dynamodbav:",omitempty"`
+	SourceAccount string `dynamodbav:",omitempty"`
+	CreatedAt     time.Time
+	// TTL gives up on revocations Venafi didn't accept in time, in Unix seconds.
+	TTL int64
+}
+
+func init() {
+	revocationQueueTableName = os.Getenv("DYNAMODB_REVOCATION_QUEUE_TABLE")
+	if revocationQueueTableName == "" {
+		revocationQueueTableName = "VenafiRevocationQueue"
+	}
+}
+
+func SaveVenafiRevocation(r VenafiRevocation) error {
+	av, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(revocationQueueTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+func ListVenafiRevocations() ([]VenafiRevocation, error) {
+	var revocations []VenafiRevocation
+	p := dynamodb.NewScanPaginator(localDB.ScanRequest(&dynamodb.ScanInput{TableName: aws.String(revocationQueueTableName)}))
+	for p.Next(context.Background()) {
+		for _, item := range p.CurrentPage().Items {
+			var r VenafiRevocation
+			err := dynamodbattribute.UnmarshalMap(item, &r)
+			if err != nil {
+				return nil, err
+			}
+			revocations = append(revocations, r)
+		}
+	}
+	return revocations, p.Err()
+}
+
+func DeleteVenafiRevocation(certificateArn string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(revocationQueueTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			revocationQueueKey: {
+				S: aws.String(certificateArn),
+			},
+		},
+	}
+	_, err := localDB.DeleteItemRequest(input).Send(context.Background())
+	return err
+}
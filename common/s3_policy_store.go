@@ -0,0 +1,172 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policyBucket is the S3 bucket zone policies are stored in instead of the policy table, when set. Policies are JSON
+// objects under policyPrefix, the policy history and all other data stay in DynamoDB.
+var policyBucket string
+
+// policyPrefix is the key prefix of policy objects in the bucket.
+var policyPrefix string
+
+var policyS3 *s3.Client
+
+// policyObject is the content of the object of a zone policy. Policy is nil for zones created from requests whose
+// policy was not retrieved from Venafi yet.
+type policyObject struct {
+	PolicyID       string
+	Policy         *endpoint.Policy `json:",omitempty"`
+	PolicyVersion  string           `json:",omitempty"`
+	PolicyRevision int64            `json:",omitempty"`
+	LastSync       time.Time        `json:",omitempty"`
+	Pinned         bool             `json:",omitempty"`
+}
+
+// policyObjectCache keeps policy objects by key with their ETag, so unchanged objects aren't downloaded again.
+var policyObjectCache = struct {
+	sync.Mutex
+	objects map[string]cachedPolicyObject
+}{objects: map[string]cachedPolicyObject{}}
+
+type cachedPolicyObject struct {
+	etag   string
+	object policyObject
+}
+
+// UsesPolicyBucket reports whether zone policies are stored in S3 instead of the policy table.
+func UsesPolicyBucket() bool {
+	return policyBucket != ""
+}
+
+// policyObjectKey escapes the zone name, which usually contains backslashes and spaces.
+func policyObjectKey(name string) string {
+	return policyPrefix + url.QueryEscape(name) + ".json"
+}
+
+func policyObjectName(key string) (string, bool) {
+	if !strings.HasPrefix(key, policyPrefix) || !strings.HasSuffix(key, ".json") {
+		return "", false
+	}
+	name, err := url.QueryUnescape(strings.TrimSuffix(strings.TrimPrefix(key, policyPrefix), ".json"))
+	return name, err == nil
+}
+
+// getPolicyObject reads the object of the zone. Unchanged objects are returned from the cache.
+func getPolicyObject(name string) (o policyObject, err error) {
+	key := policyObjectKey(name)
+	policyObjectCache.Lock()
+	cached, ok := policyObjectCache.objects[key]
+	policyObjectCache.Unlock()
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(policyBucket),
+		Key:    aws.String(key),
+	}
+	if ok {
+		input.IfNoneMatch = aws.String(cached.etag)
+	}
+	resp, err := policyS3.GetObjectRequest(input).Send(context.Background())
+	if rf, isFailure := err.(awserr.RequestFailure); ok && isFailure && rf.StatusCode() == http.StatusNotModified {
+		return cached.object, nil
+	}
+	if aerr, isAWSErr := err.(awserr.Error); isAWSErr && aerr.Code() == s3.ErrCodeNoSuchKey {
+		forgetPolicyObject(key)
+		return o, PolicyNotFound
+	} else if err != nil {
+		return o, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&o)
+	if err != nil {
+		return o, err
+	}
+	policyObjectCache.Lock()
+	policyObjectCache.objects[key] = cachedPolicyObject{etag: aws.StringValue(resp.ETag), object: o}
+	policyObjectCache.Unlock()
+	return o, nil
+}
+
+func putPolicyObject(o policyObject) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	key := policyObjectKey(o.PolicyID)
+	resp, err := policyS3.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(policyBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	}).Send(context.Background())
+	if err != nil {
+		return err
+	}
+	policyObjectCache.Lock()
+	policyObjectCache.objects[key] = cachedPolicyObject{etag: aws.StringValue(resp.ETag), object: o}
+	policyObjectCache.Unlock()
+	return nil
+}
+
+func deletePolicyObject(name string) error {
+	key := policyObjectKey(name)
+	_, err := policyS3.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(policyBucket),
+		Key:    aws.String(key),
+	}).Send(context.Background())
+	forgetPolicyObject(key)
+	return err
+}
+
+func forgetPolicyObject(key string) {
+	policyObjectCache.Lock()
+	delete(policyObjectCache.objects, key)
+	policyObjectCache.Unlock()
+}
+
+// listPolicyObjectNames returns the zones with a policy object in the bucket.
+func listPolicyObjectNames() ([]string, error) {
+	var names []string
+	p := s3.NewListObjectsV2Paginator(policyS3.ListObjectsV2Request(&s3.ListObjectsV2Input{
+		Bucket: aws.String(policyBucket),
+		Prefix: aws.String(policyPrefix),
+	}))
+	for p.Next(context.Background()) {
+		for _, object := range p.CurrentPage().Contents {
+			if name, ok := policyObjectName(aws.StringValue(object.Key)); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, p.Err()
+}
+
+// policy returns the policy of the object, PolicyFoundButEmpty for zones which weren't synced yet.
+func (o policyObject) policy() (endpoint.Policy, error) {
+	if o.Policy == nil {
+		return endpoint.Policy{}, PolicyFoundButEmpty
+	}
+	return *o.Policy, nil
+}
+
+func (o policyObject) zoneStatus() ZoneStatus {
+	return ZoneStatus{
+		Name:           o.PolicyID,
+		PolicyVersion:  o.PolicyVersion,
+		PolicyRevision: o.PolicyRevision,
+		LastSync:       o.LastSync,
+		Synced:         o.PolicyVersion != "",
+		Pinned:         o.Pinned,
+	}
+}
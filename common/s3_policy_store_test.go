@@ -0,0 +1,35 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyObjectKey(t *testing.T) {
+	for _, name := range []string{"Default", "Amazon\\PCA Policy", "Business App\\Enterprise CIT", "tpp-prod:\\Policy\\Web"} {
+		key := policyObjectKey(name)
+		got, ok := policyObjectName(key)
+		if !ok || got != name {
+			t.Errorf("zone %q: key %q maps to %q", name, key, got)
+		}
+	}
+	if key := policyObjectKey("Amazon\\PCA Policy"); key != "policies/Amazon%5CPCA+Policy.json" {
+		t.Errorf("unexpected key %q", key)
+	}
+	for _, key := range []string{"policies/", "policies/Default", "other/Default.json", "policies/%zz.json"} {
+		if _, ok := policyObjectName(key); ok {
+			t.Errorf("key %q should not be a policy object", key)
+		}
+	}
+}
+
+func TestPolicyObjectZoneStatus(t *testing.T) {
+	synced := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	z := policyObject{PolicyID: "Default", PolicyVersion: "abc", PolicyRevision: 3, LastSync: synced, Pinned: true}.zoneStatus()
+	if z.Name != "Default" || !z.Synced || !z.Pinned || z.PolicyRevision != 3 || !z.LastSync.Equal(synced) {
+		t.Errorf("unexpected zone status %+v", z)
+	}
+	if (policyObject{PolicyID: "Default"}).zoneStatus().Synced {
+		t.Error("empty policy object should not be synced")
+	}
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"encoding/json"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const sharedPolicyCacheKeyPrefix = "venafi:policy:"
+
+const defaultSharedPolicyCacheTTL = time.Hour
+
+// sharedPolicyCache is the Redis cache of policies shared by all Lambda containers, nil when POLICY_CACHE_REDIS_URL
+// isn't set. Policy writes update it right away, so its TTL only limits how long a missed update is served.
+var sharedPolicyCache *redisClient
+
+var sharedPolicyCacheTTL = defaultSharedPolicyCacheTTL
+
+func init() {
+	if v := os.Getenv("POLICY_CACHE_REDIS_URL"); v != "" {
+		c, err := newRedisClient(v)
+		if err != nil {
+			log.Printf("%s, shared policy cache is disabled", err)
+		} else {
+			sharedPolicyCache = c
+		}
+	}
+	if v := os.Getenv("POLICY_CACHE_REDIS_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl < time.Second {
+			log.Printf("Invalid POLICY_CACHE_REDIS_TTL %q, using %s", v, defaultSharedPolicyCacheTTL)
+		} else {
+			sharedPolicyCacheTTL = ttl
+		}
+	}
+}
+
+// GetSharedCachedPolicy returns the policy of the zone from the shared cache. Cache failures are logged and reported
+// as a miss, so policies are read from the database.
+func GetSharedCachedPolicy(name string) (p endpoint.Policy, ok bool) {
+	if sharedPolicyCache == nil {
+		return p, false
+	}
+	reply, err := sharedPolicyCache.do("GET", sharedPolicyCacheKeyPrefix+name)
+	if err == redisNil {
+		return p, false
+	} else if err != nil {
+		log.Printf("Can't read policy %s from shared cache: %s", name, err)
+		return p, false
+	}
+	s, _ := reply.(string)
+	err = json.Unmarshal([]byte(s), &p)
+	if err != nil {
+		log.Printf("Policy %s in shared cache is invalid: %s", name, err)
+		return p, false
+	}
+	return p, true
+}
+
+// SetSharedCachedPolicy puts the policy of the zone in the shared cache, replacing the cached one. Only policy writes
+// set it, reads fill the cache with FillSharedCachedPolicy.
+func SetSharedCachedPolicy(name string, p endpoint.Policy) {
+	setSharedCachedPolicy(name, p)
+}
+
+// FillSharedCachedPolicy puts the policy of the zone read from the database in the shared cache, unless the cache has
+// one already. A read which raced a policy write would overwrite the new policy with the old one otherwise.
+func FillSharedCachedPolicy(name string, p endpoint.Policy) {
+	setSharedCachedPolicy(name, p, "NX")
+}
+
+func setSharedCachedPolicy(name string, p endpoint.Policy, options ...string) {
+	if sharedPolicyCache == nil {
+		return
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Can't cache policy %s: %s", name, err)
+		return
+	}
+	ttl := strconv.FormatInt(int64(sharedPolicyCacheTTL/time.Second), 10)
+	args := append([]string{"SET", sharedPolicyCacheKeyPrefix + name, string(b), "EX", ttl}, options...)
+	_, err = sharedPolicyCache.do(args...)
+	// SET with NX replies nil when the key exists
+	if err != nil && err != redisNil {
+		log.Printf("Can't put policy %s in shared cache: %s", name, err)
+	}
+}
+
+// forgetSharedCachedPolicy drops the policy of the zone from the shared cache.
+func forgetSharedCachedPolicy(name string) {
+	if sharedPolicyCache == nil {
+		return
+	}
+	_, err := sharedPolicyCache.do("DEL", sharedPolicyCacheKeyPrefix+name)
+	if err != nil {
+		log.Printf("Can't drop policy %s from shared cache: %s", name, err)
+	}
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"strconv"
+	"time"
+)
+
+var uploadTableName string
+
+const uploadKey = "UploadID"
+
+const UploadNotFound venafiError = "upload not found, expired or already processed"
+
+// Upload is a request body uploaded to S3 instead of being sent through API Gateway. It keeps the identity of the
+// caller who got the upload URL, the upload itself is processed without API Gateway context.
+type Upload struct {
+	UploadID      string
+	Target        string
+	CallerArn     string
+	SourceAccount string
+	SourceIP      string
+	// Headers are request headers which apply to the uploaded requests, e.g. the target region.
+	Headers     map[string]string `dynamodbav:",omitempty"`
+	CreatedAt   time.Time
+	ProcessedAt *time.Time `dynamodbav:",omitempty"`
+	// Offset is the index of the first request in the upload object. An upload the worker ran out of time for is
+	// continued with the remaining requests in a new object.
+	Offset int `dynamodbav:",omitempty"`
+	// TTL is the expiration of the upload in Unix seconds, used by DynamoDB to delete old records.
+	TTL int64
+}
+
+func init() {
+	uploadTableName = os.Getenv("DYNAMODB_UPLOAD_TABLE")
+	if uploadTableName == "" {
+		uploadTableName = "VenafiUploads"
+	}
+}
+
+func SaveUpload(u Upload) error {
+	av, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(uploadTableName),
+	}
+	_, err = localDB.PutItemRequest(input).Send(context.Background())
+	return err
+}
+
+// ClaimUpload marks an unprocessed upload as processed and returns it. S3 may deliver an event more than once, the
+// conditional update makes sure an upload is processed once.
+func ClaimUpload(uploadID string, now time.Time) (u Upload, err error) {
+	processedAt, err := dynamodbattribute.Marshal(now.UTC())
+	if err != nil {
+		return
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(uploadTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			uploadKey: {
+				S: aws.String(uploadID),
+			},
+		},
+		UpdateExpression:          aws.String("SET ProcessedAt = :at"),
+		ConditionExpression:       aws.String("attribute_exists(" + uploadKey + ") AND attribute_not_exists(ProcessedAt)"),
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{":at": *processedAt},
+		ReturnValues:              dynamodb.ReturnValueAllNew,
+	}
+	result, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		err = UploadNotFound
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &u)
+	return
+}
+
+// ReleaseUpload makes a claimed upload claimable again, for the object with its remaining requests from the offset on.
+func ReleaseUpload(uploadID string, offset int) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(uploadTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			uploadKey: {
+				S: aws.String(uploadID),
+			},
+		},
+		UpdateExpression:    aws.String("SET #offset = :offset REMOVE ProcessedAt"),
+		ConditionExpression: aws.String("attribute_exists(ProcessedAt)"),
+		// Offset is a reserved word.
+		ExpressionAttributeNames:  map[string]string{"#offset": "Offset"},
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{":offset": {N: aws.String(strconv.Itoa(offset))}},
+	}
+	_, err := localDB.UpdateItemRequest(input).Send(context.Background())
+	return err
+}
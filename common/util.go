@@ -0,0 +1,15 @@
+package common
+
+import "strings"
+
+// SplitList parses a comma separated environment variable value.
+func SplitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"strings"
+)
+
+const InvalidCloudZone venafiError = "Venafi as a Service zone must be Application\\Issuing Template Alias"
+
+// VenafiConnection holds the settings of the connection to Trust Protection Platform or Venafi as a Service (TLS
+// Protect Cloud). TPP settings take precedence when both are set.
+type VenafiConnection struct {
+	TPPURL          string
+	TPPUser         string
+	TPPPassword     string
+	TPPAccessToken  string
+	TPPRefreshToken string
+	// TrustBundle is the PEM bundle trusted for TPP connections, inline or base64 encoded.
+	TrustBundle string
+	// CloudURL overrides the Venafi as a Service API URL, empty uses the production one.
+	CloudURL    string
+	CloudAPIKey string
+}
+
+// Config returns the vcert configuration of the connection. TPP access and refresh tokens are preferred over the
+// user and password.
+func (c VenafiConnection) Config() (vcert.Config, error) {
+	var config vcert.Config
+	switch {
+	case c.TPPURL != "" && (c.TPPAccessToken != "" || c.TPPRefreshToken != ""):
+		config = vcert.Config{
+			ConnectorType: endpoint.ConnectorTypeTPP,
+			BaseUrl:       c.TPPURL,
+			Credentials: &endpoint.Authentication{
+				AccessToken:  c.TPPAccessToken,
+				RefreshToken: c.TPPRefreshToken,
+			},
+		}
+	case c.TPPURL != "" && c.TPPUser != "" && c.TPPPassword != "":
+		config = vcert.Config{
+			ConnectorType: endpoint.ConnectorTypeTPP,
+			BaseUrl:       c.TPPURL,
+			Credentials: &endpoint.Authentication{
+				User:     c.TPPUser,
+				Password: c.TPPPassword,
+			},
+		}
+	case c.CloudAPIKey != "":
+		config = vcert.Config{
+			ConnectorType: endpoint.ConnectorTypeCloud,
+			BaseUrl:       c.CloudURL,
+			Credentials: &endpoint.Authentication{
+				APIKey: c.CloudAPIKey,
+			},
+		}
+	default:
+		return config, fmt.Errorf("no Venafi credentials: set TPP URL with an access token, refresh token or user and password, or a Venafi as a Service API key")
+	}
+	if config.ConnectorType == endpoint.ConnectorTypeTPP && c.TrustBundle != "" {
+		bundle, err := decodeTrustBundle(c.TrustBundle)
+		if err != nil {
+			return config, err
+		}
+		config.ConnectionTrust = bundle
+	}
+	return config, nil
+}
+
+// decodeTrustBundle returns the PEM of an inline or base64 encoded trust bundle. The bundle must hold at least one
+// certificate, so a bad bundle fails at startup instead of on the first TLS handshake with TPP.
+func decodeTrustBundle(bundle string) (string, error) {
+	bundle = strings.TrimSpace(bundle)
+	if !strings.HasPrefix(bundle, "-----BEGIN") {
+		buf, err := base64.StdEncoding.DecodeString(bundle)
+		if err != nil {
+			return "", fmt.Errorf("can't decode trust bundle: %s", err)
+		}
+		bundle = string(buf)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(bundle)) {
+		return "", fmt.Errorf("trust bundle has no PEM certificates")
+	}
+	return bundle, nil
+}
+
+// ParseCloudZone splits a Venafi as a Service zone into the application name and the issuing template alias, e.g.
+// "Business App\Enterprise CIT".
+func ParseCloudZone(zone string) (app, template string, err error) {
+	parts := strings.Split(zone, "\\")
+	if len(parts) != 2 {
+		return "", "", InvalidCloudZone
+	}
+	app, template = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if app == "" || template == "" {
+		return "", "", InvalidCloudZone
+	}
+	return app, template, nil
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"testing"
+)
+
+func TestParseCloudZone(t *testing.T) {
+	app, template, err := ParseCloudZone("Business App\\Enterprise CIT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app != "Business App" || template != "Enterprise CIT" {
+		t.Errorf("unexpected application %q and template %q", app, template)
+	}
+	for _, zone := range []string{"", "Business App", "\\Enterprise CIT", "Business App\\", "\\VED\\Policy\\Amazon"} {
+		if _, _, err := ParseCloudZone(zone); err != InvalidCloudZone {
+			t.Errorf("zone %q should be invalid, got %v", zone, err)
+		}
+	}
+}
+
+func TestVenafiConnectionConfig(t *testing.T) {
+	config, err := VenafiConnection{CloudURL: "https://api.venafi.eu", CloudAPIKey: "key"}.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ConnectorType != endpoint.ConnectorTypeCloud || config.BaseUrl != "https://api.venafi.eu" || config.Credentials.APIKey != "key" {
+		t.Errorf("unexpected cloud config %+v", config)
+	}
+
+	config, err = VenafiConnection{TPPURL: "https://tpp.example.com", TPPUser: "user", TPPPassword: "password", TPPAccessToken: "token", CloudAPIKey: "key"}.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ConnectorType != endpoint.ConnectorTypeTPP || config.Credentials.AccessToken != "token" || config.Credentials.User != "" {
+		t.Errorf("access token should take precedence, got %+v", config)
+	}
+
+	_, err = VenafiConnection{TPPURL: "https://tpp.example.com", TPPAccessToken: "token", TrustBundle: "bm90IGEgYnVuZGxl"}.Config()
+	if err == nil {
+		t.Error("trust bundle without certificates should fail")
+	}
+
+	_, err = VenafiConnection{TPPURL: "https://tpp.example.com"}.Config()
+	if err == nil {
+		t.Error("connection without credentials should fail")
+	}
+}
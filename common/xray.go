@@ -0,0 +1,179 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultXRayDaemonAddress = "127.0.0.1:2000"
+
+// xrayHeader precedes every segment document sent to the X-Ray daemon.
+const xrayHeader = `{"format": "json", "version": 1}` + "\n"
+
+// NamespaceAWS marks subsegments of AWS service calls, which are shown as their service in the service map.
+const NamespaceAWS = "aws"
+
+// Trace sends subsegments of the segment of a Lambda invocation to the X-Ray daemon, the Lambda service records the
+// segment itself when active tracing is enabled. Subsegments are sent as UDP datagrams when they are closed, so
+// tracing never fails or delays the request. A nil Trace, e.g. of an invocation which isn't sampled, records nothing.
+type Trace struct {
+	traceID  string
+	parentID string
+	mu       sync.Mutex
+	conn     net.Conn
+}
+
+// Subsegment is a timed part of the invocation, e.g. a downstream call.
+type Subsegment struct {
+	trace *Trace
+	doc   subsegmentDocument
+}
+
+type subsegmentDocument struct {
+	ID          string                 `json:"id"`
+	TraceID     string                 `json:"trace_id"`
+	ParentID    string                 `json:"parent_id"`
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Namespace   string                 `json:"namespace,omitempty"`
+	StartTime   float64                `json:"start_time"`
+	EndTime     float64                `json:"end_time"`
+	Fault       bool                   `json:"fault,omitempty"`
+	Annotations map[string]string      `json:"annotations,omitempty"`
+	Cause       map[string]interface{} `json:"cause,omitempty"`
+}
+
+// StartTrace returns the trace of the invocation with the X-Ray trace header, e.g.
+// Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1. Invocations which aren't sampled, or
+// run without active tracing, get a nil Trace.
+func StartTrace(header string) *Trace {
+	t := &Trace{}
+	sampled := false
+	for _, part := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Root":
+			t.traceID = kv[1]
+		case "Parent":
+			t.parentID = kv[1]
+		case "Sampled":
+			sampled = kv[1] == "1"
+		}
+	}
+	if !sampled || t.traceID == "" || t.parentID == "" {
+		return nil
+	}
+	return t
+}
+
+// BeginSubsegment starts a subsegment, which is sent when it's closed.
+func (t *Trace) BeginSubsegment(name, namespace string) *Subsegment {
+	if t == nil {
+		return nil
+	}
+	return &Subsegment{trace: t, doc: subsegmentDocument{
+		ID:        newXRayID(),
+		TraceID:   t.traceID,
+		ParentID:  t.parentID,
+		Type:      "subsegment",
+		Name:      name,
+		Namespace: namespace,
+		StartTime: xrayTime(time.Now()),
+	}}
+}
+
+// Annotate adds an annotation, e.g. the zone, which traces can be filtered by.
+func (s *Subsegment) Annotate(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.doc.Annotations == nil {
+		s.doc.Annotations = map[string]string{}
+	}
+	s.doc.Annotations[key] = value
+}
+
+// Close ends the subsegment and sends it. An error marks the subsegment as a fault with the error as its cause.
+func (s *Subsegment) Close(err error) {
+	if s == nil {
+		return
+	}
+	s.doc.EndTime = xrayTime(time.Now())
+	if err != nil {
+		s.doc.Fault = true
+		s.doc.Cause = map[string]interface{}{
+			"exceptions": []map[string]string{{"id": newXRayID(), "message": err.Error()}},
+		}
+	}
+	b, err := json.Marshal(s.doc)
+	if err != nil {
+		log.Println("Can't marshal X-Ray subsegment:", err)
+		return
+	}
+	s.trace.send(append([]byte(xrayHeader), b...))
+}
+
+func (t *Trace) send(b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		conn, err := net.Dial("udp", xrayDaemonAddress(os.Getenv("AWS_XRAY_DAEMON_ADDRESS")))
+		if err != nil {
+			log.Println("Can't connect to X-Ray daemon:", err)
+			return
+		}
+		t.conn = conn
+	}
+	_, err := t.conn.Write(b)
+	if err != nil {
+		log.Println("Can't send X-Ray subsegment:", err)
+	}
+}
+
+// Close releases the connection to the daemon.
+func (t *Trace) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+// xrayDaemonAddress returns the UDP address of AWS_XRAY_DAEMON_ADDRESS, which is either host:port or a UDP and a TCP
+// address, e.g. "udp:169.254.79.2:2000 tcp:169.254.79.2:2000".
+func xrayDaemonAddress(v string) string {
+	for _, addr := range strings.Fields(v) {
+		if strings.HasPrefix(addr, "udp:") {
+			return strings.TrimPrefix(addr, "udp:")
+		}
+		if !strings.Contains(addr, "tcp:") {
+			return addr
+		}
+	}
+	return defaultXRayDaemonAddress
+}
+
+func xrayTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// newXRayID returns a random 64-bit identifier in hex, as X-Ray expects for segments and exceptions.
+func newXRayID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestStartTrace(t *testing.T) {
+	tr := StartTrace("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	if tr == nil || tr.traceID != "1-5759e988-bd862e3fe1be46a994272793" || tr.parentID != "53995c3f42cd8ad8" {
+		t.Fatalf("unexpected trace %+v", tr)
+	}
+	for _, header := range []string{"", "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0", "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1"} {
+		if tr := StartTrace(header); tr != nil {
+			t.Errorf("header %q should not be traced", header)
+		}
+	}
+	var untraced *Trace
+	s := untraced.BeginSubsegment("GetPolicy", "")
+	s.Annotate("zone", "Default")
+	s.Close(errors.New("untraced subsegments should be ignored"))
+	untraced.Close()
+}
+
+func TestXRayDaemonAddress(t *testing.T) {
+	for v, addr := range map[string]string{
+		"":                                    defaultXRayDaemonAddress,
+		"169.254.79.2:2000":                   "169.254.79.2:2000",
+		"udp:10.0.0.1:2000 tcp:10.0.0.2:2000": "10.0.0.1:2000",
+		"tcp:10.0.0.2:2000 udp:10.0.0.1:2000": "10.0.0.1:2000",
+	} {
+		if got := xrayDaemonAddress(v); got != addr {
+			t.Errorf("address %q: expected %s, got %s", v, addr, got)
+		}
+	}
+}
+
+func TestSendSubsegment(t *testing.T) {
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+	defer os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
+	os.Setenv("AWS_XRAY_DAEMON_ADDRESS", daemon.LocalAddr().String())
+
+	tr := StartTrace("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	defer tr.Close()
+	s := tr.BeginSubsegment("ACMPCA", NamespaceAWS)
+	s.Annotate("operation", "IssueCertificate")
+	s.Close(errors.New("ThrottlingException"))
+
+	b := make([]byte, 4096)
+	n, _, err := daemon.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := bytes.SplitN(b[:n], []byte("\n"), 2)
+	if len(parts) != 2 || string(parts[0])+"\n" != xrayHeader {
+		t.Fatalf("datagram should start with the header, got %s", b[:n])
+	}
+	var doc subsegmentDocument
+	err = json.Unmarshal(parts[1], &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != "subsegment" || doc.TraceID != tr.traceID || doc.ParentID != tr.parentID || len(doc.ID) != 16 ||
+		doc.Namespace != NamespaceAWS || !doc.Fault || doc.EndTime < doc.StartTime || doc.Annotations["operation"] != "IssueCertificate" {
+		t.Errorf("unexpected subsegment %s", parts[1])
+	}
+}
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+	"time"
+)
+
+var zoneConfigTableName string
+
+// ZoneConfig holds proxy-side settings for a zone which are not part of the policy synced from Venafi.
+type ZoneConfig struct {
+	// Region is the AWS region where ACM and ACM PCA requests for the zone are sent.
+	Region string
+	// CertificateAuthorityArns lists CAs the zone is bound to in failover order, e.g. replicas of a subordinate CA
+	// in other regions.
+	CertificateAuthorityArns []string
+	// RoleArn is assumed for ACM and ACM PCA requests of the zone to issue certificates in another account.
+	RoleArn string
+	// RequireDNSSAN rejects requests without DNS name SANs, since CN-only certificates are rejected by modern clients.
+	RequireDNSSAN bool
+	// ForbidCommonName rejects CSRs with a common name, so names are only carried in SANs.
+	ForbidCommonName bool
+	// RequireCommonNameInSANs rejects requests whose common name is not also requested as a SAN, as browsers ignore
+	// the common name.
+	RequireCommonNameInSANs bool
+	// AutoCorrect rewrites IssueCertificate requests violating the policy to comply instead of rejecting them: locked
+	// subject attributes are set and SANs not allowed are dropped.
+	AutoCorrect bool
+	// DeniedDomains are domains whose names and subdomains are rejected in the zone, even if the Venafi policy allows
+	// them, e.g. corporate apex or partner domains.
+	DeniedDomains []string
+	// ForbidWildcards rejects wildcard names even if the Venafi policy allows them.
+	ForbidWildcards bool
+	// WildcardDomains restricts wildcard names to these domains and their subdomains, e.g. *.prod.example.com is
+	// allowed with prod.example.com. Wildcards are not restricted when empty.
+	WildcardDomains []string
+	// ForbidKeyExport rejects ACM ExportCertificate requests for certificates of the zone, so their private keys stay
+	// in ACM.
+	ForbidKeyExport bool
+	// CertificateTransparencyLogging is the certificate transparency logging preference of public ACM certificates in
+	// the zone, ENABLED or DISABLED. Empty leaves it to the requester.
+	CertificateTransparencyLogging string
+	// AllowedKeyCurves restricts elliptic curves of ECDSA keys in the zone (e.g. P256, P384, P521) on top of the policy.
+	AllowedKeyCurves []string
+	// AllowedExtendedKeyUsages restricts extended key usages of certificates in the zone, by name (e.g. serverAuth,
+	// clientAuth, codeSigning) or OID. Usages are not restricted when empty.
+	AllowedExtendedKeyUsages []string
+	// MaxValidityDays is the maximum validity of certificates issued in the zone, zero means no limit. Venafi policies
+	// synced through vcert don't carry the validity, so it's configured here.
+	MaxValidityDays int
+	// MaxSANs is the maximum number of SANs of all types in a request, zero means no limit.
+	MaxSANs int
+	// MaxNameLength is the maximum length of the common name and of each SAN, zero means no limit.
+	MaxNameLength int
+	// MaxCSRSize is the maximum size of the DER encoded CSR in bytes, zero means no limit.
+	MaxCSRSize int
+	// MaxExtensionsSize is the maximum total size of extension values requested in the CSR in bytes, zero means no limit.
+	MaxExtensionsSize int
+	// DNSCheck enables resolving requested names before issuance: "warn" only logs names missing in DNS, "deny" rejects them.
+	DNSCheck string
+	// VerifyOwnership requires every requested name to fall under a Route 53 hosted zone of an allowed account.
+	VerifyOwnership bool
+	// OwnershipRoleArns are roles assumed to list hosted zones of the allowed accounts. The Lambda's own account is
+	// used when empty.
+	OwnershipRoleArns []string
+	// RenewalPolicyZone is the zone whose policy applies to renewals instead of the zone's own policy, e.g. to let
+	// renewals keep a legacy SAN set while new certificates must comply.
+	RenewalPolicyZone string
+	// RenewalPolicyUntil ends the renewal policy, renewals use the zone's own policy afterwards. Zero means no end.
+	RenewalPolicyUntil time.Time
+	// FreezeWindows are change-freeze windows during which issuance in the zone is rejected.
+	FreezeWindows []FreezeWindow
+	// AllowedSourceRegions restricts requests to source IPs in published AWS IP ranges of these regions.
+	AllowedSourceRegions []string
+	// AllowedCountries restricts requests to these ISO country codes, as geolocated by CloudFront.
+	AllowedCountries []string
+	// RequiredTags are tag keys every request in the zone must carry with a non-empty value.
+	RequiredTags []string
+	// EnforcementMode is "enforce" (default) to reject requests violating rules, or "audit" to only log violations.
+	EnforcementMode string
+	// NotificationEmails get emails about certificate requests in the zone.
+	NotificationEmails []string
+	// NotificationEvents limits emails to these events: "issued" and "denied". All events are sent when empty.
+	NotificationEvents []string
+	// PassthroughExtensions are OIDs of CSR extensions passed to ACM PCA with API pass-through, ACM PCA ignores them
+	// otherwise. Certificate policies (2.5.29.32) are passed as policies, other extensions as custom extensions.
+	PassthroughExtensions []string
+	// PassthroughTemplateArn is the APIPassthrough template certificates with passed extensions are issued with.
+	// EndEntityCertificate_APIPassthrough/V1 is used when empty.
+	PassthroughTemplateArn string
+}
+
+// FreezeWindow is a recurring change-freeze period.
+type FreezeWindow struct {
+	// Schedule is a five field cron expression (minute hour day-of-month month day-of-week) of the window start.
+	Schedule string
+	// Duration is how long the window lasts after each start, e.g. "62h".
+	Duration string
+	// TimeZone is the IANA time zone of the schedule, UTC by default.
+	TimeZone string
+	// Reason is returned to callers whose requests are rejected.
+	Reason string
+}
+
+func init() {
+	zoneConfigTableName = os.Getenv("DYNAMODB_ZONE_CONFIG_TABLE")
+	if zoneConfigTableName == "" {
+		zoneConfigTableName = "VenafiZoneConfig"
+	}
+}
+
+// GetZoneConfig returns settings for the zone. Empty config is returned when the zone has no settings.
+func GetZoneConfig(name string) (c ZoneConfig, err error) {
+	if UsesPolicyFiles() {
+		return getPolicyFileZoneConfig(name)
+	}
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(zoneConfigTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			primaryKey: {
+				S: aws.String(name),
+			},
+		},
+	}
+
+	result, err := db.GetItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	if result.Item == nil {
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &c)
+	return
+}
+
+func SaveZoneConfig(name string, c ZoneConfig) error {
+	if UsesPolicyFiles() {
+		return savePolicyFileZoneConfig(name, c)
+	}
+	av, err := dynamodbattribute.MarshalMap(c)
+	if err != nil {
+		return err
+	}
+	av[primaryKey] = dynamodb.AttributeValue{S: aws.String(name)}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(zoneConfigTableName),
+	}
+
+	_, err = db.PutItemRequest(input).Send(context.Background())
+	return err
+}
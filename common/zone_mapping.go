@@ -0,0 +1,62 @@
+package common
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"os"
+)
+
+var zoneMappingTableName string
+
+const zoneMappingKey = "MappingKey"
+
+const ZoneMappingNotFound venafiError = "zone mapping not found"
+
+// ZoneMapping assigns the Venafi zone to requests of a caller or for a CA, so callers can't pick the zone of another
+// team.
+type ZoneMapping struct {
+	// MappingKey is the ARN of the CA certificates are issued by, the caller ARN, the IAM role ARN of assumed role
+	// sessions, or the account ID.
+	MappingKey string
+	// Zone is used for requests without a zone.
+	Zone string
+	// AllowedZones are other zones the caller may request explicitly.
+	AllowedZones []string
+	// FallbackZones are tried in order for requests without a zone when the policy of Zone isn't in the database yet.
+	FallbackZones []string
+}
+
+// ZoneChain returns the zones requests without a zone may be resolved to, in order of preference.
+func (m ZoneMapping) ZoneChain() []string {
+	return append([]string{m.Zone}, m.FallbackZones...)
+}
+
+func init() {
+	zoneMappingTableName = os.Getenv("DYNAMODB_ZONE_MAPPING_TABLE")
+	if zoneMappingTableName == "" {
+		zoneMappingTableName = "VenafiZoneMappings"
+	}
+}
+
+func GetZoneMapping(key string) (m ZoneMapping, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(zoneMappingTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			zoneMappingKey: {
+				S: aws.String(key),
+			},
+		},
+	}
+	result, err := db.GetItemRequest(input).Send(context.Background())
+	if err != nil {
+		return
+	}
+	if result.Item == nil {
+		err = ZoneMappingNotFound
+		return
+	}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &m)
+	return
+}
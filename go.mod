@@ -6,4 +6,5 @@ require (
 	github.com/Venafi/vcert/v4 v4.13.1
 	github.com/aws/aws-lambda-go v1.12.0
 	github.com/aws/aws-sdk-go-v2 v0.9.0
+	golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
 )
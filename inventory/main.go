@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"log"
+	"os"
+)
+
+// HandleRequest collects ACM certificates of every account in the Organization into the inventory table.
+func HandleRequest() error {
+	ctx := context.Background()
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		log.Println("can`t load aws config", err)
+		return err
+	}
+	ownAccount, err := getAccountID(ctx, cfg)
+	if err != nil {
+		log.Println("getting own account error:", err)
+		return err
+	}
+
+	accounts := []string{ownAccount}
+	roleName := os.Getenv("INVENTORY_ROLE_NAME")
+	if roleName != "" {
+		accounts, err = listOrganizationAccounts(ctx, cfg)
+		if err != nil {
+			log.Println("listing organization accounts error:", err)
+			return err
+		}
+	}
+	regions := common.SplitList(os.Getenv("INVENTORY_REGIONS"))
+	if len(regions) == 0 {
+		regions = []string{cfg.Region}
+	}
+
+	var failed int
+	for _, account := range accounts {
+		accountCfg := cfg.Copy()
+		if account != ownAccount {
+			roleArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", account, roleName)
+			accountCfg.Credentials = common.AssumeRoleCredentials(cfg, roleArn)
+		}
+		for _, region := range regions {
+			log.Printf("Collecting certificates of account %s in region %s", account, region)
+			accountCfg.Region = region
+			err = collectCertificates(ctx, accountCfg, account, region)
+			if err != nil {
+				log.Printf("collecting certificates of account %s in region %s error: %s", account, region, err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to collect certificates in %d account regions", failed)
+	}
+	log.Println("success inventory processing")
+	return nil
+}
+
+// certificateKeyTypes are all key types of ACM certificates. ListCertificates only returns RSA 2048 certificates unless
+// the key types are given. RSA_3072 is missing from the SDK's enum.
+var certificateKeyTypes = []acm.KeyAlgorithm{
+	acm.KeyAlgorithmRsa1024,
+	acm.KeyAlgorithmRsa2048,
+	acm.KeyAlgorithm("RSA_3072"),
+	acm.KeyAlgorithmRsa4096,
+	acm.KeyAlgorithmEcPrime256v1,
+	acm.KeyAlgorithmEcSecp384r1,
+	acm.KeyAlgorithmEcSecp521r1,
+}
+
+func collectCertificates(ctx context.Context, cfg aws.Config, account, region string) error {
+	cli := acm.New(cfg)
+	input := &acm.ListCertificatesInput{Includes: &acm.Filters{KeyTypes: certificateKeyTypes}}
+	p := acm.NewListCertificatesPaginator(cli.ListCertificatesRequest(input))
+	for p.Next(ctx) {
+		for _, summary := range p.CurrentPage().CertificateSummaryList {
+			resp, err := cli.DescribeCertificateRequest(&acm.DescribeCertificateInput{CertificateArn: summary.CertificateArn}).Send(ctx)
+			if err != nil {
+				log.Printf("describe certificate %s error: %s", aws.StringValue(summary.CertificateArn), err)
+				continue
+			}
+			c := resp.Certificate
+			r := common.InventoryRecord{
+				CertificateArn:          aws.StringValue(c.CertificateArn),
+				AccountID:               account,
+				Region:                  region,
+				DomainName:              aws.StringValue(c.DomainName),
+				SubjectAlternativeNames: c.SubjectAlternativeNames,
+				Serial:                  aws.StringValue(c.Serial),
+				Subject:                 aws.StringValue(c.Subject),
+				Status:                  string(c.Status),
+				Type:                    string(c.Type),
+				CertificateAuthorityArn: aws.StringValue(c.CertificateAuthorityArn),
+				Source:                  common.InventorySourceDiscovery,
+			}
+			if c.NotAfter != nil {
+				r.NotAfter = *c.NotAfter
+			}
+			existing, err := common.GetInventoryRecord(r.CertificateArn)
+			if err == nil && existing.Source == common.InventorySourceProxy {
+				r = mergeDiscovered(existing, r)
+			}
+			err = common.SaveInventoryRecord(r)
+			if err != nil {
+				log.Println("save inventory record error:", err)
+			}
+		}
+	}
+	return p.Err()
+}
+
+// mergeDiscovered updates the record of a certificate issued through the proxy with what ACM reports about it. Only the
+// fields the inventory collects are replaced, everything the proxy recorded is kept.
+func mergeDiscovered(existing, discovered common.InventoryRecord) common.InventoryRecord {
+	r := existing
+	r.AccountID = discovered.AccountID
+	r.Region = discovered.Region
+	r.DomainName = discovered.DomainName
+	r.SubjectAlternativeNames = discovered.SubjectAlternativeNames
+	r.Serial = discovered.Serial
+	r.Subject = discovered.Subject
+	r.Status = discovered.Status
+	r.Type = discovered.Type
+	r.NotAfter = discovered.NotAfter
+	// Venafi imports of certificates issued with ACM PCA need the CA, which ACM reports for private certificates only.
+	if discovered.CertificateAuthorityArn != "" {
+		r.CertificateAuthorityArn = discovered.CertificateAuthorityArn
+	}
+	return r
+}
+
+func listOrganizationAccounts(ctx context.Context, cfg aws.Config) ([]string, error) {
+	var accounts []string
+	p := organizations.NewListAccountsPaginator(organizations.New(cfg).ListAccountsRequest(&organizations.ListAccountsInput{}))
+	for p.Next(ctx) {
+		for _, a := range p.CurrentPage().Accounts {
+			if a.Status == organizations.AccountStatusActive {
+				accounts = append(accounts, aws.StringValue(a.Id))
+			}
+		}
+	}
+	return accounts, p.Err()
+}
+
+func getAccountID(ctx context.Context, cfg aws.Config) (string, error) {
+	resp, err := sts.New(cfg).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{}).Send(ctx)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.Account), nil
+}
+
+func main() {
+	log.Println("Starting inventory lambda.")
+	lambda.Start(HandleRequest)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultNotifyDays = []int{30, 14, 7, 1}
+
+var (
+	// notifyDays are days before expiration owners are notified at, in descending order.
+	notifyDays      []int
+	ownerEmailTag   string
+	ownerTopicTag   string
+	emailSender     string
+	defaultTopicArn string
+	// allowedEmailDomains and allowedTopics restrict the owner tags of requests, which requesters choose freely.
+	allowedEmailDomains []string
+	allowedTopics       []string
+)
+
+// HandleRequest notifies owners of certificates issued through the proxy about their expiration. Owners are the
+// notification emails of the zone and the allowed contact tags of the certificate request, certificates without them
+// are reported to the default topic.
+func HandleRequest() error {
+	ctx := context.Background()
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		log.Println("can`t load aws config", err)
+		return err
+	}
+	now := time.Now()
+	records, err := common.ListInventoryRecordsByExpiry(now, now.AddDate(0, 0, notifyDays[0]))
+	if err != nil {
+		log.Println("getting expiring certificates error:", err)
+		return err
+	}
+
+	var failed int
+	zoneConfigs := make(map[string]common.ZoneConfig)
+	for _, r := range records {
+		if r.Source != common.InventorySourceProxy || r.Status == common.InventoryStatusRevoked {
+			continue
+		}
+		threshold := dueThreshold(daysLeft(r.NotAfter, now), notifyDays, r.NotifiedDays)
+		if threshold == 0 {
+			continue
+		}
+		zoneConfig, ok := zoneConfigs[r.Zone]
+		if !ok {
+			zoneConfig, err = common.GetZoneConfig(r.Zone)
+			if err != nil {
+				log.Printf("getting configuration of zone %s error: %s", r.Zone, err)
+				failed++
+				continue
+			}
+			zoneConfigs[r.Zone] = zoneConfig
+		}
+		err = notifyOwners(ctx, cfg, r, zoneConfig, now)
+		if err != nil {
+			log.Printf("notifying owners of certificate %s error: %s", r.CertificateArn, err)
+			failed++
+			continue
+		}
+		err = common.SetInventoryNotifiedDays(r.CertificateArn, threshold)
+		if err != nil {
+			log.Printf("saving notification of certificate %s error: %s", r.CertificateArn, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d notifications failed", failed, len(records))
+	}
+	log.Println("success expiration notifications")
+	return nil
+}
+
+// daysLeft returns the number of started days until the expiration.
+func daysLeft(notAfter, now time.Time) int {
+	return int(math.Ceil(notAfter.Sub(now).Hours() / 24))
+}
+
+// dueThreshold returns the notification threshold reached by a certificate expiring in days, or 0 if it was already
+// notified at that threshold.
+func dueThreshold(days int, thresholds []int, notified int) int {
+	due := 0
+	for _, t := range thresholds {
+		if days <= t {
+			due = t
+		}
+	}
+	if due == 0 || (notified != 0 && due >= notified) {
+		return 0
+	}
+	return due
+}
+
+func notifyOwners(ctx context.Context, cfg aws.Config, r common.InventoryRecord, zoneConfig common.ZoneConfig, now time.Time) error {
+	subject := fmt.Sprintf("Certificate for %s expires in %d days", r.DomainName, daysLeft(r.NotAfter, now))
+	message := fmt.Sprintf("Certificate %s for %s expires on %s.\n\nZone: %s\nAccount: %s\nRequested by: %s\n",
+		r.CertificateArn, r.DomainName, r.NotAfter.Format(time.RFC1123), r.Zone, r.SourceAccount, r.RequestedBy)
+
+	emails, topics := ownerContacts(r, zoneConfig)
+	if len(emails) == 0 && len(topics) == 0 {
+		if defaultTopicArn == "" {
+			log.Printf("Certificate %s has no owner contact tags and no default topic is set", r.CertificateArn)
+			return nil
+		}
+		topics = []string{defaultTopicArn}
+	}
+	if len(emails) > 0 {
+		if emailSender == "" {
+			return fmt.Errorf("owner email %v is set but NOTIFY_EMAIL_SENDER is not", emails)
+		}
+		log.Printf("Notifying %v about certificate %s", emails, r.CertificateArn)
+		err := common.SendEmail(ctx, cfg, emailSender, emails, subject, message)
+		if err != nil {
+			return err
+		}
+	}
+	for _, topic := range topics {
+		log.Printf("Notifying topic %s about certificate %s", topic, r.CertificateArn)
+		err := common.PublishNotification(ctx, cfg, topic, subject, message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownerContacts returns the addresses and topics notified about the certificate: the notification emails of its zone
+// and the owner tags of the request. Tagged addresses must be in an allowed domain and tagged topics must be allowed,
+// others are ignored, so requesters can't make the Lambda send to arbitrary recipients.
+func ownerContacts(r common.InventoryRecord, zoneConfig common.ZoneConfig) (emails, topics []string) {
+	emails = append(emails, zoneConfig.NotificationEmails...)
+	for _, address := range common.SplitList(r.Tags[ownerEmailTag]) {
+		if !common.EmailDomainAllowed(address, allowedEmailDomains) {
+			log.Printf("Ignoring owner email %s of certificate %s, its domain is not allowed", address, r.CertificateArn)
+			continue
+		}
+		if !containsFold(emails, address) {
+			emails = append(emails, address)
+		}
+	}
+	for _, topic := range common.SplitList(r.Tags[ownerTopicTag]) {
+		if !containsFold(allowedTopics, topic) {
+			log.Printf("Ignoring owner topic %s of certificate %s, it is not allowed", topic, r.CertificateArn)
+			continue
+		}
+		if !containsFold(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	return emails, topics
+}
+
+func containsFold(l []string, s string) bool {
+	for _, v := range l {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadNotifyDays() {
+	notifyDays = nil
+	for _, v := range common.SplitList(os.Getenv("NOTIFY_DAYS")) {
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid NOTIFY_DAYS value %q, ignoring it", v)
+			continue
+		}
+		notifyDays = append(notifyDays, d)
+	}
+	if len(notifyDays) == 0 {
+		notifyDays = defaultNotifyDays
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(notifyDays)))
+}
+
+func main() {
+	log.Println("Starting notification lambda.")
+	loadNotifyDays()
+	ownerEmailTag = os.Getenv("OWNER_EMAIL_TAG")
+	if ownerEmailTag == "" {
+		ownerEmailTag = "OwnerEmail"
+	}
+	ownerTopicTag = os.Getenv("OWNER_TOPIC_TAG")
+	if ownerTopicTag == "" {
+		ownerTopicTag = "OwnerTopicArn"
+	}
+	emailSender = os.Getenv("NOTIFY_EMAIL_SENDER")
+	defaultTopicArn = os.Getenv("DEFAULT_NOTIFY_TOPIC_ARN")
+	allowedEmailDomains = common.SplitList(os.Getenv("NOTIFY_ALLOWED_EMAIL_DOMAINS"))
+	allowedTopics = common.SplitList(os.Getenv("NOTIFY_ALLOWED_TOPICS"))
+	lambda.Start(HandleRequest)
+}
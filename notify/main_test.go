@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDueThreshold(t *testing.T) {
+	thresholds := []int{30, 14, 7, 1}
+	cases := []struct {
+		days, notified, want int
+	}{
+		{45, 0, 0},
+		{30, 0, 30},
+		{20, 0, 30},
+		{20, 30, 0},
+		{14, 30, 14},
+		{10, 14, 0},
+		{3, 30, 7},
+		{1, 7, 1},
+		{0, 1, 0},
+	}
+	for _, c := range cases {
+		if got := dueThreshold(c.days, thresholds, c.notified); got != c.want {
+			t.Errorf("dueThreshold(%d, notified %d) = %d, want %d", c.days, c.notified, got, c.want)
+		}
+	}
+}
+
+func TestDaysLeft(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	if d := daysLeft(now.Add(36*time.Hour), now); d != 2 {
+		t.Errorf("daysLeft = %d, want 2", d)
+	}
+	if d := daysLeft(now.AddDate(0, 0, 7), now); d != 7 {
+		t.Errorf("daysLeft = %d, want 7", d)
+	}
+}
+
+func TestOwnerContacts(t *testing.T) {
+	ownerEmailTag, ownerTopicTag = "OwnerEmail", "OwnerTopicArn"
+	allowedEmailDomains = []string{"example.com"}
+	allowedTopics = []string{"arn:aws:sns:us-east-1:123456789012:certs"}
+	defer func() { allowedEmailDomains, allowedTopics = nil, nil }()
+
+	r := common.InventoryRecord{CertificateArn: "arn", Tags: map[string]string{
+		"OwnerEmail":    "team@example.com, victim@evil.net, pki@example.com",
+		"OwnerTopicArn": "arn:aws:sns:us-east-1:123456789012:certs,arn:aws:sns:us-east-1:999999999999:relay",
+	}}
+	emails, topics := ownerContacts(r, common.ZoneConfig{NotificationEmails: []string{"pki@example.com"}})
+	if !reflect.DeepEqual(emails, []string{"pki@example.com", "team@example.com"}) {
+		t.Errorf("emails = %v", emails)
+	}
+	if !reflect.DeepEqual(topics, []string{"arn:aws:sns:us-east-1:123456789012:certs"}) {
+		t.Errorf("topics = %v", topics)
+	}
+}
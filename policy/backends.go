@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"log"
+	"sort"
+	"strings"
+)
+
+// venafiBackend is a Venafi instance policies are read from and certificates are imported into.
+type venafiBackend struct {
+	connector endpoint.Connector
+	config    vcert.Config
+}
+
+// backends are the Venafi instances configured besides the default connection, by name. Zones prefixed with the name
+// and a colon, e.g. "tpp-prod:\Policy\Web", use the backend.
+var backends = map[string]venafiBackend{}
+
+// failedBackends are the configured backends which couldn't be loaded, with the error. Their zones are skipped until
+// the next cold start, so a broken backend doesn't stop the others.
+var failedBackends = map[string]error{}
+
+// backendSecret is the JSON value of the secret a backend connection is configured with.
+type backendSecret struct {
+	TPPURL         string `json:"tpp_url"`
+	TPPUser        string `json:"tpp_user"`
+	TPPPassword    string `json:"tpp_password"`
+	TPPAccessToken string `json:"tpp_access_token"`
+	TrustBundle    string `json:"trust_bundle"`
+	CloudURL       string `json:"cloud_url"`
+	CloudAPIKey    string `json:"cloud_api_key"`
+}
+
+// loadBackends connects to the backends of the VENAFI_BACKENDS variable, a JSON object of backend names and the ARNs
+// of Secrets Manager secrets holding their connection settings. Only an invalid variable is an error, backends which
+// can't be loaded are logged and kept in failedBackends.
+func loadBackends(ctx context.Context, value string) error {
+	failedBackends = map[string]error{}
+	if value == "" {
+		return nil
+	}
+	var secrets map[string]string
+	err := json.Unmarshal([]byte(value), &secrets)
+	if err != nil {
+		return fmt.Errorf("VENAFI_BACKENDS must be a JSON object of backend names and secret ARNs: %s", err)
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		if !validBackendName(name) {
+			return fmt.Errorf("invalid Venafi backend name %q: use letters, digits, '-' and '_'", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("Getting Venafi connection of backend %s", name)
+		b, err := loadBackend(ctx, name, secrets[name])
+		if err != nil {
+			log.Printf("Venafi backend %s is unavailable: %s", name, err)
+			common.Monitor.Record(common.BackendVenafi, err)
+			failedBackends[name] = err
+			continue
+		}
+		backends[name] = b
+	}
+	return nil
+}
+
+// loadBackend connects to a backend with the settings of its secret.
+func loadBackend(ctx context.Context, name, secretArn string) (venafiBackend, error) {
+	s, err := readSecret(ctx, secretArn)
+	if err != nil {
+		return venafiBackend{}, err
+	}
+	var settings backendSecret
+	err = json.Unmarshal([]byte(s), &settings)
+	if err != nil {
+		return venafiBackend{}, fmt.Errorf("secret of Venafi backend %s is not JSON: %s", name, err)
+	}
+	b, err := newBackend(common.VenafiConnection{
+		TPPURL:         settings.TPPURL,
+		TPPUser:        settings.TPPUser,
+		TPPPassword:    settings.TPPPassword,
+		TPPAccessToken: settings.TPPAccessToken,
+		TrustBundle:    settings.TrustBundle,
+		CloudURL:       settings.CloudURL,
+		CloudAPIKey:    settings.CloudAPIKey,
+	})
+	if err != nil {
+		return venafiBackend{}, fmt.Errorf("can't connect to Venafi backend %s: %s", name, err)
+	}
+	return b, nil
+}
+
+func newBackend(conn common.VenafiConnection) (venafiBackend, error) {
+	config, err := conn.Config()
+	if err != nil {
+		return venafiBackend{}, err
+	}
+	err = configureClient(&config)
+	if err != nil {
+		return venafiBackend{}, err
+	}
+	connector, err := vcert.NewClient(&config)
+	if err != nil {
+		return venafiBackend{}, err
+	}
+	return venafiBackend{connector: connector, config: config}, nil
+}
+
+func validBackendName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// backendFor returns the backend of the zone and the name of the zone in the backend. Zones without the prefix of a
+// configured backend use the default connection, zones of a backend which failed to load return its error.
+func backendFor(zone string) (venafiBackend, string, error) {
+	if prefix := backendPrefix(zone); prefix != "" {
+		if b, ok := backends[prefix]; ok {
+			return b, zone[len(prefix)+1:], nil
+		}
+		if err, ok := failedBackends[prefix]; ok {
+			return venafiBackend{}, "", fmt.Errorf("Venafi backend %s of zone %s is unavailable: %s", prefix, zone, err)
+		}
+	}
+	return venafiBackend{connector: vcertConnector, config: connectionConfig}, zone, nil
+}
+
+// backendPrefix returns the backend name the zone is prefixed with, e.g. tpp-prod of tpp-prod:\Policy\Web, whether the
+// backend is configured or not.
+func backendPrefix(zone string) string {
+	if i := strings.Index(zone, ":"); i > 0 && validBackendName(zone[:i]) {
+		return zone[:i]
+	}
+	return ""
+}
+
+// zoneDeletable reports whether a zone which isn't found in Venafi may be deleted. Zones prefixed with a backend which
+// isn't configured, e.g. after VENAFI_BACKENDS was changed, are looked up in the default backend, which doesn't know
+// them, so they are kept.
+func zoneDeletable(zone string) bool {
+	prefix := backendPrefix(zone)
+	if prefix == "" {
+		return true
+	}
+	_, ok := backends[prefix]
+	return ok
+}
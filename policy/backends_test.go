@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Venafi/vcert/v4/pkg/venafi/cloud"
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+	"testing"
+)
+
+func TestBackendFor(t *testing.T) {
+	defaultConnector, err := tpp.NewConnector("https://tpp.example.com", "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaas, err := cloud.NewConnector("", "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vcertConnector = defaultConnector
+	backends = map[string]venafiBackend{"vaas": {connector: vaas}}
+	failedBackends = map[string]error{"tpp-dr": errors.New("secret not found")}
+	defer func() { backends, failedBackends = map[string]venafiBackend{}, map[string]error{} }()
+
+	cases := []struct {
+		zone, name string
+		vaas       bool
+	}{
+		{"\\VED\\Policy\\Web", "\\VED\\Policy\\Web", false},
+		{"vaas:App\\Tmpl", "App\\Tmpl", true},
+		{"tpp-prod:\\VED\\Policy\\Web", "tpp-prod:\\VED\\Policy\\Web", false},
+		{":App\\Tmpl", ":App\\Tmpl", false},
+	}
+	for _, c := range cases {
+		b, name, err := backendFor(c.zone)
+		if err != nil || name != c.name || (b.connector == vaas) != c.vaas {
+			t.Errorf("zone %q: unexpected backend or zone %q (%v)", c.zone, name, err)
+		}
+	}
+	if _, _, err := backendFor("tpp-dr:\\VED\\Policy\\Web"); err == nil {
+		t.Error("zones of a backend which failed to load should fail")
+	}
+	for zone, want := range map[string]bool{
+		"\\VED\\Policy\\Web":          true,
+		"vaas:App\\Tmpl":              true,
+		"tpp-prod:\\VED\\Policy\\Web": false,
+		"tpp-dr:\\VED\\Policy\\Web":   false,
+	} {
+		if zoneDeletable(zone) != want {
+			t.Errorf("zoneDeletable(%q) should be %v", zone, want)
+		}
+	}
+}
+
+func TestLoadBackendsConfiguration(t *testing.T) {
+	if err := loadBackends(context.Background(), ""); err != nil {
+		t.Error(err)
+	}
+	for _, value := range []string{"tpp-prod", `{"tpp prod": "arn:aws:secretsmanager:us-east-1:123456789012:secret:VenafiBackend"}`, `{"": "arn"}`} {
+		if err := loadBackends(context.Background(), value); err == nil {
+			t.Errorf("backends %s should be invalid", value)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"log"
+)
+
+// venafiClientCertificate is presented to TPP when its API gateway requires mutual TLS, nil presents none.
+var venafiClientCertificate *tls.Certificate
+
+// loadClientCertificate loads the TPP client certificate from the TPP_CLIENT_CERTIFICATE variable. It is the ARN of a
+// Secrets Manager secret holding the PEM certificate and private key, or of a private ACM certificate which is exported.
+func loadClientCertificate(ctx context.Context, source string) error {
+	if source == "" {
+		return nil
+	}
+	a, err := arn.Parse(source)
+	if err != nil {
+		return fmt.Errorf("client certificate %s is not the ARN of a secret or ACM certificate", source)
+	}
+	var cert tls.Certificate
+	switch a.Service {
+	case "secretsmanager":
+		log.Printf("Reading client certificate from secret %s", source)
+		var s string
+		s, err = readSecret(ctx, source)
+		if err != nil {
+			return err
+		}
+		cert, err = tls.X509KeyPair([]byte(s), []byte(s))
+	case "acm":
+		log.Printf("Exporting client certificate %s", source)
+		cert, err = exportClientCertificate(ctx, a.Region, source)
+	default:
+		return fmt.Errorf("client certificate %s is not the ARN of a secret or ACM certificate", source)
+	}
+	if err != nil {
+		return fmt.Errorf("can't load client certificate %s: %s", source, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("can't load client certificate %s: %s", source, err)
+	}
+	log.Printf("Using client certificate %s expiring %s", leaf.Subject, leaf.NotAfter)
+	venafiClientCertificate = &cert
+	return nil
+}
+
+// exportClientCertificate exports an ACM certificate issued by a private CA. The private key is encrypted with a
+// passphrase generated for the export only.
+func exportClientCertificate(ctx context.Context, region, certificateArn string) (tls.Certificate, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	passphrase := []byte(hex.EncodeToString(buf))
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cfg.Region = region
+	resp, err := acm.New(cfg).ExportCertificateRequest(&acm.ExportCertificateInput{
+		CertificateArn: aws.String(certificateArn),
+		Passphrase:     passphrase,
+	}).Send(ctx)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return exportedKeyPair(aws.StringValue(resp.Certificate)+"\n"+aws.StringValue(resp.CertificateChain), aws.StringValue(resp.PrivateKey), passphrase)
+}
+
+// exportedKeyPair returns the key pair of an exported certificate with its PKCS#8 encrypted private key.
+func exportedKeyPair(certPEM, encryptedKeyPEM string, passphrase []byte) (tls.Certificate, error) {
+	block, _ := pem.Decode([]byte(encryptedKeyPEM))
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return tls.Certificate{}, fmt.Errorf("exported private key is not an encrypted PKCS#8 PEM")
+	}
+	key, err := decryptPKCS8PrivateKey(block.Bytes, passphrase)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair([]byte(certPEM), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
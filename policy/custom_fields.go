@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4"
+	"net/http"
+	"strings"
+)
+
+// Labels of the TPP custom fields set on imported certificates. Fields which aren't defined in TPP are skipped.
+const (
+	customFieldAccount   = "AWS Account"
+	customFieldRegion    = "AWS Region"
+	customFieldCA        = "AWS CA ARN"
+	customFieldRequester = "AWS Requester"
+)
+
+// importCustomFields returns the custom field values of an imported certificate by label.
+func importCustomFields(i common.VenafiImport) map[string]string {
+	fields := map[string]string{}
+	for label, value := range map[string]string{
+		customFieldAccount:   i.AccountID,
+		customFieldRegion:    i.Region,
+		customFieldCA:        i.CertificateAuthorityArn,
+		customFieldRequester: i.RequestedBy,
+	} {
+		if value != "" {
+			fields[label] = value
+		}
+	}
+	return fields
+}
+
+// tppAPI calls TPP WebSDK endpoints vcert doesn't expose for imported certificates.
+type tppAPI struct {
+	baseURL string
+	client  *http.Client
+	config  vcert.Config
+	apiKey  string
+}
+
+func newTPPAPI(config vcert.Config) (*tppAPI, error) {
+	client, err := getHTTPClient(config.ConnectionTrust)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := strings.TrimSuffix(strings.TrimSuffix(config.BaseUrl, "/"), "/vedsdk")
+	if !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "https://" + strings.TrimPrefix(baseURL, "http://")
+	}
+	return &tppAPI{baseURL: baseURL + "/vedsdk/", client: client, config: config}, nil
+}
+
+func (a *tppAPI) call(resource string, in, out interface{}) error {
+	if a.config.Credentials.AccessToken == "" && a.apiKey == "" {
+		var auth struct{ APIKey string }
+		err := a.post("authorize/", struct{ Username, Password string }{a.config.Credentials.User, a.config.Credentials.Password}, &auth)
+		if err != nil {
+			return fmt.Errorf("can't authorize: %s", err)
+		}
+		a.apiKey = auth.APIKey
+	}
+	return a.post(resource, in, out)
+}
+
+func (a *tppAPI) post(resource string, in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPost, a.baseURL+resource, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if a.config.Credentials.AccessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+a.config.Credentials.AccessToken)
+	} else if a.apiKey != "" {
+		r.Header.Set("X-Venafi-Api-Key", a.apiKey)
+	}
+	resp, err := a.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", resource, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// setCustomFields sets custom fields of the certificate object by label. Labels not defined in TPP are skipped.
+func (a *tppAPI) setCustomFields(dn string, fields map[string]string) error {
+	var items struct {
+		Items []struct {
+			Guid  string
+			Label string
+		}
+	}
+	err := a.call("metadata/getitems", struct{ DN string }{dn}, &items)
+	if err != nil {
+		return err
+	}
+	type guidData struct {
+		ItemGuid string
+		List     []string
+	}
+	var data []guidData
+	for _, item := range items.Items {
+		if value, ok := fields[item.Label]; ok {
+			data = append(data, guidData{ItemGuid: item.Guid, List: []string{value}})
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	var result struct{ Result int }
+	err = a.call("metadata/set", struct {
+		DN           string
+		GuidData     []guidData
+		KeepExisting bool
+	}{dn, data, true}, &result)
+	if err != nil {
+		return err
+	}
+	if result.Result != 0 {
+		return fmt.Errorf("metadata/set failed with result %d", result.Result)
+	}
+	return nil
+}
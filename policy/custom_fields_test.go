@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetCustomFields(t *testing.T) {
+	var set struct {
+		DN       string
+		GuidData []struct {
+			ItemGuid string
+			List     []string
+		}
+		KeepExisting bool
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/vedsdk/metadata/getitems":
+			_, _ = w.Write([]byte(`{"Items": [{"Guid": "{1}", "Label": "AWS Account"}, {"Guid": "{2}", "Label": "Cost Center"}]}`))
+		case "/vedsdk/metadata/set":
+			_ = json.NewDecoder(r.Body).Decode(&set)
+			_, _ = w.Write([]byte(`{"Result": 0}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	api := &tppAPI{
+		baseURL: server.URL + "/vedsdk/",
+		client:  server.Client(),
+		config:  vcert.Config{Credentials: &endpoint.Authentication{AccessToken: "token"}},
+	}
+	fields := importCustomFields(common.VenafiImport{AccountID: "123456789012", Region: "us-east-1"})
+	err := api.setCustomFields(`\VED\Policy\Amazon\0a1b2c3d`, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.DN != `\VED\Policy\Amazon\0a1b2c3d` || !set.KeepExisting || len(set.GuidData) != 1 ||
+		set.GuidData[0].ItemGuid != "{1}" || set.GuidData[0].List[0] != "123456789012" {
+		t.Errorf("unexpected metadata %+v", set)
+	}
+}
+
+func TestImportCustomFields(t *testing.T) {
+	fields := importCustomFields(common.VenafiImport{Region: "us-east-1", RequestedBy: "arn:aws:iam::123456789012:role/Deployer"})
+	if len(fields) != 2 || fields[customFieldRegion] != "us-east-1" || fields[customFieldRequester] == "" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
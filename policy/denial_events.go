@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// denialEventID is the TPP event ID denials are logged with, an event defined for the integration in TPP. Zero
+// leaves queued denials to expire.
+var denialEventID int64
+
+func loadDenialEventID() error {
+	v := os.Getenv("VENAFI_DENIAL_EVENT_ID")
+	if v == "" {
+		return nil
+	}
+	id, err := strconv.ParseInt(v, 0, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("VENAFI_DENIAL_EVENT_ID %q must be a positive decimal or hexadecimal event ID", v)
+	}
+	denialEventID = id
+	return nil
+}
+
+// maxDenialEventsPerRun limits the denials one invocation logs, so a burst of denials doesn't run into the timeout of
+// the policy Lambda. The rest stays queued for the next runs.
+const maxDenialEventsPerRun = 50
+
+// processDenialEvents writes requests rejected by policy to the TPP log of their zone. Failed events stay queued and
+// are retried on the next run until they expire.
+func processDenialEvents(ctx context.Context) error {
+	if denialEventID == 0 {
+		return nil
+	}
+	denials, err := common.ListDenialEvents()
+	if err != nil {
+		return err
+	}
+	apis := tppAPIs{}
+	for n, k := range queueBatch(len(denials), maxDenialEventsPerRun) {
+		if !queueTimeLeft(ctx) {
+			log.Printf("Handled %d of %d queued denials, logging the rest later", n, len(denials))
+			break
+		}
+		e := denials[k]
+		backend, zone, err := backendFor(e.Zone)
+		if err != nil {
+			log.Printf("log denial of request %s error: %s", e.RequestID, err)
+			continue
+		}
+		api := apis.get(backend)
+		if api == nil {
+			log.Printf("Denial of request %s can't be logged to Venafi zone %q, only TPP has a log", e.RequestID, e.Zone)
+		} else {
+			err = api.logDenial(policyDN(zone), e)
+			common.Monitor.Record(common.BackendVenafi, err)
+			if err != nil {
+				log.Printf("log denial of request %s error: %s", e.RequestID, err)
+				continue
+			}
+		}
+		err = common.DeleteDenialEvent(e.RequestID)
+		if err != nil {
+			log.Println("delete denial event error:", err)
+		}
+	}
+	return nil
+}
+
+// logDenial writes the denial to the TPP log with the policy folder as its component.
+func (a *tppAPI) logDenial(dn string, e common.DenialEvent) error {
+	var result struct{ LogResult int }
+	return a.call("Log/", struct {
+		Component string
+		ID        int64
+		Text1     string
+		Text2     string
+		Value1    int
+		Data      string
+	}{dn, denialEventID, e.CallerArn, e.Message, e.StatusCode, fmt.Sprintf("%s %s from account %s", e.Target, e.RequestID, e.SourceAccount)}, &result)
+}
+
+// policyDN returns the DN of the policy folder of a TPP zone, which may be relative to \VED\Policy.
+func policyDN(zone string) string {
+	if strings.HasPrefix(zone, "\\VED\\") {
+		return zone
+	}
+	return "\\VED\\Policy\\" + strings.TrimPrefix(zone, "\\")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPolicyDN(t *testing.T) {
+	for zone, dn := range map[string]string{
+		"Amazon\\PCA Policy":         "\\VED\\Policy\\Amazon\\PCA Policy",
+		"\\Amazon\\PCA Policy":       "\\VED\\Policy\\Amazon\\PCA Policy",
+		"\\VED\\Policy\\Amazon\\Web": "\\VED\\Policy\\Amazon\\Web",
+	} {
+		if got := policyDN(zone); got != dn {
+			t.Errorf("zone %q: expected DN %q, got %q", zone, dn, got)
+		}
+	}
+}
+
+func TestLogDenial(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vedsdk/Log/" || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"LogResult": 0}`))
+	}))
+	defer server.Close()
+	denialEventID = 0x41070000
+	defer func() { denialEventID = 0 }()
+
+	api := &tppAPI{baseURL: server.URL + "/vedsdk/", client: server.Client(), config: vcert.Config{Credentials: &endpoint.Authentication{AccessToken: "token"}}}
+	err := api.logDenial("\\VED\\Policy\\Amazon", common.DenialEvent{RequestID: "r1", CallerArn: "arn:aws:iam::123456789012:user/alice", StatusCode: 400, Message: "Key length 1024 is too small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["Component"] != "\\VED\\Policy\\Amazon" || got["ID"] != float64(0x41070000) || got["Text2"] != "Key length 1024 is too small" || got["Value1"] != float64(400) {
+		t.Errorf("unexpected log event %v", got)
+	}
+}
+
+func TestLoadDenialEventID(t *testing.T) {
+	defer func() {
+		os.Unsetenv("VENAFI_DENIAL_EVENT_ID")
+		denialEventID = 0
+	}()
+	for v, id := range map[string]int64{"0x41070000": 0x41070000, "1091960832": 1091960832} {
+		os.Setenv("VENAFI_DENIAL_EVENT_ID", v)
+		if err := loadDenialEventID(); err != nil || denialEventID != id {
+			t.Errorf("VENAFI_DENIAL_EVENT_ID %q: unexpected ID %d (%v)", v, denialEventID, err)
+		}
+	}
+	for _, v := range []string{"-1", "event"} {
+		os.Setenv("VENAFI_DENIAL_EVENT_ID", v)
+		if err := loadDenialEventID(); err == nil {
+			t.Errorf("VENAFI_DENIAL_EVENT_ID %q should be invalid", v)
+		}
+	}
+}
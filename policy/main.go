@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"github.com/Venafi/aws-private-ca-policy-venafi/common"
 	"github.com/Venafi/vcert/v4"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
@@ -11,42 +13,152 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"log"
+	"math/rand"
 	"os"
 	"strings"
+	"time"
 )
 
 var vcertConnector endpoint.Connector
 
-func HandleRequest() error {
-	log.Println("Getting policies")
-	names, err := common.GetAllPoliciesNames()
+// connectionConfig is the configuration vcertConnector was created with.
+var connectionConfig vcert.Config
+
+func HandleRequest(ctx context.Context, req common.PolicySyncRequest) (result common.PolicySyncResult, err error) {
+	err = refreshAccessToken(ctx, time.Now())
 	if err != nil {
-		log.Println("getting policies names error:", err)
-		return err
+		log.Println("refreshing access token error:", err)
+		return result, err
+	}
+	names := []string{req.VenafiZone}
+	if req.VenafiZone == "" {
+		log.Println("Getting policies")
+		names, err = common.GetAllPoliciesNames()
+		common.Monitor.Record(common.BackendDynamoDB, err)
+		if err != nil {
+			log.Println("getting policies names error:", err)
+			return result, err
+		}
 	}
+	// A zone which fails to sync, e.g. since its backend is down, doesn't stop the sync of the other zones.
+	var failed []string
 	for _, name := range names {
-		log.Printf("Getting policy %s", name)
-		vcertConnector.SetZone(name)
-		p, err := vcertConnector.ReadPolicyConfiguration()
-		if err == verror.ZoneNotFoundError {
-			log.Printf("Policy %s not found. Deleting.", name)
+		deleted, err := syncPolicy(name)
+		if err != nil {
+			log.Printf("sync policy %s error: %s", name, err)
+			failed = append(failed, name)
+			if req.VenafiZone != "" {
+				return result, err
+			}
+			continue
+		}
+		if deleted {
+			result.Deleted = append(result.Deleted, name)
+		} else {
+			result.Synced = append(result.Synced, name)
+		}
+	}
+	if req.VenafiZone != "" {
+		log.Printf("success policy %s processing", req.VenafiZone)
+		return result, nil
+	}
+	if len(failed) == 0 {
+		log.Println("success policies processing")
+	}
+	putPolicyAgeMetrics(time.Now())
+	err = processRevocations()
+	if err != nil {
+		log.Println("processing revocations error:", err)
+	}
+	err = processImports(ctx)
+	if err != nil {
+		log.Println("processing imports error:", err)
+	}
+	err = processDenialEvents(ctx)
+	if err != nil {
+		log.Println("processing denial events error:", err)
+	}
+	if len(failed) > 0 {
+		return result, fmt.Errorf("%d of %d policies failed to sync: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return result, nil
+}
+
+// queueDeadlineMargin is the time an invocation keeps for the rest of the run when it stops handling queued items.
+const queueDeadlineMargin = 2 * time.Second
+
+// queueTimeLeft reports whether the invocation has time to handle another queued item.
+func queueTimeLeft(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > queueDeadlineMargin
+}
+
+// queueBatch returns the indexes of at most max of n queued items in random order, so items which stay queued, e.g.
+// certificates waiting for validation, don't keep the others from being handled.
+func queueBatch(n, max int) []int {
+	batch := rand.Perm(n)
+	if len(batch) > max {
+		batch = batch[:max]
+	}
+	return batch
+}
+
+// syncPolicy reads the policy of the zone from Venafi and saves it. Zones which don't exist in Venafi are deleted.
+func syncPolicy(name string) (deleted bool, err error) {
+	log.Printf("Getting policy %s", name)
+	backend, zone, err := backendFor(name)
+	if err != nil {
+		putSyncMetrics(name, err)
+		return false, err
+	}
+	if backend.connector.GetType() == endpoint.ConnectorTypeCloud {
+		if _, _, err := common.ParseCloudZone(zone); err != nil && zoneDeletable(name) {
+			log.Printf("Policy %s is not a Venafi as a Service zone (%s). Deleting.", name, err)
 			err = common.DeletePolicy(name)
 			if err != nil {
 				log.Println("delete policy error:", err)
 			}
-			continue
-		} else if err != nil {
-			log.Println(err)
-			return err
+			return true, nil
 		}
-		log.Printf("Saving policy %s", name)
-		err = common.SavePolicy(name, *p)
+	}
+	// The policy is only saved if the zone isn't written while it's read from Venafi, e.g. by a rollback.
+	itemVersion, err := common.PolicyItemVersion(name)
+	if err != nil {
+		putSyncMetrics(name, err)
+		return false, err
+	}
+	backend.connector.SetZone(zone)
+	p, err := backend.connector.ReadPolicyConfiguration()
+	if err != verror.ZoneNotFoundError {
+		common.Monitor.Record(common.BackendVenafi, err)
+	}
+	if err == verror.ZoneNotFoundError && !zoneDeletable(name) {
+		putSyncMetrics(name, err)
+		return false, fmt.Errorf("policy %s not found, keeping it until its backend is configured", name)
+	} else if err == verror.ZoneNotFoundError {
+		log.Printf("Policy %s not found. Deleting.", name)
+		err = common.DeletePolicy(name)
 		if err != nil {
-			log.Println("save policy error:", err)
+			log.Println("delete policy error:", err)
 		}
+		return true, nil
+	} else if err != nil {
+		putSyncMetrics(name, err)
+		return false, err
+	}
+	log.Printf("Saving policy %s", name)
+	err = common.SavePolicy(name, *p, itemVersion)
+	if err == common.PolicyPinned {
+		log.Printf("Zone %s is pinned to a policy revision, policy is saved to history only", name)
+	} else if err == common.PolicyWriteConflict {
+		log.Printf("Policy %s was written by a concurrent sync or rollback, keeping it", name)
+	} else if err != nil {
+		log.Println("save policy error:", err)
+		putSyncMetrics(name, err)
+		return false, nil
 	}
-	log.Println("success policies processing")
-	return nil
+	putSyncMetrics(name, nil)
+	return false, nil
 }
 
 func kmsDecrypt(encrypted string) (string, error) {
@@ -112,82 +224,127 @@ func main() {
 		}
 	}
 
-	vcertConnector, err = getConnection(
-		os.Getenv("TPPURL"),
-		os.Getenv("TPPUSER"),
-		password,
-		accessToken,
-		refreshToken,
-		apiKey,
-		os.Getenv("TRUST_BUNDLE"),
-	)
+	err = loadVenafiProxy()
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 
-	lambda.Start(HandleRequest)
-}
-
-func getConnection(tppUrl, tppUser, tppPassword, accessToken, refreshToken, apiKey, trustBundle string) (endpoint.Connector, error) {
-	log.Println("Getting Venafi connection")
-	var config vcert.Config
-
-	if tppUrl != "" && (accessToken != "" || refreshToken != "") {
-		config = vcert.Config{
-			ConnectorType: endpoint.ConnectorTypeTPP,
-			BaseUrl:       tppUrl,
-			Credentials: &endpoint.Authentication{
-				AccessToken:  accessToken,
-				RefreshToken: refreshToken,
-				ClientId:     ClientId,
-			},
-		}
-	} else if tppUrl != "" && tppUser != "" && tppPassword != "" {
-		config = vcert.Config{
-			ConnectorType: endpoint.ConnectorTypeTPP,
-			BaseUrl:       tppUrl,
-			Credentials: &endpoint.Authentication{
-				User:     tppUser,
-				Password: tppPassword,
-			},
-		}
-	} else if apiKey != "" {
-		config = vcert.Config{
-			ConnectorType: endpoint.ConnectorTypeCloud,
-			Credentials: &endpoint.Authentication{
-				APIKey: apiKey,
-			},
-		}
+	err = loadClientCertificate(context.TODO(), os.Getenv("TPP_CLIENT_CERTIFICATE"))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 
-	} else {
-		panic("bad credentials for connection") //todo: replace with something more beautiful
+	trustBundle, err := loadTrustBundle(context.TODO(), os.Getenv("TRUST_BUNDLE"))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
 	}
 
-	if config.ConnectorType == endpoint.ConnectorTypeTPP && trustBundle != "" {
-		buf, err := base64.StdEncoding.DecodeString(trustBundle)
+	if tokenSecretID != "" && refreshToken == "" {
+		stored, err := readStoredGrant(context.TODO())
 		if err != nil {
-			log.Printf("Can`t read trust bundle from file %s: %v\n", trustBundle, err)
-			return nil, err
+			log.Println(err)
+			os.Exit(1)
 		}
-		config.ConnectionTrust = string(buf)
+		refreshToken = stored.RefreshToken
+	}
+
+	vcertConnector, err = getConnection(common.VenafiConnection{
+		TPPURL:          os.Getenv("TPPURL"),
+		TPPUser:         os.Getenv("TPPUSER"),
+		TPPPassword:     password,
+		TPPAccessToken:  accessToken,
+		TPPRefreshToken: refreshToken,
+		TrustBundle:     trustBundle,
+		CloudURL:        os.Getenv("CLOUDURL"),
+		CloudAPIKey:     apiKey,
+	})
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	err = loadBackends(context.TODO(), os.Getenv("VENAFI_BACKENDS"))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	err = loadDenialEventID()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	lambda.Start(HandleRequest)
+}
+
+func getConnection(conn common.VenafiConnection) (endpoint.Connector, error) {
+	log.Println("Getting Venafi connection")
+	config, err := conn.Config()
+	if err != nil {
+		return nil, err
+	}
+	err = configureClient(&config)
+	if err != nil {
+		return nil, err
+	}
+	if config.ConnectorType == endpoint.ConnectorTypeTPP && config.Credentials.AccessToken+config.Credentials.RefreshToken != "" {
+		config.Credentials.ClientId = ClientId
 	}
 
 	// When we have a refresh token, we want to consume it with the purpose of gaining exclusive ownership
 	// of the token. So, no other plugin/entity/user can refresh it and make it invalid.
+	// With a token secret the grant is shared with the other containers instead.
 	if config.ConnectorType == endpoint.ConnectorTypeTPP && config.Credentials.RefreshToken != "" {
-		newAuth, err := consumeToken(&config)
+		tppGrant.config = &config
+		err := renewGrant(context.TODO(), time.Now())
 		if err != nil {
 			log.Printf("Error while consuming refresh token: %v\n", err)
 			return nil, err
 		}
-		config.Credentials = &newAuth
+		return newConnector(*tppGrant.config)
 	}
 
-	return vcert.NewClient(&config)
+	return newConnector(config)
 }
 
-func consumeToken(cfg *vcert.Config) (auth endpoint.Authentication, err error) {
+// configureClient sets the HTTP client of the configuration. vcert clients honor HTTPS_PROXY and NO_PROXY, only an
+// explicit Venafi proxy or a TPP client certificate needs a client of its own.
+func configureClient(config *vcert.Config) (err error) {
+	if venafiProxyURL != nil || (venafiClientCertificate != nil && config.ConnectorType == endpoint.ConnectorTypeTPP) {
+		if venafiProxyURL != nil {
+			log.Printf("Using proxy %s for Venafi", venafiProxyURL.Host)
+		}
+		config.Client, err = getHTTPClient(config.ConnectionTrust)
+	}
+	return err
+}
+
+// newConnector connects to Venafi with the configuration. Grants connect with their access token only, since vcert
+// would refresh the grant again if it got the refresh token, invalidating the one kept for the next refresh.
+func newConnector(config vcert.Config) (endpoint.Connector, error) {
+	if config.Credentials != nil && config.Credentials.RefreshToken != "" {
+		if config.Credentials.AccessToken == "" {
+			return nil, errors.New("grant has no access token, refresh it before connecting")
+		}
+		auth := *config.Credentials
+		auth.RefreshToken = ""
+		config.Credentials = &auth
+	}
+	connector, err := vcert.NewClient(&config)
+	if err != nil {
+		return nil, err
+	}
+	connectionConfig = config
+	return connector, nil
+}
+
+// consumeToken refreshes the access token with the refresh token of the configuration. It returns the new grant and the
+// expiry of its access token, zero if TPP didn't return one.
+func consumeToken(cfg *vcert.Config) (auth endpoint.Authentication, expires time.Time, err error) {
 	log.Println("Trying to consume Refresh Token")
 
 	tppConnector, err := getTppConnector(cfg)
@@ -222,6 +379,9 @@ func consumeToken(cfg *vcert.Config) (auth endpoint.Authentication, err error) {
 		AccessToken:  tokenInfoResponse.Access_token,
 		ClientPKCS12: cfg.Credentials.ClientPKCS12,
 	}
+	if tokenInfoResponse.Expires > 0 {
+		expires = time.Unix(int64(tokenInfoResponse.Expires), 0)
+	}
 
 	return
 }
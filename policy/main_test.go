@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"github.com/Venafi/aws-private-ca-policy-venafi/common"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
@@ -11,7 +12,7 @@ import (
 
 func TestHandleRequestCloud(t *testing.T) {
 	var err error
-	vcertConnector, err = getConnection("", "", "", "", "", os.Getenv("CLOUDAPIKEY"), "")
+	vcertConnector, err = getConnection(common.VenafiConnection{CloudURL: os.Getenv("CLOUDURL"), CloudAPIKey: os.Getenv("CLOUDAPIKEY")})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -28,7 +29,12 @@ func TestHandleRequestTPP(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	vcertConnector, err = getConnection(os.Getenv("TPPURL"), os.Getenv("TPPUSER"), os.Getenv("TPPPASSWORD"), "", "", "", base64.StdEncoding.EncodeToString(trustBundle))
+	vcertConnector, err = getConnection(common.VenafiConnection{
+		TPPURL:      os.Getenv("TPPURL"),
+		TPPUser:     os.Getenv("TPPUSER"),
+		TPPPassword: os.Getenv("TPPPASSWORD"),
+		TrustBundle: base64.StdEncoding.EncodeToString(trustBundle),
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -45,7 +51,12 @@ func TestHandleRequestTPPToken(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	vcertConnector, err = getConnection(os.Getenv("TPP_TOKEN_URL"), "", "", os.Getenv("TPP_ACCESS_TOKEN"), os.Getenv("TPP_REFRESH_TOKEN"), "", base64.StdEncoding.EncodeToString(trustBundle))
+	vcertConnector, err = getConnection(common.VenafiConnection{
+		TPPURL:          os.Getenv("TPP_TOKEN_URL"),
+		TPPAccessToken:  os.Getenv("TPP_ACCESS_TOKEN"),
+		TPPRefreshToken: os.Getenv("TPP_REFRESH_TOKEN"),
+		TrustBundle:     base64.StdEncoding.EncodeToString(trustBundle),
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,15 +68,15 @@ func testHandleRequest(t *testing.T, zoneName, invalidZone, checkRegexp string)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = common.SavePolicy(zoneName, endpoint.Policy{})
+	err = common.SavePolicy(zoneName, endpoint.Policy{}, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = common.SavePolicy(invalidZone, endpoint.Policy{})
+	err = common.SavePolicy(invalidZone, endpoint.Policy{}, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = HandleRequest()
+	_, err = HandleRequest(context.Background(), common.PolicySyncRequest{})
 	if err != nil {
 		t.Fatal(err)
 	}
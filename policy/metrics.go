@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"log"
+	"time"
+)
+
+// putSyncMetrics counts the sync of the zone as a success or a failure, so alarms fire when policies stop being
+// synced from Venafi.
+func putSyncMetrics(zone string, err error) {
+	success, failure := 1.0, 0.0
+	if err != nil {
+		success, failure = 0, 1
+	}
+	common.PutMetrics([]common.Dimension{{Name: "Zone", Value: zone}},
+		common.Metric{Name: "SyncSuccess", Unit: cloudwatch.StandardUnitCount, Value: success},
+		common.Metric{Name: "SyncFailure", Unit: cloudwatch.StandardUnitCount, Value: failure},
+	)
+}
+
+// putPolicyAgeMetrics emits the time since the last sync of each synced zone. The age of a zone whose syncs fail grows
+// until it's synced again, pinned zones age since the sync of the revision they are pinned to.
+func putPolicyAgeMetrics(now time.Time) {
+	zones, err := common.ListZones()
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		log.Println("Can't put policy age metrics:", err)
+		return
+	}
+	for _, z := range zones {
+		if !z.Synced || z.LastSync.IsZero() {
+			continue
+		}
+		common.PutMetrics([]common.Dimension{{Name: "Zone", Value: z.Name}},
+			common.Metric{Name: "PolicyAge", Unit: cloudwatch.StandardUnitSeconds, Value: now.Sub(z.LastSync).Seconds()})
+	}
+}
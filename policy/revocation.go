@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"log"
+)
+
+// processRevocations applies revocations done through the proxy to Venafi records of the certificates. Failed
+// revocations stay queued and are retried on the next run until they expire.
+func processRevocations() error {
+	revocations, err := common.ListVenafiRevocations()
+	if err != nil {
+		return err
+	}
+	if len(revocations) == 0 {
+		return nil
+	}
+	for _, r := range revocations {
+		backend, _, err := backendFor(r.Zone)
+		if err != nil {
+			log.Printf("revoke certificate %s error: %s", r.CertificateArn, err)
+			continue
+		}
+		if backend.connector.GetType() != endpoint.ConnectorTypeTPP {
+			log.Printf("Venafi Cloud doesn't support revocation, revocation of certificate %s is not applied", r.CertificateArn)
+			continue
+		}
+		log.Printf("Revoking certificate %s (%s) of zone %q in Venafi with reason %s, revoked by %s", r.CertificateArn, r.Thumbprint, r.Zone, r.Reason, r.RevokedBy)
+		err = backend.connector.RevokeCertificate(&certificate.RevocationRequest{
+			Thumbprint: r.Thumbprint,
+			Reason:     r.Reason,
+			Comments:   r.Comments,
+		})
+		if err != nil {
+			log.Printf("revoke certificate %s error: %s", r.CertificateArn, err)
+			continue
+		}
+		err = common.DeleteVenafiRevocation(r.CertificateArn)
+		if err != nil {
+			log.Println("delete revocation error:", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// accessTokenRefreshWindow makes the policy Lambda refresh the TPP access token a bit before it expires, so syncs in a
+// long-lived container don't fail with an expired token.
+const accessTokenRefreshWindow = 10 * time.Minute
+
+// maxStoredGrantWait limits the wait for the grant of a concurrent refresh, the policy Lambda has a short timeout.
+const maxStoredGrantWait = time.Second
+
+// tppGrant is the OAuth grant the connector authenticates with. It's only set with refresh token authentication.
+var tppGrant struct {
+	config  *vcert.Config
+	expires time.Time
+}
+
+// refreshJitter is added to the refresh window per container, so containers sharing a grant through the token secret
+// don't refresh it at the same time.
+var refreshJitter = time.Duration(rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(int64(accessTokenRefreshWindow)))
+
+func accessTokenExpiring(now time.Time) bool {
+	return tppGrant.config != nil && !tppGrant.expires.IsZero() &&
+		now.Add(accessTokenRefreshWindow+refreshJitter).After(tppGrant.expires)
+}
+
+// refreshAccessToken refreshes the access token of the grant and reconnects with it when it's about to expire.
+func refreshAccessToken(ctx context.Context, now time.Time) error {
+	if !accessTokenExpiring(now) {
+		return nil
+	}
+	log.Printf("Access token expires at %s, refreshing", tppGrant.expires.Format(time.RFC3339))
+	err := renewGrant(ctx, now)
+	if err != nil {
+		return err
+	}
+	connector, err := newConnector(*tppGrant.config)
+	if err != nil {
+		return err
+	}
+	vcertConnector = connector
+	return nil
+}
+
+// renewGrant gets a new access token for the grant. With a token secret, a grant another container renewed already is
+// adopted instead, and a renewed grant is stored for the other containers. Failures are reported to the backend
+// monitor and as the AccessTokenRefreshFailure metric.
+func renewGrant(ctx context.Context, now time.Time) (err error) {
+	defer func() {
+		common.Monitor.Record(common.BackendVenafiToken, err)
+		putTokenRefreshMetric(ctx, err)
+	}()
+	if tokenSecretID != "" {
+		stored, err := readStoredGrant(ctx)
+		if err != nil {
+			return err
+		}
+		if adoptStoredGrant(stored, now) {
+			return nil
+		}
+		if stored.RefreshToken != "" {
+			auth := *tppGrant.config.Credentials
+			auth.RefreshToken = stored.RefreshToken
+			setGrant(auth, time.Time{})
+		}
+	}
+	auth, expires, err := consumeToken(tppGrant.config)
+	if err != nil && tokenSecretID != "" {
+		// Another container may have consumed the refresh token concurrently, its grant is in the secret then.
+		time.Sleep(storedGrantWait(ctx, now))
+		stored, serr := readStoredGrant(ctx)
+		if serr == nil && adoptStoredGrant(stored, now) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if auth.AccessToken == "" {
+		return errors.New("TPP returned no access token for the refresh token")
+	}
+	setGrant(auth, expires)
+	if tokenSecretID != "" {
+		return storeGrant(ctx, auth, expires)
+	}
+	return nil
+}
+
+// storedGrantWait is how long to wait for the grant of a concurrent refresh to be stored. It's spread by the jitter of
+// the container but stays well below the remaining time of the invocation.
+func storedGrantWait(ctx context.Context, now time.Time) time.Duration {
+	wait := refreshJitter % maxStoredGrantWait
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now)/4 < wait {
+		wait = deadline.Sub(now) / 4
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// adoptStoredGrant switches to the stored grant if its access token doesn't need a refresh yet.
+func adoptStoredGrant(stored storedGrant, now time.Time) bool {
+	if stored.AccessToken == "" || stored.RefreshToken == "" || !now.Add(accessTokenRefreshWindow).Before(stored.expiry()) {
+		return false
+	}
+	log.Printf("Using access token from the token secret, it expires at %s", stored.expiry().Format(time.RFC3339))
+	auth := *tppGrant.config.Credentials
+	auth.AccessToken, auth.RefreshToken = stored.AccessToken, stored.RefreshToken
+	setGrant(auth, stored.expiry())
+	return true
+}
+
+func setGrant(auth endpoint.Authentication, expires time.Time) {
+	config := *tppGrant.config
+	config.Credentials = &auth
+	tppGrant.config, tppGrant.expires = &config, expires
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"log"
+	"os"
+	"time"
+)
+
+const metricNamespace = "VenafiCertificateProxy"
+
+// tokenSecretID is the Secrets Manager secret the TPP grant is kept in, so the refresh token rotated by one container
+// is used by the others. Empty keeps the grant in memory of each container.
+var tokenSecretID = os.Getenv("TPP_TOKEN_SECRET_ID")
+
+// storedGrant is the JSON value of the token secret.
+type storedGrant struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token"`
+	// Expires is the Unix time the access token expires at.
+	Expires int64 `json:"expires,omitempty"`
+}
+
+func (g storedGrant) expiry() time.Time {
+	if g.Expires == 0 {
+		return time.Time{}
+	}
+	return time.Unix(g.Expires, 0)
+}
+
+// readStoredGrant reads the grant from the token secret. A secret without a value yet returns an empty grant, so the
+// refresh token of the TPP_REFRESH_TOKEN variable seeds it.
+func readStoredGrant(ctx context.Context) (g storedGrant, err error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return g, err
+	}
+	resp, err := secretsmanager.New(cfg).GetSecretValueRequest(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(tokenSecretID),
+	}).Send(ctx)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return g, nil
+	} else if err != nil {
+		return g, fmt.Errorf("can't read token secret %s: %s", tokenSecretID, err)
+	}
+	if aws.StringValue(resp.SecretString) == "" {
+		return g, nil
+	}
+	err = json.Unmarshal([]byte(*resp.SecretString), &g)
+	if err != nil {
+		return g, fmt.Errorf("token secret %s is not a JSON grant: %s", tokenSecretID, err)
+	}
+	return g, nil
+}
+
+// storeGrant writes the renewed grant to the token secret. The previous refresh token is consumed already, so a
+// failure leaves the other containers without a valid grant until this one stores the next one.
+func storeGrant(ctx context.Context, auth endpoint.Authentication, expires time.Time) error {
+	g := storedGrant{AccessToken: auth.AccessToken, RefreshToken: auth.RefreshToken}
+	if !expires.IsZero() {
+		g.Expires = expires.Unix()
+	}
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	_, err = secretsmanager.New(cfg).PutSecretValueRequest(&secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(tokenSecretID),
+		SecretString: aws.String(string(b)),
+	}).Send(ctx)
+	if err != nil {
+		return fmt.Errorf("can't store refreshed grant in token secret %s: %s", tokenSecretID, err)
+	}
+	log.Printf("Stored refreshed grant in token secret %s", tokenSecretID)
+	return nil
+}
+
+func putTokenRefreshMetric(ctx context.Context, err error) {
+	failure := 0.0
+	if err != nil {
+		failure = 1
+	}
+	cfg, merr := external.LoadDefaultAWSConfig()
+	if merr != nil {
+		log.Println("Can't put token refresh metric:", merr)
+		return
+	}
+	_, merr = cloudwatch.New(cfg).PutMetricDataRequest(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(metricNamespace),
+		MetricData: []cloudwatch.MetricDatum{{
+			MetricName: aws.String("AccessTokenRefreshFailure"),
+			Unit:       cloudwatch.StandardUnitCount,
+			Value:      aws.Float64(failure),
+		}},
+	}).Send(ctx)
+	if merr != nil {
+		log.Println("Can't put token refresh metric:", merr)
+	}
+}
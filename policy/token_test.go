@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenExpiring(t *testing.T) {
+	defer func() { tppGrant.config, tppGrant.expires = nil, time.Time{} }()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if accessTokenExpiring(now) {
+		t.Error("connection without grant can't expire")
+	}
+	tppGrant.config = &vcert.Config{}
+	if accessTokenExpiring(now) {
+		t.Error("grant without expiry shouldn't be refreshed")
+	}
+	tppGrant.expires = now.Add(time.Hour)
+	if accessTokenExpiring(now) {
+		t.Error("access token valid for an hour shouldn't be refreshed")
+	}
+	tppGrant.expires = now.Add(accessTokenRefreshWindow / 2)
+	if !accessTokenExpiring(now) {
+		t.Error("access token about to expire should be refreshed")
+	}
+}
+
+func TestAdoptStoredGrant(t *testing.T) {
+	defer func() { tppGrant.config, tppGrant.expires = nil, time.Time{} }()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tppGrant.config = &vcert.Config{Credentials: &endpoint.Authentication{AccessToken: "old", RefreshToken: "old", ClientId: ClientId}}
+
+	if adoptStoredGrant(storedGrant{AccessToken: "new", RefreshToken: "new", Expires: now.Add(time.Minute).Unix()}, now) {
+		t.Error("stored access token about to expire shouldn't be adopted")
+	}
+	if adoptStoredGrant(storedGrant{RefreshToken: "new"}, now) {
+		t.Error("stored grant without access token shouldn't be adopted")
+	}
+	if !adoptStoredGrant(storedGrant{AccessToken: "new", RefreshToken: "new", Expires: now.Add(time.Hour).Unix()}, now) {
+		t.Fatal("fresh stored grant should be adopted")
+	}
+	auth := tppGrant.config.Credentials
+	if auth.AccessToken != "new" || auth.RefreshToken != "new" || auth.ClientId != ClientId || !tppGrant.expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected grant %+v expiring at %s", auth, tppGrant.expires)
+	}
+}
+
+func TestNewConnectorRequiresAccessToken(t *testing.T) {
+	config := vcert.Config{
+		ConnectorType: endpoint.ConnectorTypeTPP,
+		BaseUrl:       "https://tpp.example.com",
+		Credentials:   &endpoint.Authentication{RefreshToken: "refresh"},
+	}
+	_, err := newConnector(config)
+	if err == nil {
+		t.Fatal("connecting with a refresh token only must fail, vcert would consume it")
+	}
+}
+
+func TestStoredGrantWait(t *testing.T) {
+	defer func(j time.Duration) { refreshJitter = j }(refreshJitter)
+	refreshJitter = 9*time.Minute + 500*time.Millisecond
+	now := time.Now()
+	if w := storedGrantWait(context.Background(), now); w != 500*time.Millisecond {
+		t.Errorf("wait without deadline = %s", w)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Second))
+	defer cancel()
+	if w := storedGrantWait(ctx, now); w != 250*time.Millisecond {
+		t.Errorf("wait should be capped by the deadline, got %s", w)
+	}
+	ctx, cancel = context.WithDeadline(context.Background(), now.Add(-time.Second))
+	defer cancel()
+	if w := storedGrantWait(ctx, now); w != 0 {
+		t.Errorf("wait after the deadline = %s", w)
+	}
+}
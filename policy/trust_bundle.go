@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+const s3TrustBundlePrefix = "s3://"
+
+// loadTrustBundle resolves the TRUST_BUNDLE variable. It is the bundle itself, inline or base64 encoded, an
+// s3://bucket/key URL of an object holding it, or the ARN of a Secrets Manager secret holding it.
+func loadTrustBundle(ctx context.Context, bundle string) (string, error) {
+	switch {
+	case strings.HasPrefix(bundle, s3TrustBundlePrefix):
+		bucket, key, err := parseS3TrustBundle(bundle)
+		if err != nil {
+			return "", err
+		}
+		return readS3TrustBundle(ctx, bucket, key)
+	case strings.HasPrefix(bundle, "arn:") && strings.Contains(bundle, ":secretsmanager:"):
+		log.Printf("Reading trust bundle from secret %s", bundle)
+		return readSecret(ctx, bundle)
+	}
+	return bundle, nil
+}
+
+func parseS3TrustBundle(bundle string) (bucket, key string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(bundle, s3TrustBundlePrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("trust bundle %s is not an s3://bucket/key URL", bundle)
+	}
+	return parts[0], parts[1], nil
+}
+
+func readS3TrustBundle(ctx context.Context, bucket, key string) (string, error) {
+	log.Printf("Reading trust bundle from s3://%s/%s", bucket, key)
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", err
+	}
+	resp, err := s3.New(cfg).GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}).Send(ctx)
+	if err != nil {
+		return "", fmt.Errorf("can't read trust bundle s3://%s/%s: %s", bucket, key, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("can't read trust bundle s3://%s/%s: %s", bucket, key, err)
+	}
+	return string(b), nil
+}
+
+// readSecret returns the string or binary value of a Secrets Manager secret.
+func readSecret(ctx context.Context, secretID string) (string, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", err
+	}
+	resp, err := secretsmanager.New(cfg).GetSecretValueRequest(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	}).Send(ctx)
+	if err != nil {
+		return "", fmt.Errorf("can't read secret %s: %s", secretID, err)
+	}
+	if resp.SecretString != nil {
+		return *resp.SecretString, nil
+	}
+	return string(resp.SecretBinary), nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseS3TrustBundle(t *testing.T) {
+	bucket, key, err := parseS3TrustBundle("s3://venafi-config/tpp/bundle.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket != "venafi-config" || key != "tpp/bundle.pem" {
+		t.Errorf("unexpected bucket %q and key %q", bucket, key)
+	}
+	for _, bundle := range []string{"s3://", "s3://venafi-config", "s3://venafi-config/", "s3:///bundle.pem"} {
+		if _, _, err := parseS3TrustBundle(bundle); err == nil {
+			t.Errorf("trust bundle %q should be invalid", bundle)
+		}
+	}
+}
+
+func TestLoadInlineTrustBundle(t *testing.T) {
+	for _, bundle := range []string{"", "LS0tLS1CRUdJTi", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"} {
+		got, err := loadTrustBundle(context.Background(), bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != bundle {
+			t.Errorf("inline trust bundle %q should be returned as is, got %q", bundle, got)
+		}
+	}
+}
@@ -8,17 +8,47 @@ import (
 	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"time"
 )
 
 const ClientId = "aws-private-ca-by-venafi"
 const Scope = "certificate:manage"
 
-func getTppConnector(cfg *vcert.Config) (*tpp.Connector, error) {
+// venafiProxyURL is the forward proxy for Venafi requests, e.g. for TPP instances only reachable through a proxy from
+// the VPC. Without it Venafi requests use HTTPS_PROXY and NO_PROXY like AWS API requests.
+var venafiProxyURL *url.URL
+
+func loadVenafiProxy() error {
+	venafiProxyURL = nil
+	s := os.Getenv("VENAFI_PROXY_URL")
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid VENAFI_PROXY_URL %q", s)
+	}
+	venafiProxyURL = u
+	return nil
+}
 
-	connectionTrustBundle, error := parseTrustBundlePEM(cfg.ConnectionTrust)
-	if error != nil {
-		return nil, error
+func venafiProxy(r *http.Request) (*url.URL, error) {
+	if venafiProxyURL != nil {
+		return venafiProxyURL, nil
+	}
+	return http.ProxyFromEnvironment(r)
+}
+
+func getTppConnector(cfg *vcert.Config) (*tpp.Connector, error) {
+	var connectionTrustBundle *x509.CertPool
+	if cfg.ConnectionTrust != "" {
+		var err error
+		connectionTrustBundle, err = parseTrustBundlePEM(cfg.ConnectionTrust)
+		if err != nil {
+			return nil, err
+		}
 	}
 	tppConnector, err := tpp.NewConnector(cfg.BaseUrl, "", cfg.LogVerbose, connectionTrustBundle)
 	if err != nil {
@@ -31,7 +61,7 @@ func getTppConnector(cfg *vcert.Config) (*tpp.Connector, error) {
 func getHTTPClient(trustBundlePem string) (*http.Client, error) {
 
 	var netTransport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: venafiProxy,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -43,20 +73,22 @@ func getHTTPClient(trustBundlePem string) (*http.Client, error) {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 	tlsConfig := http.DefaultTransport.(*http.Transport).TLSClientConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
 	/* #nosec */
 	if trustBundlePem != "" {
 		trustBundle, err := parseTrustBundlePEM(trustBundlePem)
 		if err != nil {
 			return nil, err
 		}
-
-		if tlsConfig == nil {
-			tlsConfig = &tls.Config{}
-		} else {
-			tlsConfig = tlsConfig.Clone()
-		}
 		tlsConfig.RootCAs = trustBundle
 	}
+	if venafiClientCertificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*venafiClientCertificate}
+	}
 
 	tlsConfig.Renegotiation = tls.RenegotiateFreelyAsClient
 	netTransport.TLSClientConfig = tlsConfig
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestVenafiProxy(t *testing.T) {
+	defer os.Unsetenv("VENAFI_PROXY_URL")
+	defer func() { venafiProxyURL = nil }()
+	r, _ := http.NewRequest(http.MethodGet, "https://tpp.example.com/vedsdk/", nil)
+
+	os.Setenv("VENAFI_PROXY_URL", "http://proxy.example.com:3128")
+	err := loadVenafiProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := venafiProxy(r)
+	if err != nil || u == nil || u.Host != "proxy.example.com:3128" {
+		t.Errorf("Venafi requests should use the Venafi proxy, got %v %v", u, err)
+	}
+
+	os.Setenv("VENAFI_PROXY_URL", "proxy.example.com:3128")
+	if loadVenafiProxy() == nil {
+		t.Error("proxy URL without scheme should be rejected")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"strings"
+)
+
+// importOrigin is the origin of certificates imported into Venafi, shown in the Venafi inventory.
+const importOrigin = "AWS Private CA Policy Venafi"
+
+// maxImportsPerRun limits the imports of one invocation, so a backlog doesn't run into the timeout of the policy Lambda.
+// The rest stays queued for the next runs.
+const maxImportsPerRun = 10
+
+// processImports imports certificates issued through the proxy into their Venafi zones. Certificates which aren't
+// issued yet, e.g. public ACM certificates waiting for domain validation, and failed imports stay queued and are
+// retried on the next run until they expire.
+func processImports(ctx context.Context) error {
+	imports, err := common.ListVenafiImports()
+	if err != nil {
+		return err
+	}
+	if len(imports) == 0 {
+		return nil
+	}
+	// vcert only sets the origin of imported certificates, TPP custom fields are set through the WebSDK of the backend.
+	apis := tppAPIs{}
+	for n, k := range queueBatch(len(imports), maxImportsPerRun) {
+		if !queueTimeLeft(ctx) {
+			log.Printf("Handled %d of %d queued imports, importing the rest later", n, len(imports))
+			break
+		}
+		i := imports[k]
+		pemCert, err := issuedCertificate(ctx, i)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == acm.ErrCodeRequestInProgressException {
+			log.Printf("Certificate %s is not issued yet, importing it into Venafi later", i.CertificateArn)
+			continue
+		} else if err != nil {
+			log.Printf("get certificate %s error: %s", i.CertificateArn, err)
+			continue
+		}
+		log.Printf("Importing certificate %s into Venafi zone %q", i.CertificateArn, i.Zone)
+		backend, zone, err := backendFor(i.Zone)
+		if err != nil {
+			log.Printf("import certificate %s error: %s", i.CertificateArn, err)
+			continue
+		}
+		backend.connector.SetZone(zone)
+		resp, err := backend.connector.ImportCertificate(&certificate.ImportRequest{
+			ObjectName:      importObjectName(i.CertificateArn),
+			CertificateData: pemCert,
+			CustomFields:    []certificate.CustomField{{Type: certificate.CustomFieldOrigin, Value: importOrigin}},
+		})
+		common.Monitor.Record(common.BackendVenafi, err)
+		if err != nil {
+			log.Printf("import certificate %s error: %s", i.CertificateArn, err)
+			continue
+		}
+		log.Printf("Certificate %s imported into Venafi as %s%s", i.CertificateArn, resp.CertificateDN, resp.CertId)
+		if api := apis.get(backend); api != nil && resp.CertificateDN != "" {
+			// The certificate is imported already, so it isn't imported again if its custom fields can't be set.
+			err = api.setCustomFields(resp.CertificateDN, importCustomFields(i))
+			if err != nil {
+				log.Printf("set custom fields of certificate %s error: %s", i.CertificateArn, err)
+			}
+		}
+		err = common.DeleteVenafiImport(i.CertificateArn)
+		if err != nil {
+			log.Println("delete import error:", err)
+		}
+	}
+	return nil
+}
+
+// tppAPIs are the WebSDK clients of TPP backends by URL.
+type tppAPIs map[string]*tppAPI
+
+// get returns the WebSDK client of the backend, nil for Venafi as a Service and clients which can't be created.
+func (apis tppAPIs) get(b venafiBackend) *tppAPI {
+	if b.connector.GetType() != endpoint.ConnectorTypeTPP {
+		return nil
+	}
+	api, ok := apis[b.config.BaseUrl]
+	if !ok {
+		var err error
+		api, err = newTPPAPI(b.config)
+		if err != nil {
+			log.Printf("custom fields of certificates imported into %s won't be set: %s", b.config.BaseUrl, err)
+		}
+		apis[b.config.BaseUrl] = api
+	}
+	return api
+}
+
+// issuedCertificate returns the PEM certificate from ACM PCA or ACM, with the role of the zone the certificate was
+// issued with.
+func issuedCertificate(ctx context.Context, i common.VenafiImport) (string, error) {
+	a, err := arn.Parse(i.CertificateArn)
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate ARN: %s", err)
+	}
+	zoneConfig, err := common.GetZoneConfig(i.Zone)
+	if err != nil {
+		return "", fmt.Errorf("can't get zone configuration: %s", err)
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", err
+	}
+	cfg.Region = a.Region
+	if zoneConfig.RoleArn != "" {
+		cfg.Credentials = common.AssumeRoleCredentials(cfg, zoneConfig.RoleArn)
+	}
+	if a.Service == "acm-pca" {
+		caArn := strings.SplitN(i.CertificateArn, "/certificate/", 2)[0]
+		resp, err := acmpca.New(cfg).GetCertificateRequest(&acmpca.GetCertificateInput{
+			CertificateArn:          aws.String(i.CertificateArn),
+			CertificateAuthorityArn: aws.String(caArn),
+		}).Send(ctx)
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(resp.Certificate), nil
+	}
+	resp, err := acm.New(cfg).GetCertificateRequest(&acm.GetCertificateInput{
+		CertificateArn: aws.String(i.CertificateArn),
+	}).Send(ctx)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.Certificate), nil
+}
+
+// importObjectName names the Venafi certificate object after the ACM certificate ID or the ACM PCA serial number.
+func importObjectName(certificateArn string) string {
+	return certificateArn[strings.LastIndex(certificateArn, "/")+1:]
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestImportObjectName(t *testing.T) {
+	for certificateArn, name := range map[string]string{
+		"arn:aws:acm:us-east-1:123456789012:certificate/0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d":                                  "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d",
+		"arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/11111111-2222-3333-4444-555555555555/certificate/6f1a2b": "6f1a2b",
+	} {
+		if got := importObjectName(certificateArn); got != name {
+			t.Errorf("object name of %s is %s, expected %s", certificateArn, got, name)
+		}
+	}
+}
+
+func TestQueueBatch(t *testing.T) {
+	if batch := queueBatch(3, 10); len(batch) != 3 {
+		t.Errorf("all of 3 items should be handled, got %v", batch)
+	}
+	batch := queueBatch(100, 10)
+	if len(batch) != 10 {
+		t.Fatalf("batch should be limited to 10 items, got %d", len(batch))
+	}
+	seen := map[int]bool{}
+	for _, i := range batch {
+		if i < 0 || i >= 100 || seen[i] {
+			t.Errorf("invalid or repeated index %d in %v", i, batch)
+		}
+		seen[i] = true
+	}
+}
+
+func TestQueueTimeLeft(t *testing.T) {
+	if !queueTimeLeft(context.Background()) {
+		t.Error("invocations without deadline have time left")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if queueTimeLeft(ctx) {
+		t.Error("no queued items should be handled close to the deadline")
+	}
+}
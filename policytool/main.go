@@ -0,0 +1,167 @@
+// Command policytool exports the zone policies of the policy store to JSON and imports them again, e.g. to back them up,
+// to migrate them to another account or to review the policies the request Lambda enforces. The policy store is
+// configured with the environment variables of the Lambdas, e.g. DYNAMODB_ZONES_TABLE, and the default AWS credentials.
+//
+//	policytool export [-o policies.json]
+//	policytool import [-i policies.json] [-zone name]... [-dry-run]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const usage = `Usage:
+  policytool export [-o file]
+  policytool import [-i file] [-zone name]... [-dry-run]
+`
+
+// zoneList collects repeated -zone flags.
+type zoneList []string
+
+func (l *zoneList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *zoneList) Set(zone string) error {
+	*l = append(*l, zone)
+	return nil
+}
+
+func main() {
+	// vcert sets a prefix for its own logging.
+	log.SetFlags(0)
+	log.SetPrefix("")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = exportCommand(os.Args[2:])
+	case "import":
+		err = importCommand(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "file to write the export to, standard output by default")
+	flags.Parse(args)
+
+	e, err := common.ExportPolicies()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	err = ioutil.WriteFile(*output, b, 0600)
+	if err == nil {
+		log.Printf("Exported %d zones to %s", len(e.Zones), *output)
+	}
+	return err
+}
+
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	input := flags.String("i", "", "file to read the export from, standard input by default")
+	dryRun := flags.Bool("dry-run", false, "only list the zones which would be imported")
+	var zones zoneList
+	flags.Var(&zones, "zone", "zone to import, all zones of the export by default (repeatable)")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	e, err := readExport(r)
+	if err != nil {
+		return err
+	}
+	selected, err := selectZones(e.Zones, zones)
+	if err != nil {
+		return err
+	}
+	for _, z := range selected {
+		if *dryRun {
+			log.Printf("Would import zone %s (%s)", z.Zone, describeZone(z))
+			continue
+		}
+		err = common.ImportZone(z)
+		if err != nil {
+			return fmt.Errorf("failed to import zone %s: %s", z.Zone, err)
+		}
+		log.Printf("Imported zone %s (%s)", z.Zone, describeZone(z))
+	}
+	return nil
+}
+
+func readExport(r io.Reader) (e common.PolicyExport, err error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	err = d.Decode(&e)
+	if err != nil {
+		return e, fmt.Errorf("can't read policy export: %s", err)
+	}
+	return e, nil
+}
+
+// selectZones returns the exported zones with the names, all of them if no names are given.
+func selectZones(exported []common.ZoneExport, names []string) ([]common.ZoneExport, error) {
+	if len(names) == 0 {
+		return exported, nil
+	}
+	byName := make(map[string]common.ZoneExport, len(exported))
+	for _, z := range exported {
+		byName[z.Zone] = z
+	}
+	selected := make([]common.ZoneExport, 0, len(names))
+	for _, name := range names {
+		z, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("zone %s is not in the export", name)
+		}
+		selected = append(selected, z)
+	}
+	return selected, nil
+}
+
+func describeZone(z common.ZoneExport) string {
+	if z.Policy == nil {
+		return "not synced"
+	}
+	s := "synced"
+	if z.PolicyRevision > 0 {
+		s = fmt.Sprintf("revision %d", z.PolicyRevision)
+	}
+	if z.Pinned {
+		s += ", pinned"
+	}
+	return s
+}
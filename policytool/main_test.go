@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"strings"
+	"testing"
+)
+
+func TestReadExport(t *testing.T) {
+	e, err := readExport(strings.NewReader(`{"ExportedAt": "2020-03-01T10:00:00Z", "Zones": [
+		{"Zone": "Default", "Policy": {"AllowWildcards": true}, "PolicyVersion": "abc", "PolicyRevision": 2},
+		{"Zone": "Amazon\\PCA Policy"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Zones) != 2 || !e.Zones[0].Policy.AllowWildcards || e.Zones[1].Policy != nil {
+		t.Errorf("unexpected export %+v", e)
+	}
+	_, err = readExport(strings.NewReader(`{"Zones": [{"Name": "Default"}]}`))
+	if err == nil {
+		t.Error("export with unknown fields should be rejected")
+	}
+}
+
+func TestSelectZones(t *testing.T) {
+	exported := []common.ZoneExport{{Zone: "Default"}, {Zone: "Web"}, {Zone: "Mobile"}}
+	selected, err := selectZones(exported, nil)
+	if err != nil || len(selected) != 3 {
+		t.Errorf("all zones should be selected without names, got %v %v", selected, err)
+	}
+	selected, err = selectZones(exported, []string{"Mobile", "Default"})
+	if err != nil || len(selected) != 2 || selected[0].Zone != "Mobile" || selected[1].Zone != "Default" {
+		t.Errorf("unexpected zones %v %v", selected, err)
+	}
+	_, err = selectZones(exported, []string{"Missing"})
+	if err == nil {
+		t.Error("zone missing in the export should be rejected")
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// allowedAccounts lists AWS account IDs permitted to call the proxy. Empty list allows any caller that passed API Gateway auth.
+var allowedAccounts []string
+
+// checkSourceAccount rejects requests from accounts missing in the allow-list, so a misconfigured API resource policy
+// can't open issuance to unknown accounts.
+func checkSourceAccount(request events.APIGatewayProxyRequest) error {
+	if len(allowedAccounts) == 0 {
+		return nil
+	}
+	account := request.RequestContext.Identity.AccountID
+	for _, a := range allowedAccounts {
+		if a == account {
+			return nil
+		}
+	}
+	if account == "" {
+		return fmt.Errorf("caller account is unknown and account allow-list is configured")
+	}
+	return fmt.Errorf("account %s is not allowed to call this API", account)
+}
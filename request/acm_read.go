@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"log"
+	"net/http"
+)
+
+// maxListedCertificates limits certificates returned by one ListCertificates request with AllPages, so the response
+// fits into the API Gateway response size. The NextToken of the response continues the listing.
+const maxListedCertificates = 5000
+
+// VenafiListCertificatesInput is the ACM ListCertificates request. With AllPages the proxy follows NextToken and
+// returns certificates of all pages, up to maxListedCertificates.
+type VenafiListCertificatesInput struct {
+	acm.ListCertificatesInput
+	AllPages bool `json:"AllPages"`
+}
+
+// ListCertificatesOutput is the ACM ListCertificates response. NextToken is set when more certificates can be listed.
+type ListCertificatesOutput struct {
+	CertificateSummaryList []acm.CertificateSummary `json:"CertificateSummaryList"`
+	NextToken              *string                  `json:"NextToken,omitempty"`
+}
+
+func describeACMCertificate(ctx context.Context, cli *acm.Client, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input acm.DescribeCertificateInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, acmDescribeCertificate, err))
+	}
+	resp, err := cli.DescribeCertificateRequest(&input).Send(ctx)
+	common.Monitor.Record(common.BackendACM, err)
+	if err != nil {
+		return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, acmDescribeCertificate, err))
+	}
+	return jsonResponse(acmDescribeCertificate, resp.DescribeCertificateOutput)
+}
+
+// getACMCertificate returns an issued ACM certificate with its chain, in the requested output format. The thumbprint
+// is added to the inventory like for ACM PCA certificates, so the certificate can be found in Venafi.
+func getACMCertificate(ctx context.Context, cli *acm.Client, request events.APIGatewayProxyRequest, format string) (events.APIGatewayProxyResponse, error) {
+	var input acm.GetCertificateInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, acmGetCertificate, err))
+	}
+	resp, err := cli.GetCertificateRequest(&input).Send(ctx)
+	common.Monitor.Record(common.BackendACM, err)
+	if err != nil {
+		return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, acmGetCertificate, err))
+	}
+	recordThumbprint(aws.StringValue(input.CertificateArn), aws.StringValue(resp.Certificate))
+	if format != outputPEM {
+		return certificateResponse(format, aws.StringValue(resp.Certificate), aws.StringValue(resp.CertificateChain))
+	}
+	return jsonResponse(acmGetCertificate, resp.GetCertificateOutput)
+}
+
+func listACMCertificates(ctx context.Context, cli *acm.Client, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input VenafiListCertificatesInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, acmListCertificates, err))
+	}
+	var output ListCertificatesOutput
+	for {
+		resp, err := cli.ListCertificatesRequest(&input.ListCertificatesInput).Send(ctx)
+		common.Monitor.Record(common.BackendACM, err)
+		if err != nil {
+			return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, acmListCertificates, err))
+		}
+		output.CertificateSummaryList = append(output.CertificateSummaryList, resp.CertificateSummaryList...)
+		output.NextToken = resp.NextToken
+		if !input.AllPages || aws.StringValue(resp.NextToken) == "" {
+			break
+		}
+		if len(output.CertificateSummaryList) >= maxListedCertificates {
+			log.Printf("Listed %d certificates, returning NextToken to continue", len(output.CertificateSummaryList))
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+	if output.CertificateSummaryList == nil {
+		output.CertificateSummaryList = []acm.CertificateSummary{}
+	}
+	return jsonResponse(acmListCertificates, output)
+}
+
+// acmErrorStatus returns the status of an ACM error caused by the request, so clients can tell invalid requests, e.g.
+// with an expired NextToken, from failures of the proxy.
+func acmErrorStatus(err error) int {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case acm.ErrCodeInvalidArgsException, acm.ErrCodeInvalidArnException, aws.InvalidParameterErrCode, "ValidationException":
+			return http.StatusBadRequest
+		case acm.ErrCodeResourceNotFoundException:
+			return http.StatusNotFound
+		case acm.ErrCodeRequestInProgressException:
+			// The certificate is not issued yet, clients retry later.
+			return http.StatusConflict
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+func jsonResponse(target string, v interface{}) (events.APIGatewayProxyResponse, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf("Error marshaling response JSON for target %s: %s", target, err))
+	}
+	return events.APIGatewayProxyResponse{Body: string(b), StatusCode: http.StatusOK}, nil
+}
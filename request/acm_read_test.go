@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"net/http"
+	"testing"
+)
+
+func TestACMErrorStatus(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{awserr.New(acm.ErrCodeInvalidArgsException, "invalid NextToken", nil), http.StatusBadRequest},
+		{awserr.New(acm.ErrCodeInvalidArnException, "invalid ARN", nil), http.StatusBadRequest},
+		{awserr.New(acm.ErrCodeResourceNotFoundException, "not found", nil), http.StatusNotFound},
+		{awserr.New(acm.ErrCodeRequestInProgressException, "pending validation", nil), http.StatusConflict},
+		{awserr.New("ThrottlingException", "rate exceeded", nil), http.StatusInternalServerError},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if status := acmErrorStatus(c.err); status != c.status {
+			t.Errorf("status of %s is %d, expected %d", c.err, status, c.status)
+		}
+	}
+}
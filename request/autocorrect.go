@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"net"
+	"net/url"
+)
+
+// PolicyCorrection is a change the proxy made to a request so it complies with the policy of a zone in auto-correction
+// mode. To is empty for removed values.
+type PolicyCorrection struct {
+	Field string
+	From  string `json:",omitempty"`
+	To    string `json:",omitempty"`
+}
+
+// correctRequest rewrites a request violating the policy so it complies, like Venafi does for service generated CSRs.
+// Subject attributes locked in Venafi are set to the locked value and SANs not allowed by the policy are dropped. The
+// CSR itself can't be changed, so the changes are issued with ApiPassthrough. Violations which can't be corrected, e.g.
+// of the common name or the key, are returned as the error, like requests whose SANs would all be dropped.
+func correctRequest(p endpoint.Policy, req certificate.Request, ap *apiPassthrough, violations policyViolationError) (certificate.Request, *apiPassthrough, []PolicyCorrection, error) {
+	locked := make(map[string][]string)
+	for _, c := range subjectComponents(p, &req) {
+		if values, ok := lockedValues(c.regexes); ok {
+			locked[c.field] = values
+		}
+	}
+	subject := map[string]*[]string{
+		"Organization":       &req.Subject.Organization,
+		"OrganizationalUnit": &req.Subject.OrganizationalUnit,
+		"Locality":           &req.Subject.Locality,
+		"Province":           &req.Subject.Province,
+		"Country":            &req.Subject.Country,
+	}
+	var corrections []PolicyCorrection
+	var uncorrectable policyViolationError
+	subjectChanged, sansChanged := false, false
+	for _, v := range violations {
+		values, isSubject := subject[v.Field]
+		switch {
+		case isSubject && locked[v.Field] != nil:
+			kept := removeValue(*values, v.Value)
+			to := ""
+			if len(kept) == 0 {
+				to = locked[v.Field][0]
+				kept = []string{to}
+			}
+			*values = kept
+			subjectChanged = true
+			corrections = append(corrections, PolicyCorrection{Field: v.Field, From: v.Value, To: to})
+		case v.Field == "DNSName":
+			req.DNSNames = removeValue(req.DNSNames, v.Value)
+			sansChanged = true
+			corrections = append(corrections, PolicyCorrection{Field: v.Field, From: v.Value})
+		case v.Field == "EmailAddress":
+			req.EmailAddresses = removeValue(req.EmailAddresses, v.Value)
+			sansChanged = true
+			corrections = append(corrections, PolicyCorrection{Field: v.Field, From: v.Value})
+		case v.Field == "IPAddress":
+			var ips []net.IP
+			for _, ip := range req.IPAddresses {
+				if ip.String() != v.Value {
+					ips = append(ips, ip)
+				}
+			}
+			req.IPAddresses = ips
+			sansChanged = true
+			corrections = append(corrections, PolicyCorrection{Field: v.Field, From: v.Value})
+		case v.Field == "URI":
+			var uris []*url.URL
+			for _, uri := range req.URIs {
+				if uri.String() != v.Value {
+					uris = append(uris, uri)
+				}
+			}
+			req.URIs = uris
+			sansChanged = true
+			corrections = append(corrections, PolicyCorrection{Field: v.Field, From: v.Value})
+		default:
+			uncorrectable = append(uncorrectable, v)
+		}
+	}
+	if len(uncorrectable) > 0 {
+		return req, ap, nil, uncorrectable
+	}
+
+	corrected := apiPassthrough{}
+	if ap != nil {
+		corrected = *ap
+	}
+	if subjectChanged {
+		s, err := asn1Subject(req.Subject)
+		if err != nil {
+			return req, ap, nil, err
+		}
+		corrected.Subject = s
+	}
+	if sansChanged {
+		if len(req.UPNs) > 0 {
+			return req, ap, nil, fmt.Errorf("SANs of requests with UPNs can't be corrected")
+		}
+		corrected.Extensions.SubjectAlternativeNames = passthroughGeneralNames(&req)
+		// ACM PCA takes the SANs of the CSR if ApiPassthrough has none, so those would be issued again.
+		if len(corrected.Extensions.SubjectAlternativeNames) == 0 {
+			return req, ap, nil, violations
+		}
+	}
+	return req, &corrected, corrections, validateRequest(p, &req)
+}
+
+func removeValue(values []string, value string) []string {
+	kept := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// asn1Subject converts a subject to the ApiPassthrough form, which has single-valued attributes only.
+func asn1Subject(n pkix.Name) (*acmpca.ASN1Subject, error) {
+	if len(n.StreetAddress) > 0 || len(n.PostalCode) > 0 {
+		return nil, fmt.Errorf("subjects with street address or postal code can't be corrected")
+	}
+	value := func(field string, values []string) (*string, error) {
+		switch len(values) {
+		case 0:
+			return nil, nil
+		case 1:
+			return aws.String(values[0]), nil
+		default:
+			return nil, fmt.Errorf("subject with several %s values can't be corrected", field)
+		}
+	}
+	s := &acmpca.ASN1Subject{}
+	if n.CommonName != "" {
+		s.CommonName = aws.String(n.CommonName)
+	}
+	if n.SerialNumber != "" {
+		s.SerialNumber = aws.String(n.SerialNumber)
+	}
+	var err error
+	for _, a := range []struct {
+		field  string
+		values []string
+		v      **string
+	}{
+		{"organization", n.Organization, &s.Organization},
+		{"organizational unit", n.OrganizationalUnit, &s.OrganizationalUnit},
+		{"locality", n.Locality, &s.Locality},
+		{"state (province)", n.Province, &s.State},
+		{"country", n.Country, &s.Country},
+	} {
+		*a.v, err = value(a.field, a.values)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// passthroughGeneralNames converts SANs of the request to the ApiPassthrough form.
+func passthroughGeneralNames(req *certificate.Request) []passthroughGeneralName {
+	var names []passthroughGeneralName
+	for _, name := range req.DNSNames {
+		names = append(names, passthroughGeneralName{DnsName: aws.String(name)})
+	}
+	for _, ip := range req.IPAddresses {
+		names = append(names, passthroughGeneralName{IpAddress: aws.String(ip.String())})
+	}
+	for _, email := range req.EmailAddresses {
+		names = append(names, passthroughGeneralName{Rfc822Name: aws.String(email)})
+	}
+	for _, uri := range req.URIs {
+		names = append(names, passthroughGeneralName{UniformResourceIdentifier: aws.String(uri.String())})
+	}
+	return names
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"reflect"
+	"testing"
+)
+
+func TestCorrectRequest(t *testing.T) {
+	policy := endpoint.Policy{
+		SubjectCNRegexes: []string{`^.*\.example\.com$`},
+		DnsSanRegExs:     []string{`^.*\.example\.com$`},
+		SubjectORegexes:  []string{`^Example Inc\.$`},
+		SubjectOURegexes: []string{`.*`},
+		SubjectLRegexes:  []string{`.*`},
+		SubjectSTRegexes: []string{`.*`},
+		SubjectCRegexes:  []string{`^US$`},
+	}
+	var req certificate.Request
+	req.Subject.CommonName = "www.example.com"
+	req.Subject.Organization = []string{"Example"}
+	req.DNSNames = []string{"www.example.com", "www.example.org"}
+	violations := validateRequest(policy, &req).(policyViolationError)
+
+	corrected, ap, corrections, err := correctRequest(policy, req, nil, violations)
+	if err != nil {
+		t.Fatalf("request should be corrected: %s", err)
+	}
+	if len(corrections) != 3 {
+		t.Errorf("unexpected corrections %+v", corrections)
+	}
+	if aws.StringValue(ap.Subject.Organization) != "Example Inc." || aws.StringValue(ap.Subject.Country) != "US" ||
+		aws.StringValue(ap.Subject.CommonName) != "www.example.com" {
+		t.Errorf("unexpected subject %+v", ap.Subject)
+	}
+	if len(ap.Extensions.SubjectAlternativeNames) != 1 || aws.StringValue(ap.Extensions.SubjectAlternativeNames[0].DnsName) != "www.example.com" {
+		t.Errorf("unexpected SANs %+v", ap.Extensions.SubjectAlternativeNames)
+	}
+	if len(corrected.DNSNames) != 1 || req.DNSNames[1] != "www.example.org" {
+		t.Errorf("corrected request should drop the name without changing the original, got %v and %v", corrected.DNSNames, req.DNSNames)
+	}
+
+	req.Subject.CommonName = "www.example.org"
+	violations = validateRequest(policy, &req).(policyViolationError)
+	_, _, _, err = correctRequest(policy, req, nil, violations)
+	if v, ok := err.(policyViolationError); !ok || len(v) != 1 || v[0].Field != "CommonName" {
+		t.Errorf("common name should not be corrected, got %v", err)
+	}
+}
+
+func TestCorrectRequestWithoutAllowedSANs(t *testing.T) {
+	policy := endpoint.Policy{
+		SubjectCNRegexes: []string{`.*`},
+		DnsSanRegExs:     []string{`^.*\.example\.com$`},
+		SubjectORegexes:  []string{`.*`},
+		SubjectOURegexes: []string{`.*`},
+		SubjectLRegexes:  []string{`.*`},
+		SubjectSTRegexes: []string{`.*`},
+		SubjectCRegexes:  []string{`.*`},
+	}
+	var req certificate.Request
+	req.Subject.CommonName = "www.example.org"
+	req.DNSNames = []string{"www.example.org", "api.example.org"}
+	violations := validateRequest(policy, &req).(policyViolationError)
+
+	_, ap, _, err := correctRequest(policy, req, nil, violations)
+	if v, ok := err.(policyViolationError); !ok || len(v) != 2 || ap != nil {
+		t.Errorf("request without allowed SANs should not be corrected, got %v", err)
+	}
+}
+
+// TestCorrectRequestNeverWidens checks that the certificate ACM PCA issues for a corrected request, with the names of
+// the CSR unless ApiPassthrough has some, has no names and subject but those of the corrected request.
+func TestCorrectRequestNeverWidens(t *testing.T) {
+	policy := endpoint.Policy{
+		SubjectCNRegexes: []string{`.*`},
+		DnsSanRegExs:     []string{`^.*\.example\.com$`},
+		EmailSanRegExs:   []string{`^.*@example\.com$`},
+		SubjectORegexes:  []string{`^Example Inc\.$`},
+		SubjectOURegexes: []string{`.*`},
+		SubjectLRegexes:  []string{`.*`},
+		SubjectSTRegexes: []string{`.*`},
+		SubjectCRegexes:  []string{`.*`},
+	}
+	requests := []certificate.Request{
+		{DNSNames: []string{"www.example.com", "www.example.org"}},
+		{DNSNames: []string{"www.example.org"}},
+		{DNSNames: []string{"www.example.org"}, EmailAddresses: []string{"admin@example.com"}},
+		{EmailAddresses: []string{"admin@example.org"}},
+		{Subject: pkix.Name{Organization: []string{"Example"}}, DNSNames: []string{"www.example.com"}},
+		{Subject: pkix.Name{Organization: []string{"Example"}}, DNSNames: []string{"www.example.org"}},
+	}
+	corrected := 0
+	for _, req := range requests {
+		req.Subject.CommonName = "www.example.com"
+		violations, ok := validateRequest(policy, &req).(policyViolationError)
+		if !ok {
+			t.Fatalf("request %+v should violate the policy", req)
+		}
+		correctedReq, ap, _, err := correctRequest(policy, req, nil, violations)
+		if err != nil {
+			continue
+		}
+		corrected++
+		issued := req
+		if err := applyApiPassthrough(&issued, ap, common.ZoneConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if !stringsSubset(issued.DNSNames, correctedReq.DNSNames) || !stringsSubset(issued.EmailAddresses, correctedReq.EmailAddresses) ||
+			!reflect.DeepEqual(issued.Subject.Organization, correctedReq.Subject.Organization) {
+			t.Errorf("request %+v would be issued as %+v, corrected to %+v", req, issued, correctedReq)
+		}
+	}
+	if corrected == 0 {
+		t.Error("no request was corrected")
+	}
+}
+
+func stringsSubset(values, of []string) bool {
+	for _, v := range values {
+		if !stringInSlice(v, of) {
+			return false
+		}
+	}
+	return true
+}
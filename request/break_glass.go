@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const venafiCreateBreakGlassToken = "Venafi.CreateBreakGlassToken"
+
+// breakGlassHeader carries a break-glass token of the request.
+const breakGlassHeader = "X-Venafi-Break-Glass"
+
+// Rules a break-glass token can bypass
+const (
+	rulePolicy    = "policy"
+	ruleZoneRules = "zone-rules"
+	ruleFreeze    = "freeze"
+	ruleOrigin    = "origin"
+	ruleDNSCheck  = "dns-check"
+	ruleOwnership = "ownership"
+)
+
+var breakGlassRules = []string{rulePolicy, ruleZoneRules, ruleFreeze, ruleOrigin, ruleDNSCheck, ruleOwnership}
+
+const (
+	defaultBreakGlassDuration = time.Hour
+	maxBreakGlassDuration     = 24 * time.Hour
+)
+
+// breakGlassAdmins are principals allowed to create break-glass tokens.
+var breakGlassAdmins []string
+
+type CreateBreakGlassTokenInput struct {
+	VenafiZone      string
+	Rules           []string
+	Reason          string
+	DurationMinutes int
+}
+
+type CreateBreakGlassTokenOutput struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// bypassRules are rules a request may bypass, with its break-glass token or in a zone in audit mode.
+type bypassRules struct {
+	// by describes what allowed the bypass in audit logs.
+	by    string
+	rules map[string]bool
+}
+
+// apply returns the error of a rule check unless the rule is bypassed. Every bypass is logged for audit.
+func (b bypassRules) apply(rule string, err error) error {
+	if err == nil || !b.rules[rule] {
+		return err
+	}
+	log.Printf("AUDIT: %s bypassed rule %s: %s", b.by, rule, err)
+	return nil
+}
+
+// useBreakGlassToken consumes the request's break-glass token for the zone. Requests without a token bypass nothing.
+func useBreakGlassToken(request events.APIGatewayProxyRequest, zone string) (b bypassRules, err error) {
+	token := strings.TrimSpace(request.Headers[breakGlassHeader])
+	if token == "" {
+		return b, nil
+	}
+	hash := hashBreakGlassToken(token)
+	tokenID := hash[:8]
+	b.by = "break-glass token " + tokenID
+	identity := request.RequestContext.Identity
+	t, err := common.UseBreakGlassToken(hash, zone, identity.UserArn, request.RequestContext.RequestID, time.Now())
+	if err != nil {
+		return b, err
+	}
+	if t.Zone != zone {
+		return b, fmt.Errorf("break-glass token %s is not valid for zone %s", tokenID, zone)
+	}
+	b.rules = make(map[string]bool, len(t.Rules))
+	for _, rule := range t.Rules {
+		b.rules[rule] = true
+	}
+	log.Printf("AUDIT: break-glass token %s created by %s for %q used by %s in request %s, bypassable rules %v",
+		tokenID, t.CreatedBy, t.Reason, identity.UserArn, request.RequestContext.RequestID, t.Rules)
+	return b, nil
+}
+
+func hashBreakGlassToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func createBreakGlassToken(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	admin := request.RequestContext.Identity.UserArn
+	if !principalAllowed(admin, breakGlassAdmins) {
+		log.Printf("AUDIT: %s is not allowed to create break-glass tokens", admin)
+		return clientError(http.StatusForbidden, "Caller is not allowed to create break-glass tokens")
+	}
+	var input CreateBreakGlassTokenInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiCreateBreakGlassToken, err))
+	}
+	if input.VenafiZone == "" || input.Reason == "" || len(input.Rules) == 0 {
+		return clientError(http.StatusBadRequest, "VenafiZone, Reason and Rules are required")
+	}
+	for _, rule := range input.Rules {
+		if !stringInSlice(rule, breakGlassRules) {
+			return clientError(http.StatusBadRequest, fmt.Sprintf("Unknown rule %q, supported rules are %v", rule, breakGlassRules))
+		}
+	}
+	duration := time.Duration(input.DurationMinutes) * time.Minute
+	if duration == 0 {
+		duration = defaultBreakGlassDuration
+	}
+	if duration < 0 || duration > maxBreakGlassDuration {
+		return clientError(http.StatusBadRequest, fmt.Sprintf("Token duration must be at most %s", maxBreakGlassDuration))
+	}
+
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Can't generate token: %s", err))
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	t := common.BreakGlassToken{
+		TokenHash: hashBreakGlassToken(token),
+		Zone:      input.VenafiZone,
+		Rules:     input.Rules,
+		Reason:    input.Reason,
+		CreatedBy: admin,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+	err = common.SaveBreakGlassToken(t)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to save token to database: %s", err))
+	}
+	log.Printf("AUDIT: break-glass token %s created by %s for zone %s, rules %v, expires at %s: %s",
+		t.TokenHash[:8], admin, t.Zone, t.Rules, t.ExpiresAt, t.Reason)
+
+	body, err := json.Marshal(CreateBreakGlassTokenOutput{Token: token, ExpiresAt: t.ExpiresAt})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+// principalAllowed matches a caller ARN against allowed IAM users and roles. Sessions of an allowed role match the
+// role ARN.
+func principalAllowed(callerArn string, allowed []string) bool {
+	if callerArn == "" {
+		return false
+	}
+	if stringInSlice(callerArn, allowed) {
+		return true
+	}
+	roleArn, ok := sessionRoleArn(callerArn)
+	return ok && stringInSlice(roleArn, allowed)
+}
+
+// sessionRoleArn returns the IAM role ARN of an assumed role session ARN.
+func sessionRoleArn(callerArn string) (string, bool) {
+	a, err := arn.Parse(callerArn)
+	if err != nil || a.Service != "sts" || !strings.HasPrefix(a.Resource, "assumed-role/") {
+		return "", false
+	}
+	parts := strings.Split(a.Resource, "/")
+	if len(parts) < 3 {
+		return "", false
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", a.Partition, a.AccountID, parts[1]), true
+}
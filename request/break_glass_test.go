@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPrincipalAllowed(t *testing.T) {
+	allowed := []string{"arn:aws:iam::123456789012:role/SecurityAdmin", "arn:aws:iam::123456789012:user/alice"}
+	cases := []struct {
+		caller string
+		want   bool
+	}{
+		{"arn:aws:iam::123456789012:user/alice", true},
+		{"arn:aws:sts::123456789012:assumed-role/SecurityAdmin/incident-42", true},
+		{"arn:aws:sts::210987654321:assumed-role/SecurityAdmin/incident-42", false},
+		{"arn:aws:sts::123456789012:assumed-role/Developer/bob", false},
+		{"arn:aws:iam::123456789012:user/bob", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := principalAllowed(c.caller, allowed); got != c.want {
+			t.Errorf("principalAllowed(%q) = %v, want %v", c.caller, got, c.want)
+		}
+	}
+}
+
+func TestBypassRules(t *testing.T) {
+	violation := fmt.Errorf("violation")
+	var none bypassRules
+	if none.apply(rulePolicy, violation) == nil {
+		t.Error("request without token must not bypass rules")
+	}
+	b := bypassRules{by: "break-glass token abcdef12", rules: map[string]bool{ruleFreeze: true}}
+	if b.apply(ruleFreeze, violation) != nil {
+		t.Error("freeze should be bypassed")
+	}
+	if b.apply(rulePolicy, violation) == nil {
+		t.Error("policy should not be bypassed")
+	}
+	if b.apply(rulePolicy, nil) != nil {
+		t.Error("passed check should stay passed")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// accountBudget is the maximum number of certificates a source account may get issued per month. Zero means no limit.
+var accountBudget int64
+
+// budgetAlertThresholds are budget usage percentages at which an alert is sent.
+var budgetAlertThresholds []int64
+
+var budgetAlertTopicArn string
+
+func loadBudget() {
+	accountBudget = 0
+	if s := os.Getenv("ACCOUNT_MONTHLY_BUDGET"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v < 0 {
+			log.Printf("Invalid ACCOUNT_MONTHLY_BUDGET %q, budget is not enforced", s)
+		} else {
+			accountBudget = v
+		}
+	}
+	budgetAlertThresholds = nil
+	for _, s := range common.SplitList(os.Getenv("BUDGET_ALERT_THRESHOLDS")) {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v <= 0 || v > 100 {
+			log.Printf("Invalid budget alert threshold %q", s)
+			continue
+		}
+		budgetAlertThresholds = append(budgetAlertThresholds, v)
+	}
+	budgetAlertTopicArn = os.Getenv("BUDGET_ALERT_TOPIC_ARN")
+}
+
+// reserveIssuance counts a certificate against the monthly budget of the source account before it's issued.
+// The returned function takes the count back if issuance fails.
+func reserveIssuance(ctx context.Context, request events.APIGatewayProxyRequest, now time.Time) (release func(), err error) {
+	release = func() {}
+	if accountBudget == 0 {
+		return release, nil
+	}
+	account := request.RequestContext.Identity.AccountID
+	if account == "" {
+		account = "unknown"
+	}
+	month := issuanceMonth(now)
+	count, err := common.IncrementIssuanceCount(account, month, accountBudget)
+	if err == common.BudgetExceeded {
+		log.Printf("ALERT: account %s exceeded monthly issuance budget of %d certificates", account, accountBudget)
+		return release, fmt.Errorf("account %s reached its budget of %d certificates for %s", account, accountBudget, month)
+	} else if err != nil {
+		return release, fmt.Errorf("can't check issuance budget: %s", err)
+	}
+	if threshold := crossedThreshold(count, accountBudget, budgetAlertThresholds); threshold > 0 {
+		sendBudgetAlert(ctx, fmt.Sprintf("Account %s used %d%% (%d of %d) of its monthly certificate issuance budget for %s",
+			account, threshold, count, accountBudget, month))
+	}
+	return func() {
+		releaseIssuance(account, month)
+	}, nil
+}
+
+// issuanceMonth returns the budget month of an issuance, or empty string if no budget is enforced.
+func issuanceMonth(now time.Time) string {
+	if accountBudget == 0 {
+		return ""
+	}
+	return now.UTC().Format("2006-01")
+}
+
+func releaseIssuance(account, month string) {
+	if err := common.DecrementIssuanceCount(account, month); err != nil {
+		log.Printf("Can't release issuance budget of account %s: %s", account, err)
+	}
+}
+
+// crossedThreshold returns the alert threshold percentage reached exactly by the count, or zero. Reporting only the
+// exact crossing sends one alert per threshold.
+func crossedThreshold(count, budget int64, thresholds []int64) int64 {
+	for _, t := range thresholds {
+		if count == (budget*t+99)/100 {
+			return t
+		}
+	}
+	return 0
+}
+
+func sendBudgetAlert(ctx context.Context, msg string) {
+	log.Println("ALERT:", msg)
+	if budgetAlertTopicArn == "" {
+		return
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		log.Println("Can't load config to send budget alert:", err)
+		return
+	}
+	_, err = sns.New(cfg).PublishRequest(&sns.PublishInput{
+		TopicArn: aws.String(budgetAlertTopicArn),
+		Subject:  aws.String("Venafi certificate issuance budget alert"),
+		Message:  aws.String(msg),
+	}).Send(ctx)
+	if err != nil {
+		log.Println("Can't send budget alert:", err)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestCrossedThreshold(t *testing.T) {
+	thresholds := []int64{50, 80, 100}
+	cases := []struct {
+		count, budget, want int64
+	}{
+		{49, 100, 0},
+		{50, 100, 50},
+		{51, 100, 0},
+		{80, 100, 80},
+		{100, 100, 100},
+		// 80% of 7 is 5.6, the alert is sent when the usage reaches it.
+		{6, 7, 80},
+		{5, 7, 0},
+	}
+	for _, c := range cases {
+		if got := crossedThreshold(c.count, c.budget, thresholds); got != c.want {
+			t.Errorf("crossedThreshold(%d, %d) = %d, want %d", c.count, c.budget, got, c.want)
+		}
+	}
+}
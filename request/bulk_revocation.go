@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const venafiRevokeCertificates = "Venafi.RevokeCertificates"
+
+// maxBulkRevocations keeps a request within the request Lambda timeout. Larger selections are listed with DryRun and
+// revoked in batches of CertificateArns.
+const (
+	maxBulkRevocations        = 50
+	bulkRevocationConcurrency = 10
+)
+
+// revocationAdmins are principals allowed to revoke certificates in bulk.
+var revocationAdmins []string
+
+type RevokeCertificatesInput struct {
+	CertificateArns []string
+	// Domain selects unexpired certificates of the inventory issued for the domain or its subdomains.
+	Domain string
+	// VenafiZone limits certificates selected by Domain to the zone.
+	VenafiZone       string
+	RevocationReason acmpca.RevocationReason
+	// DryRun returns the selected certificates without revoking them.
+	DryRun bool
+}
+
+type RevokeCertificatesOutput struct {
+	Results []RevocationResult
+}
+
+type RevocationResult struct {
+	CertificateArn string
+	Revoked        bool
+	Error          string `json:",omitempty"`
+}
+
+func revokeCertificates(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	caller := request.RequestContext.Identity.UserArn
+	if !principalAllowed(caller, revocationAdmins) {
+		log.Printf("AUDIT: %s is not allowed to revoke certificates in bulk", caller)
+		return clientError(http.StatusForbidden, "Caller is not allowed to revoke certificates in bulk")
+	}
+	var input RevokeCertificatesInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiRevokeCertificates, err))
+	}
+	if len(input.CertificateArns) == 0 && input.Domain == "" {
+		return clientError(http.StatusBadRequest, "CertificateArns or Domain is required")
+	}
+	if input.RevocationReason == "" {
+		input.RevocationReason = acmpca.RevocationReasonUnspecified
+	}
+	if !isRevocationReason(input.RevocationReason) {
+		return clientError(http.StatusBadRequest, fmt.Sprintf("Unknown revocation reason %q", input.RevocationReason))
+	}
+
+	targets, err := revocationTargets(input, time.Now())
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get inventory from database: %s", err))
+	}
+	if !input.DryRun && len(targets) > maxBulkRevocations {
+		return clientError(http.StatusBadRequest, fmt.Sprintf("%d certificates selected, at most %d can be revoked in one request, "+
+			"list them with DryRun and revoke them in batches of CertificateArns", len(targets), maxBulkRevocations))
+	}
+	log.Printf("AUDIT: %s requested revocation of %d certificates with reason %s (dry run: %v)",
+		caller, len(targets), input.RevocationReason, input.DryRun)
+
+	results := make([]RevocationResult, len(targets))
+	if input.DryRun {
+		for i, t := range targets {
+			results[i].CertificateArn = t.CertificateArn
+		}
+	} else {
+		cfg, err := loadAWSConfig("", "")
+		if err != nil {
+			return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
+		}
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, bulkRevocationConcurrency)
+		for i := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() { <-sem; wg.Done() }()
+				results[i] = revokeTarget(ctx, cfg, targets[i], input.RevocationReason, request.RequestContext.Identity)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	body, err := json.Marshal(RevokeCertificatesOutput{Results: results})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+func isRevocationReason(reason acmpca.RevocationReason) bool {
+	_, ok := venafiRevocationReasons[reason]
+	return ok
+}
+
+// revocationTargets returns the requested certificates followed by unrevoked inventory certificates of the domain.
+// Only certificates found in the inventory are marked revoked there.
+func revocationTargets(input RevokeCertificatesInput, now time.Time) ([]common.InventoryRecord, error) {
+	var targets []common.InventoryRecord
+	seen := make(map[string]bool)
+	for _, a := range input.CertificateArns {
+		a = strings.TrimSpace(a)
+		if a != "" && !seen[a] {
+			seen[a] = true
+			targets = append(targets, common.InventoryRecord{CertificateArn: a})
+		}
+	}
+	if input.Domain == "" {
+		return targets, nil
+	}
+	records, err := listInventoryByName(input.Domain, func(r common.InventoryRecord) bool {
+		if seen[r.CertificateArn] || r.Status == common.InventoryStatusRevoked || (!r.NotAfter.IsZero() && r.NotAfter.Before(now)) {
+			return false
+		}
+		if input.VenafiZone != "" && r.Zone != input.VenafiZone {
+			return false
+		}
+		return certificateForDomain(r, input.Domain)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(targets, records...), nil
+}
+
+// certificateForDomain reports whether any name of the certificate is the domain or its subdomain, or is a wildcard
+// covering the domain.
+func certificateForDomain(r common.InventoryRecord, domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "*."))
+	parent := ""
+	if i := strings.Index(domain, "."); i >= 0 {
+		parent = domain[i+1:]
+	}
+	for _, name := range append([]string{r.DomainName}, r.SubjectAlternativeNames...) {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "*.") {
+			name = name[2:]
+			if name == parent {
+				return true
+			}
+		}
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func revokeTarget(ctx context.Context, cfg aws.Config, r common.InventoryRecord, reason acmpca.RevocationReason, caller events.APIGatewayRequestIdentity) RevocationResult {
+	result := RevocationResult{CertificateArn: r.CertificateArn}
+	err := revokeCertificate(ctx, cfg, r.CertificateArn, reason, caller)
+	if err != nil {
+		log.Printf("AUDIT: revocation of certificate %s failed: %s", r.CertificateArn, err)
+		result.Error = err.Error()
+		return result
+	}
+	log.Printf("AUDIT: certificate %s revoked with reason %s", r.CertificateArn, reason)
+	result.Revoked = true
+	if r.Status != "" {
+		r.Status = common.InventoryStatusRevoked
+		err = common.SaveInventoryRecord(r)
+		if err != nil {
+			log.Printf("Can't update certificate %s in inventory: %s", r.CertificateArn, err)
+		}
+	}
+	return result
+}
+
+// revokeCertificate revokes an ACM PCA certificate or a private ACM certificate by its ARN on behalf of the caller.
+func revokeCertificate(ctx context.Context, cfg aws.Config, certificateArn string, reason acmpca.RevocationReason, caller events.APIGatewayRequestIdentity) error {
+	caArn, serial, err := certificateIssuer(ctx, cfg, certificateArn)
+	if err != nil {
+		return err
+	}
+	ca, err := resolveCA(ctx, cfg, caArn)
+	if err != nil {
+		return err
+	}
+	if ca.Shared {
+		return fmt.Errorf("certificate authority %s belongs to account %s, revoke the certificate there", ca.Arn, ca.Arn.AccountID)
+	}
+	cfg = cfg.Copy()
+	cfg.Region = ca.Arn.Region
+	cli := acmpca.New(cfg)
+	input := &acmpca.RevokeCertificateInput{
+		CertificateAuthorityArn: aws.String(caArn),
+		CertificateSerial:       aws.String(serial),
+		RevocationReason:        reason,
+	}
+	_, err = cli.RevokeCertificateRequest(input).Send(ctx)
+	if err != nil {
+		return err
+	}
+	queueVenafiRevocation(ctx, cli, input, caller.UserArn, caller.AccountID, time.Now())
+	return nil
+}
+
+// certificateIssuer returns the issuing CA ARN and the serial number of a certificate.
+func certificateIssuer(ctx context.Context, cfg aws.Config, certificateArn string) (caArn, serial string, err error) {
+	a, err := arn.Parse(certificateArn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid certificate ARN: %s", err)
+	}
+	switch a.Service {
+	case "acm-pca":
+		i := strings.LastIndex(certificateArn, "/certificate/")
+		if i < 0 {
+			return "", "", fmt.Errorf("ARN is not an ACM PCA certificate")
+		}
+		return certificateArn[:i], certificateArn[i+len("/certificate/"):], nil
+	case "acm":
+		cfg = cfg.Copy()
+		cfg.Region = a.Region
+		resp, err := acm.New(cfg).DescribeCertificateRequest(&acm.DescribeCertificateInput{
+			CertificateArn: aws.String(certificateArn),
+		}).Send(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		c := resp.Certificate
+		if c == nil || aws.StringValue(c.CertificateAuthorityArn) == "" {
+			return "", "", fmt.Errorf("certificate is not issued by a private CA")
+		}
+		return aws.StringValue(c.CertificateAuthorityArn), aws.StringValue(c.Serial), nil
+	}
+	return "", "", fmt.Errorf("ARN is not an ACM or ACM PCA certificate")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"testing"
+)
+
+func TestCertificateForDomain(t *testing.T) {
+	r := common.InventoryRecord{
+		DomainName:              "www.example.com",
+		SubjectAlternativeNames: []string{"www.example.com", "*.api.example.org"},
+	}
+	cases := map[string]bool{
+		"example.com":          true,
+		"WWW.example.com":      true,
+		"api.example.org":      true,
+		"v1.api.example.org":   true,
+		"a.v1.api.example.org": false,
+		"*.example.org":        true,
+		"ample.com":            false,
+		"example.net":          false,
+	}
+	for domain, want := range cases {
+		if got := certificateForDomain(r, domain); got != want {
+			t.Errorf("certificateForDomain(%q) = %v, want %v", domain, got, want)
+		}
+	}
+}
+
+func TestCertificateIssuerPCA(t *testing.T) {
+	ca, serial, err := certificateIssuer(context.Background(), aws.Config{},
+		"arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/11111111-2222-3333-4444-555555555555/certificate/6b6cf0fe4e1d1f1b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca != "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/11111111-2222-3333-4444-555555555555" {
+		t.Errorf("unexpected CA %s", ca)
+	}
+	if serial != "6b6cf0fe4e1d1f1b" {
+		t.Errorf("unexpected serial %s", serial)
+	}
+	_, _, err = certificateIssuer(context.Background(), aws.Config{}, "arn:aws:s3:::bucket")
+	if err == nil {
+		t.Error("non-certificate ARN must fail")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"net/http"
+)
+
+const venafiIssueCertificates = "Venafi.IssueCertificates"
+
+// Bundled requests are issued one after another. maxBundleRequests keeps a bundle sent through API Gateway within the
+// request Lambda timeout, larger bundles are uploaded and issued by the upload Lambda, which runs for longer.
+const (
+	maxBundleRequests         = 3
+	maxUploadedBundleRequests = 10
+)
+
+type IssueCertificatesInput struct {
+	// Bundle is a PKCS#7/CMS bundle of certificate requests, see parseCSRBundle.
+	Bundle                  []byte
+	CertificateAuthorityArn *string
+	SigningAlgorithm        acmpca.SigningAlgorithm
+	Validity                *acmpca.Validity
+	VenafiZone              string
+	Tags                    []acm.Tag
+	// IdempotencyToken identifies retries of the request, see idempotencyKey.
+	IdempotencyToken string
+}
+
+type IssueCertificatesOutput struct {
+	Results []BundleResult
+}
+
+type BundleResult struct {
+	BodyPartID int
+	// RequestID is the ID the status of the request is recorded under.
+	RequestID               string `json:",omitempty"`
+	StatusCode              int
+	CertificateArn          string `json:",omitempty"`
+	CertificateAuthorityArn string `json:",omitempty"`
+	Error                   string `json:",omitempty"`
+}
+
+// issueCertificates issues every request of a bundle as a separate IssueCertificate request, so each of them is
+// validated against its zone and tracked like a single request.
+func issueCertificates(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input IssueCertificatesInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiIssueCertificates, err))
+	}
+	csrs, err := parseCSRBundle(input.Bundle)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if len(csrs) == 0 {
+		return clientError(http.StatusBadRequest, "Bundle has no certificate requests")
+	}
+	limit := maxBundleRequests
+	if uploadWorkerMode() {
+		limit = maxUploadedBundleRequests
+	}
+	if len(csrs) > limit {
+		return clientError(http.StatusBadRequest, fmt.Sprintf("Bundle has %d certificate requests, at most %d are allowed, "+
+			"upload larger bundles with %s", len(csrs), limit, venafiCreateUploadURL))
+	}
+
+	results := make([]BundleResult, len(csrs))
+	for i, csr := range csrs {
+		results[i] = issueBundledCSR(request, input, csr)
+	}
+	body, err := json.Marshal(IssueCertificatesOutput{Results: results})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+func issueBundledCSR(request events.APIGatewayProxyRequest, input IssueCertificatesInput, csr bundledCSR) BundleResult {
+	result := BundleResult{BodyPartID: csr.BodyPartID}
+	body, err := json.Marshal(ACMPCAIssueCertificateRequest{
+		IssueCertificateInput: acmpca.IssueCertificateInput{
+			CertificateAuthorityArn: input.CertificateAuthorityArn,
+			Csr:                     csr.CSR,
+			SigningAlgorithm:        input.SigningAlgorithm,
+			Validity:                input.Validity,
+		},
+		VenafiZone: input.VenafiZone,
+		Tags:       input.Tags,
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	response, err := ACMPCAHandler(bundledRequest(request, csr.BodyPartID, body))
+	if err != nil {
+		log.Printf("Bundled request %d failed: %s", csr.BodyPartID, err)
+		result.Error = err.Error()
+		return result
+	}
+	result.StatusCode = response.StatusCode
+	result.RequestID = response.Headers[requestIDHeader]
+	var responseBody struct {
+		ACMPCAIssueCertificateResponse
+		Msg string `json:"msg"`
+	}
+	_ = json.Unmarshal([]byte(response.Body), &responseBody)
+	result.CertificateArn = responseBody.CertificateArn
+	result.CertificateAuthorityArn = responseBody.CertificateAuthorityArn
+	result.Error = responseBody.Msg
+	return result
+}
+
+// bundledRequest builds the IssueCertificate request of a bundled CSR. A break-glass token is single-use, so it's not
+// passed on.
+func bundledRequest(request events.APIGatewayProxyRequest, bodyPartID int, body []byte) events.APIGatewayProxyRequest {
+	bundled := request
+	bundled.Headers = make(map[string]string, len(request.Headers))
+	for h, v := range request.Headers {
+		if h != breakGlassHeader {
+			bundled.Headers[h] = v
+		}
+	}
+	bundled.Headers["X-Amz-Target"] = acmpcaIssueCertificate
+	bundled.Body = string(body)
+	if request.RequestContext.RequestID != "" {
+		bundled.RequestContext.RequestID = fmt.Sprintf("%s-%d", request.RequestContext.RequestID, bodyPartID)
+	}
+	return bundled
+}
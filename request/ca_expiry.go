@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"sync"
+	"time"
+)
+
+// caExpiryCacheTTL is how long expiries of CA certificates are kept in memory of the Lambda container. They only
+// change when the CA certificate is renewed.
+const caExpiryCacheTTL = time.Hour
+
+type cachedCAExpiry struct {
+	notAfter time.Time
+	expires  time.Time
+}
+
+var (
+	caExpiryCacheMu sync.Mutex
+	caExpiryCache   = map[string]cachedCAExpiry{}
+)
+
+// caNotAfter returns the expiry of the CA certificate, from the cache or DescribeCertificateAuthority. The CA is
+// described with the configuration the certificate is issued with, i.e. with the role of the zone, and expiries are
+// cached per role, so a zone never gets the expiry of a CA its role can't describe.
+func caNotAfter(ctx context.Context, cfg aws.Config, ca caInfo, now time.Time) (time.Time, error) {
+	caArn := ca.Arn.String()
+	key := caExpiryCacheKey(cfg, caArn)
+	caExpiryCacheMu.Lock()
+	c, ok := caExpiryCache[key]
+	caExpiryCacheMu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.notAfter, nil
+	}
+	cfg = cfg.Copy()
+	cfg.Region = ca.Arn.Region
+	resp, err := acmpca.New(cfg).DescribeCertificateAuthorityRequest(&acmpca.DescribeCertificateAuthorityInput{
+		CertificateAuthorityArn: aws.String(caArn),
+	}).Send(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("can't describe certificate authority %s: %s", caArn, err)
+	}
+	if resp.CertificateAuthority == nil || resp.CertificateAuthority.NotAfter == nil {
+		// CAs without an installed certificate have no expiry yet, ACM PCA rejects the request itself.
+		return time.Time{}, nil
+	}
+	notAfter := resp.CertificateAuthority.NotAfter.UTC()
+	caExpiryCacheMu.Lock()
+	caExpiryCache[key] = cachedCAExpiry{notAfter: notAfter, expires: now.Add(caExpiryCacheTTL)}
+	caExpiryCacheMu.Unlock()
+	return notAfter, nil
+}
+
+// caExpiryCacheKey is the CA ARN, preceded by the role of the configuration if it assumes one.
+func caExpiryCacheKey(cfg aws.Config, caArn string) string {
+	if p, ok := cfg.Credentials.(*stscreds.AssumeRoleProvider); ok {
+		return p.RoleARN + " " + caArn
+	}
+	return caArn
+}
+
+// checkCAExpiry rejects validities ending after the CA certificate expires, which ACM PCA only rejects when issuing.
+// With clampValidity the validity is shortened to end with the CA certificate instead.
+func checkCAExpiry(notAfter time.Time, input *acmpca.IssueCertificateInput, now time.Time) error {
+	if notAfter.IsZero() {
+		return nil
+	}
+	end := validityEnd(input.Validity, now)
+	if end.IsZero() || !end.After(notAfter) {
+		return nil
+	}
+	if !clampValidity {
+		return fmt.Errorf("validity until %s exceeds expiry of the certificate authority on %s",
+			end.Format(time.RFC3339), notAfter.Format(time.RFC3339))
+	}
+	log.Printf("Clamping validity until %s to expiry of the certificate authority on %s", end.Format(time.RFC3339), notAfter.Format(time.RFC3339))
+	input.Validity = &acmpca.Validity{Type: acmpca.ValidityPeriodTypeAbsolute, Value: aws.Int64(notAfter.Unix())}
+	return nil
+}
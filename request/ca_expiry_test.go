@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"testing"
+	"time"
+)
+
+func TestCheckCAExpiry(t *testing.T) {
+	defer func(c bool) { clampValidity = c }(clampValidity)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := now.AddDate(0, 6, 0)
+
+	clampValidity = false
+	input := acmpca.IssueCertificateInput{Validity: &acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(90)}}
+	if err := checkCAExpiry(notAfter, &input, now); err != nil {
+		t.Errorf("validity within CA expiry was rejected: %s", err)
+	}
+	input.Validity = &acmpca.Validity{Type: acmpca.ValidityPeriodTypeYears, Value: aws.Int64(1)}
+	if checkCAExpiry(notAfter, &input, now) == nil {
+		t.Error("validity exceeding CA expiry should be rejected")
+	}
+	if err := checkCAExpiry(time.Time{}, &input, now); err != nil {
+		t.Errorf("unknown CA expiry should be left to ACM PCA: %s", err)
+	}
+
+	clampValidity = true
+	if err := checkCAExpiry(notAfter, &input, now); err != nil {
+		t.Fatal(err)
+	}
+	if input.Validity.Type != acmpca.ValidityPeriodTypeAbsolute || *input.Validity.Value != notAfter.Unix() {
+		t.Errorf("validity wasn't clamped to CA expiry: %v", input.Validity)
+	}
+}
+
+func TestCAExpiryCacheKey(t *testing.T) {
+	caArn := "arn:aws:acm-pca:us-east-1:111111111111:certificate-authority/11111111-2222-3333-4444-555555555555"
+	var cfg aws.Config
+	if key := caExpiryCacheKey(cfg, caArn); key != caArn {
+		t.Errorf("unexpected key without role %q", key)
+	}
+	cfg.Credentials = stscreds.NewAssumeRoleProvider(nil, "arn:aws:iam::222222222222:role/Issuer")
+	if key := caExpiryCacheKey(cfg, caArn); key != "arn:aws:iam::222222222222:role/Issuer "+caArn {
+		t.Errorf("unexpected key with role %q", key)
+	}
+}
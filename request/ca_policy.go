@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+)
+
+// caPolicyZone is the Venafi zone whose policy subjects and keys of CAs created through the proxy must comply with.
+// CAs are not validated when it's empty.
+var caPolicyZone string
+
+// newCARequest builds the request validated against the CA policy from the configuration of a new CA.
+func newCARequest(c *acmpca.CertificateAuthorityConfiguration) (req certificate.Request, err error) {
+	if c == nil || c.Subject == nil {
+		return req, fmt.Errorf("certificate authority configuration with subject is required")
+	}
+	req.Subject = normalizeSubject(asn1SubjectName(c.Subject))
+	switch c.KeyAlgorithm {
+	case acmpca.KeyAlgorithmRsa2048:
+		req.KeyType, req.KeyLength = certificate.KeyTypeRSA, 2048
+	case acmpca.KeyAlgorithmRsa4096:
+		req.KeyType, req.KeyLength = certificate.KeyTypeRSA, 4096
+	case acmpca.KeyAlgorithmEcPrime256v1:
+		req.KeyType, req.KeyCurve = certificate.KeyTypeECDSA, certificate.EllipticCurveP256
+	case acmpca.KeyAlgorithmEcSecp384r1:
+		req.KeyType, req.KeyCurve = certificate.KeyTypeECDSA, certificate.EllipticCurveP384
+	default:
+		return req, fmt.Errorf("unsupported key algorithm %q", c.KeyAlgorithm)
+	}
+	return req, nil
+}
+
+// validateCAConfiguration checks the subject and key algorithm of a new CA against the CA policy.
+func validateCAConfiguration(p endpoint.Policy, c *acmpca.CertificateAuthorityConfiguration) error {
+	req, err := newCARequest(c)
+	if err != nil {
+		return err
+	}
+	err = validateRequest(p, &req)
+	if err != nil {
+		return fmt.Errorf("certificate authority is not allowed by policy %s: %s", caPolicyZone, err)
+	}
+	return nil
+}
+
+// asn1SubjectName converts an ACM PCA subject to the name validated against the policy.
+func asn1SubjectName(s *acmpca.ASN1Subject) pkix.Name {
+	return pkix.Name{
+		CommonName:         aws.StringValue(s.CommonName),
+		SerialNumber:       aws.StringValue(s.SerialNumber),
+		Country:            subjectValues(s.Country),
+		Organization:       subjectValues(s.Organization),
+		OrganizationalUnit: subjectValues(s.OrganizationalUnit),
+		Locality:           subjectValues(s.Locality),
+		Province:           subjectValues(s.State),
+	}
+}
+
+// subjectValues converts a single-valued ASN1Subject attribute to values of a pkix.Name attribute.
+func subjectValues(v *string) []string {
+	if aws.StringValue(v) == "" {
+		return nil
+	}
+	return []string{*v}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"testing"
+)
+
+func TestValidateCAConfiguration(t *testing.T) {
+	policy := endpoint.Policy{
+		SubjectCNRegexes: []string{`^Example .* CA$`},
+		SubjectORegexes:  []string{`^Example Inc$`},
+		SubjectOURegexes: []string{`.*`},
+		SubjectLRegexes:  []string{`.*`},
+		SubjectSTRegexes: []string{`.*`},
+		SubjectCRegexes:  []string{`.*`},
+		AllowedKeyConfigurations: []endpoint.AllowedKeyConfiguration{
+			{KeyType: certificate.KeyTypeRSA, KeySizes: []int{4096}},
+			{KeyType: certificate.KeyTypeECDSA, KeyCurves: []certificate.EllipticCurve{certificate.EllipticCurveP384}},
+		},
+	}
+	c := &acmpca.CertificateAuthorityConfiguration{
+		KeyAlgorithm: acmpca.KeyAlgorithmRsa4096,
+		Subject: &acmpca.ASN1Subject{
+			CommonName:   aws.String("Example Issuing CA"),
+			Organization: aws.String("Example Inc"),
+		},
+	}
+	if err := validateCAConfiguration(policy, c); err != nil {
+		t.Errorf("CA should be allowed: %s", err)
+	}
+	c.KeyAlgorithm = acmpca.KeyAlgorithmEcSecp384r1
+	if err := validateCAConfiguration(policy, c); err != nil {
+		t.Errorf("CA with P-384 key should be allowed: %s", err)
+	}
+	c.KeyAlgorithm = acmpca.KeyAlgorithmRsa2048
+	if validateCAConfiguration(policy, c) == nil {
+		t.Error("CA with RSA 2048 key should be rejected")
+	}
+	c.KeyAlgorithm = acmpca.KeyAlgorithmRsa4096
+	c.Subject.Organization = aws.String("Other Inc")
+	if validateCAConfiguration(policy, c) == nil {
+		t.Error("CA of another organization should be rejected")
+	}
+	if validateCAConfiguration(policy, &acmpca.CertificateAuthorityConfiguration{}) == nil {
+		t.Error("CA without subject should be rejected")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// revocationRequirements are organizational requirements for revocation configuration of new and updated CAs.
+type revocationRequirements struct {
+	RequireCRL           bool
+	CRLBucketRegexp      *regexp.Regexp
+	MaxCRLExpirationDays int64
+}
+
+var caRevocationRequirements revocationRequirements
+
+func loadRevocationRequirements() {
+	caRevocationRequirements = revocationRequirements{
+		RequireCRL: os.Getenv("CA_REQUIRE_CRL") == "true",
+	}
+	if s := os.Getenv("CA_CRL_BUCKET_REGEX"); s != "" {
+		r, err := regexp.Compile(s)
+		if err != nil {
+			log.Printf("Can't parse CA_CRL_BUCKET_REGEX %q: %s", s, err)
+		} else {
+			caRevocationRequirements.CRLBucketRegexp = r
+		}
+	}
+	if s := os.Getenv("CA_CRL_MAX_EXPIRATION_DAYS"); s != "" {
+		days, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			log.Printf("Can't parse CA_CRL_MAX_EXPIRATION_DAYS %q: %s", s, err)
+		} else {
+			caRevocationRequirements.MaxCRLExpirationDays = days
+		}
+	}
+}
+
+// validate checks revocation configuration of a CA before it is forwarded to ACM PCA.
+func (r revocationRequirements) validate(rc *acmpca.RevocationConfiguration) error {
+	var crl *acmpca.CrlConfiguration
+	if rc != nil {
+		crl = rc.CrlConfiguration
+	}
+	if crl == nil || !aws.BoolValue(crl.Enabled) {
+		if r.RequireCRL {
+			return fmt.Errorf("CRL must be enabled for certificate authorities")
+		}
+		return nil
+	}
+	bucket := aws.StringValue(crl.S3BucketName)
+	if r.CRLBucketRegexp != nil && !r.CRLBucketRegexp.MatchString(bucket) {
+		return fmt.Errorf("CRL bucket %q doesn't match regular expression: %s", bucket, r.CRLBucketRegexp)
+	}
+	if r.MaxCRLExpirationDays > 0 && aws.Int64Value(crl.ExpirationInDays) > r.MaxCRLExpirationDays {
+		return fmt.Errorf("CRL validity %d days exceeds maximum of %d days", aws.Int64Value(crl.ExpirationInDays), r.MaxCRLExpirationDays)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// metricNamespace is the CloudWatch namespace of the proxy metrics.
+const metricNamespace = "VenafiCertificateProxy"
+
+const (
+	canaryRetrieveTimeout  = 30 * time.Second
+	canaryRetrieveInterval = 2 * time.Second
+)
+
+// canarySettings configure the canary mode, in which the Lambda runs on a schedule and issues a test certificate
+// through the whole request pipeline instead of serving API Gateway requests.
+type canarySettings struct {
+	Domain                  string
+	Zone                    string
+	CertificateAuthorityArn string
+}
+
+func loadCanarySettings() (s canarySettings, enabled bool) {
+	enabled = strings.HasPrefix(strings.ToLower(os.Getenv("CANARY_MODE")), "t")
+	s = canarySettings{
+		Domain:                  os.Getenv("CANARY_DOMAIN"),
+		Zone:                    os.Getenv("CANARY_ZONE"),
+		CertificateAuthorityArn: os.Getenv("CANARY_CA_ARN"),
+	}
+	if s.Zone == "" {
+		s.Zone = os.Getenv("DEFAULT_ZONE")
+	}
+	if s.Zone == "" {
+		s.Zone = defaultZone
+	}
+	return
+}
+
+// CanaryHandler requests a one day certificate through the request handler, retrieves and revokes it, and
+// publishes the CanarySuccess and CanaryDuration metrics.
+func CanaryHandler(ctx context.Context) error {
+	s, _ := loadCanarySettings()
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		log.Println("can`t load aws config", err)
+		return err
+	}
+	start := time.Now()
+	err = runCanary(ctx, s, cfg)
+	success := 1.0
+	if err != nil {
+		log.Println("ALERT: canary failed:", err)
+		success = 0
+	} else {
+		log.Printf("Canary succeeded in %s", time.Since(start))
+	}
+	putCanaryMetrics(ctx, cfg, s.Zone, success, time.Since(start))
+	return err
+}
+
+func runCanary(ctx context.Context, s canarySettings, cfg aws.Config) error {
+	if s.Domain == "" || s.CertificateAuthorityArn == "" {
+		return fmt.Errorf("CANARY_DOMAIN and CANARY_CA_ARN must be set")
+	}
+	account, err := getLambdaAccountID(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	csr, err := canaryCSR(s.Domain)
+	if err != nil {
+		return err
+	}
+
+	issueBody, err := json.Marshal(ACMPCAIssueCertificateRequest{
+		IssueCertificateInput: acmpca.IssueCertificateInput{
+			CertificateAuthorityArn: aws.String(s.CertificateAuthorityArn),
+			Csr:                     csr,
+			SigningAlgorithm:        acmpca.SigningAlgorithmSha256withecdsa,
+			Validity:                &acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(1)},
+		},
+		VenafiZone: s.Zone,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := canaryRequest(account, acmpcaIssueCertificate, issueBody)
+	if err != nil {
+		return fmt.Errorf("issuing certificate: %s", err)
+	}
+	var issued ACMPCAIssueCertificateResponse
+	err = json.Unmarshal([]byte(resp.Body), &issued)
+	if err != nil {
+		return err
+	}
+	caArn := issued.CertificateAuthorityArn
+	if caArn == "" {
+		caArn = s.CertificateAuthorityArn
+	}
+	log.Printf("Canary certificate %s issued", issued.CertificateArn)
+
+	getBody, err := json.Marshal(acmpca.GetCertificateInput{
+		CertificateArn:          aws.String(issued.CertificateArn),
+		CertificateAuthorityArn: aws.String(caArn),
+	})
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(canaryRetrieveTimeout)
+	for {
+		_, err = canaryRequest(account, acmpcaGetCertificate, getBody)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("retrieving certificate: %s", err)
+		}
+		time.Sleep(canaryRetrieveInterval)
+	}
+
+	// The canary certificate is revoked directly, it doesn't exist in Venafi.
+	caCfg := cfg.Copy()
+	if i := strings.Index(caArn, ":acm-pca:"); i >= 0 {
+		caCfg.Region = strings.Split(caArn[i+len(":acm-pca:"):], ":")[0]
+	}
+	_, err = acmpca.New(caCfg).RevokeCertificateRequest(&acmpca.RevokeCertificateInput{
+		CertificateAuthorityArn: aws.String(caArn),
+		CertificateSerial:       aws.String(issued.CertificateArn[strings.LastIndex(issued.CertificateArn, "/")+1:]),
+		RevocationReason:        acmpca.RevocationReasonCessationOfOperation,
+	}).Send(ctx)
+	if err != nil {
+		return fmt.Errorf("revoking certificate: %s", err)
+	}
+	return nil
+}
+
+// canaryRequest sends a request to the request handler as the canary and returns the response if it succeeded.
+func canaryRequest(account, target string, body []byte) (events.APIGatewayProxyResponse, error) {
+	var request events.APIGatewayProxyRequest
+	request.Headers = map[string]string{"X-Amz-Target": target}
+	request.Body = string(body)
+	request.RequestContext.RequestID = fmt.Sprintf("canary-%d", time.Now().UnixNano())
+	request.RequestContext.Identity.AccountID = account
+	request.RequestContext.Identity.UserArn = os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	resp, err := ACMPCAHandler(request)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("status %d: %s", resp.StatusCode, resp.Body)
+	}
+	return resp, nil
+}
+
+func canaryCSR(domain string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+func putCanaryMetrics(ctx context.Context, cfg aws.Config, zone string, success float64, duration time.Duration) {
+	dimensions := []cloudwatch.Dimension{{Name: aws.String("Zone"), Value: aws.String(zone)}}
+	_, err := cloudwatch.New(cfg).PutMetricDataRequest(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(metricNamespace),
+		MetricData: []cloudwatch.MetricDatum{
+			{
+				MetricName: aws.String("CanarySuccess"),
+				Dimensions: dimensions,
+				Unit:       cloudwatch.StandardUnitCount,
+				Value:      aws.Float64(success),
+			},
+			{
+				MetricName: aws.String("CanaryDuration"),
+				Dimensions: dimensions,
+				Unit:       cloudwatch.StandardUnitMilliseconds,
+				Value:      aws.Float64(float64(duration / time.Millisecond)),
+			},
+		},
+	}).Send(ctx)
+	if err != nil {
+		log.Println("Can't put canary metrics:", err)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCanaryCSR(t *testing.T) {
+	csr, err := canaryCSR("canary.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := newCSRRequest(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Subject.CommonName != "canary.example.com" || len(req.DNSNames) != 1 || req.DNSNames[0] != "canary.example.com" {
+		t.Errorf("unexpected canary request %s %v", req.Subject.CommonName, req.DNSNames)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	// oidCMCPKIData is the content type of a CMC Full PKI Request (RFC 5272).
+	oidCMCPKIData = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 12, 2}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// signedData holds the fields of CMS SignedData up to the content, the rest is ignored.
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapsulatedContentInfo
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type pkiData struct {
+	ControlSequence  []asn1.RawValue
+	ReqSequence      []asn1.RawValue
+	CmsSequence      []asn1.RawValue
+	OtherMsgSequence []asn1.RawValue
+}
+
+type taggedCertificationRequest struct {
+	BodyPartID           int
+	CertificationRequest asn1.RawValue
+}
+
+// bundledCSR is a certificate request of a bundle, identified by its CMC body part ID.
+type bundledCSR struct {
+	BodyPartID int
+	// CSR is PEM encoded, as in IssueCertificate requests.
+	CSR []byte
+}
+
+// parseCSRBundle returns PKCS#10 requests of a CMC Full PKI Request in a PKCS#7/CMS ContentInfo, DER or PEM encoded.
+// The content may be signed, the signature is not verified: callers are authenticated by API Gateway.
+func parseCSRBundle(bundle []byte) ([]bundledCSR, error) {
+	if block, _ := pem.Decode(bundle); block != nil {
+		bundle = block.Bytes
+	}
+	var ci contentInfo
+	_, err := asn1.Unmarshal(bundle, &ci)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 bundle: %s", err)
+	}
+	var content []byte
+	switch {
+	case ci.ContentType.Equal(oidSignedData):
+		var sd signedData
+		_, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PKCS#7 signed data: %s", err)
+		}
+		if !sd.EncapContentInfo.EContentType.Equal(oidCMCPKIData) {
+			return nil, fmt.Errorf("unsupported signed content type %s, expected CMC PKI data", sd.EncapContentInfo.EContentType)
+		}
+		content = sd.EncapContentInfo.EContent
+	case ci.ContentType.Equal(oidData):
+		_, err = asn1.Unmarshal(ci.Content.Bytes, &content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PKCS#7 data: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#7 content type %s", ci.ContentType)
+	}
+
+	var pki pkiData
+	_, err = asn1.Unmarshal(content, &pki)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMC PKI data: %s", err)
+	}
+	csrs := make([]bundledCSR, 0, len(pki.ReqSequence))
+	for i, r := range pki.ReqSequence {
+		// Only tagged PKCS#10 requests (tcr) are supported, not CRMF ones.
+		if r.Class != asn1.ClassContextSpecific || r.Tag != 0 {
+			return nil, fmt.Errorf("request %d is not a PKCS#10 request", i)
+		}
+		var tcr taggedCertificationRequest
+		_, err = asn1.UnmarshalWithParams(r.FullBytes, &tcr, "tag:0")
+		if err != nil {
+			return nil, fmt.Errorf("invalid request %d: %s", i, err)
+		}
+		_, err = x509.ParseCertificateRequest(tcr.CertificationRequest.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request with body part ID %d: %s", tcr.BodyPartID, err)
+		}
+		csrs = append(csrs, bundledCSR{
+			BodyPartID: tcr.BodyPartID,
+			CSR:        pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: tcr.CertificationRequest.FullBytes}),
+		})
+	}
+	return csrs, nil
+}
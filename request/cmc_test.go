@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"github.com/aws/aws-lambda-go/events"
+	"testing"
+)
+
+func testCMCBundle(t *testing.T, signed bool, names ...string) []byte {
+	var pki pkiData
+	pki.ControlSequence = []asn1.RawValue{}
+	pki.CmsSequence = []asn1.RawValue{}
+	pki.OtherMsgSequence = []asn1.RawValue{}
+	for i, name := range names {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tcr, err := asn1.MarshalWithParams(taggedCertificationRequest{BodyPartID: i + 1, CertificationRequest: asn1.RawValue{FullBytes: csr}}, "tag:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pki.ReqSequence = append(pki.ReqSequence, asn1.RawValue{FullBytes: tcr})
+	}
+	content, err := asn1.Marshal(pki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci := contentInfo{ContentType: oidData}
+	inner, err := asn1.Marshal(content)
+	if signed {
+		ci.ContentType = oidSignedData
+		inner, err = asn1.Marshal(signedData{
+			Version:          3,
+			DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+			EncapContentInfo: encapsulatedContentInfo{EContentType: oidCMCPKIData, EContent: content},
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Raw values are marshaled as they are, so the explicit tag is added here.
+	ci.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner}
+	bundle, err := asn1.Marshal(ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func TestParseCSRBundle(t *testing.T) {
+	for _, signed := range []bool{true, false} {
+		csrs, err := parseCSRBundle(testCMCBundle(t, signed, "a.example.com", "b.example.com"))
+		if err != nil {
+			t.Fatalf("signed %v: %s", signed, err)
+		}
+		if len(csrs) != 2 || csrs[0].BodyPartID != 1 || csrs[1].BodyPartID != 2 {
+			t.Fatalf("signed %v: unexpected requests %+v", signed, csrs)
+		}
+		req, err := newCSRRequest(csrs[1].CSR)
+		if err != nil || req.Subject.CommonName != "b.example.com" {
+			t.Fatalf("signed %v: unexpected request %+v: %v", signed, req.Subject, err)
+		}
+	}
+
+	pemBundle := pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: testCMCBundle(t, true, "a.example.com")})
+	if csrs, err := parseCSRBundle(pemBundle); err != nil || len(csrs) != 1 {
+		t.Fatalf("PEM bundle: %d requests: %v", len(csrs), err)
+	}
+	if _, err := parseCSRBundle([]byte("not a bundle")); err == nil {
+		t.Fatal("invalid bundle should fail")
+	}
+}
+
+func TestBundledRequest(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	request.Headers = map[string]string{"X-Amz-Target": venafiIssueCertificates, breakGlassHeader: "token", regionHeader: "eu-west-1"}
+	request.RequestContext.RequestID = "c6af9ac6-7b61-11e6-9a41-93e8deadbeef"
+	bundled := bundledRequest(request, 2, []byte(`{}`))
+	if bundled.Headers["X-Amz-Target"] != acmpcaIssueCertificate || bundled.Headers[regionHeader] != "eu-west-1" {
+		t.Fatalf("unexpected headers %v", bundled.Headers)
+	}
+	if _, ok := bundled.Headers[breakGlassHeader]; ok {
+		t.Fatal("break-glass token should not be passed to bundled requests")
+	}
+	if bundled.RequestContext.RequestID != "c6af9ac6-7b61-11e6-9a41-93e8deadbeef-2" {
+		t.Fatalf("unexpected request ID %s", bundled.RequestContext.RequestID)
+	}
+	if request.Headers["X-Amz-Target"] != venafiIssueCertificates {
+		t.Fatal("bundle request headers should not change")
+	}
+}
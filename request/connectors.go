@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	connectorSCEP = "pca-connector-scep"
+	connectorAD   = "pca-connector-ad"
+)
+
+// connectorAdmins may change connectors. Certificates issued through connectors are not validated by the proxy, so
+// connectors are administered by a few principals only.
+var connectorAdmins []string
+
+// connectorOperation is a REST operation of Connector for SCEP or Connector for AD. The SDK doesn't have these
+// services, requests are built from the JSON body of the pass-through target and signed here.
+type connectorOperation struct {
+	service string
+	method  string
+	// path has {Field} placeholders filled with body fields.
+	path string
+	// query are body fields sent as query parameters.
+	query []string
+	// mutating operations are allowed for connector admins only.
+	mutating bool
+}
+
+// Connector pass-through targets, named like other targets after the CLI service and operation names.
+var connectorOperations = map[string]connectorOperation{
+	"PcaConnectorScepCreateConnector":       {connectorSCEP, http.MethodPost, "/connectors", nil, true},
+	"PcaConnectorScepGetConnector":          {connectorSCEP, http.MethodGet, "/connectors/{ConnectorArn}", nil, false},
+	"PcaConnectorScepListConnectors":        {connectorSCEP, http.MethodGet, "/connectors", []string{"MaxResults", "NextToken"}, false},
+	"PcaConnectorScepDeleteConnector":       {connectorSCEP, http.MethodDelete, "/connectors/{ConnectorArn}", nil, true},
+	"PcaConnectorScepCreateChallenge":       {connectorSCEP, http.MethodPost, "/challenges", nil, true},
+	"PcaConnectorScepListChallengeMetadata": {connectorSCEP, http.MethodGet, "/challengeMetadata", []string{"ConnectorArn", "MaxResults", "NextToken"}, false},
+	"PcaConnectorScepDeleteChallenge":       {connectorSCEP, http.MethodDelete, "/challenges/{ChallengeArn}", nil, true},
+	"PcaConnectorAdCreateConnector":         {connectorAD, http.MethodPost, "/connectors", nil, true},
+	"PcaConnectorAdGetConnector":            {connectorAD, http.MethodGet, "/connectors/{ConnectorArn}", nil, false},
+	"PcaConnectorAdListConnectors":          {connectorAD, http.MethodGet, "/connectors", []string{"MaxResults", "NextToken"}, false},
+	"PcaConnectorAdDeleteConnector":         {connectorAD, http.MethodDelete, "/connectors/{ConnectorArn}", nil, true},
+	"PcaConnectorAdCreateTemplate":          {connectorAD, http.MethodPost, "/templates", nil, true},
+	"PcaConnectorAdGetTemplate":             {connectorAD, http.MethodGet, "/templates/{TemplateArn}", nil, false},
+	"PcaConnectorAdListTemplates":           {connectorAD, http.MethodGet, "/templates", []string{"ConnectorArn", "MaxResults", "NextToken"}, false},
+	"PcaConnectorAdUpdateTemplate":          {connectorAD, http.MethodPatch, "/templates/{TemplateArn}", nil, true},
+	"PcaConnectorAdDeleteTemplate":          {connectorAD, http.MethodDelete, "/templates/{TemplateArn}", nil, true},
+}
+
+// connectorPassThru passes a connector operation through. Connectors issue certificates without the proxy, so
+// creating one is allowed only for a CA the zone is bound to.
+func connectorPassThru(ctx context.Context, request events.APIGatewayProxyRequest, target string, op connectorOperation) (events.APIGatewayProxyResponse, error) {
+	caller := request.RequestContext.Identity.UserArn
+	if op.mutating && !principalAllowed(caller, connectorAdmins) {
+		log.Printf("AUDIT: %s is not allowed to call %s", caller, target)
+		return clientError(http.StatusForbidden, fmt.Sprintf("Caller is not allowed to call %s", target))
+	}
+	fields := map[string]json.RawMessage{}
+	if strings.TrimSpace(request.Body) != "" {
+		err := json.Unmarshal([]byte(request.Body), &fields)
+		if err != nil {
+			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
+		}
+	}
+	var zone string
+	if v, ok := fields["VenafiZone"]; ok {
+		_ = json.Unmarshal(v, &zone)
+		delete(fields, "VenafiZone")
+	}
+	if strings.HasSuffix(target, "CreateConnector") {
+		var caArn string
+		_ = json.Unmarshal(fields["CertificateAuthorityArn"], &caArn)
+		err := checkConnectorCA(zone, caArn)
+		if err != nil {
+			log.Printf("AUDIT: %s denied for %s: %s", target, caller, err)
+			return clientError(http.StatusForbidden, err.Error())
+		}
+	}
+
+	region, err := targetRegion(request, common.ZoneConfig{})
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	awsCfg, err := loadAWSConfig(region, "")
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
+	}
+	path, query, body, err := op.build(fields)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	header := http.Header{}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+	status, respBody, err := sendSignedRequest(ctx, awsCfg, op.service, op.method, path, query, header, body)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(respBody),
+		StatusCode: status,
+	}, nil
+}
+
+// checkConnectorCA allows connectors only for CAs listed in CertificateAuthorityArns of the zone.
+func checkConnectorCA(zone, caArn string) error {
+	if zone == "" {
+		zone = defaultZone
+	}
+	zoneConfig, err := common.GetZoneConfig(zone)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		return fmt.Errorf("failed to get zone configuration from database: %s", err)
+	}
+	if !stringInSlice(caArn, zoneConfig.CertificateAuthorityArns) {
+		return fmt.Errorf("certificate authority %q is not bound to zone %s, connectors can only be created for CAs of the zone", caArn, zone)
+	}
+	return nil
+}
+
+// build returns the escaped path, the query and the body of a request. Fields used in the path and the query are
+// removed from the body.
+func (op connectorOperation) build(fields map[string]json.RawMessage) (path string, query url.Values, body []byte, err error) {
+	field := func(name string) (string, bool) {
+		raw, ok := fields[name]
+		if !ok {
+			return "", false
+		}
+		delete(fields, name)
+		var s string
+		if json.Unmarshal(raw, &s) != nil {
+			// Numbers, e.g. MaxResults
+			s = string(raw)
+		}
+		return s, true
+	}
+	path = op.path
+	for strings.Contains(path, "{") {
+		start := strings.Index(path, "{")
+		end := strings.Index(path, "}")
+		name := path[start+1 : end]
+		value, ok := field(name)
+		if !ok || value == "" {
+			return "", nil, nil, fmt.Errorf("%s is required", name)
+		}
+		path = path[:start] + url.PathEscape(value) + path[end+1:]
+	}
+	query = url.Values{}
+	for _, name := range op.query {
+		if value, ok := field(name); ok {
+			query.Set(name, value)
+		}
+	}
+	if op.method == http.MethodPost || op.method == http.MethodPatch {
+		body, err = json.Marshal(fields)
+	}
+	return
+}
+
+// sendSignedRequest sends a request signed with the Lambda credentials to an AWS service and returns the status and
+// body of the response, for requests the SDK can't make.
+func sendSignedRequest(ctx context.Context, cfg aws.Config, service, method, path string, query url.Values, header http.Header, body []byte) (int, []byte, error) {
+	endpoint, err := cfg.EndpointResolver.ResolveEndpoint(service, cfg.Region)
+	if err != nil {
+		return 0, nil, err
+	}
+	u, err := url.Parse(endpoint.URL + path)
+	if err != nil {
+		return 0, nil, err
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	signingName := endpoint.SigningName
+	if signingName == "" {
+		signingName = service
+	}
+	_, err = v4.NewSigner(cfg.Credentials).Sign(req, bytes.NewReader(body), signingName, cfg.Region, time.Now())
+	if err != nil {
+		return 0, nil, err
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestConnectorOperationBuild(t *testing.T) {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal([]byte(`{"TemplateArn": "arn:aws:pca-connector-ad:us-east-1:123456789012:connector/c/template/t", "Name": "web"}`), &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, query, body, err := connectorOperations["PcaConnectorAdUpdateTemplate"].build(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/templates/arn:aws:pca-connector-ad:us-east-1:123456789012:connector%2Fc%2Ftemplate%2Ft" {
+		t.Errorf("unexpected path %s", path)
+	}
+	if len(query) != 0 || string(body) != `{"Name":"web"}` {
+		t.Errorf("unexpected query %v or body %s", query, body)
+	}
+
+	fields = map[string]json.RawMessage{}
+	_ = json.Unmarshal([]byte(`{"ConnectorArn": "arn:c", "MaxResults": 10}`), &fields)
+	op := connectorOperations["PcaConnectorScepListChallengeMetadata"]
+	path, query, body, err = op.build(fields)
+	if err != nil || op.method != http.MethodGet {
+		t.Fatal(err)
+	}
+	if path != "/challengeMetadata" || query.Encode() != "ConnectorArn=arn%3Ac&MaxResults=10" || body != nil {
+		t.Errorf("unexpected request %s?%s %s", path, query.Encode(), body)
+	}
+
+	_, _, _, err = connectorOperations["PcaConnectorScepDeleteConnector"].build(map[string]json.RawMessage{})
+	if err == nil {
+		t.Error("missing path field should fail")
+	}
+}
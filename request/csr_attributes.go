@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+// oidExtensionRequest is the PKCS#9 extensionRequest attribute which carries requested extensions, e.g. SANs.
+const oidExtensionRequest = "1.2.840.113549.1.9.14"
+
+// csrAllowedAttributes lists OIDs of PKCS#10 attributes allowed in CSRs besides extensionRequest.
+var csrAllowedAttributes []string
+
+type csrAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type tbsCertificateRequest struct {
+	Version       int
+	Subject       asn1.RawValue
+	PublicKey     asn1.RawValue
+	RawAttributes []asn1.RawValue `asn1:"tag:0"`
+}
+
+// checkCSRAttributes rejects CSRs with unexpected PKCS#10 attributes. Attributes like challengePassword usually come
+// from copy-pasted templates or tooling bugs and may leak secrets into requests.
+func checkCSRAttributes(csrPEM []byte, allowed []string) error {
+	pemBlock, _ := pem.Decode(csrPEM)
+	if pemBlock == nil {
+		return fmt.Errorf("CSR is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	var tbs tbsCertificateRequest
+	if _, err = asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return err
+	}
+	for _, raw := range tbs.RawAttributes {
+		var attr csrAttribute
+		if _, err = asn1.Unmarshal(raw.FullBytes, &attr); err != nil {
+			return fmt.Errorf("can't parse CSR attribute: %s", err)
+		}
+		oid := attr.Type.String()
+		if oid == oidExtensionRequest || stringInSlice(oid, allowed) {
+			continue
+		}
+		return fmt.Errorf("CSR attribute %s is not allowed", attributeName(oid))
+	}
+	return nil
+}
+
+func attributeName(oid string) string {
+	switch oid {
+	case "1.2.840.113549.1.9.7":
+		return "challengePassword (" + oid + ")"
+	case "1.2.840.113549.1.9.2":
+		return "unstructuredName (" + oid + ")"
+	}
+	return oid
+}
+
+func stringInSlice(s string, sl []string) bool {
+	for i := range sl {
+		if sl[i] == s {
+			return true
+		}
+	}
+	return false
+}
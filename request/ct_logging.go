@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"strings"
+)
+
+// applyCTLoggingPreference enforces the certificate transparency logging preference of the zone on public ACM
+// certificate requests. Requests without a preference get the zone's one, requests with a different one are rejected.
+// Private certificates aren't logged, so they're left alone.
+func applyCTLoggingPreference(zoneConfig common.ZoneConfig, input *acm.RequestCertificateInput) error {
+	if zoneConfig.CertificateTransparencyLogging == "" || input.CertificateAuthorityArn != nil {
+		return nil
+	}
+	want := acm.CertificateTransparencyLoggingPreference(strings.ToUpper(zoneConfig.CertificateTransparencyLogging))
+	if want != acm.CertificateTransparencyLoggingPreferenceEnabled && want != acm.CertificateTransparencyLoggingPreferenceDisabled {
+		return fmt.Errorf("zone has invalid certificate transparency logging preference %s", zoneConfig.CertificateTransparencyLogging)
+	}
+	if input.Options == nil {
+		input.Options = &acm.CertificateOptions{}
+	}
+	got := input.Options.CertificateTransparencyLoggingPreference
+	if got != "" && got != want {
+		return fmt.Errorf("certificate transparency logging %s is not allowed, the zone requires %s", got, want)
+	}
+	input.Options.CertificateTransparencyLoggingPreference = want
+	return nil
+}
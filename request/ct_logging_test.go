@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"testing"
+)
+
+func TestApplyCTLoggingPreference(t *testing.T) {
+	zoneConfig := common.ZoneConfig{CertificateTransparencyLogging: "enabled"}
+
+	var input acm.RequestCertificateInput
+	err := applyCTLoggingPreference(zoneConfig, &input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.Options == nil || input.Options.CertificateTransparencyLoggingPreference != acm.CertificateTransparencyLoggingPreferenceEnabled {
+		t.Errorf("preference wasn't set: %v", input.Options)
+	}
+
+	input = acm.RequestCertificateInput{Options: &acm.CertificateOptions{CertificateTransparencyLoggingPreference: acm.CertificateTransparencyLoggingPreferenceDisabled}}
+	if applyCTLoggingPreference(zoneConfig, &input) == nil {
+		t.Error("conflicting preference should be rejected")
+	}
+
+	input = acm.RequestCertificateInput{CertificateAuthorityArn: aws.String("arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/1")}
+	err = applyCTLoggingPreference(zoneConfig, &input)
+	if err != nil || input.Options != nil {
+		t.Errorf("private certificate shouldn't be changed: %v %v", err, input.Options)
+	}
+
+	if applyCTLoggingPreference(common.ZoneConfig{CertificateTransparencyLogging: "sometimes"}, &acm.RequestCertificateInput{}) == nil {
+		t.Error("invalid zone preference should be rejected")
+	}
+	if applyCTLoggingPreference(common.ZoneConfig{}, &input) != nil {
+		t.Error("zone without preference shouldn't reject")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultDedupWindow = 5 * time.Minute
+
+// dedupWindow is how long identical IssueCertificate requests return the previously issued certificate.
+// Zero disables deduplication.
+var dedupWindow = defaultDedupWindow
+
+func loadDedupWindow() {
+	dedupWindow = defaultDedupWindow
+	if s := os.Getenv("DEDUP_WINDOW_SECONDS"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			log.Printf("Invalid DEDUP_WINDOW_SECONDS %q, using %s", s, defaultDedupWindow)
+			return
+		}
+		dedupWindow = time.Duration(seconds) * time.Second
+	}
+}
+
+// issueRequestHash identifies an IssueCertificate request by the CSR and the parameters which affect the certificate.
+// The CSR is hashed in DER form, so PEM formatting differences of a retry don't matter.
+func issueRequestHash(zone string, input acmpca.IssueCertificateInput, ap *apiPassthrough) string {
+	csr := input.Csr
+	if pemBlock, _ := pem.Decode(csr); pemBlock != nil {
+		csr = pemBlock.Bytes
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", zone, aws.StringValue(input.CertificateAuthorityArn), input.SigningAlgorithm)
+	if input.Validity != nil {
+		fmt.Fprintf(h, "%s %d\n", input.Validity.Type, aws.Int64Value(input.Validity.Value))
+	}
+	if ap != nil {
+		// Marshaling a struct is deterministic, so identical ApiPassthrough values hash the same.
+		b, _ := json.Marshal(ap)
+		h.Write(b)
+	}
+	h.Write(csr)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// findIssuedRequest returns the certificate issued for an identical request within the deduplication window.
+func findIssuedRequest(requestHash string, now time.Time) (r common.IssuedRequest, found bool) {
+	if dedupWindow == 0 {
+		return r, false
+	}
+	r, err := common.GetIssuedRequest(requestHash)
+	if err != nil {
+		if err != common.IssuedRequestNotFound {
+			log.Println("Can't check previously issued requests:", err)
+		}
+		return r, false
+	}
+	// Expired items are deleted by DynamoDB with a delay.
+	if now.Sub(r.CreatedAt) > dedupWindow {
+		return r, false
+	}
+	return r, true
+}
+
+func saveIssuedRequest(requestHash, certificateArn, issuer string, now time.Time) {
+	if dedupWindow == 0 {
+		return
+	}
+	err := common.SaveIssuedRequest(common.IssuedRequest{
+		RequestHash:             requestHash,
+		CertificateArn:          certificateArn,
+		CertificateAuthorityArn: issuer,
+		CreatedAt:               now.UTC(),
+		TTL:                     now.Add(dedupWindow).Unix(),
+	})
+	if err != nil {
+		log.Println("Can't save issued request:", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"testing"
+)
+
+func TestIssueRequestHash(t *testing.T) {
+	csr := createCSR("dedup.example.com")
+	input := acmpca.IssueCertificateInput{
+		CertificateAuthorityArn: aws.String("arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"),
+		Csr:                     csr,
+		SigningAlgorithm:        acmpca.SigningAlgorithmSha256withrsa,
+		Validity:                &acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(30)},
+	}
+	hash := issueRequestHash("Default", input, nil)
+
+	retry := input
+	retry.Csr = bytes.Replace(csr, []byte("\n"), []byte("\r\n"), -1)
+	if issueRequestHash("Default", retry, nil) != hash {
+		t.Error("PEM formatting of a resubmission should not change the hash")
+	}
+	if issueRequestHash("Other", input, nil) == hash {
+		t.Error("hash should depend on the zone")
+	}
+	otherValidity := input
+	otherValidity.Validity = &acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(60)}
+	if issueRequestHash("Default", otherValidity, nil) == hash {
+		t.Error("hash should depend on the validity")
+	}
+	otherCSR := input
+	otherCSR.Csr = createCSR("other.example.com")
+	if issueRequestHash("Default", otherCSR, nil) == hash {
+		t.Error("hash should depend on the CSR")
+	}
+	ap := &apiPassthrough{Subject: &acmpca.ASN1Subject{CommonName: aws.String("other.example.com")}}
+	if issueRequestHash("Default", input, ap) == hash {
+		t.Error("hash should depend on ApiPassthrough")
+	}
+}
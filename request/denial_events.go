@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// denialEventRetention is how long the policy Lambda retries writing a denial to the TPP log.
+const denialEventRetention = 24 * time.Hour
+
+// denialEventTimeout limits how long a rejected request waits for its denial to be forwarded, it delays the response.
+const denialEventTimeout = time.Second
+
+// Syslog priority of denial events: facility log audit (13), severity warning (4).
+const denialSyslogPriority = 13*8 + 4
+
+type denialEventSettings struct {
+	// Venafi queues denials for the policy Lambda to write to the TPP log.
+	Venafi bool
+	// URL is an https:// endpoint denials are posted to as JSON, or a udp://, tcp:// or tls:// syslog server, none
+	// when nil.
+	URL *url.URL
+	// Authorization is the Authorization header of posted denials.
+	Authorization string
+}
+
+var denialEvents denialEventSettings
+
+var denialEventClient = &http.Client{Timeout: denialEventTimeout}
+
+func loadDenialEvents() {
+	denialEvents = denialEventSettings{
+		Venafi:        os.Getenv("DENIAL_EVENTS_TO_VENAFI") == "true",
+		Authorization: os.Getenv("DENIAL_EVENT_AUTHORIZATION"),
+	}
+	if v := os.Getenv("DENIAL_EVENT_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			log.Printf("Invalid DENIAL_EVENT_URL %q, denials are not forwarded to it", v)
+			return
+		}
+		switch u.Scheme {
+		case "https", "udp", "tcp", "tls":
+			denialEvents.URL = u
+		default:
+			log.Printf("DENIAL_EVENT_URL %q must be an https://, udp://, tcp:// or tls:// URL, denials are not forwarded to it", v)
+		}
+	}
+}
+
+// forwardDenialEvent sends requests rejected by policy to the TPP log and the SIEM endpoint, so security teams see
+// enforcement next to their Venafi audit stream. It is best effort, failures are only logged.
+func forwardDenialEvent(ctx context.Context, request events.APIGatewayProxyRequest, target string, response events.APIGatewayProxyResponse) {
+	if !denialEvents.Venafi && denialEvents.URL == nil {
+		return
+	}
+	now := time.Now()
+	status := newRequestStatus(request, target, response, now)
+	if status.Status != common.RequestRejected {
+		return
+	}
+	if status.Zone == "" {
+		status.Zone = defaultZone
+	}
+	e := common.DenialEvent{
+		RequestID:     status.RequestID,
+		Target:        status.Target,
+		Zone:          status.Zone,
+		CallerArn:     status.CallerArn,
+		SourceAccount: status.SourceAccount,
+		StatusCode:    status.StatusCode,
+		Message:       status.Message,
+		CreatedAt:     status.CreatedAt,
+		TTL:           now.Add(denialEventRetention).Unix(),
+	}
+	if denialEvents.Venafi {
+		err := common.SaveDenialEvent(e)
+		if err != nil {
+			log.Printf("Can't queue denial event of request %s: %s", e.RequestID, err)
+		}
+	}
+	if denialEvents.URL != nil {
+		err := sendDenialEvent(ctx, denialEvents, e)
+		if err != nil {
+			log.Printf("Can't forward denial event of request %s to %s: %s", e.RequestID, denialEvents.URL.Host, err)
+		}
+	}
+}
+
+func sendDenialEvent(ctx context.Context, s denialEventSettings, e common.DenialEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if s.URL.Scheme != "https" {
+		return sendSyslog(s.URL, syslogMessage(e.CreatedAt, body))
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if s.Authorization != "" {
+		req.Header.Set("Authorization", s.Authorization)
+	}
+	resp, err := denialEventClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// syslogMessage formats the event as an RFC 5424 message with the JSON event as its message.
+func syslogMessage(t time.Time, event []byte) []byte {
+	app := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	if app == "" {
+		app = "-"
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s - %s - POLICY_DENIAL - %s", denialSyslogPriority, t.UTC().Format(time.RFC3339), app, event))
+}
+
+// sendSyslog sends the message as a UDP datagram, or framed by octet counting over TCP or TLS (RFC 6587).
+func sendSyslog(u *url.URL, msg []byte) error {
+	var conn net.Conn
+	var err error
+	switch u.Scheme {
+	case "udp":
+		conn, err = net.DialTimeout("udp", u.Host, denialEventTimeout)
+	case "tcp":
+		conn, err = net.DialTimeout("tcp", u.Host, denialEventTimeout)
+	case "tls":
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: denialEventTimeout}, "tcp", u.Host, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(denialEventTimeout))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "udp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	_, err = conn.Write(msg)
+	return err
+}
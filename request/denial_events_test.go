@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendDenialEventHTTPS(t *testing.T) {
+	var got common.DenialEvent
+	var gotAuth string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+	client := denialEventClient
+	denialEventClient = server.Client()
+	defer func() { denialEventClient = client }()
+
+	u, _ := url.Parse(server.URL)
+	e := common.DenialEvent{RequestID: "r1", Zone: "Default", Message: "Key length 1024 is too small"}
+	err := sendDenialEvent(context.Background(), denialEventSettings{URL: u, Authorization: "Splunk token"}, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RequestID != "r1" || got.Message != e.Message || gotAuth != "Splunk token" {
+		t.Errorf("unexpected event %+v with authorization %q", got, gotAuth)
+	}
+}
+
+func TestSendDenialEventSyslog(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('}')
+		received <- line
+	}()
+
+	created := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	e := common.DenialEvent{RequestID: "r1", Zone: "Default", CreatedAt: created}
+	err = sendDenialEvent(context.Background(), denialEventSettings{URL: &url.URL{Scheme: "tcp", Host: l.Addr().String()}}, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-received
+	if !strings.Contains(msg, " <108>1 2020-03-01T10:00:00Z - ") || !strings.Contains(msg, " POLICY_DENIAL - {\"RequestID\":\"r1\"") {
+		t.Errorf("unexpected syslog message %q", msg)
+	}
+}
+
+func TestLoadDenialEvents(t *testing.T) {
+	defer func() {
+		os.Unsetenv("DENIAL_EVENT_URL")
+		loadDenialEvents()
+	}()
+	for v, valid := range map[string]bool{"https://siem.example.com/events": true, "udp://syslog.example.com:514": true, "http://siem.example.com": false, "syslog.example.com:514": false} {
+		os.Setenv("DENIAL_EVENT_URL", v)
+		loadDenialEvents()
+		if (denialEvents.URL != nil) != valid {
+			t.Errorf("DENIAL_EVENT_URL %q valid should be %v", v, valid)
+		}
+	}
+}
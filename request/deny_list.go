@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+// checkDeniedDomains rejects requests for names in domains denied in the zone. It's checked before the policy, so
+// names under a denied domain are rejected even if the domains allowed in Venafi include them.
+func checkDeniedDomains(zoneConfig common.ZoneConfig, req *certificate.Request) error {
+	if len(zoneConfig.DeniedDomains) == 0 {
+		return nil
+	}
+	denied := canonicalDNSNames(zoneConfig.DeniedDomains)
+	for _, name := range requestedNames(req) {
+		if nameInZones(name, denied) {
+			return fmt.Errorf("name %s is in a domain denied in this zone", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"strings"
+)
+
+const venafiDescribePolicy = "Venafi.DescribePolicy"
+
+type DescribePolicyInput struct {
+	VenafiZone string
+}
+
+// PolicyDescription is the effective policy of a zone in a form readable by developers.
+type PolicyDescription struct {
+	Zone string
+	// AllowedDomains are domains whose names and subdomains are allowed, as synced from Venafi.
+	AllowedDomains []string
+	AllowWildcards bool
+	AllowKeyReuse  bool
+	// Subject and SANs hold regular expressions values must match. Empty list means any value is allowed.
+	Subject  SubjectRules
+	SANs     SANRules
+	KeyTypes []KeyRule
+	// LockedSubject are subject attributes locked to values in Venafi, requests must have one of the values.
+	LockedSubject map[string][]string `json:",omitempty"`
+	// ZoneRules are proxy-side rules of the zone applied on top of the Venafi policy.
+	ZoneRules ZoneRules
+}
+
+type SubjectRules struct {
+	CommonName         []string
+	Organization       []string
+	OrganizationalUnit []string
+	Locality           []string
+	Province           []string
+	Country            []string
+}
+
+type SANRules struct {
+	DNS   []string
+	IP    []string
+	Email []string
+	URI   []string
+	UPN   []string
+}
+
+type KeyRule struct {
+	Type   string
+	Sizes  []int    `json:",omitempty"`
+	Curves []string `json:",omitempty"`
+}
+
+type ZoneRules struct {
+	EnforcementMode                string
+	RequireDNSSAN                  bool
+	ForbidCommonName               bool
+	RequireCommonNameInSANs        bool
+	AutoCorrect                    bool
+	DeniedDomains                  []string
+	ForbidWildcards                bool
+	WildcardDomains                []string
+	ForbidKeyExport                bool
+	CertificateTransparencyLogging string
+	AllowedKeyCurves               []string
+	AllowedExtendedKeyUsages       []string
+	MaxSANs                        int
+	MaxNameLength                  int
+	MaxCSRSize                     int
+	MaxExtensionsSize              int
+	DNSCheck                       string
+	VerifyOwnership                bool
+	RequiredTags                   []string
+	FreezeWindows                  []common.FreezeWindow
+	AllowedSourceRegions           []string
+	AllowedCountries               []string
+}
+
+func describePolicy(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input DescribePolicyInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiDescribePolicy, err))
+	}
+	input.VenafiZone, err = zoneOrDefault(input.VenafiZone)
+	if err != nil {
+		return zoneErrorResponse(err)
+	}
+	policy, err := getPolicy(input.VenafiZone)
+	if err == common.PolicyNotFound || err == common.PolicyFoundButEmpty {
+		return clientError(http.StatusNotFound, fmt.Sprintf("Policy %s not exist in database.", input.VenafiZone))
+	} else if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
+	}
+	zoneConfig, err := common.GetZoneConfig(input.VenafiZone)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+	}
+
+	body, err := json.Marshal(newPolicyDescription(input.VenafiZone, policy, zoneConfig))
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+func newPolicyDescription(zone string, p endpoint.Policy, zoneConfig common.ZoneConfig) PolicyDescription {
+	d := PolicyDescription{
+		Zone:           zone,
+		AllowWildcards: p.AllowWildcards,
+		AllowKeyReuse:  p.AllowKeyReuse,
+		Subject: SubjectRules{
+			CommonName:         p.SubjectCNRegexes,
+			Organization:       p.SubjectORegexes,
+			OrganizationalUnit: p.SubjectOURegexes,
+			Locality:           p.SubjectLRegexes,
+			Province:           p.SubjectSTRegexes,
+			Country:            p.SubjectCRegexes,
+		},
+		SANs: SANRules{
+			DNS:   p.DnsSanRegExs,
+			IP:    p.IpSanRegExs,
+			Email: p.EmailSanRegExs,
+			URI:   p.UriSanRegExs,
+			UPN:   p.UpnSanRegExs,
+		},
+		ZoneRules: ZoneRules{
+			EnforcementMode:                enforcementMode(zoneConfig),
+			RequireDNSSAN:                  zoneConfig.RequireDNSSAN,
+			ForbidCommonName:               zoneConfig.ForbidCommonName,
+			RequireCommonNameInSANs:        zoneConfig.RequireCommonNameInSANs,
+			AutoCorrect:                    zoneConfig.AutoCorrect,
+			DeniedDomains:                  zoneConfig.DeniedDomains,
+			ForbidWildcards:                zoneConfig.ForbidWildcards,
+			WildcardDomains:                zoneConfig.WildcardDomains,
+			ForbidKeyExport:                zoneConfig.ForbidKeyExport,
+			CertificateTransparencyLogging: zoneConfig.CertificateTransparencyLogging,
+			AllowedKeyCurves:               zoneConfig.AllowedKeyCurves,
+			AllowedExtendedKeyUsages:       zoneConfig.AllowedExtendedKeyUsages,
+			MaxSANs:                        zoneConfig.MaxSANs,
+			MaxNameLength:                  zoneConfig.MaxNameLength,
+			MaxCSRSize:                     zoneConfig.MaxCSRSize,
+			MaxExtensionsSize:              zoneConfig.MaxExtensionsSize,
+			DNSCheck:                       zoneConfig.DNSCheck,
+			VerifyOwnership:                zoneConfig.VerifyOwnership,
+			RequiredTags:                   zoneConfig.RequiredTags,
+			FreezeWindows:                  zoneConfig.FreezeWindows,
+			AllowedSourceRegions:           zoneConfig.AllowedSourceRegions,
+			AllowedCountries:               zoneConfig.AllowedCountries,
+		},
+	}
+	for _, r := range append(append([]string{}, p.SubjectCNRegexes...), p.DnsSanRegExs...) {
+		if domain, ok := regexDomain(r); ok && !stringInSlice(domain, d.AllowedDomains) {
+			d.AllowedDomains = append(d.AllowedDomains, domain)
+		}
+	}
+	for _, c := range p.AllowedKeyConfigurations {
+		rule := KeyRule{Type: c.KeyType.String(), Sizes: c.KeySizes}
+		for _, curve := range c.KeyCurves {
+			rule.Curves = append(rule.Curves, curve.String())
+		}
+		d.KeyTypes = append(d.KeyTypes, rule)
+	}
+	for _, c := range subjectComponents(p, &certificate.Request{}) {
+		if locked, ok := lockedValues(c.regexes); ok {
+			if d.LockedSubject == nil {
+				d.LockedSubject = map[string][]string{}
+			}
+			d.LockedSubject[c.field] = locked
+		}
+	}
+	return d
+}
+
+// Prefixes of domain regular expressions generated by vcert from Venafi policy domains
+var domainRegexPrefixes = []string{`^([\p{L}\p{N}-*]+\.)*`, `^([\p{L}\p{N}-]+\.)*`}
+
+// regexDomain returns the domain of a regular expression generated from a Venafi policy domain.
+func regexDomain(r string) (string, bool) {
+	for _, prefix := range domainRegexPrefixes {
+		if !strings.HasPrefix(r, prefix) || !strings.HasSuffix(r, "$") {
+			continue
+		}
+		quoted := strings.TrimSuffix(strings.TrimPrefix(r, prefix), "$")
+		var domain strings.Builder
+		for i := 0; i < len(quoted); i++ {
+			if quoted[i] == '\\' && i+1 < len(quoted) {
+				i++
+			} else if strings.IndexByte(`.*+?()[]{}|^$\`, quoted[i]) >= 0 {
+				// Custom regular expression, not a plain domain.
+				return "", false
+			}
+			domain.WriteByte(quoted[i])
+		}
+		return domain.String(), true
+	}
+	return "", false
+}
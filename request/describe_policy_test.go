@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestRegexDomain(t *testing.T) {
+	cases := []struct {
+		regex  string
+		domain string
+		ok     bool
+	}{
+		{`^([\p{L}\p{N}-*]+\.)*example\.com$`, "example.com", true},
+		{`^([\p{L}\p{N}-]+\.)*my-site\.example\.org$`, "my-site.example.org", true},
+		{`^([\p{L}\p{N}-]+\.)*.*\.example\.org$`, "", false},
+		{`^.*$`, "", false},
+	}
+	for _, c := range cases {
+		domain, ok := regexDomain(c.regex)
+		if domain != c.domain || ok != c.ok {
+			t.Errorf("regexDomain(%q) = %q, %v, want %q, %v", c.regex, domain, ok, c.domain, c.ok)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS check modes of a zone
+const (
+	dnsCheckWarn = "warn"
+	dnsCheckDeny = "deny"
+)
+
+const dnsLookupTimeout = 2 * time.Second
+
+var resolver = net.DefaultResolver
+
+// checkDNSNames resolves requested names and warns or denies, depending on the zone, when a name doesn't exist in DNS.
+// It catches typos before a certificate is minted for the wrong hostname. Wildcard names are not checked.
+func checkDNSNames(ctx context.Context, zoneConfig common.ZoneConfig, req *certificate.Request) error {
+	if zoneConfig.DNSCheck != dnsCheckWarn && zoneConfig.DNSCheck != dnsCheckDeny {
+		return nil
+	}
+	names := requestedNames(req)
+	var missing []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+		_, err := resolver.LookupHost(lookupCtx, name)
+		cancel()
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			missing = append(missing, name)
+		} else if err != nil {
+			log.Printf("Can't resolve %s, skipping DNS check: %s", name, err)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if zoneConfig.DNSCheck == dnsCheckWarn {
+		log.Printf("Warning: names %v don't exist in DNS", missing)
+		return nil
+	}
+	return fmt.Errorf("names %v don't exist in DNS", missing)
+}
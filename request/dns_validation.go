@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// dnsValidationAccounts are accounts whose public hosted zones get validation records of ACM certificates requested
+// with DNS validation. Hosted zones of other accounts are accessed with dnsValidationRoleName.
+var (
+	dnsValidationAccounts []string
+	dnsValidationRoleName string
+)
+
+const (
+	validationRecordTTL = 300
+	// dnsValidationRetention outlives the 72 hours ACM waits for validation, so records of timed out certificates
+	// are cleaned up too.
+	dnsValidationRetention = 7 * 24 * time.Hour
+)
+
+var errNoHostedZone = fmt.Errorf("no hosted zone in DNS validation accounts")
+
+func loadDNSValidationSettings() {
+	dnsValidationAccounts = common.SplitList(os.Getenv("DNS_VALIDATION_ACCOUNTS"))
+	dnsValidationRoleName = os.Getenv("DNS_VALIDATION_ROLE_NAME")
+}
+
+func dnsValidationWorkerMode() bool {
+	return os.Getenv("DNS_VALIDATION_WORKER_MODE") == "true"
+}
+
+// needsDNSValidation tells if validation records of a requested certificate are managed by the proxy. Private
+// certificates are not validated.
+func needsDNSValidation(input acm.RequestCertificateInput) bool {
+	return len(dnsValidationAccounts) > 0 && input.ValidationMethod == acm.ValidationMethodDns && input.CertificateAuthorityArn == nil
+}
+
+// startDNSValidation registers a requested certificate for DNS validation and creates the records ACM already
+// provides. The rest is done by DNSValidationHandler, ACM takes a few seconds to provide the records.
+func startDNSValidation(ctx context.Context, input acm.RequestCertificateInput, certificateArn, region, roleArn string) {
+	if !needsDNSValidation(input) {
+		return
+	}
+	now := time.Now()
+	v := common.DNSValidation{
+		CertificateArn: certificateArn,
+		Region:         region,
+		RoleArn:        roleArn,
+		CreatedAt:      now.UTC(),
+		TTL:            now.Add(dnsValidationRetention).Unix(),
+	}
+	err := common.SaveDNSValidation(v)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		log.Printf("ALERT: can't save DNS validation of certificate %s, it must be validated manually: %s", certificateArn, err)
+		return
+	}
+	err = processDNSValidation(ctx, v)
+	if err != nil {
+		log.Printf("Can't validate certificate %s yet: %s", certificateArn, err)
+	}
+}
+
+// DNSValidationHandler runs on a schedule in DNS validation worker mode. It creates validation records of pending
+// certificates and deletes them once certificates are issued or validation fails.
+func DNSValidationHandler(ctx context.Context) error {
+	validations, err := common.ListDNSValidations()
+	if err != nil {
+		log.Println("Can't list DNS validations:", err)
+		return err
+	}
+	var failed int
+	for _, v := range validations {
+		err = processDNSValidation(ctx, v)
+		if err != nil {
+			log.Printf("DNS validation of certificate %s failed: %s", v.CertificateArn, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d DNS validations failed", failed, len(validations))
+	}
+	return nil
+}
+
+func processDNSValidation(ctx context.Context, v common.DNSValidation) error {
+	cfg, err := loadAWSConfig(v.Region, v.RoleArn)
+	if err != nil {
+		return err
+	}
+	resp, err := acm.New(cfg).DescribeCertificateRequest(&acm.DescribeCertificateInput{CertificateArn: aws.String(v.CertificateArn)}).Send(ctx)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == acm.ErrCodeResourceNotFoundException {
+		return finishDNSValidation(ctx, v)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Certificate.Status != acm.CertificateStatusPendingValidation {
+		log.Printf("Certificate %s is %s, deleting its validation records", v.CertificateArn, resp.Certificate.Status)
+		return finishDNSValidation(ctx, v)
+	}
+	for _, o := range resp.Certificate.DomainValidationOptions {
+		r := o.ResourceRecord
+		// Names of a certificate may share a validation record, e.g. a domain and its wildcard.
+		if r == nil || hasValidationRecord(v.Records, aws.StringValue(r.Name)) {
+			continue
+		}
+		record, err := createValidationRecord(ctx, aws.StringValue(o.DomainName), aws.StringValue(r.Name), aws.StringValue(r.Value))
+		if err == errNoHostedZone {
+			log.Printf("Domain %s of certificate %s must be validated manually: %s", aws.StringValue(o.DomainName), v.CertificateArn, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Created validation record %s of certificate %s in hosted zone %s", record.Name, v.CertificateArn, record.HostedZoneID)
+		v.Records = append(v.Records, record)
+	}
+	return common.SaveDNSValidation(v)
+}
+
+func finishDNSValidation(ctx context.Context, v common.DNSValidation) error {
+	for _, r := range v.Records {
+		err := changeValidationRecord(ctx, route53.ChangeActionDelete, r)
+		if err != nil {
+			return err
+		}
+	}
+	return common.DeleteDNSValidation(v.CertificateArn)
+}
+
+func hasValidationRecord(records []common.DNSValidationRecord, name string) bool {
+	for _, r := range records {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func createValidationRecord(ctx context.Context, domain, name, value string) (common.DNSValidationRecord, error) {
+	record := common.DNSValidationRecord{Name: name, Value: value}
+	for _, account := range dnsValidationAccounts {
+		cfg, err := dnsValidationConfig(ctx, account)
+		if err != nil {
+			return record, err
+		}
+		var zones []route53.HostedZone
+		p := route53.NewListHostedZonesPaginator(route53.New(cfg).ListHostedZonesRequest(&route53.ListHostedZonesInput{}))
+		for p.Next(ctx) {
+			zones = append(zones, p.CurrentPage().HostedZones...)
+		}
+		if err = p.Err(); err != nil {
+			return record, fmt.Errorf("can't list hosted zones of account %s: %s", account, err)
+		}
+		zone, found := matchHostedZone(domain, zones)
+		if !found {
+			continue
+		}
+		record.Account = account
+		record.HostedZoneID = aws.StringValue(zone.Id)
+		return record, changeValidationRecord(ctx, route53.ChangeActionUpsert, record)
+	}
+	return record, errNoHostedZone
+}
+
+// matchHostedZone returns the public hosted zone with the longest name the domain belongs to.
+func matchHostedZone(domain string, zones []route53.HostedZone) (zone route53.HostedZone, found bool) {
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSuffix(domain, "."), "*."))
+	var longest int
+	for _, z := range zones {
+		if z.Config != nil && aws.BoolValue(z.Config.PrivateZone) {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(aws.StringValue(z.Name), "."))
+		if (domain == name || strings.HasSuffix(domain, "."+name)) && len(name) > longest {
+			zone, found, longest = z, true, len(name)
+		}
+	}
+	return
+}
+
+func changeValidationRecord(ctx context.Context, action route53.ChangeAction, r common.DNSValidationRecord) error {
+	cfg, err := dnsValidationConfig(ctx, r.Account)
+	if err != nil {
+		return err
+	}
+	_, err = route53.New(cfg).ChangeResourceRecordSetsRequest(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.HostedZoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("ACM validation record managed by the Venafi certificate request Lambda"),
+			Changes: []route53.Change{{
+				Action: action,
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String(r.Name),
+					Type:            route53.RRTypeCname,
+					TTL:             aws.Int64(validationRecordTTL),
+					ResourceRecords: []route53.ResourceRecord{{Value: aws.String(r.Value)}},
+				},
+			}},
+		},
+	}).Send(ctx)
+	// A record deleted by someone else doesn't need to be deleted.
+	if aerr, ok := err.(awserr.Error); ok && action == route53.ChangeActionDelete && aerr.Code() == route53.ErrCodeInvalidChangeBatch {
+		log.Printf("Validation record %s is already deleted: %s", r.Name, err)
+		return nil
+	}
+	return err
+}
+
+// dnsValidationConfig returns the configuration to access hosted zones of the account, the Lambda account is accessed
+// directly.
+func dnsValidationConfig(ctx context.Context, account string) (aws.Config, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return cfg, err
+	}
+	lambdaAccount, err := getLambdaAccountID(ctx, cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if account != lambdaAccount {
+		if dnsValidationRoleName == "" {
+			return cfg, fmt.Errorf("DNS validation role name is not set, can't access account %s", account)
+		}
+		cfg.Credentials = common.AssumeRoleCredentials(cfg, fmt.Sprintf("arn:aws:iam::%s:role/%s", account, dnsValidationRoleName))
+	}
+	return cfg, nil
+}
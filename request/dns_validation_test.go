@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"testing"
+)
+
+func TestMatchHostedZone(t *testing.T) {
+	zones := []route53.HostedZone{
+		{Id: aws.String("/hostedzone/Z1"), Name: aws.String("example.com.")},
+		{Id: aws.String("/hostedzone/Z2"), Name: aws.String("dev.example.com.")},
+		{Id: aws.String("/hostedzone/Z3"), Name: aws.String("internal.example.com."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+	}
+	cases := []struct {
+		domain string
+		zoneID string
+	}{
+		{"www.example.com", "/hostedzone/Z1"},
+		{"example.com", "/hostedzone/Z1"},
+		{"*.dev.example.com", "/hostedzone/Z2"},
+		{"api.Dev.Example.com", "/hostedzone/Z2"},
+		{"app.internal.example.com", "/hostedzone/Z1"},
+		{"notexample.com", ""},
+	}
+	for _, c := range cases {
+		zone, found := matchHostedZone(c.domain, zones)
+		if found != (c.zoneID != "") || aws.StringValue(zone.Id) != c.zoneID {
+			t.Errorf("expected zone %q for %s, got %q", c.zoneID, c.domain, aws.StringValue(zone.Id))
+		}
+	}
+}
+
+func TestNeedsDNSValidation(t *testing.T) {
+	input := acm.RequestCertificateInput{DomainName: aws.String("www.example.com"), ValidationMethod: acm.ValidationMethodDns}
+	dnsValidationAccounts = nil
+	if needsDNSValidation(input) {
+		t.Error("DNS validation should be disabled without accounts")
+	}
+	dnsValidationAccounts = []string{"123456789012"}
+	defer func() { dnsValidationAccounts = nil }()
+	if !needsDNSValidation(input) {
+		t.Error("public certificate with DNS validation should be validated")
+	}
+	input.ValidationMethod = acm.ValidationMethodEmail
+	if needsDNSValidation(input) {
+		t.Error("email validation should be left to the owner")
+	}
+	input.ValidationMethod = acm.ValidationMethodDns
+	input.CertificateAuthorityArn = aws.String("arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/a")
+	if needsDNSValidation(input) {
+		t.Error("private certificates are not validated")
+	}
+}
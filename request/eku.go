@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"strings"
+)
+
+var oidExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// extendedKeyUsages maps names of extended key usages allowed in zones to their OIDs.
+var extendedKeyUsages = map[string]string{
+	"serverAuth":              "1.3.6.1.5.5.7.3.1",
+	"clientAuth":              "1.3.6.1.5.5.7.3.2",
+	"codeSigning":             "1.3.6.1.5.5.7.3.3",
+	"emailProtection":         "1.3.6.1.5.5.7.3.4",
+	"timeStamping":            "1.3.6.1.5.5.7.3.8",
+	"OCSPSigning":             "1.3.6.1.5.5.7.3.9",
+	"smartCardLogin":          "1.3.6.1.4.1.311.20.2.2",
+	"documentSigning":         "1.3.6.1.4.1.311.10.3.12",
+	"certificateTransparency": "1.3.6.1.4.1.11129.2.4.4",
+}
+
+// apiExtendedKeyUsages maps ExtendedKeyUsageType values of ApiPassthrough to extended key usage names.
+var apiExtendedKeyUsages = map[string]string{
+	"SERVER_AUTH":              "serverAuth",
+	"CLIENT_AUTH":              "clientAuth",
+	"CODE_SIGNING":             "codeSigning",
+	"EMAIL_PROTECTION":         "emailProtection",
+	"TIME_STAMPING":            "timeStamping",
+	"OCSP_SIGNING":             "OCSPSigning",
+	"SMART_CARD_LOGIN":         "smartCardLogin",
+	"DOCUMENT_SIGNING":         "documentSigning",
+	"CERTIFICATE_TRANSPARENCY": "certificateTransparency",
+}
+
+// templateExtendedKeyUsages are extended key usages ACM PCA templates put into end-entity certificates, by template
+// name without the version. EndEntityCertificate/V1 is used for requests without a template.
+var templateExtendedKeyUsages = map[string][]string{
+	"EndEntityCertificate":                          {"serverAuth", "clientAuth"},
+	"EndEntityCertificate_APIPassthrough":           {"serverAuth", "clientAuth"},
+	"EndEntityCertificate_CSRPassthrough":           {"serverAuth", "clientAuth"},
+	"EndEntityClientAuthCertificate":                {"clientAuth"},
+	"EndEntityClientAuthCertificate_APIPassthrough": {"clientAuth"},
+	"EndEntityServerAuthCertificate":                {"serverAuth"},
+	"EndEntityServerAuthCertificate_APIPassthrough": {"serverAuth"},
+	"CodeSigningCertificate":                        {"codeSigning"},
+	"CodeSigningCertificate_APIPassthrough":         {"codeSigning"},
+	"OCSPSigningCertificate":                        {"OCSPSigning"},
+	"OCSPSigningCertificate_APIPassthrough":         {"OCSPSigning"},
+}
+
+// extendedKeyUsageOID returns the OID of an extended key usage given by name or OID.
+func extendedKeyUsageOID(usage string) string {
+	if oid, ok := extendedKeyUsages[usage]; ok {
+		return oid
+	}
+	return usage
+}
+
+// checkExtendedKeyUsages rejects requests whose certificate would get extended key usages the zone doesn't allow.
+// Usages come from the template the certificate is issued with, the CSR and the ApiPassthrough of the request.
+func checkExtendedKeyUsages(zoneConfig common.ZoneConfig, csrPEM []byte, pt *passthrough) error {
+	if len(zoneConfig.AllowedExtendedKeyUsages) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(zoneConfig.AllowedExtendedKeyUsages))
+	for _, usage := range zoneConfig.AllowedExtendedKeyUsages {
+		allowed[extendedKeyUsageOID(usage)] = true
+	}
+	usages, err := requestedExtendedKeyUsages(csrPEM, pt)
+	if err != nil {
+		return err
+	}
+	for _, usage := range usages {
+		if !allowed[extendedKeyUsageOID(usage)] {
+			return fmt.Errorf("extended key usage %s is not allowed in this zone, allowed usages are %v", usage, zoneConfig.AllowedExtendedKeyUsages)
+		}
+	}
+	return nil
+}
+
+func requestedExtendedKeyUsages(csrPEM []byte, pt *passthrough) ([]string, error) {
+	template := "EndEntityCertificate"
+	if pt != nil {
+		if a, err := arn.Parse(pt.TemplateArn); err == nil {
+			template = strings.TrimPrefix(a.Resource, "template/")
+			if i := strings.LastIndex(template, "/"); i >= 0 {
+				template = template[:i]
+			}
+		}
+	}
+	usages, ok := templateExtendedKeyUsages[template]
+	if !ok {
+		return nil, fmt.Errorf("extended key usages of template %s are unknown", template)
+	}
+	usages = append([]string{}, usages...)
+
+	pemBlock, _ := pem.Decode(csrPEM)
+	if pemBlock == nil {
+		return nil, fmt.Errorf("CSR is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range csr.Extensions {
+		if !e.Id.Equal(oidExtendedKeyUsage) {
+			continue
+		}
+		var oids []asn1.ObjectIdentifier
+		_, err = asn1.Unmarshal(e.Value, &oids)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extended key usage extension: %s", err)
+		}
+		for _, oid := range oids {
+			usages = append(usages, oid.String())
+		}
+	}
+
+	if pt != nil {
+		for _, u := range pt.ApiPassthrough.Extensions.ExtendedKeyUsage {
+			if u.ExtendedKeyUsageObjectIdentifier != "" {
+				usages = append(usages, u.ExtendedKeyUsageObjectIdentifier)
+				continue
+			}
+			usage, ok := apiExtendedKeyUsages[u.ExtendedKeyUsageType]
+			if !ok {
+				return nil, fmt.Errorf("unknown extended key usage type %q", u.ExtendedKeyUsageType)
+			}
+			usages = append(usages, usage)
+		}
+	}
+	return usages, nil
+}
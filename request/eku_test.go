@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"testing"
+)
+
+func testEKUCSR(t *testing.T, usages ...asn1.ObjectIdentifier) []byte {
+	value, err := asn1.Marshal(usages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "eku.example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: oidExtendedKeyUsage, Value: value}},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
+
+func TestCheckExtendedKeyUsages(t *testing.T) {
+	serverAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	codeSigning := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}
+	csr := testEKUCSR(t, serverAuth)
+	if err := checkExtendedKeyUsages(common.ZoneConfig{}, testEKUCSR(t, codeSigning), nil); err != nil {
+		t.Errorf("zone without allowed usages should allow any usage: %s", err)
+	}
+
+	zoneConfig := common.ZoneConfig{AllowedExtendedKeyUsages: []string{"serverAuth", "1.3.6.1.5.5.7.3.2"}}
+	if err := checkExtendedKeyUsages(zoneConfig, csr, nil); err != nil {
+		t.Errorf("usages of the default template and the CSR should be allowed: %s", err)
+	}
+	if checkExtendedKeyUsages(zoneConfig, testEKUCSR(t, codeSigning), nil) == nil {
+		t.Error("code signing requested in the CSR should be rejected")
+	}
+
+	pt := &passthrough{TemplateArn: "arn:aws:acm-pca:::template/CodeSigningCertificate_APIPassthrough/V1"}
+	if checkExtendedKeyUsages(zoneConfig, csr, pt) == nil {
+		t.Error("code signing template should be rejected")
+	}
+	pt = &passthrough{TemplateArn: "arn:aws:acm-pca:::template/EndEntityCertificate_APIPassthrough/V1"}
+	pt.ApiPassthrough.Extensions.ExtendedKeyUsage = []passthroughExtendedKeyUsage{{ExtendedKeyUsageType: "EMAIL_PROTECTION"}}
+	if checkExtendedKeyUsages(zoneConfig, csr, pt) == nil {
+		t.Error("email protection in ApiPassthrough should be rejected")
+	}
+	zoneConfig.AllowedExtendedKeyUsages = append(zoneConfig.AllowedExtendedKeyUsages, "emailProtection")
+	if err := checkExtendedKeyUsages(zoneConfig, csr, pt); err != nil {
+		t.Errorf("allowed usage in ApiPassthrough should pass: %s", err)
+	}
+	pt.TemplateArn = "arn:aws:acm-pca:::template/BlankEndEntityCertificate_APICSRPassthrough/V1"
+	if checkExtendedKeyUsages(zoneConfig, csr, pt) == nil {
+		t.Error("template with unknown usages should be rejected")
+	}
+}
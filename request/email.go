@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Request events emails are sent on
+const (
+	emailEventIssued = "issued"
+	emailEventDenied = "denied"
+)
+
+var (
+	// emailSender is the SES verified address emails are sent from. Emails are disabled when it's empty.
+	emailSender string
+	// ownerEmailTag is the request tag holding email addresses of the requester.
+	ownerEmailTag string
+	// allowedEmailDomains are domains addresses of the owner tag must be in, requesters choose the tag freely.
+	allowedEmailDomains []string
+)
+
+// emailData is passed to email templates.
+type emailData struct {
+	Event          string
+	Zone           string
+	Target         string
+	RequestID      string
+	CallerArn      string
+	SourceAccount  string
+	CertificateArn string
+	// NotAfter is zero if the expiration is not known yet, e.g. before ACM validation.
+	NotAfter time.Time
+	Message  string
+}
+
+var emailTemplates = map[string]struct{ subject, body *template.Template }{
+	emailEventIssued: {
+		subject: template.Must(template.New("issuedSubject").Parse(`Certificate issued in zone {{.Zone}}`)),
+		body: template.Must(template.New("issuedBody").Parse(`A certificate was issued in Venafi zone {{.Zone}}.
+
+Certificate: {{.CertificateArn}}
+Expires: {{if .NotAfter.IsZero}}after validation{{else}}{{.NotAfter.Format "2006-01-02 15:04 MST"}}{{end}}
+Requested by: {{.CallerArn}} (account {{.SourceAccount}})
+Request ID: {{.RequestID}}
+`)),
+	},
+	emailEventDenied: {
+		subject: template.Must(template.New("deniedSubject").Parse(`Certificate request denied in zone {{.Zone}}`)),
+		body: template.Must(template.New("deniedBody").Parse(`A certificate request was denied in Venafi zone {{.Zone}}.
+
+Violation: {{.Message}}
+Requested by: {{.CallerArn}} (account {{.SourceAccount}})
+Request ID: {{.RequestID}}
+`)),
+	},
+}
+
+// sendRequestEmail emails the outcome of a certificate request to addresses of the zone and of the requester tag.
+// Emails are best effort, failures are only logged.
+func sendRequestEmail(ctx context.Context, request events.APIGatewayProxyRequest, target string, response events.APIGatewayProxyResponse) {
+	if emailSender == "" {
+		return
+	}
+	status := newRequestStatus(request, target, response, time.Now())
+	var event string
+	switch status.Status {
+	case common.RequestSucceeded:
+		event = emailEventIssued
+	case common.RequestRejected:
+		event = emailEventDenied
+	default:
+		return
+	}
+	if status.Zone == "" {
+		status.Zone = defaultZone
+	}
+	zoneConfig, err := common.GetZoneConfig(status.Zone)
+	if err != nil {
+		log.Printf("Can't get zone configuration to send email: %s", err)
+		return
+	}
+	if !emailEventEnabled(zoneConfig, event) {
+		return
+	}
+	to := emailRecipients(zoneConfig, request.Body)
+	if len(to) == 0 {
+		return
+	}
+	data := emailData{
+		Event:          event,
+		Zone:           status.Zone,
+		Target:         target,
+		RequestID:      status.RequestID,
+		CallerArn:      status.CallerArn,
+		SourceAccount:  status.SourceAccount,
+		CertificateArn: status.CertificateArn,
+		Message:        status.Message,
+	}
+	if status.CertificateArn != "" {
+		if r, err := common.GetInventoryRecord(status.CertificateArn); err == nil {
+			data.NotAfter = r.NotAfter
+		}
+	}
+	subject, body, err := renderEmail(data)
+	if err != nil {
+		log.Printf("Can't render %s email: %s", event, err)
+		return
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		log.Println("Can't load config to send email:", err)
+		return
+	}
+	err = common.SendEmail(ctx, cfg, emailSender, to, subject, body)
+	if err != nil {
+		log.Printf("Can't send %s email to %v: %s", event, to, err)
+	}
+}
+
+// emailEventEnabled reports whether the zone sends emails on the event. All events are sent by default.
+func emailEventEnabled(zoneConfig common.ZoneConfig, event string) bool {
+	return len(zoneConfig.NotificationEvents) == 0 || containsFold(zoneConfig.NotificationEvents, event)
+}
+
+// emailRecipients returns addresses of the zone and of the requester tag of the request body. Tagged addresses outside
+// the allowed domains are ignored, so requests can't make the Lambda email arbitrary recipients.
+func emailRecipients(zoneConfig common.ZoneConfig, requestBody string) []string {
+	var body struct {
+		Tags []acm.Tag
+	}
+	_ = json.Unmarshal([]byte(requestBody), &body)
+	to := append([]string{}, zoneConfig.NotificationEmails...)
+	for _, t := range body.Tags {
+		if aws.StringValue(t.Key) != ownerEmailTag {
+			continue
+		}
+		for _, address := range common.SplitList(aws.StringValue(t.Value)) {
+			if !common.EmailDomainAllowed(address, allowedEmailDomains) {
+				log.Printf("Ignoring owner email %s, its domain is not allowed", address)
+				continue
+			}
+			if !containsFold(to, address) {
+				to = append(to, address)
+			}
+		}
+	}
+	return to
+}
+
+func renderEmail(data emailData) (subject, body string, err error) {
+	t := emailTemplates[data.Event]
+	var b bytes.Buffer
+	err = t.subject.Execute(&b, data)
+	if err != nil {
+		return
+	}
+	subject = b.String()
+	b.Reset()
+	err = t.body.Execute(&b, data)
+	return subject, b.String(), err
+}
+
+func loadEmailSettings() {
+	emailSender = os.Getenv("NOTIFY_EMAIL_SENDER")
+	ownerEmailTag = strings.TrimSpace(os.Getenv("OWNER_EMAIL_TAG"))
+	if ownerEmailTag == "" {
+		ownerEmailTag = "OwnerEmail"
+	}
+	allowedEmailDomains = common.SplitList(os.Getenv("NOTIFY_ALLOWED_EMAIL_DOMAINS"))
+}
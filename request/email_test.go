@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailRecipients(t *testing.T) {
+	ownerEmailTag = "OwnerEmail"
+	allowedEmailDomains = []string{"example.com"}
+	defer func() { allowedEmailDomains = nil }()
+	zoneConfig := common.ZoneConfig{NotificationEmails: []string{"pki@example.com"}}
+	body := `{"VenafiZone": "Default", "Tags": [{"Key": "OwnerEmail", "Value": "team@example.com, PKI@example.com, victim@evil.net"}, {"Key": "Team", "Value": "web"}]}`
+	to := emailRecipients(zoneConfig, body)
+	if strings.Join(to, ",") != "pki@example.com,team@example.com" {
+		t.Errorf("unexpected recipients %v", to)
+	}
+	allowedEmailDomains = nil
+	if to := emailRecipients(zoneConfig, body); strings.Join(to, ",") != "pki@example.com" {
+		t.Errorf("owner tag must be ignored without allowed domains, got %v", to)
+	}
+	if to := emailRecipients(common.ZoneConfig{}, `{}`); len(to) != 0 {
+		t.Errorf("unexpected recipients %v", to)
+	}
+}
+
+func TestEmailEventEnabled(t *testing.T) {
+	if !emailEventEnabled(common.ZoneConfig{}, emailEventDenied) {
+		t.Error("all events must be enabled by default")
+	}
+	zoneConfig := common.ZoneConfig{NotificationEvents: []string{"Denied"}}
+	if !emailEventEnabled(zoneConfig, emailEventDenied) || emailEventEnabled(zoneConfig, emailEventIssued) {
+		t.Error("only denied event must be enabled")
+	}
+}
+
+func TestRenderEmail(t *testing.T) {
+	subject, body, err := renderEmail(emailData{
+		Event:          emailEventIssued,
+		Zone:           "Default",
+		CertificateArn: "arn:aws:acm:us-east-1:123456789012:certificate/1",
+		NotAfter:       time.Date(2021, 1, 2, 3, 4, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Certificate issued in zone Default" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, "Expires: 2021-01-02 03:04 UTC") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	_, body, err = renderEmail(emailData{Event: emailEventDenied, Zone: "Default", Message: "CN doesn't match"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "Violation: CN doesn't match") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
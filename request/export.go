@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"log"
+	"net/http"
+)
+
+// reasonKeyExportForbidden is the reason of ExportCertificate requests rejected because the zone forbids key export.
+const reasonKeyExportForbidden = "KeyExportForbidden"
+
+// errNotInInventory rejects exports of certificates the inventory doesn't know yet, their zone can't be told.
+var errNotInInventory = errors.New("is not in the certificate inventory yet")
+
+// exportACMCertificate exports a private ACM certificate with its private key unless the zone the certificate was
+// issued in forbids key export. The zone is taken from the inventory, so callers can't choose a more permissive one.
+func exportACMCertificate(ctx context.Context, cli *acm.Client, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input acm.ExportCertificateInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, acmExportCertificate, err))
+	}
+	certificateArn := aws.StringValue(input.CertificateArn)
+	caller := request.RequestContext.Identity.UserArn
+	zone, err := exportZone(common.GetInventoryRecord(certificateArn))
+	if err == errNotInInventory {
+		log.Printf("AUDIT: %s denied export of certificate %s, it %s", caller, certificateArn, err)
+		return clientErrorReason(http.StatusForbidden, reasonKeyExportForbidden, fmt.Sprintf("certificate %s %s", certificateArn, err))
+	} else if err != nil {
+		common.Monitor.Record(common.BackendDynamoDB, err)
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get certificate from inventory: %s", err))
+	}
+	zoneConfig, err := common.GetZoneConfig(zone)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+	}
+	if zoneConfig.ForbidKeyExport {
+		log.Printf("AUDIT: %s denied export of certificate %s, zone %s forbids key export", caller, certificateArn, zone)
+		return clientErrorReason(http.StatusForbidden, reasonKeyExportForbidden,
+			fmt.Sprintf("private keys of certificates in zone %s can't be exported", zone))
+	}
+
+	resp, err := cli.ExportCertificateRequest(&input).Send(ctx)
+	common.Monitor.Record(common.BackendACM, err)
+	if err != nil {
+		return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, acmExportCertificate, err))
+	}
+	log.Printf("AUDIT: %s exported certificate %s of zone %s", caller, certificateArn, zone)
+	return jsonResponse(acmExportCertificate, resp.ExportCertificateOutput)
+}
+
+// exportZone returns the zone whose ForbidKeyExport applies to the certificate of the inventory record: the zone it was
+// issued in through the proxy, or the default zone for other certificates of the inventory. Lookup errors are returned
+// as is, so the export fails closed.
+func exportZone(r common.InventoryRecord, err error) (string, error) {
+	if err == common.CertificateNotFound {
+		return "", errNotInInventory
+	} else if err != nil {
+		return "", err
+	}
+	if r.Zone == "" {
+		return defaultZone, nil
+	}
+	return r.Zone, nil
+}
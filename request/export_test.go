@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"net/http"
+	"testing"
+)
+
+func TestClientErrorReason(t *testing.T) {
+	resp, _ := clientErrorReason(http.StatusForbidden, reasonKeyExportForbidden, "private keys of certificates in zone Default can't be exported")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status is %d", resp.StatusCode)
+	}
+	var body struct {
+		Msg    string `json:"msg"`
+		Reason string `json:"reason"`
+	}
+	err := json.Unmarshal([]byte(resp.Body), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Reason != reasonKeyExportForbidden || body.Msg == "" {
+		t.Errorf("unexpected body %s", resp.Body)
+	}
+}
+
+func TestExportZone(t *testing.T) {
+	lookupErr := errors.New("ProvisionedThroughputExceededException")
+	cases := []struct {
+		record  common.InventoryRecord
+		err     error
+		zone    string
+		zoneErr error
+	}{
+		{common.InventoryRecord{Zone: "Restricted"}, nil, "Restricted", nil},
+		{common.InventoryRecord{}, nil, defaultZone, nil},
+		{common.InventoryRecord{}, common.CertificateNotFound, "", errNotInInventory},
+		{common.InventoryRecord{}, lookupErr, "", lookupErr},
+	}
+	for _, c := range cases {
+		zone, err := exportZone(c.record, c.err)
+		if zone != c.zone || err != c.zoneErr {
+			t.Errorf("expected zone %q and error %v for %+v and %v, got %q and %v", c.zone, c.zoneErr, c.record, c.err, zone, err)
+		}
+	}
+}
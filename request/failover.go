@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"net"
+	"time"
+)
+
+// failoverCAs returns CAs to retry issuance with when the requested one fails. Failover happens only when the
+// requested CA is one of the CAs the zone is bound to.
+func failoverCAs(requested string, zoneConfig common.ZoneConfig) []string {
+	bound := false
+	for _, a := range zoneConfig.CertificateAuthorityArns {
+		if a == requested {
+			bound = true
+			break
+		}
+	}
+	if !bound {
+		return nil
+	}
+	failover := make([]string, 0, len(zoneConfig.CertificateAuthorityArns)-1)
+	for _, a := range zoneConfig.CertificateAuthorityArns {
+		if a != requested {
+			failover = append(failover, a)
+		}
+	}
+	return failover
+}
+
+// isFailoverError reports whether issuance may be retried with another CA. Only errors which show that the CA didn't
+// accept the request fail over: after other errors, e.g. timeouts, the certificate may have been issued already and
+// failover would issue it twice.
+func isFailoverError(err error) bool {
+	if isThrottled(err) {
+		return true
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case acmpca.ErrCodeInvalidStateException, acmpca.ErrCodeResourceNotFoundException, acmpca.ErrCodeLimitExceededException:
+		// The CA isn't ACTIVE, is deleted or can't issue more certificates.
+		return true
+	case "RequestError":
+		// The SDK couldn't send the request, only a failed connection shows it never reached ACM PCA.
+		var opErr *net.OpError
+		return errors.As(aerr.OrigErr(), &opErr) && opErr.Op == "dial"
+	}
+	return false
+}
+
+// issueWithFailover issues the certificate with the requested CA and on failure tries failover CAs in order.
+// Throttled calls are retried until the deadline. It returns the ARN of the CA which issued the certificate.
+func issueWithFailover(ctx context.Context, awsCfg aws.Config, region string, input acmpca.IssueCertificateInput,
+	pt *passthrough, requested caInfo, failover []string, deadline time.Time) (*acmpca.IssueCertificateResponse, string, error) {
+
+	cfg := awsCfg.Copy()
+	if region == "" {
+		cfg.Region = requested.Arn.Region
+	}
+	input.CertificateAuthorityArn = aws.String(requested.Arn.String())
+	resp, err := issueCertificate(ctx, acmpca.New(cfg), &input, pt, deadline)
+	if err == nil || !isFailoverError(err) {
+		return resp, requested.Arn.String(), err
+	}
+
+	for _, caArn := range failover {
+		log.Printf("Issuance with CA %s failed: %s. Trying CA %s", aws.StringValue(input.CertificateAuthorityArn), err, caArn)
+		ca, caErr := resolveCA(ctx, awsCfg, caArn)
+		if caErr == nil {
+			caErr = checkSharedCAEntitlement(ctx, awsCfg, ca)
+		}
+		if caErr != nil {
+			log.Printf("Skipping failover CA %s: %s", caArn, caErr)
+			continue
+		}
+		cfg := awsCfg.Copy()
+		cfg.Region = ca.Arn.Region
+		input.CertificateAuthorityArn = aws.String(caArn)
+		resp, err = issueCertificate(ctx, acmpca.New(cfg), &input, pt, deadline)
+		if err == nil {
+			log.Printf("Certificate issued by failover CA %s", caArn)
+			return resp, caArn, nil
+		}
+		if !isFailoverError(err) {
+			break
+		}
+	}
+	return nil, "", err
+}
+
+func issueCertificate(ctx context.Context, cli *acmpca.Client, input *acmpca.IssueCertificateInput, pt *passthrough,
+	deadline time.Time) (resp *acmpca.IssueCertificateResponse, err error) {
+
+	err = retryThrottled(deadline, func() error {
+		req := cli.IssueCertificateRequest(input)
+		pt.addTo(req.Request)
+		resp, err = req.Send(ctx)
+		return err
+	})
+	return
+}
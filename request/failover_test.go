@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFailoverCAs(t *testing.T) {
+	zoneConfig := common.ZoneConfig{CertificateAuthorityArns: []string{"ca1", "ca2", "ca3"}}
+	failover := failoverCAs("ca2", zoneConfig)
+	if !reflect.DeepEqual(failover, []string{"ca1", "ca3"}) {
+		t.Fatalf("unexpected failover CAs: %v", failover)
+	}
+	if failover = failoverCAs("other", zoneConfig); len(failover) != 0 {
+		t.Fatalf("CA not bound to the zone should not fail over: %v", failover)
+	}
+}
+
+func TestIsFailoverError(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "https://acm-pca.us-east-1.amazonaws.com/",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	reset := &url.Error{Op: "Post", URL: "https://acm-pca.us-east-1.amazonaws.com/",
+		Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+	cases := []struct {
+		err      error
+		failover bool
+	}{
+		{awserr.New(acmpca.ErrCodeMalformedCSRException, "bad csr", nil), false},
+		{awserr.New(acmpca.ErrCodeInvalidStateException, "CA is disabled", nil), true},
+		{throttledError{awserr.New("ThrottlingException", "rate exceeded", nil)}, true},
+		{awserr.New("RequestError", "send request failed", refused), true},
+		{awserr.New("RequestError", "send request failed", reset), false},
+		{awserr.New("InternalFailure", "internal error", nil), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, c := range cases {
+		if isFailoverError(c.err) != c.failover {
+			t.Errorf("expected failover %t for %v", c.failover, c.err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFreezeDuration is the longest freeze window.
+const maxFreezeDuration = 31 * 24 * time.Hour
+
+// checkFreezeWindows rejects issuance while one of the zone's change-freeze windows is active.
+func checkFreezeWindows(zoneConfig common.ZoneConfig, now time.Time) error {
+	for _, w := range zoneConfig.FreezeWindows {
+		active, err := freezeActive(w, now)
+		if err != nil {
+			return fmt.Errorf("invalid freeze window %q: %s", w.Schedule, err)
+		}
+		if active {
+			msg := "issuance is frozen by change-freeze window " + w.Schedule
+			if w.Reason != "" {
+				msg += ": " + w.Reason
+			}
+			return fmt.Errorf("%s", msg)
+		}
+	}
+	return nil
+}
+
+// freezeActive reports whether a window started by the schedule within the window duration before now.
+func freezeActive(w common.FreezeWindow, now time.Time) (bool, error) {
+	s, err := parseCronSchedule(w.Schedule)
+	if err != nil {
+		return false, err
+	}
+	d, err := time.ParseDuration(w.Duration)
+	if err != nil {
+		return false, err
+	}
+	if d <= 0 || d > maxFreezeDuration {
+		return false, fmt.Errorf("duration must be positive and at most %s", maxFreezeDuration)
+	}
+	loc := time.UTC
+	if w.TimeZone != "" {
+		loc, err = time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return false, err
+		}
+	}
+	now = now.In(loc)
+	_, ok := s.latestStart(now, now.Add(-d))
+	return ok, nil
+}
+
+// cronSchedule is a parsed standard five field cron expression: minute, hour, day of month, month, day of week.
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	// domStar and dowStar keep cron semantics: if both day fields are restricted, either of them may match.
+	domStar, dowStar bool
+}
+
+var (
+	cronMonths = []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	cronDays   = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+)
+
+func parseCronSchedule(expr string) (s cronSchedule, err error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return s, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return s, fmt.Errorf("minute: %s", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return s, fmt.Errorf("hour: %s", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return s, fmt.Errorf("day of month: %s", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return s, fmt.Errorf("month: %s", err)
+	}
+	// 7 is Sunday as well.
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return s, fmt.Errorf("day of week: %s", err)
+	}
+	s.dow[0] = s.dow[0] || s.dow[7]
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps (e.g. "1-5", "*/15", "MON,FRI").
+func parseCronField(field string, min, max int, names []string) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], names); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = parseCronValue(bounds[1], names); err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func parseCronValue(s string, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// latestStart returns the latest minute at or before t the schedule matches, if it's after the limit. Days, hours and
+// minutes which don't match are skipped as a whole, so the search takes at most a few iterations per day.
+func (s cronSchedule) latestStart(t, limit time.Time) (time.Time, bool) {
+	y, m, d := t.Date()
+	for i := 0; ; i++ {
+		day := time.Date(y, m, d-i, 0, 0, 0, 0, t.Location())
+		if !time.Date(y, m, d-i+1, 0, 0, 0, 0, t.Location()).After(limit) {
+			return time.Time{}, false
+		}
+		if !s.month[int(day.Month())] || !s.dayMatches(day) {
+			continue
+		}
+		maxHour := 23
+		if i == 0 {
+			maxHour = t.Hour()
+		}
+		for h := maxHour; h >= 0; h-- {
+			if !s.hour[h] {
+				continue
+			}
+			maxMinute := 59
+			if i == 0 && h == t.Hour() {
+				maxMinute = t.Minute()
+			}
+			for min := maxMinute; min >= 0; min-- {
+				if s.minute[min] {
+					start := time.Date(day.Year(), day.Month(), day.Day(), h, min, 0, 0, t.Location())
+					return start, start.After(limit)
+				}
+			}
+		}
+	}
+}
+
+func (s cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	return s.dayMatches(t)
+}
+
+// dayMatches reports whether the day of month or the day of week of t matches.
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom[t.Day()]
+	dowMatch := s.dow[int(t.Weekday())]
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "*/15 0-6 1,15 JAN-MAR MON-FRI", "0 18 * * 5", "0 0 * * 7"} {
+		if _, err := parseCronSchedule(expr); err != nil {
+			t.Errorf("%q: unexpected error: %s", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "* * * FOO *", "5-1 * * * *"} {
+		if _, err := parseCronSchedule(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestCheckFreezeWindows(t *testing.T) {
+	// Weekend freeze from Friday 18:00 to Monday 08:00.
+	zoneConfig := common.ZoneConfig{FreezeWindows: []common.FreezeWindow{
+		{Schedule: "0 18 * * FRI", Duration: "62h", Reason: "weekend"},
+	}}
+	cases := []struct {
+		now    string
+		frozen bool
+	}{
+		{"2026-10-16T17:59:00Z", false}, // Friday
+		{"2026-10-16T18:00:00Z", true},
+		{"2026-10-18T12:00:00Z", true}, // Sunday
+		{"2026-10-19T07:59:59Z", true}, // Monday
+		{"2026-10-19T08:00:00Z", false},
+	}
+	for _, c := range cases {
+		now, _ := time.Parse(time.RFC3339, c.now)
+		err := checkFreezeWindows(zoneConfig, now)
+		if (err != nil) != c.frozen {
+			t.Errorf("%s: frozen = %v, want %v", c.now, err != nil, c.frozen)
+		}
+	}
+
+	// Both day fields restricted: either may match.
+	s, _ := parseCronSchedule("0 0 1 * MON")
+	for _, day := range []string{"2026-10-01T00:00:00Z", "2026-10-05T00:00:00Z"} {
+		d, _ := time.Parse(time.RFC3339, day)
+		if !s.matches(d) {
+			t.Errorf("%s should match", day)
+		}
+	}
+
+	bad := common.ZoneConfig{FreezeWindows: []common.FreezeWindow{{Schedule: "0 18 * * FRI", Duration: "forever"}}}
+	if checkFreezeWindows(bad, time.Now()) == nil {
+		t.Error("expected error for invalid duration")
+	}
+}
+
+func TestLatestStart(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2026-10-15T10:07:30Z")
+	for _, expr := range []string{"* * * * *", "0 18 * * FRI", "*/15 0-6 1,15 * MON-FRI", "30 9 29 2 *", "0 0 1 * MON"} {
+		s, _ := parseCronSchedule(expr)
+		limit := now.Add(-maxFreezeDuration)
+		// The latest start found minute by minute.
+		var want time.Time
+		for m := now.Truncate(time.Minute); m.After(limit); m = m.Add(-time.Minute) {
+			if s.matches(m) {
+				want = m
+				break
+			}
+		}
+		got, ok := s.latestStart(now, limit)
+		if ok != !want.IsZero() || ok && !got.Equal(want) {
+			t.Errorf("%q: latest start %s (%v), want %s", expr, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultIdempotencyWindow = time.Hour
+
+// idempotencyInProgressTimeout is longer than any Lambda processing a request. A request in progress for longer
+// failed without releasing its key.
+const idempotencyInProgressTimeout = 5 * time.Minute
+
+// idempotencyWindow is how long successful responses of issuance requests are returned to retries. Zero disables
+// stored responses.
+var idempotencyWindow = defaultIdempotencyWindow
+
+// idempotentTargets create certificates, their retries must not create more.
+var idempotentTargets = []string{acmpcaIssueCertificate, acmRequestCertificate, venafiIssueCertificates, venafiIssueCertificateWithKey}
+
+func loadIdempotencyWindow() {
+	idempotencyWindow = defaultIdempotencyWindow
+	if s := os.Getenv("IDEMPOTENCY_WINDOW_SECONDS"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			log.Printf("Invalid IDEMPOTENCY_WINDOW_SECONDS %q, using %s", s, defaultIdempotencyWindow)
+			return
+		}
+		idempotencyWindow = time.Duration(seconds) * time.Second
+	}
+}
+
+// idempotencyKey identifies retries of a request by the caller, the target and the IdempotencyToken of the body.
+// IssueCertificate requests without a token are identified by the CSR and the parameters of the certificate.
+// Requests of other targets without a token have no key.
+func idempotencyKey(request events.APIGatewayProxyRequest, target string) string {
+	if idempotencyWindow == 0 || !stringInSlice(target, idempotentTargets) {
+		return ""
+	}
+	var body struct {
+		IdempotencyToken string
+	}
+	_ = json.Unmarshal([]byte(request.Body), &body)
+	token := body.IdempotencyToken
+	if token == "" && target == acmpcaIssueCertificate {
+		var certRequest ACMPCAIssueCertificateRequest
+		if json.Unmarshal([]byte(request.Body), &certRequest) != nil || len(certRequest.Csr) == 0 {
+			return ""
+		}
+		token = "csr:" + issueRequestHash(certRequest.VenafiZone, certRequest.IssueCertificateInput, certRequest.ApiPassthrough)
+	}
+	if token == "" {
+		return ""
+	}
+	identity := request.RequestContext.Identity
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s", identity.AccountID, identity.UserArn, target, token)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// claimIdempotencyKey returns the response to return instead of processing the request, if it's a retry. A retry
+// of a request still in progress is rejected, so it can't create a second certificate.
+func claimIdempotencyKey(key string, now time.Time) (response events.APIGatewayProxyResponse, retry bool) {
+	stored, claimed, err := common.ClaimIdempotencyKey(common.StoredResponse{
+		IdempotencyKey: key,
+		Status:         common.ResponseInProgress,
+		CreatedAt:      now.UTC(),
+		TTL:            now.Add(idempotencyInProgressTimeout).Unix(),
+	}, now)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		// Duplicates are less harmful than failing every request while DynamoDB is unavailable.
+		log.Printf("Can't claim idempotency key, processing the request: %s", err)
+		return response, false
+	}
+	if claimed {
+		return response, false
+	}
+	if stored.Status != common.ResponseCompleted {
+		response, _ = clientError(http.StatusConflict, "A request with the same idempotency token is in progress, retry later")
+		return response, true
+	}
+	log.Printf("Request is a retry, returning the response stored at %s", stored.CreatedAt)
+	return events.APIGatewayProxyResponse{
+		StatusCode:      stored.StatusCode,
+		Headers:         stored.Headers,
+		Body:            stored.Body,
+		IsBase64Encoded: stored.IsBase64Encoded,
+	}, true
+}
+
+// storeResponse stores a successful or queued response for retries. The key of a failed request is released, so it
+// can be retried.
+func storeResponse(key string, response events.APIGatewayProxyResponse, now time.Time) {
+	var err error
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		err = common.DeleteStoredResponse(key)
+	} else {
+		err = common.SaveStoredResponse(common.StoredResponse{
+			IdempotencyKey:  key,
+			Status:          common.ResponseCompleted,
+			StatusCode:      response.StatusCode,
+			Headers:         response.Headers,
+			Body:            response.Body,
+			IsBase64Encoded: response.IsBase64Encoded,
+			CreatedAt:       now.UTC(),
+			TTL:             now.Add(idempotencyWindow).Unix(),
+		})
+	}
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		log.Printf("Can't store response for idempotency key %s: %s", key, err)
+	}
+}
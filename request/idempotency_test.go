@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"testing"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	request.RequestContext.Identity.AccountID = "123456789012"
+	request.RequestContext.Identity.UserArn = "arn:aws:sts::123456789012:assumed-role/Deployer/build-1"
+	request.Body = `{"DomainName": "www.example.com", "IdempotencyToken": "abc"}`
+	key := idempotencyKey(request, acmRequestCertificate)
+	if key == "" {
+		t.Fatal("request with a token should have a key")
+	}
+	if idempotencyKey(request, acmRequestCertificate) != key {
+		t.Error("retry should have the same key")
+	}
+	if idempotencyKey(request, venafiIssueCertificates) == key {
+		t.Error("key should depend on the target")
+	}
+	if idempotencyKey(request, acmpcaGetCertificate) != "" {
+		t.Error("requests which don't create certificates should have no key")
+	}
+	other := request
+	other.RequestContext.Identity.UserArn = "arn:aws:sts::123456789012:assumed-role/Deployer/build-2"
+	if idempotencyKey(other, acmRequestCertificate) == key {
+		t.Error("key should depend on the caller")
+	}
+	request.Body = `{"DomainName": "www.example.com"}`
+	if idempotencyKey(request, acmRequestCertificate) != "" {
+		t.Error("RequestCertificate without a token should have no key")
+	}
+
+	body, err := json.Marshal(ACMPCAIssueCertificateRequest{IssueCertificateInput: acmpca.IssueCertificateInput{
+		CertificateAuthorityArn: aws.String("arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/a"),
+		Csr:                     createCSR("idempotency.example.com"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Body = string(body)
+	if idempotencyKey(request, acmpcaIssueCertificate) == "" {
+		t.Error("IssueCertificate without a token should be identified by the CSR")
+	}
+
+	idempotencyWindow = 0
+	defer func() { idempotencyWindow = defaultIdempotencyWindow }()
+	if idempotencyKey(request, acmpcaIssueCertificate) != "" {
+		t.Error("stored responses should be disabled")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"log"
+	"net/http"
+)
+
+const acmImportCertificate = "CertificateManagerImportCertificate"
+
+// VenafiImportCertificateInput is the ACM ImportCertificate request with the zone whose policy the imported
+// certificate must comply with.
+type VenafiImportCertificateInput struct {
+	acm.ImportCertificateInput
+	VenafiZone string `json:"VenafiZone"`
+	// Tags are added to the certificate after it's imported.
+	Tags []acm.Tag `json:"Tags"`
+}
+
+// importCertificate validates a certificate imported into ACM against the zone policy like a CSR of the same
+// certificate, so certificates which couldn't be issued through the proxy can't be imported either.
+func importCertificate(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input VenafiImportCertificateInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, acmImportCertificate, err))
+	}
+	req, cert, err := newImportedCertificateRequest(input.Certificate)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf("Can't parse imported certificate: %s", err))
+	}
+
+	input.VenafiZone, err = resolveZone(request, input.VenafiZone)
+	if err != nil {
+		return zoneErrorResponse(err)
+	}
+	policy, err := getPolicy(input.VenafiZone)
+	if err == common.PolicyNotFound {
+		return handlePolicyNotFound(input.VenafiZone)
+	} else if err != nil {
+		common.Monitor.Record(common.BackendDynamoDB, err)
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
+	}
+	zoneConfig, err := common.GetZoneConfig(input.VenafiZone)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+	}
+	err = checkRequiredTags(zoneConfig, input.Tags)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	bypass, err := useBreakGlassToken(request, input.VenafiZone)
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	bypass = auditModeBypass(input.VenafiZone, zoneConfig, bypass)
+	err = bypass.apply(ruleZoneRules, checkDeniedDomains(zoneConfig, &req))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(rulePolicy, validateRequest(policy, &req))
+	if err != nil {
+		return policyViolationResponse(input.VenafiZone, policy, err.Error(), err)
+	}
+	err = bypass.apply(ruleZoneRules, validateZoneRules(zoneConfig, &req))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleOwnership, verifyDomainOwnership(ctx, zoneConfig, &req))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+
+	region, err := targetRegion(request, zoneConfig)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	awsCfg, err := loadAWSConfig(region, zoneConfig.RoleArn)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
+	}
+	acmCli := acm.New(awsCfg)
+	resp, err := acmCli.ImportCertificateRequest(&input.ImportCertificateInput).Send(ctx)
+	common.Monitor.Record(common.BackendACM, err)
+	if err != nil {
+		return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, acmImportCertificate, err))
+	}
+	log.Printf("Imported certificate %s of zone %s", aws.StringValue(resp.CertificateArn), input.VenafiZone)
+	tags := mergeTags(input.Tags, requesterTags(request, input.VenafiZone))
+	// The certificate is already imported, so a tagging failure doesn't fail the request.
+	err = tagCertificate(ctx, acmCli, resp.CertificateArn, tags)
+	if err != nil {
+		log.Printf("Can't tag certificate %s: %s", aws.StringValue(resp.CertificateArn), err)
+	}
+
+	record := importedRecord(aws.StringValue(resp.CertificateArn), &req, cert)
+	record.Zone = input.VenafiZone
+	record.PolicyVersion, _ = common.PolicyVersion(policy)
+	record.SourceAccount = request.RequestContext.Identity.AccountID
+	record.RequestedBy = request.RequestContext.Identity.UserArn
+	record.Tags = tagsMap(tags)
+	record.Thumbprint, _ = certificateThumbprint(string(input.Certificate))
+	recordIssuedCertificate(record)
+	return jsonResponse(acmImportCertificate, resp.ImportCertificateOutput)
+}
+
+// newImportedCertificateRequest parses a PEM encoded certificate into a certificate request, normalized as CSRs are.
+func newImportedCertificateRequest(certPEM []byte) (req certificate.Request, cert *x509.Certificate, err error) {
+	pemBlock, _ := pem.Decode(certPEM)
+	if pemBlock == nil || pemBlock.Type != "CERTIFICATE" {
+		return req, nil, fmt.Errorf("certificate is not PEM encoded")
+	}
+	cert, err = x509.ParseCertificate(pemBlock.Bytes)
+	if err != nil {
+		return req, nil, err
+	}
+	req.Subject = normalizeSubject(cert.Subject)
+	req.DNSNames = canonicalDNSNames(cert.DNSNames)
+	req.EmailAddresses = canonicalEmails(cert.EmailAddresses)
+	req.IPAddresses = uniqueIPs(cert.IPAddresses)
+	req.URIs = uniqueURIs(cert.URIs)
+	req.UPNs, err = upnSANs(cert.Extensions)
+	if err != nil {
+		return req, nil, err
+	}
+	err = setRequestKey(&req, cert.PublicKey)
+	return req, cert, err
+}
+
+// importedRecord describes a certificate imported into ACM.
+func importedRecord(certificateArn string, req *certificate.Request, cert *x509.Certificate) common.InventoryRecord {
+	names := requestedNames(req)
+	r := common.InventoryRecord{
+		CertificateArn:          certificateArn,
+		SubjectAlternativeNames: req.DNSNames,
+		Serial:                  cert.SerialNumber.Text(16),
+		Status:                  string(acm.CertificateStatusIssued),
+		Type:                    string(acm.CertificateTypeImported),
+		NotAfter:                cert.NotAfter.UTC(),
+		Subject:                 req.Subject.String(),
+	}
+	if len(names) > 0 {
+		r.DomainName = names[0]
+	}
+	return r
+}
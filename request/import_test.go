@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"testing"
+)
+
+func TestNewImportedCertificateRequest(t *testing.T) {
+	req, cert, err := newImportedCertificateRequest([]byte(testPEMCertificate(t, "WWW.Example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Subject.CommonName != "www.example.com" {
+		t.Errorf("common name is %q", req.Subject.CommonName)
+	}
+	if req.KeyType != certificate.KeyTypeECDSA {
+		t.Errorf("key type is %s", req.KeyType.String())
+	}
+	record := importedRecord("arn:aws:acm:us-east-1:123456789012:certificate/a", &req, cert)
+	if record.DomainName != "www.example.com" || record.Serial != "1" || record.Type != "IMPORTED" {
+		t.Errorf("unexpected inventory record %+v", record)
+	}
+
+	policy := endpoint.Policy{SubjectCNRegexes: []string{`^.*\.example\.org$`}}
+	if validateRequest(policy, &req) == nil {
+		t.Error("certificate out of policy should be rejected")
+	}
+
+	_, _, err = newImportedCertificateRequest(createCSR("www.example.com"))
+	if err == nil {
+		t.Error("CSR should not be accepted as certificate")
+	}
+}
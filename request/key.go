@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"strings"
+)
+
+// acmpcaRSAKeySizes are RSA key sizes ACM PCA can sign.
+var acmpcaRSAKeySizes = []int{2048, 3072, 4096}
+
+// setRequestKey translates the CSR public key into the key type, size and curve used by the policy. Keys ACM PCA
+// can't sign are rejected, so callers get a clear error instead of a late failure from ACM PCA.
+func setRequestKey(req *certificate.Request, publicKey interface{}) error {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		req.KeyType = certificate.KeyTypeRSA
+		req.KeyLength = pub.Size() * 8
+		for _, size := range acmpcaRSAKeySizes {
+			if size == req.KeyLength {
+				return nil
+			}
+		}
+		return fmt.Errorf("RSA key size %d is not supported by ACM PCA, supported sizes are %v", req.KeyLength, acmpcaRSAKeySizes)
+	case *ecdsa.PublicKey:
+		req.KeyType = certificate.KeyTypeECDSA
+		name := pub.Curve.Params().Name
+		switch name {
+		case "P-256":
+			req.KeyCurve = certificate.EllipticCurveP256
+		case "P-384":
+			req.KeyCurve = certificate.EllipticCurveP384
+		case "P-521":
+			req.KeyCurve = certificate.EllipticCurveP521
+		default:
+			return fmt.Errorf("elliptic curve %s is not supported by ACM PCA, supported curves are P-256, P-384 and P-521", name)
+		}
+		return nil
+	case ed25519.PublicKey:
+		return fmt.Errorf("Ed25519 keys are not supported by ACM PCA, use RSA or ECDSA keys")
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+// validateRequestKey checks the key of the request against key configurations allowed by the policy. Unlike the
+// policy validation of vcert, the error names the requested and the allowed keys.
+func validateRequestKey(p endpoint.Policy, req *certificate.Request) error {
+	if len(p.AllowedKeyConfigurations) == 0 {
+		return nil
+	}
+	for _, allowed := range p.AllowedKeyConfigurations {
+		if allowed.KeyType != req.KeyType {
+			continue
+		}
+		switch req.KeyType {
+		case certificate.KeyTypeRSA:
+			for _, size := range allowed.KeySizes {
+				if size == req.KeyLength {
+					return nil
+				}
+			}
+		case certificate.KeyTypeECDSA:
+			for _, curve := range allowed.KeyCurves {
+				if curve == req.KeyCurve {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("key %s is not allowed by policy, allowed keys are %s", keyDescription(req), allowedKeysDescription(p.AllowedKeyConfigurations))
+}
+
+func keyDescription(req *certificate.Request) string {
+	if req.KeyType == certificate.KeyTypeECDSA {
+		return "ECDSA " + req.KeyCurve.String()
+	}
+	return fmt.Sprintf("%s %d", req.KeyType.String(), req.KeyLength)
+}
+
+func allowedKeysDescription(configurations []endpoint.AllowedKeyConfiguration) string {
+	return strings.Join(allowedKeys(configurations), ", ")
+}
+
+// allowedKeys describes each allowed key configuration, e.g. RSA 2048/4096.
+func allowedKeys(configurations []endpoint.AllowedKeyConfiguration) []string {
+	descriptions := make([]string, 0, len(configurations))
+	for _, c := range configurations {
+		values := make([]string, 0, len(c.KeySizes)+len(c.KeyCurves))
+		for _, size := range c.KeySizes {
+			values = append(values, fmt.Sprint(size))
+		}
+		for i := range c.KeyCurves {
+			values = append(values, c.KeyCurves[i].String())
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s %s", c.KeyType.String(), strings.Join(values, "/")))
+	}
+	return descriptions
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"log"
+	"net/http"
+	"os"
+)
+
+const venafiIssueCertificateWithKey = "Venafi.IssueCertificateWithKey"
+
+// keySecretPrefix is the name prefix of secrets with generated private keys.
+const keySecretPrefix = "venafi/keys/"
+
+// Key specs of generated keys, named like ACM key algorithms.
+const (
+	keySpecRSA2048 = "RSA_2048"
+	keySpecRSA3072 = "RSA_3072"
+	keySpecRSA4096 = "RSA_4096"
+	keySpecP256    = "EC_prime256v1"
+	keySpecP384    = "EC_secp384r1"
+	keySpecP521    = "EC_secp521r1"
+)
+
+// fallbackKeySpec is generated for zones whose policy doesn't restrict keys.
+const fallbackKeySpec = keySpecRSA2048
+
+var rsaKeySpecs = map[int]string{
+	2048: keySpecRSA2048,
+	3072: keySpecRSA3072,
+	4096: keySpecRSA4096,
+}
+
+var curveKeySpecs = map[certificate.EllipticCurve]string{
+	certificate.EllipticCurveP256: keySpecP256,
+	certificate.EllipticCurveP384: keySpecP384,
+	certificate.EllipticCurveP521: keySpecP521,
+}
+
+type IssueCertificateWithKeyInput struct {
+	CertificateAuthorityArn *string
+	SigningAlgorithm        acmpca.SigningAlgorithm
+	Validity                *acmpca.Validity
+	VenafiZone              string
+	Tags                    []acm.Tag
+	// KeySpec defaults to the first key type allowed by the zone policy.
+	KeySpec    string
+	CommonName string
+	DNSNames   []string
+	// IdempotencyToken identifies retries of the request, see idempotencyKey.
+	IdempotencyToken string
+}
+
+type IssueCertificateWithKeyOutput struct {
+	ACMPCAIssueCertificateResponse
+	// SecretArn is the Secrets Manager secret with the PEM encoded private key, readable by the caller only.
+	SecretArn string
+}
+
+// issueCertificateWithKey generates a key pair for devices which can't generate their own, issues a certificate for
+// it as a regular IssueCertificate request and delivers the private key in a KMS encrypted secret. The key is never
+// returned in the response. The secret is created before the certificate is issued, so an issued certificate
+// always has its key, and deleted if the request fails.
+func issueCertificateWithKey(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	kmsKeyID := os.Getenv("KEYGEN_KMS_KEY_ID")
+	if kmsKeyID == "" {
+		return clientError(http.StatusNotImplemented, "Server-side key generation is not enabled")
+	}
+	var input IssueCertificateWithKeyInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiIssueCertificateWithKey, err))
+	}
+	if input.CommonName == "" && len(input.DNSNames) == 0 {
+		return clientError(http.StatusBadRequest, "CommonName or DNSNames is required")
+	}
+	reader := keyReaderArn(request.RequestContext.Identity.UserArn)
+	if reader == "" {
+		return clientError(http.StatusForbidden, "Caller ARN is unknown, the key can't be delivered")
+	}
+	input.VenafiZone, err = resolveZone(request, input.VenafiZone)
+	if err != nil {
+		return zoneErrorResponse(err)
+	}
+	if input.KeySpec == "" {
+		policy, err := getPolicy(input.VenafiZone)
+		if err == common.PolicyNotFound {
+			return handlePolicyNotFound(input.VenafiZone)
+		} else if err != nil {
+			common.Monitor.Record(common.BackendDynamoDB, err)
+			return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
+		}
+		input.KeySpec = defaultKeySpec(policy)
+	}
+	key, err := generateKey(input.KeySpec)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: input.CommonName},
+		DNSNames: input.DNSNames,
+	}, key)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Can't create certificate request: %s", err))
+	}
+
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
+	}
+	cli := secretsmanager.New(cfg)
+	secretArn, err := createKeySecret(ctx, cli, keySecretName(request), kmsKeyID, reader, input.VenafiZone, key)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Can't store private key: %s", err))
+	}
+
+	body, err := json.Marshal(ACMPCAIssueCertificateRequest{
+		IssueCertificateInput: acmpca.IssueCertificateInput{
+			CertificateAuthorityArn: input.CertificateAuthorityArn,
+			Csr:                     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+			SigningAlgorithm:        input.SigningAlgorithm,
+			Validity:                input.Validity,
+		},
+		VenafiZone: input.VenafiZone,
+		Tags:       input.Tags,
+	})
+	if err != nil {
+		deleteKeySecret(ctx, cli, secretArn)
+		return clientError(http.StatusInternalServerError, err.Error())
+	}
+	issueRequest := request
+	issueRequest.Headers = make(map[string]string, len(request.Headers))
+	for h, v := range request.Headers {
+		issueRequest.Headers[h] = v
+	}
+	issueRequest.Headers["X-Amz-Target"] = acmpcaIssueCertificate
+	issueRequest.Body = string(body)
+	response, err := ACMPCAHandler(issueRequest)
+	if err != nil || response.StatusCode >= 300 {
+		deleteKeySecret(ctx, cli, secretArn)
+		return response, err
+	}
+
+	var output IssueCertificateWithKeyOutput
+	_ = json.Unmarshal([]byte(response.Body), &output.ACMPCAIssueCertificateResponse)
+	output.SecretArn = secretArn
+	if output.CertificateArn != "" {
+		_, err = cli.TagResourceRequest(&secretsmanager.TagResourceInput{
+			SecretId: aws.String(secretArn),
+			Tags:     []secretsmanager.Tag{{Key: aws.String("CertificateArn"), Value: aws.String(output.CertificateArn)}},
+		}).Send(ctx)
+		if err != nil {
+			log.Printf("Can't tag secret %s with certificate %s: %s", secretArn, output.CertificateArn, err)
+		}
+	}
+	respoBodyJSON, err := json.Marshal(output)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	// A queued request keeps its 202 status, the key is already in the secret.
+	response.Body = string(respoBodyJSON)
+	return response, nil
+}
+
+// defaultKeySpec returns the key spec of the first key allowed by the policy, as validateRequestKey checks it. RSA sizes
+// and curves ACM PCA can't sign are skipped.
+func defaultKeySpec(policy endpoint.Policy) string {
+	for _, c := range policy.AllowedKeyConfigurations {
+		switch c.KeyType {
+		case certificate.KeyTypeRSA:
+			for _, size := range acmpcaRSAKeySizes {
+				if intInSlice(size, c.KeySizes) {
+					return rsaKeySpecs[size]
+				}
+			}
+		case certificate.KeyTypeECDSA:
+			for _, curve := range c.KeyCurves {
+				if spec, ok := curveKeySpecs[curve]; ok {
+					return spec
+				}
+			}
+		}
+	}
+	return fallbackKeySpec
+}
+
+func intInSlice(i int, list []int) bool {
+	for _, v := range list {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+func generateKey(spec string) (crypto.Signer, error) {
+	switch spec {
+	case keySpecRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case keySpecRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case keySpecRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case keySpecP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case keySpecP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case keySpecP521:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	}
+	return nil, fmt.Errorf("unknown key spec %q, supported specs are %s, %s, %s, %s, %s and %s", spec,
+		keySpecRSA2048, keySpecRSA3072, keySpecRSA4096, keySpecP256, keySpecP384, keySpecP521)
+}
+
+// keyReaderArn returns the principal the key secret is shared with: the caller, or the role of an assumed role session.
+func keyReaderArn(callerArn string) string {
+	if roleArn, ok := sessionRoleArn(callerArn); ok {
+		return roleArn
+	}
+	return callerArn
+}
+
+func keySecretName(request events.APIGatewayProxyRequest) string {
+	id := request.RequestContext.RequestID
+	if id == "" {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return keySecretPrefix + id
+}
+
+// keySecretPolicy allows the reader to get the secret value. The reader also needs to decrypt with the KMS key.
+func keySecretPolicy(reader string) (string, error) {
+	policy := map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":    "Allow",
+			"Principal": map[string]string{"AWS": reader},
+			"Action":    []string{"secretsmanager:GetSecretValue", "secretsmanager:DescribeSecret"},
+			"Resource":  "*",
+		}},
+	}
+	b, err := json.Marshal(policy)
+	return string(b), err
+}
+
+func createKeySecret(ctx context.Context, cli *secretsmanager.Client, name, kmsKeyID, reader, zone string, key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	policy, err := keySecretPolicy(reader)
+	if err != nil {
+		return "", err
+	}
+	resp, err := cli.CreateSecretRequest(&secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		Description:  aws.String("Private key generated by the Venafi certificate request Lambda"),
+		KmsKeyId:     aws.String(kmsKeyID),
+		SecretString: aws.String(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))),
+		Tags: []secretsmanager.Tag{
+			{Key: aws.String("VenafiZone"), Value: aws.String(zone)},
+			{Key: aws.String("RequestedBy"), Value: aws.String(reader)},
+		},
+	}).Send(ctx)
+	if err != nil {
+		return "", err
+	}
+	secretArn := aws.StringValue(resp.ARN)
+	_, err = cli.PutResourcePolicyRequest(&secretsmanager.PutResourcePolicyInput{
+		SecretId:       aws.String(secretArn),
+		ResourcePolicy: aws.String(policy),
+	}).Send(ctx)
+	if err != nil {
+		deleteKeySecret(ctx, cli, secretArn)
+		return "", err
+	}
+	return secretArn, nil
+}
+
+// deleteKeySecret deletes the key of a failed request. Nothing was issued for it, so it's deleted without recovery.
+func deleteKeySecret(ctx context.Context, cli *secretsmanager.Client, secretArn string) {
+	_, err := cli.DeleteSecretRequest(&secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(secretArn),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	}).Send(ctx)
+	if err != nil {
+		log.Printf("ALERT: can't delete key secret %s of failed request: %s", secretArn, err)
+	}
+}
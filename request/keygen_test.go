@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"testing"
+)
+
+func TestDefaultKeySpec(t *testing.T) {
+	cases := []struct {
+		configs []endpoint.AllowedKeyConfiguration
+		spec    string
+	}{
+		{nil, fallbackKeySpec},
+		{[]endpoint.AllowedKeyConfiguration{{KeyType: certificate.KeyTypeRSA, KeySizes: []int{1024, 4096}}}, keySpecRSA4096},
+		{[]endpoint.AllowedKeyConfiguration{{KeyType: certificate.KeyTypeECDSA, KeyCurves: []certificate.EllipticCurve{certificate.EllipticCurveP384}}}, keySpecP384},
+		{[]endpoint.AllowedKeyConfiguration{{KeyType: certificate.KeyTypeECDSA}, {KeyType: certificate.KeyTypeRSA, KeySizes: []int{3072}}}, keySpecRSA3072},
+	}
+	for _, c := range cases {
+		if spec := defaultKeySpec(endpoint.Policy{AllowedKeyConfigurations: c.configs}); spec != c.spec {
+			t.Errorf("expected key spec %s for %+v, got %s", c.spec, c.configs, spec)
+		}
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := generateKey(keySpecP384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); !ok || k.Curve.Params().Name != "P-384" {
+		t.Fatalf("unexpected key %T", key)
+	}
+	key, err = generateKey(keySpecRSA2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*rsa.PrivateKey); !ok || k.N.BitLen() != 2048 {
+		t.Fatalf("unexpected key %T", key)
+	}
+	if _, err = generateKey("RSA_1024"); err == nil {
+		t.Fatal("unsupported key spec should fail")
+	}
+}
+
+func TestKeyReaderArn(t *testing.T) {
+	if r := keyReaderArn("arn:aws:sts::123456789012:assumed-role/Provisioner/device-42"); r != "arn:aws:iam::123456789012:role/Provisioner" {
+		t.Errorf("session should resolve to its role, got %s", r)
+	}
+	if r := keyReaderArn("arn:aws:iam::123456789012:user/alice"); r != "arn:aws:iam::123456789012:user/alice" {
+		t.Errorf("user should be the reader, got %s", r)
+	}
+}
@@ -9,12 +9,13 @@ import (
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/acm"
 	"github.com/aws/aws-sdk-go-v2/service/acmpca"
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 type venafiError string
@@ -36,15 +37,41 @@ var defaultZone = "Default"
 type ACMPCAIssueCertificateRequest struct {
 	acmpca.IssueCertificateInput
 	VenafiZone string `json:"VenafiZone"`
+	// Tags are checked against tags required by the zone. ACM PCA certificates can't be tagged.
+	Tags []acm.Tag `json:"Tags"`
+	// WaitForCertificate returns the issued certificate and its chain instead of only the ARN, if the certificate is
+	// issued before the request has to return, see waitDeadline.
+	WaitForCertificate bool `json:"WaitForCertificate"`
+	// ApiPassthrough overrides the subject and extensions of the CSR. It's validated like the CSR and issued with the
+	// pass-through template of the zone.
+	ApiPassthrough *apiPassthrough `json:"ApiPassthrough"`
 }
 
 type VenafiRequestCertificateInput struct {
 	acm.RequestCertificateInput
 	VenafiZone string `json:"VenafiZone"`
+	// Tags are added to the certificate after it's requested.
+	Tags []acm.Tag `json:"Tags"`
+}
+
+// zoneResourceKeys returns the zone mapping keys of the resources of the request: the CA of private certificates, so
+// they are mapped like IssueCertificate requests to the CA.
+func (in *VenafiRequestCertificateInput) zoneResourceKeys() []string {
+	if in.CertificateAuthorityArn == nil {
+		return nil
+	}
+	return []string{aws.StringValue(in.CertificateAuthorityArn)}
 }
 
 type ACMPCAIssueCertificateResponse struct {
 	CertificateArn string `json:"CertificateArn"`
+	// CertificateAuthorityArn is the CA which actually issued the certificate, it differs from the requested one after failover.
+	CertificateAuthorityArn string `json:"CertificateAuthorityArn,omitempty"`
+	// Certificate and CertificateChain are PEM encoded, returned for requests with WaitForCertificate.
+	Certificate      string `json:"Certificate,omitempty"`
+	CertificateChain string `json:"CertificateChain,omitempty"`
+	// Corrections are changes made to the request to comply with the policy in zones with AutoCorrect.
+	Corrections []PolicyCorrection `json:"Corrections,omitempty"`
 }
 
 type ACMPCAGetCertificateResponse struct {
@@ -58,20 +85,93 @@ type ACMPCAGetCertificateResponse struct {
 func ACMPCAHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
 	ctx := context.TODO()
+	start := time.Now()
 	target := request.Headers["X-Amz-Target"]
 	log.Println("ACMPCAHandler started. Parsing header", target)
-	log.Printf("Request: %s", request.Body)
+	// Imported certificates come with their private keys.
+	if target != acmImportCertificate {
+		log.Printf("Request: %s", request.Body)
+	}
 	initHandler()
+	requestZone = ""
+	bypassPolicyCache = policyCacheBypassed(request)
+	key := idempotencyKey(request, target)
+	if key != "" {
+		if stored, retry := claimIdempotencyKey(key, time.Now()); retry {
+			return stored, nil
+		}
+	}
+	response, err := handleTarget(ctx, request, target)
+	if err == nil && target != venafiGetRequestStatus {
+		response = trackRequest(request, target, response)
+	}
+	if err == nil && (target == acmpcaIssueCertificate || target == acmRequestCertificate) {
+		sendRequestEmail(ctx, request, target, response)
+		openDenialTicket(ctx, request, target, response)
+		forwardDenialEvent(ctx, request, target, response)
+	}
+	if err == nil {
+		putRequestMetrics(request, target, response, start)
+	}
+	if key != "" {
+		storeResponse(key, response, time.Now())
+	}
+	return response, err
+}
+
+func handleTarget(ctx context.Context, request events.APIGatewayProxyRequest, target string) (events.APIGatewayProxyResponse, error) {
+	if err := checkSourceAccount(request); err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
 	switch target {
 	case acmpcaIssueCertificate:
 		return venafiACMPCAIssueCertificateRequest(request)
 	case acmRequestCertificate:
 		return venafiACMRequestCertificate(request)
+	case venafiCreateBreakGlassToken:
+		return createBreakGlassToken(request)
+	case venafiListExpiringCertificates:
+		return listExpiringCertificates(request)
+	case venafiDescribePolicy:
+		return describePolicy(request)
+	case venafiValidateCertificateRequest:
+		return validateCertificateRequest(request)
+	case venafiListZones:
+		return listZones(request)
+	case venafiListPolicyRevisions:
+		return listPolicyRevisions(request)
+	case venafiRollbackPolicy:
+		return rollbackPolicy(request)
+	case venafiSyncPolicy:
+		return syncPolicy(ctx, request)
+	case venafiGetRequestStatus:
+		return getRequestStatus(request)
+	case venafiSearchCertificates:
+		return searchCertificates(request)
+	case venafiRevokeCertificates:
+		return revokeCertificates(ctx, request)
+	case venafiIssueCertificates:
+		return issueCertificates(request)
+	case venafiCreateUploadURL:
+		return createUploadURL(ctx, request)
+	case venafiIssueCertificateWithKey:
+		return issueCertificateWithKey(ctx, request)
+	case acmImportCertificate:
+		return importCertificate(ctx, request)
 	case acmDescribeCertificate, acmExportCertificate, acmGetCertificate, acmListCertificates, acmRenewCertificate,
-		acmpcaGetCertificate, acmpcaGetCertificateAuthorityCertificate, acmpcaListCertificateAuthorities,
-		acmpcaRevokeCertificate:
+		acmAddTagsToCertificate, acmRemoveTagsFromCertificate, acmListTagsForCertificate,
+		acmpcaGetCertificate, acmpcaGetCertificateAuthorityCertificate, acmpcaGetCertificateAuthorityCsr,
+		acmpcaListCertificateAuthorities, acmpcaRevokeCertificate, acmpcaCreateCertificateAuthority,
+		acmpcaUpdateCertificateAuthority:
 		return passThru(request, ctx, target)
 	default:
+		if op, ok := connectorOperations[target]; ok {
+			return connectorPassThru(ctx, request, target, op)
+		}
+		if action, ok := passThruAction(target); ok {
+			return actionPassThru(ctx, request, action)
+		}
 		log.Println("Can't determine requested method for header: ", target)
 		return clientError(http.StatusMethodNotAllowed, fmt.Sprintf("Can't determine requested method for header: %s", target))
 	}
@@ -81,52 +181,218 @@ func ACMPCAHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProx
 func venafiACMPCAIssueCertificateRequest(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
 	log.Println("Requesting ACMP CA certificate")
+	start := time.Now()
 	var err error
 	ctx := context.TODO()
 	//TODO: Parse request body with CSR
 	var certRequest ACMPCAIssueCertificateRequest
+	parse := trace.BeginSubsegment("ParseRequest", "")
 	err = json.Unmarshal([]byte(request.Body), &certRequest)
 	if err != nil {
+		parse.Close(nil)
 		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, acmpcaIssueCertificate, err))
 	}
 
-	var req certificate.Request
-	err = req.SetCSR([]byte(certRequest.IssueCertificateInput.Csr))
+	req, err := newCSRRequest(certRequest.IssueCertificateInput.Csr)
+	parse.Close(nil)
 	if err != nil {
-		return clientError(http.StatusUnprocessableEntity, "Can't parse certificate request")
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf("Can't parse certificate request: %s", err))
+	}
+	err = checkCSRAttributes(certRequest.IssueCertificateInput.Csr, csrAllowedAttributes)
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
 	}
 	//TODO: add SigningAlgorithm validation
 
-	if certRequest.VenafiZone == "" {
-		certRequest.VenafiZone = defaultZone
+	certRequest.VenafiZone, err = resolveZone(request, certRequest.VenafiZone, aws.StringValue(certRequest.CertificateAuthorityArn))
+	if err != nil {
+		return zoneErrorResponse(err)
 	}
-	policy, err := common.GetPolicy(certRequest.VenafiZone)
+	fetch := beginPolicyFetch(certRequest.VenafiZone)
+	policy, err := getPolicy(certRequest.VenafiZone)
+	closePolicyFetch(fetch, err)
 	if err == common.PolicyNotFound {
 		return handlePolicyNotFound(certRequest.VenafiZone)
 	} else if err != nil {
+		common.Monitor.Record(common.BackendDynamoDB, err)
 		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
 	}
 
-	//TODO: also validate SigningAlgorithm from request
-	err = policy.ValidateCertificateRequest(&req)
+	fetch = trace.BeginSubsegment("GetZoneConfig", "")
+	zoneConfig, err := common.GetZoneConfig(certRequest.VenafiZone)
+	fetch.Close(err)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+	}
+	err = checkRequiredTags(zoneConfig, certRequest.Tags)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	err = applyApiPassthrough(&req, certRequest.ApiPassthrough, zoneConfig)
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	bypass, err := useBreakGlassToken(request, certRequest.VenafiZone)
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	bypass = auditModeBypass(certRequest.VenafiZone, zoneConfig, bypass)
+	err = bypass.apply(ruleFreeze, checkFreezeWindows(zoneConfig, time.Now()))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleOrigin, checkRequestOrigin(request, zoneConfig))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = validateCSRSize(zoneConfig, certRequest.IssueCertificateInput.Csr)
 	if err != nil {
 		return clientError(http.StatusForbidden, err.Error())
 	}
+	region, err := targetRegion(request, zoneConfig)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
 
 	//Issuing ACM certificate
-	awsCfg, err := external.LoadDefaultAWSConfig()
+	awsCfg, err := loadAWSConfig(region, zoneConfig.RoleArn)
 	if err != nil {
 		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
 	}
-	acmCli := acmpca.New(awsCfg)
-	caReqInput := acmCli.IssueCertificateRequest(&certRequest.IssueCertificateInput)
+	renewal, err := renewalPolicy(request, certRequest.VenafiZone, zoneConfig, requestedNames(&req))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	if renewal != nil {
+		policy = *renewal
+	}
 
-	csrResp, err := caReqInput.Send(ctx)
+	err = bypass.apply(ruleZoneRules, checkDeniedDomains(zoneConfig, &req))
 	if err != nil {
-		return clientError(http.StatusInternalServerError, fmt.Sprintf("Could not get certificate response: %s", err))
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	//TODO: also validate SigningAlgorithm from request
+	validation := trace.BeginSubsegment("ValidateRequest", "")
+	err = validateRequest(policy, &req)
+	var corrections []PolicyCorrection
+	if violations, ok := err.(policyViolationError); ok && zoneConfig.AutoCorrect {
+		req, certRequest.ApiPassthrough, corrections, err = correctRequest(policy, req, certRequest.ApiPassthrough, violations)
+		if err == nil {
+			log.Printf("AUDIT: request %s of %s in zone %s corrected to comply with policy: %+v", request.RequestContext.RequestID,
+				request.RequestContext.Identity.UserArn, certRequest.VenafiZone, corrections)
+		}
+	}
+	validation.Close(nil)
+	err = bypass.apply(rulePolicy, err)
+	if err != nil {
+		return policyViolationResponse(certRequest.VenafiZone, policy, err.Error(), err)
+	}
+	err = bypass.apply(ruleZoneRules, validateZoneRules(zoneConfig, &req))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleZoneRules, checkValidity(zoneConfig, &certRequest.IssueCertificateInput, time.Now()))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleDNSCheck, checkDNSNames(ctx, zoneConfig, &req))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleOwnership, verifyDomainOwnership(ctx, zoneConfig, &req))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+
+	ca, err := resolveCA(ctx, awsCfg, aws.StringValue(certRequest.CertificateAuthorityArn))
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	err = checkSharedCAEntitlement(ctx, awsCfg, ca)
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	// Without the CA expiry the request is left to ACM PCA, which rejects validities exceeding it.
+	caExpiry, err := caNotAfter(ctx, awsCfg, ca, time.Now())
+	if err != nil {
+		log.Println(err)
+	}
+	err = checkCAExpiry(caExpiry, &certRequest.IssueCertificateInput, time.Now())
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	pt, err := csrPassthrough(certRequest.Csr, zoneConfig, ca.Arn.String())
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	pt = withApiPassthrough(pt, certRequest.ApiPassthrough, zoneConfig, ca.Arn.String())
+	err = bypass.apply(ruleZoneRules, checkExtendedKeyUsages(zoneConfig, certRequest.Csr, pt))
+	if err != nil {
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	// Clients retrying after an API Gateway timeout get the certificate issued for the first attempt.
+	requestHash := issueRequestHash(certRequest.VenafiZone, certRequest.IssueCertificateInput, certRequest.ApiPassthrough)
+	issued, found := findIssuedRequest(requestHash, time.Now())
+	if found {
+		log.Printf("Request is a resubmission, returning previously issued certificate %s", issued.CertificateArn)
+	} else {
+		release, err := reserveIssuance(ctx, request, time.Now())
+		if err != nil {
+			return clientError(http.StatusTooManyRequests, err.Error())
+		}
+		failover := failoverCAs(ca.Arn.String(), zoneConfig)
+		now := time.Now()
+		issuance := trace.BeginSubsegment("ACMPCA", common.NamespaceAWS)
+		issuance.Annotate("operation", "IssueCertificate")
+		csrResp, issuer, err := issueWithFailover(ctx, awsCfg, region, certRequest.IssueCertificateInput, pt, ca, failover, now.Add(throttleRetryBudget))
+		issuance.Close(err)
+		common.Monitor.Record(common.BackendACMPCA, err)
+		tags := mergeTags(certRequest.Tags, requesterTags(request, certRequest.VenafiZone))
+		q := newQueuedIssuance(request, acmpcaIssueCertificate, certRequest.VenafiZone, region, zoneConfig.RoleArn, tags, now)
+		q.PolicyVersion, _ = common.PolicyVersion(policy)
+		q.IssueCertificateInput = &certRequest.IssueCertificateInput
+		q.Passthrough = pt
+		q.CertificateAuthorityArn = ca.Arn.String()
+		q.FailoverCAs = failover
+		q.RequestHash = requestHash
+		q.BudgetMonth = issuanceMonth(now)
+		if queueThrottled(ctx, err, q) {
+			return queuedResponse(q.RequestID)
+		}
+		if err != nil {
+			release()
+			return clientError(http.StatusInternalServerError, fmt.Sprintf("Could not get certificate response: %s", err))
+		}
+		issued.CertificateArn = aws.StringValue(csrResp.CertificateArn)
+		issued.CertificateAuthorityArn = issuer
+		saveIssuedRequest(requestHash, issued.CertificateArn, issuer, time.Now())
+		record := issuedPCARecord(issued.CertificateArn, &req, certRequest.IssueCertificateInput, time.Now())
+		record.Zone = certRequest.VenafiZone
+		record.PolicyVersion = q.PolicyVersion
+		record.SourceAccount = request.RequestContext.Identity.AccountID
+		record.RequestedBy = request.RequestContext.Identity.UserArn
+		record.Tags = tagsMap(tags)
+		recordIssuedCertificate(record)
 	}
 
-	respoBodyJSON, err := json.Marshal(csrResp)
+	response := ACMPCAIssueCertificateResponse{
+		CertificateArn:          issued.CertificateArn,
+		CertificateAuthorityArn: issued.CertificateAuthorityArn,
+		Corrections:             corrections,
+	}
+	if certRequest.WaitForCertificate {
+		cert, err := pollIssuedCertificate(ctx, zoneConfig.RoleArn, issued.CertificateArn, waitDeadline(start))
+		if err != nil {
+			// The certificate is issued, clients get it with GetCertificate later.
+			log.Printf("Returning only the ARN of certificate %s: %s", issued.CertificateArn, err)
+		} else {
+			response.Certificate = aws.StringValue(cert.Certificate)
+			response.CertificateChain = aws.StringValue(cert.CertificateChain)
+			recordThumbprint(issued.CertificateArn, response.Certificate)
+		}
+	}
+	respoBodyJSON, err := json.Marshal(response)
 	if err != nil {
 		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf("Error marshaling response JSON for target %s: %s", acmpcaIssueCertificate, err))
 	}
@@ -141,45 +407,182 @@ func venafiACMRequestCertificate(request events.APIGatewayProxyRequest) (events.
 	log.Println("Starting RequestCertificate")
 	ctx := context.TODO()
 	var certRequest VenafiRequestCertificateInput
+	parse := trace.BeginSubsegment("ParseRequest", "")
 	err := json.Unmarshal([]byte(request.Body), &certRequest)
+	parse.Close(nil)
 	if err != nil {
 		log.Println(err)
 		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf("Error unmarshaling JSON: %s", err))
 	}
 
+	// Canonical names are also forwarded to ACM, so format variants and duplicates don't end up in the certificate.
+	if certRequest.DomainName != nil {
+		certRequest.DomainName = aws.String(canonicalDNSName(*certRequest.DomainName))
+	}
+	certRequest.SubjectAlternativeNames = canonicalDNSNames(certRequest.SubjectAlternativeNames)
+
 	var req certificate.Request
-	req.Subject = pkix.Name{CommonName: *certRequest.DomainName}
+	req.Subject = normalizeSubject(pkix.Name{CommonName: aws.StringValue(certRequest.DomainName)})
 	req.DNSNames = certRequest.SubjectAlternativeNames
 
 	if certRequest.VenafiZone == "" {
-		certRequest.VenafiZone = defaultZone
+		certRequest.VenafiZone = zoneFromTags(certRequest.Tags)
+	}
+	certRequest.VenafiZone, err = resolveZone(request, certRequest.VenafiZone, certRequest.zoneResourceKeys()...)
+	if err != nil {
+		return zoneErrorResponse(err)
 	}
-	policy, err := common.GetPolicy(certRequest.VenafiZone)
+	fetch := beginPolicyFetch(certRequest.VenafiZone)
+	policy, err := getPolicy(certRequest.VenafiZone)
+	closePolicyFetch(fetch, err)
 	if err == common.PolicyNotFound {
 		return handlePolicyNotFound(certRequest.VenafiZone)
 	} else if err != nil {
 		log.Println(err)
+		common.Monitor.Record(common.BackendDynamoDB, err)
 		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
 	}
-	err = policy.SimpleValidateCertificateRequest(req)
+	fetch = trace.BeginSubsegment("GetZoneConfig", "")
+	zoneConfig, err := common.GetZoneConfig(certRequest.VenafiZone)
+	fetch.Close(err)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+	}
+	err = checkRequiredTags(zoneConfig, certRequest.Tags)
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	bypass, err := useBreakGlassToken(request, certRequest.VenafiZone)
 	if err != nil {
 		log.Println(err)
 		return clientError(http.StatusForbidden, err.Error())
 	}
-	awsCfg, err := external.LoadDefaultAWSConfig()
+	bypass = auditModeBypass(certRequest.VenafiZone, zoneConfig, bypass)
+	err = bypass.apply(ruleFreeze, checkFreezeWindows(zoneConfig, time.Now()))
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleOrigin, checkRequestOrigin(request, zoneConfig))
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	region, err := targetRegion(request, zoneConfig)
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	awsCfg, err := loadAWSConfig(region, zoneConfig.RoleArn)
 	if err != nil {
 		log.Println("Error loading client", err)
 		return clientError(http.StatusInternalServerError, fmt.Sprintf("Can`t load client config: %v", err))
 	}
+	renewal, err := renewalPolicy(request, certRequest.VenafiZone, zoneConfig, acmZoneRulesRequest(req, aws.StringValue(certRequest.DomainName)).DNSNames)
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	if renewal != nil {
+		policy = *renewal
+	}
+	err = bypass.apply(ruleZoneRules, checkDeniedDomains(zoneConfig, &req))
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	validation := trace.BeginSubsegment("ValidateRequest", "")
+	err = simpleValidateRequest(policy, req)
+	validation.Close(nil)
+	err = bypass.apply(rulePolicy, err)
+	if err != nil {
+		log.Println(err)
+		return policyViolationResponse(certRequest.VenafiZone, policy, err.Error(), err)
+	}
+	err = bypass.apply(ruleZoneRules, validateZoneRules(zoneConfig, acmZoneRulesRequest(req, aws.StringValue(certRequest.DomainName))))
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleZoneRules, applyCTLoggingPreference(zoneConfig, &certRequest.RequestCertificateInput))
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleDNSCheck, checkDNSNames(ctx, zoneConfig, acmZoneRulesRequest(req, aws.StringValue(certRequest.DomainName))))
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	err = bypass.apply(ruleOwnership, verifyDomainOwnership(ctx, zoneConfig, acmZoneRulesRequest(req, aws.StringValue(certRequest.DomainName))))
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	if certRequest.CertificateAuthorityArn != nil {
+		ca, err := resolveCA(ctx, awsCfg, *certRequest.CertificateAuthorityArn)
+		if err != nil {
+			log.Println(err)
+			return clientError(http.StatusBadRequest, err.Error())
+		}
+		err = checkSharedCAEntitlement(ctx, awsCfg, ca)
+		if err != nil {
+			log.Println(err)
+			return clientError(http.StatusForbidden, err.Error())
+		}
+	}
+	// Only private certificates are billed.
+	release := func() {}
+	if certRequest.CertificateAuthorityArn != nil {
+		release, err = reserveIssuance(ctx, request, time.Now())
+		if err != nil {
+			log.Println(err)
+			return clientError(http.StatusTooManyRequests, err.Error())
+		}
+	}
 	acmCli := acm.New(awsCfg)
 
-	caReqInput := acmCli.RequestCertificateRequest(&certRequest.RequestCertificateInput)
-
-	certResp, err := caReqInput.Send(ctx)
+	now := time.Now()
+	var certResp *acm.RequestCertificateResponse
+	issuance := trace.BeginSubsegment("ACM", common.NamespaceAWS)
+	issuance.Annotate("operation", "RequestCertificate")
+	err = retryThrottled(now.Add(throttleRetryBudget), func() error {
+		certResp, err = acmCli.RequestCertificateRequest(&certRequest.RequestCertificateInput).Send(ctx)
+		return err
+	})
+	issuance.Close(err)
+	common.Monitor.Record(common.BackendACM, err)
+	tags := mergeTags(certRequest.Tags, requesterTags(request, certRequest.VenafiZone))
+	q := newQueuedIssuance(request, acmRequestCertificate, certRequest.VenafiZone, region, zoneConfig.RoleArn, tags, now)
+	q.PolicyVersion, _ = common.PolicyVersion(policy)
+	q.RequestCertificateInput = &certRequest.RequestCertificateInput
+	if certRequest.CertificateAuthorityArn != nil {
+		q.BudgetMonth = issuanceMonth(now)
+	}
+	if queueThrottled(ctx, err, q) {
+		return queuedResponse(q.RequestID)
+	}
 	if err != nil {
+		release()
 		log.Println(err)
 		return clientError(http.StatusInternalServerError, fmt.Sprintf("Could not get certificate response: %s", err))
 	}
+	// The certificate already exists, so a tagging failure doesn't fail the request.
+	err = tagCertificate(ctx, acmCli, certResp.CertificateArn, tags)
+	if err != nil {
+		log.Printf("Can't tag certificate %s: %s", aws.StringValue(certResp.CertificateArn), err)
+	}
+	startDNSValidation(ctx, certRequest.RequestCertificateInput, aws.StringValue(certResp.CertificateArn), region, zoneConfig.RoleArn)
+	record := acmIssuedRecord(aws.StringValue(certResp.CertificateArn), certRequest.RequestCertificateInput)
+	record.Zone = certRequest.VenafiZone
+	record.PolicyVersion = q.PolicyVersion
+	record.SourceAccount = request.RequestContext.Identity.AccountID
+	record.RequestedBy = request.RequestContext.Identity.UserArn
+	record.Tags = tagsMap(tags)
+	recordIssuedCertificate(record)
 
 	respoBodyJSON, err := json.Marshal(certResp)
 	if err != nil {
@@ -200,6 +603,10 @@ func handlePolicyNotFound(venafiZone string) (events.APIGatewayProxyResponse, er
 	if !savePolicy {
 		return clientError(http.StatusFailedDependency, fmt.Sprintf("Policy %s not exist in database.", venafiZone))
 	}
+	if common.IsRemotePolicyTable() {
+		log.Println("Policy table is in another account, skipping policy creation")
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Policy %s not exist in database. Policy must be added in the central account", venafiZone))
+	}
 	err := common.CreateEmptyPolicy(venafiZone)
 	if err != nil {
 		return clientError(http.StatusFailedDependency, err.Error())
@@ -223,14 +630,72 @@ func clientError(status int, body string) (events.APIGatewayProxyResponse, error
 	}, nil
 }
 
+// clientErrorReason returns an error with a machine-readable reason besides the message, for rejections clients are
+// expected to handle.
+func clientErrorReason(status int, reason, body string) (events.APIGatewayProxyResponse, error) {
+	b, _ := json.Marshal(struct {
+		Msg    string `json:"msg"`
+		Reason string `json:"reason"`
+	}{body, reason})
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Body:       string(b),
+	}, nil
+}
+
 func initHandler() {
 	d := os.Getenv("DEFAULT_ZONE")
 	if d != "" {
 		defaultZone = d
 	}
 	log.Printf("Default zone is: %s", defaultZone)
+	strictZone = os.Getenv("STRICT_ZONE") == "true"
+	allowedAccounts = common.SplitList(os.Getenv("ALLOWED_ACCOUNTS"))
+	loadRevocationRequirements()
+	csrAllowedAttributes = common.SplitList(os.Getenv("CSR_ALLOWED_ATTRIBUTES"))
+	breakGlassAdmins = common.SplitList(os.Getenv("BREAK_GLASS_ADMINS"))
+	revocationAdmins = common.SplitList(os.Getenv("REVOCATION_ADMINS"))
+	connectorAdmins = common.SplitList(os.Getenv("CONNECTOR_ADMINS"))
+	policyAdmins = common.SplitList(os.Getenv("POLICY_ADMINS"))
+	inventoryAdmins = common.SplitList(os.Getenv("INVENTORY_ADMINS"))
+	policyLambdaName = os.Getenv("POLICY_LAMBDA_NAME")
+	loadPolicyCacheTTL()
+	loadVenafiImport()
+	loadDedupWindow()
+	loadIdempotencyWindow()
+	loadBudget()
+	loadEmailSettings()
+	loadTicketSettings()
+	loadDenialEvents()
+	loadRetryQueue()
+	uploadBucket = os.Getenv("UPLOAD_BUCKET")
+	caPolicyZone = os.Getenv("CA_POLICY_ZONE")
+	zoneMapping = os.Getenv("ZONE_MAPPING") == "true"
+	clampValidity = os.Getenv("CLAMP_VALIDITY") == "true"
+	loadZoneTagKey()
+	loadPassThruActions()
+	loadDNSValidationSettings()
 }
 
 func main() {
-	lambda.Start(ACMPCAHandler)
+	if _, canary := loadCanarySettings(); canary {
+		lambda.Start(CanaryHandler)
+		return
+	}
+	if retryWorkerMode() {
+		lambda.Start(RetryWorkerHandler)
+		return
+	}
+	if uploadWorkerMode() {
+		lambda.Start(UploadHandler)
+		return
+	}
+	if dnsValidationWorkerMode() {
+		loadDNSValidationSettings()
+		lambda.Start(DNSValidationHandler)
+		return
+	}
+	initHandler()
+	preloadPolicies()
+	lambda.Start(TracedHandler)
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// metricTargets are the targets metrics are dimensioned by, other targets are counted as "other". Callers choose the
+// target header, so it's never used as a dimension value as is.
+var metricTargets = map[string]bool{
+	acmpcaIssueCertificate:           true,
+	acmRequestCertificate:            true,
+	acmImportCertificate:             true,
+	acmRenewCertificate:              true,
+	acmExportCertificate:             true,
+	acmpcaRevokeCertificate:          true,
+	venafiIssueCertificates:          true,
+	venafiIssueCertificateWithKey:    true,
+	venafiRevokeCertificates:         true,
+	venafiValidateCertificateRequest: true,
+	venafiDescribePolicy:             true,
+	venafiCreateBreakGlassToken:      true,
+	venafiCreateUploadURL:            true,
+	venafiGetRequestStatus:           true,
+	venafiListExpiringCertificates:   true,
+	venafiSearchCertificates:         true,
+	venafiListZones:                  true,
+	venafiListPolicyRevisions:        true,
+	venafiRollbackPolicy:             true,
+	venafiSyncPolicy:                 true,
+}
+
+// putRequestMetrics emits the outcome and latency of the request, dimensioned by target and by the zone the request was
+// resolved to. Requests are counted as approved, denied or failed like their request status. Denials are also counted
+// by reason, so alarms can tell policy violations from e.g. throttling.
+func putRequestMetrics(request events.APIGatewayProxyRequest, target string, response events.APIGatewayProxyResponse, start time.Time) {
+	now := time.Now()
+	status := newRequestStatus(request, target, response, now)
+	dimensions := []common.Dimension{{Name: "Target", Value: metricTarget(target)}, {Name: "Zone", Value: requestZone}}
+	metrics := []common.Metric{
+		{Name: "Requests", Unit: cloudwatch.StandardUnitCount, Value: 1},
+		{Name: "Approvals", Unit: cloudwatch.StandardUnitCount, Value: outcome(status.Status == common.RequestSucceeded || status.Status == common.RequestQueued)},
+		{Name: "Denials", Unit: cloudwatch.StandardUnitCount, Value: outcome(status.Status == common.RequestRejected)},
+		{Name: "Failures", Unit: cloudwatch.StandardUnitCount, Value: outcome(status.Status == common.RequestFailed)},
+		{Name: "Latency", Unit: cloudwatch.StandardUnitMilliseconds, Value: float64(now.Sub(start)) / float64(time.Millisecond)},
+	}
+	common.PutMetrics(dimensions, metrics...)
+	if status.Status == common.RequestRejected {
+		common.PutMetrics(append(dimensions, common.Dimension{Name: "Reason", Value: denialReason(response)}),
+			common.Metric{Name: "DenialsByReason", Unit: cloudwatch.StandardUnitCount, Value: 1})
+	}
+}
+
+func metricTarget(target string) string {
+	if metricTargets[target] {
+		return target
+	}
+	return "other"
+}
+
+func outcome(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// denialReason returns the machine-readable reason of the rejection, e.g. PolicyViolation, or the status text of
+// rejections without one, e.g. Forbidden.
+func denialReason(response events.APIGatewayProxyResponse) string {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.Unmarshal([]byte(response.Body), &body)
+	if body.Reason != "" {
+		return body.Reason
+	}
+	return strings.Replace(http.StatusText(response.StatusCode), " ", "", -1)
+}
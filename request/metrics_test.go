@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"testing"
+)
+
+func TestDenialReason(t *testing.T) {
+	response, _ := clientErrorReason(http.StatusForbidden, reasonPolicyViolation, "CN is not allowed")
+	if reason := denialReason(response); reason != reasonPolicyViolation {
+		t.Errorf("reason of the response should be used, got %s", reason)
+	}
+	response, _ = clientError(http.StatusTooManyRequests, "budget exceeded")
+	if reason := denialReason(response); reason != "TooManyRequests" {
+		t.Errorf("status text should be used without a reason, got %s", reason)
+	}
+	if reason := denialReason(events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: "not json"}); reason != "BadRequest" {
+		t.Errorf("unexpected reason %s", reason)
+	}
+}
+
+func TestMetricTarget(t *testing.T) {
+	if target := metricTarget(acmpcaIssueCertificate); target != acmpcaIssueCertificate {
+		t.Errorf("known targets should be kept, got %s", target)
+	}
+	for _, target := range []string{"", "Venafi.Bogus", acmListTagsForCertificate} {
+		if got := metricTarget(target); got != "other" {
+			t.Errorf("target %q should be other, got %s", target, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// viewerCountryHeader is set by CloudFront in front of edge-optimized API Gateway endpoints. Clients can send it to
+// regional and private endpoints themselves, so the country check is advisory, see README.
+const viewerCountryHeader = "CloudFront-Viewer-Country"
+
+const awsIPRangesURL = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+
+type ipRange struct {
+	Net    *net.IPNet
+	Region string
+}
+
+// awsIPRanges are published AWS IP ranges. They are downloaded once per container.
+var awsIPRanges struct {
+	sync.Mutex
+	ranges []ipRange
+}
+
+// checkRequestOrigin rejects requests from AWS regions or countries the zone does not allow. The country is only as
+// trustworthy as the viewer country header.
+func checkRequestOrigin(request events.APIGatewayProxyRequest, zoneConfig common.ZoneConfig) error {
+	if len(zoneConfig.AllowedCountries) > 0 {
+		country := request.Headers[viewerCountryHeader]
+		if country == "" {
+			return fmt.Errorf("request country is unknown, the zone allows only requests from %v", zoneConfig.AllowedCountries)
+		}
+		if !containsFold(zoneConfig.AllowedCountries, country) {
+			return fmt.Errorf("requests from country %s are not allowed in the zone", country)
+		}
+	}
+	if len(zoneConfig.AllowedSourceRegions) > 0 {
+		sourceIP := request.RequestContext.Identity.SourceIP
+		region, err := sourceRegion(sourceIP)
+		if err != nil {
+			return fmt.Errorf("can't determine region of source IP %s: %s", sourceIP, err)
+		}
+		if region == "" {
+			return fmt.Errorf("source IP %s is not in AWS, the zone allows only requests from regions %v", sourceIP, zoneConfig.AllowedSourceRegions)
+		}
+		if !containsFold(zoneConfig.AllowedSourceRegions, region) {
+			return fmt.Errorf("requests from region %s are not allowed in the zone", region)
+		}
+	}
+	return nil
+}
+
+// sourceRegion returns the AWS region an IP address belongs to, or empty string if it's not an AWS address.
+// Ranges not bound to a region (GLOBAL) are ignored.
+func sourceRegion(sourceIP string) (string, error) {
+	ip := net.ParseIP(sourceIP)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address")
+	}
+	awsIPRanges.Lock()
+	defer awsIPRanges.Unlock()
+	if awsIPRanges.ranges == nil {
+		ranges, err := downloadAWSIPRanges()
+		if err != nil {
+			return "", err
+		}
+		awsIPRanges.ranges = ranges
+	}
+	for _, r := range awsIPRanges.ranges {
+		if r.Region != "GLOBAL" && r.Net.Contains(ip) {
+			return r.Region, nil
+		}
+	}
+	return "", nil
+}
+
+func downloadAWSIPRanges() ([]ipRange, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(awsIPRangesURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting AWS IP ranges: status %s", resp.Status)
+	}
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+		} `json:"ipv6_prefixes"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make([]ipRange, 0, len(doc.Prefixes)+len(doc.IPv6Prefixes))
+	add := func(prefix, region string) {
+		if _, n, err := net.ParseCIDR(prefix); err == nil {
+			ranges = append(ranges, ipRange{Net: n, Region: region})
+		}
+	}
+	for _, p := range doc.Prefixes {
+		add(p.IPPrefix, p.Region)
+	}
+	for _, p := range doc.IPv6Prefixes {
+		add(p.IPv6Prefix, p.Region)
+	}
+	return ranges, nil
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"net"
+	"testing"
+)
+
+func TestCheckRequestOrigin(t *testing.T) {
+	_, east, _ := net.ParseCIDR("3.80.0.0/12")
+	_, west, _ := net.ParseCIDR("34.208.0.0/12")
+	_, global, _ := net.ParseCIDR("52.94.0.0/16")
+	awsIPRanges.ranges = []ipRange{{east, "us-east-1"}, {west, "us-west-2"}, {global, "GLOBAL"}}
+	defer func() { awsIPRanges.ranges = nil }()
+
+	newRequest := func(ip, country string) events.APIGatewayProxyRequest {
+		r := events.APIGatewayProxyRequest{Headers: map[string]string{}}
+		r.RequestContext.Identity.SourceIP = ip
+		if country != "" {
+			r.Headers[viewerCountryHeader] = country
+		}
+		return r
+	}
+	regions := common.ZoneConfig{AllowedSourceRegions: []string{"us-east-1"}}
+	countries := common.ZoneConfig{AllowedCountries: []string{"US", "CA"}}
+	cases := []struct {
+		config  common.ZoneConfig
+		request events.APIGatewayProxyRequest
+		allowed bool
+	}{
+		{common.ZoneConfig{}, newRequest("8.8.8.8", ""), true},
+		{regions, newRequest("3.85.1.1", ""), true},
+		{regions, newRequest("34.210.1.1", ""), false},
+		{regions, newRequest("52.94.1.1", ""), false},
+		{regions, newRequest("8.8.8.8", ""), false},
+		{regions, newRequest("not-an-ip", ""), false},
+		{countries, newRequest("8.8.8.8", "us"), true},
+		{countries, newRequest("8.8.8.8", "DE"), false},
+		{countries, newRequest("8.8.8.8", ""), false},
+	}
+	for i, c := range cases {
+		err := checkRequestOrigin(c.request, c.config)
+		if (err == nil) != c.allowed {
+			t.Errorf("case %d: allowed = %v, want %v (%v)", i, err == nil, c.allowed, err)
+		}
+	}
+}
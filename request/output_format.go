@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"strings"
+)
+
+// outputFormatHeader selects the format of certificates returned by GetCertificate targets. The OutputFormat field
+// of the request body does the same.
+const outputFormatHeader = "X-Venafi-Output-Format"
+
+// Certificate output formats
+const (
+	outputPEM = "pem"
+	// outputDER is the certificate alone, without the chain.
+	outputDER = "der"
+	// outputPKCS7 is a certs-only PKCS#7 bundle with the certificate and its chain.
+	outputPKCS7 = "pkcs7"
+)
+
+// Content types of binary output. API Gateway has to list them as binary media types.
+const (
+	contentTypeDER   = "application/pkix-cert"
+	contentTypePKCS7 = "application/pkcs7-mime"
+)
+
+type degenerateSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      encapsulatedContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+var emptySet = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+
+func outputFormat(request events.APIGatewayProxyRequest) (string, error) {
+	format := request.Headers[outputFormatHeader]
+	if format == "" {
+		var body struct {
+			OutputFormat string
+		}
+		_ = json.Unmarshal([]byte(request.Body), &body)
+		format = body.OutputFormat
+	}
+	format = strings.ToLower(format)
+	switch format {
+	case "":
+		return outputPEM, nil
+	case outputPEM, outputDER, outputPKCS7:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, supported formats are %s, %s and %s", format, outputPEM, outputDER, outputPKCS7)
+}
+
+// certificateResponse returns the PEM certificate and chain in a binary output format, base64 encoded for API Gateway.
+func certificateResponse(format, certificate, chain string) (events.APIGatewayProxyResponse, error) {
+	certs := pemCertificates(certificate)
+	if len(certs) == 0 {
+		return clientError(http.StatusInternalServerError, "No certificate in response")
+	}
+	var body []byte
+	var contentType string
+	switch format {
+	case outputDER:
+		body, contentType = certs[0], contentTypeDER
+	case outputPKCS7:
+		var err error
+		body, err = certsOnlyPKCS7(append(certs, pemCertificates(chain)...))
+		if err != nil {
+			return clientError(http.StatusInternalServerError, fmt.Sprintf("Can't encode PKCS#7 bundle: %s", err))
+		}
+		contentType = contentTypePKCS7
+	default:
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Unsupported output format %s", format))
+	}
+	return events.APIGatewayProxyResponse{
+		Headers:         map[string]string{"Content-Type": contentType},
+		Body:            base64.StdEncoding.EncodeToString(body),
+		IsBase64Encoded: true,
+		StatusCode:      http.StatusOK,
+	}, nil
+}
+
+// pemCertificates returns DER certificates of PEM blocks in order.
+func pemCertificates(s string) [][]byte {
+	var certs [][]byte
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, block.Bytes)
+		}
+	}
+}
+
+// certsOnlyPKCS7 encodes certificates as degenerate PKCS#7 signed data without content and signers (RFC 2315).
+func certsOnlyPKCS7(certs [][]byte) ([]byte, error) {
+	sd, err := asn1.Marshal(degenerateSignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      encapsulatedContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certs, nil)},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
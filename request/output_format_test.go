@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/aws/aws-lambda-go/events"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testPEMCertificate(t *testing.T, cn string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestOutputFormat(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	if f, err := outputFormat(request); err != nil || f != outputPEM {
+		t.Fatalf("default format should be PEM, got %q: %v", f, err)
+	}
+	request.Body = `{"CertificateArn": "arn", "OutputFormat": "DER"}`
+	if f, err := outputFormat(request); err != nil || f != outputDER {
+		t.Fatalf("expected DER from body, got %q: %v", f, err)
+	}
+	request.Headers = map[string]string{outputFormatHeader: "pkcs7"}
+	if f, err := outputFormat(request); err != nil || f != outputPKCS7 {
+		t.Fatalf("header should take precedence, got %q: %v", f, err)
+	}
+	request.Headers[outputFormatHeader] = "pfx"
+	if _, err := outputFormat(request); err == nil {
+		t.Fatal("unknown format should fail")
+	}
+}
+
+func TestCertificateResponse(t *testing.T) {
+	leaf := testPEMCertificate(t, "leaf.example.com")
+	chain := testPEMCertificate(t, "Intermediate CA") + testPEMCertificate(t, "Root CA")
+
+	resp, _ := certificateResponse(outputDER, leaf, chain)
+	der, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil || !resp.IsBase64Encoded || resp.Headers["Content-Type"] != contentTypeDER {
+		t.Fatalf("unexpected DER response %+v: %v", resp, err)
+	}
+	if cert, err := x509.ParseCertificate(der); err != nil || cert.Subject.CommonName != "leaf.example.com" {
+		t.Fatalf("DER output is not the certificate: %v", err)
+	}
+
+	resp, _ = certificateResponse(outputPKCS7, leaf, chain)
+	p7, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil || resp.Headers["Content-Type"] != contentTypePKCS7 {
+		t.Fatalf("unexpected PKCS#7 response %+v: %v", resp, err)
+	}
+	var ci contentInfo
+	if _, err = asn1.Unmarshal(p7, &ci); err != nil || !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("invalid PKCS#7 content info: %v", err)
+	}
+	var sd degenerateSignedData
+	if _, err = asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil || len(certs) != 3 || !bytes.Equal(certs[0].Raw, der) {
+		t.Fatalf("PKCS#7 bundle should have the certificate and its chain, got %d certificates: %v", len(certs), err)
+	}
+}
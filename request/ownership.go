@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifyDomainOwnership checks that every requested name falls under a Route 53 hosted zone of the allow-listed
+// accounts, which proves the requester's organization controls DNS for the name.
+func verifyDomainOwnership(ctx context.Context, zoneConfig common.ZoneConfig, req *certificate.Request) error {
+	if !zoneConfig.VerifyOwnership {
+		return nil
+	}
+	names := requestedNames(req)
+	if len(names) == 0 {
+		return nil
+	}
+	zones, err := listHostedZoneNames(ctx, zoneConfig.OwnershipRoleArns)
+	if err != nil {
+		return fmt.Errorf("can't verify domain ownership: %s", err)
+	}
+	var unowned []string
+	for _, name := range names {
+		if !nameInZones(name, zones) {
+			unowned = append(unowned, name)
+		}
+	}
+	if len(unowned) > 0 {
+		return fmt.Errorf("names %v are not under a Route 53 hosted zone of an allowed account", unowned)
+	}
+	return nil
+}
+
+// hostedZoneCacheTTL is how long hosted zone names of an account are kept in memory of the Lambda container, so
+// requests don't list them each time and get throttled by Route 53. New hosted zones are seen after it.
+const hostedZoneCacheTTL = 5 * time.Minute
+
+type cachedHostedZones struct {
+	names   []string
+	expires time.Time
+}
+
+var (
+	hostedZoneCacheMu sync.Mutex
+	hostedZoneCache   = map[string]cachedHostedZones{}
+)
+
+// listHostedZoneNames returns names of public hosted zones visible to the roles. The Lambda's own account is used when
+// no roles are set.
+func listHostedZoneNames(ctx context.Context, roleArns []string) ([]string, error) {
+	if len(roleArns) == 0 {
+		roleArns = []string{""}
+	}
+	var zones []string
+	for _, roleArn := range roleArns {
+		names, err := hostedZoneNames(ctx, roleArn, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, names...)
+	}
+	return zones, nil
+}
+
+// hostedZoneNames returns names of the public hosted zones visible to the role, from the cache or ListHostedZones.
+// Private hosted zones are left out, they don't prove control of the public DNS name.
+func hostedZoneNames(ctx context.Context, roleArn string, now time.Time) ([]string, error) {
+	hostedZoneCacheMu.Lock()
+	c, ok := hostedZoneCache[roleArn]
+	hostedZoneCacheMu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.names, nil
+	}
+	cfg, err := loadAWSConfig("", roleArn)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	p := route53.NewListHostedZonesPaginator(route53.New(cfg).ListHostedZonesRequest(&route53.ListHostedZonesInput{}))
+	for p.Next(ctx) {
+		names = append(names, publicHostedZoneNames(p.CurrentPage().HostedZones)...)
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	hostedZoneCacheMu.Lock()
+	hostedZoneCache[roleArn] = cachedHostedZones{names: names, expires: now.Add(hostedZoneCacheTTL)}
+	hostedZoneCacheMu.Unlock()
+	return names, nil
+}
+
+func publicHostedZoneNames(zones []route53.HostedZone) []string {
+	var names []string
+	for _, z := range zones {
+		if z.Config != nil && aws.BoolValue(z.Config.PrivateZone) {
+			continue
+		}
+		names = append(names, canonicalDNSName(aws.StringValue(z.Name)))
+	}
+	return names
+}
+
+// nameInZones reports whether a DNS name, or the domain of a wildcard name, equals or is a subdomain of a zone.
+func nameInZones(name string, zones []string) bool {
+	name = strings.TrimPrefix(canonicalDNSName(name), "*.")
+	for _, zone := range zones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
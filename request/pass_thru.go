@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/acm"
 	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
 	"net/http"
+	"time"
 )
 
 const (
@@ -21,7 +24,10 @@ const (
 	acmpcaGetCertificate                     = "ACMPrivateCAGetCertificate"
 	acmpcaListCertificateAuthorities         = "ACMPrivateCAListCertificateAuthorities"
 	acmpcaGetCertificateAuthorityCertificate = "ACMPrivateCAGetCertificateAuthorityCertificate"
+	acmpcaGetCertificateAuthorityCsr         = "ACMPrivateCAGetCertificateAuthorityCsr"
 	acmpcaRevokeCertificate                  = "ACMPrivateCARevokeCertificate"
+	acmpcaCreateCertificateAuthority         = "ACMPrivateCACreateCertificateAuthority"
+	acmpcaUpdateCertificateAuthority         = "ACMPrivateCAUpdateCertificateAuthority"
 )
 
 const (
@@ -34,108 +40,146 @@ func passThru(request events.APIGatewayProxyRequest, ctx context.Context, target
 	var respoBodyJSON []byte
 	var err error
 
-	awsCfg, err := external.LoadDefaultAWSConfig()
+	region, err := targetRegion(request, common.ZoneConfig{})
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	awsCfg, err := loadAWSConfig(region, "")
 	if err != nil {
 		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
 	}
 	acmpcaCli := acmpca.New(awsCfg)
 	acmCli := acm.New(awsCfg)
-
-	switch target {
-	case acmDescribeCertificate:
-		var req = &acm.DescribeCertificateInput{}
-		err = json.Unmarshal([]byte(request.Body), req)
+	format := outputPEM
+	if target == acmGetCertificate || target == acmpcaGetCertificate {
+		format, err = outputFormat(request)
 		if err != nil {
-			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
+			return clientError(http.StatusBadRequest, err.Error())
 		}
+	}
 
-		doRequest := acmCli.DescribeCertificateRequest(req)
-		var doRequestResponse *acm.DescribeCertificateResponse
-		doRequestResponse, err = doRequest.Send(ctx)
-		if err != nil {
-			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
-		}
-		respoBodyJSON, err = json.Marshal(doRequestResponse)
+	switch target {
+	case acmDescribeCertificate:
+		return describeACMCertificate(ctx, acmCli, request)
 	case acmExportCertificate:
-		var req = &acm.ExportCertificateInput{}
+		return exportACMCertificate(ctx, acmCli, request)
+	case acmGetCertificate:
+		return getACMCertificate(ctx, acmCli, request, format)
+	case acmListCertificates:
+		return listACMCertificates(ctx, acmCli, request)
+	case acmRenewCertificate:
+		return renewACMCertificate(ctx, acmCli, request)
+	case acmAddTagsToCertificate, acmRemoveTagsFromCertificate, acmListTagsForCertificate:
+		return tagsPassThru(ctx, acmCli, request, target)
+
+	case acmpcaGetCertificateAuthorityCertificate:
+		var req = &acmpca.GetCertificateAuthorityCertificateInput{}
 		err = json.Unmarshal([]byte(request.Body), req)
 		if err != nil {
 			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
 		}
 
-		doRequest := acmCli.ExportCertificateRequest(req)
-		var doRequestResponse *acm.ExportCertificateResponse
+		doRequest := acmpcaCli.GetCertificateAuthorityCertificateRequest(req)
+		var doRequestResponse *acmpca.GetCertificateAuthorityCertificateResponse
 		doRequestResponse, err = doRequest.Send(ctx)
 		if err != nil {
 			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
 		}
 		respoBodyJSON, err = json.Marshal(doRequestResponse)
-	case acmGetCertificate:
-		var req = &acm.GetCertificateInput{}
+	case acmpcaGetCertificateAuthorityCsr:
+		var req = &acmpca.GetCertificateAuthorityCsrInput{}
 		err = json.Unmarshal([]byte(request.Body), req)
 		if err != nil {
 			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
 		}
 
-		doRequest := acmCli.GetCertificateRequest(req)
-		var doRequestResponse *acm.GetCertificateResponse
+		doRequest := acmpcaCli.GetCertificateAuthorityCsrRequest(req)
+		var doRequestResponse *acmpca.GetCertificateAuthorityCsrResponse
 		doRequestResponse, err = doRequest.Send(ctx)
 		if err != nil {
 			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
 		}
 		respoBodyJSON, err = json.Marshal(doRequestResponse)
-	case acmListCertificates:
-		var req = &acm.ListCertificatesInput{}
+	case acmpcaRevokeCertificate:
+		var req = &acmpca.RevokeCertificateInput{}
 		err = json.Unmarshal([]byte(request.Body), req)
 		if err != nil {
 			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
 		}
 
-		doRequest := acmCli.ListCertificatesRequest(req)
-		var doRequestResponse *acm.ListCertificatesResponse
-		doRequestResponse, err = doRequest.Send(ctx)
+		ca, err := resolveCA(ctx, awsCfg, aws.StringValue(req.CertificateAuthorityArn))
 		if err != nil {
-			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
+			return clientError(http.StatusBadRequest, err.Error())
 		}
-		respoBodyJSON, err = json.Marshal(doRequestResponse)
-	case acmRenewCertificate:
-		var req = &acm.RenewCertificateInput{}
-		err = json.Unmarshal([]byte(request.Body), req)
-		if err != nil {
-			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
+		if ca.Shared {
+			return clientError(http.StatusForbidden, sharedCAPassThruError(target, ca).Error())
 		}
 
-		doRequest := acmCli.RenewCertificateRequest(req)
-		var doRequestResponse *acm.RenewCertificateResponse
+		identity := request.RequestContext.Identity
+		doRequest := acmpcaCli.RevokeCertificateRequest(req)
+		var doRequestResponse *acmpca.RevokeCertificateResponse
 		doRequestResponse, err = doRequest.Send(ctx)
 		if err != nil {
+			log.Printf("AUDIT: %s failed to revoke certificate %s: %s", identity.UserArn, revokedCertificateArn(req), err)
 			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
 		}
+		log.Printf("AUDIT: %s revoked certificate %s with reason %s", identity.UserArn, revokedCertificateArn(req), req.RevocationReason)
+		markInventoryRevoked(revokedCertificateArn(req))
+		queueVenafiRevocation(ctx, acmpcaCli, req, identity.UserArn, identity.AccountID, time.Now())
 		respoBodyJSON, err = json.Marshal(doRequestResponse)
 
-	case acmpcaGetCertificateAuthorityCertificate:
-		var req = &acmpca.GetCertificateAuthorityCertificateInput{}
+	case acmpcaCreateCertificateAuthority:
+		var req = &acmpca.CreateCertificateAuthorityInput{}
 		err = json.Unmarshal([]byte(request.Body), req)
 		if err != nil {
 			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
 		}
-
-		doRequest := acmpcaCli.GetCertificateAuthorityCertificateRequest(req)
-		var doRequestResponse *acmpca.GetCertificateAuthorityCertificateResponse
+		err = caRevocationRequirements.validate(req.RevocationConfiguration)
+		if err != nil {
+			return clientError(http.StatusForbidden, err.Error())
+		}
+		if caPolicyZone != "" {
+			policy, err := getPolicy(caPolicyZone)
+			if err == common.PolicyNotFound {
+				return handlePolicyNotFound(caPolicyZone)
+			} else if err != nil {
+				common.Monitor.Record(common.BackendDynamoDB, err)
+				return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
+			}
+			bypass, err := useBreakGlassToken(request, caPolicyZone)
+			if err != nil {
+				return clientError(http.StatusForbidden, err.Error())
+			}
+			err = bypass.apply(rulePolicy, validateCAConfiguration(policy, req.CertificateAuthorityConfiguration))
+			if err != nil {
+				log.Println(err)
+				return clientError(http.StatusForbidden, err.Error())
+			}
+		}
+
+		doRequest := acmpcaCli.CreateCertificateAuthorityRequest(req)
+		var doRequestResponse *acmpca.CreateCertificateAuthorityResponse
 		doRequestResponse, err = doRequest.Send(ctx)
 		if err != nil {
 			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
 		}
 		respoBodyJSON, err = json.Marshal(doRequestResponse)
-	case acmpcaRevokeCertificate:
-		var req = &acmpca.RevokeCertificateInput{}
+	case acmpcaUpdateCertificateAuthority:
+		var req = &acmpca.UpdateCertificateAuthorityInput{}
 		err = json.Unmarshal([]byte(request.Body), req)
 		if err != nil {
 			return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
 		}
+		// Update may only change the CA status, revocation configuration is kept then.
+		if req.RevocationConfiguration != nil {
+			err = caRevocationRequirements.validate(req.RevocationConfiguration)
+			if err != nil {
+				return clientError(http.StatusForbidden, err.Error())
+			}
+		}
 
-		doRequest := acmpcaCli.RevokeCertificateRequest(req)
-		var doRequestResponse *acmpca.RevokeCertificateResponse
+		doRequest := acmpcaCli.UpdateCertificateAuthorityRequest(req)
+		var doRequestResponse *acmpca.UpdateCertificateAuthorityResponse
 		doRequestResponse, err = doRequest.Send(ctx)
 		if err != nil {
 			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
@@ -155,6 +199,10 @@ func passThru(request events.APIGatewayProxyRequest, ctx context.Context, target
 		if err != nil {
 			return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, target, err))
 		}
+		recordThumbprint(aws.StringValue(req.CertificateArn), aws.StringValue(doRequestResponse.Certificate))
+		if format != outputPEM {
+			return certificateResponse(format, aws.StringValue(doRequestResponse.Certificate), aws.StringValue(doRequestResponse.CertificateChain))
+		}
 		respoBodyJSON, err = json.Marshal(doRequestResponse)
 	case acmpcaListCertificateAuthorities:
 		var req = &acmpca.ListCertificateAuthoritiesInput{}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+)
+
+const oidCertificatePolicies = "2.5.29.32"
+
+var oidCPSQualifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+
+const defaultPassthroughTemplate = "template/EndEntityCertificate_APIPassthrough/V1"
+
+// passthrough holds IssueCertificate fields the SDK doesn't support yet. They are added to the request body.
+type passthrough struct {
+	TemplateArn    string
+	ApiPassthrough apiPassthrough
+}
+
+// apiPassthrough is the ApiPassthrough of IssueCertificate. The subject and extensions override those of the CSR.
+type apiPassthrough struct {
+	Subject    *acmpca.ASN1Subject `json:",omitempty"`
+	Extensions passthroughExtensions
+}
+
+type passthroughExtensions struct {
+	CertificatePolicies     []passthroughPolicy           `json:",omitempty"`
+	CustomExtensions        []passthroughCustomExtension  `json:",omitempty"`
+	SubjectAlternativeNames []passthroughGeneralName      `json:",omitempty"`
+	ExtendedKeyUsage        []passthroughExtendedKeyUsage `json:",omitempty"`
+}
+
+// passthroughExtendedKeyUsage is an extended key usage of ApiPassthrough, given by type or OID.
+type passthroughExtendedKeyUsage struct {
+	ExtendedKeyUsageType             string `json:",omitempty"`
+	ExtendedKeyUsageObjectIdentifier string `json:",omitempty"`
+}
+
+// passthroughGeneralName is a subject alternative name of ApiPassthrough. Only names which can be validated against
+// the policy are accepted, the raw fields catch the others.
+type passthroughGeneralName struct {
+	DnsName                   *string         `json:",omitempty"`
+	IpAddress                 *string         `json:",omitempty"`
+	Rfc822Name                *string         `json:",omitempty"`
+	UniformResourceIdentifier *string         `json:",omitempty"`
+	DirectoryName             json.RawMessage `json:",omitempty"`
+	EdiPartyName              json.RawMessage `json:",omitempty"`
+	OtherName                 json.RawMessage `json:",omitempty"`
+	RegisteredId              json.RawMessage `json:",omitempty"`
+}
+
+type passthroughPolicy struct {
+	CertPolicyId     string
+	PolicyQualifiers []passthroughPolicyQualifier `json:",omitempty"`
+}
+
+type passthroughPolicyQualifier struct {
+	PolicyQualifierId string
+	Qualifier         struct {
+		CpsUri string
+	}
+}
+
+type passthroughCustomExtension struct {
+	ObjectIdentifier string
+	// Value is the base64 encoded DER value of the extension.
+	Value    string
+	Critical bool `json:",omitempty"`
+}
+
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	Qualifiers       []policyQualifierInfo `asn1:"optional"`
+}
+
+type policyQualifierInfo struct {
+	PolicyQualifierId asn1.ObjectIdentifier
+	Qualifier         asn1.RawValue
+}
+
+// csrPassthrough returns the extensions of the CSR which the zone passes through to the certificate, or nil if there
+// are none. It must be called after the CSR is validated.
+func csrPassthrough(csrPEM []byte, zoneConfig common.ZoneConfig, caArn string) (*passthrough, error) {
+	if len(zoneConfig.PassthroughExtensions) == 0 {
+		return nil, nil
+	}
+	pemBlock, _ := pem.Decode(csrPEM)
+	if pemBlock == nil {
+		return nil, fmt.Errorf("CSR is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var ext passthroughExtensions
+	for _, e := range csr.Extensions {
+		oid := e.Id.String()
+		if !stringInSlice(oid, zoneConfig.PassthroughExtensions) {
+			continue
+		}
+		if oid == oidCertificatePolicies {
+			ext.CertificatePolicies, err = passthroughPolicies(e.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate policies extension: %s", err)
+			}
+			continue
+		}
+		ext.CustomExtensions = append(ext.CustomExtensions, passthroughCustomExtension{
+			ObjectIdentifier: oid,
+			Value:            base64.StdEncoding.EncodeToString(e.Value),
+			Critical:         e.Critical,
+		})
+	}
+	if len(ext.CertificatePolicies) == 0 && len(ext.CustomExtensions) == 0 {
+		return nil, nil
+	}
+	return &passthrough{TemplateArn: passthroughTemplate(zoneConfig, caArn), ApiPassthrough: apiPassthrough{Extensions: ext}}, nil
+}
+
+// passthroughTemplate returns the template which lets ApiPassthrough values into certificates of the zone.
+func passthroughTemplate(zoneConfig common.ZoneConfig, caArn string) string {
+	if zoneConfig.PassthroughTemplateArn != "" {
+		return zoneConfig.PassthroughTemplateArn
+	}
+	partition := "aws"
+	if a, err := arn.Parse(caArn); err == nil {
+		partition = a.Partition
+	}
+	return arn.ARN{Partition: partition, Service: "acm-pca", Resource: defaultPassthroughTemplate}.String()
+}
+
+// applyApiPassthrough applies the ApiPassthrough of a request to the certificate request, so the policy is validated
+// against the subject and names ACM PCA puts into the certificate instead of those of the CSR. Extensions are
+// accepted only if the zone passes them through from CSRs too.
+func applyApiPassthrough(req *certificate.Request, ap *apiPassthrough, zoneConfig common.ZoneConfig) error {
+	if ap == nil {
+		return nil
+	}
+	if len(ap.Extensions.CertificatePolicies) > 0 && !stringInSlice(oidCertificatePolicies, zoneConfig.PassthroughExtensions) {
+		return fmt.Errorf("extension %s is not allowed in ApiPassthrough", oidCertificatePolicies)
+	}
+	for _, e := range ap.Extensions.CustomExtensions {
+		if !stringInSlice(e.ObjectIdentifier, zoneConfig.PassthroughExtensions) {
+			return fmt.Errorf("extension %s is not allowed in ApiPassthrough", e.ObjectIdentifier)
+		}
+	}
+	if ap.Subject != nil {
+		req.Subject = normalizeSubject(asn1SubjectName(ap.Subject))
+	}
+	if len(ap.Extensions.SubjectAlternativeNames) == 0 {
+		return nil
+	}
+	var dnsNames, emails []string
+	var ips []net.IP
+	var uris []*url.URL
+	for _, n := range ap.Extensions.SubjectAlternativeNames {
+		switch {
+		case n.DnsName != nil:
+			dnsNames = append(dnsNames, *n.DnsName)
+		case n.IpAddress != nil:
+			ip := net.ParseIP(*n.IpAddress)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address %q in ApiPassthrough", *n.IpAddress)
+			}
+			ips = append(ips, ip)
+		case n.Rfc822Name != nil:
+			emails = append(emails, *n.Rfc822Name)
+		case n.UniformResourceIdentifier != nil:
+			uri, err := url.Parse(*n.UniformResourceIdentifier)
+			if err != nil {
+				return fmt.Errorf("invalid URI %q in ApiPassthrough: %s", *n.UniformResourceIdentifier, err)
+			}
+			uris = append(uris, uri)
+		default:
+			return fmt.Errorf("only DNS name, IP address, RFC 822 name and URI subject alternative names are supported in ApiPassthrough")
+		}
+	}
+	// Subject alternative names of ApiPassthrough replace those of the CSR.
+	req.DNSNames = canonicalDNSNames(dnsNames)
+	req.EmailAddresses = canonicalEmails(emails)
+	req.IPAddresses = uniqueIPs(ips)
+	req.URIs = uniqueURIs(uris)
+	return nil
+}
+
+// withApiPassthrough adds the ApiPassthrough of a request to the pass-through fields of the CSR. Values of the request
+// override the CSR ones like in ACM PCA.
+func withApiPassthrough(pt *passthrough, ap *apiPassthrough, zoneConfig common.ZoneConfig, caArn string) *passthrough {
+	if ap == nil {
+		return pt
+	}
+	merged := passthrough{TemplateArn: passthroughTemplate(zoneConfig, caArn), ApiPassthrough: *ap}
+	if pt == nil {
+		return &merged
+	}
+	ext := &merged.ApiPassthrough.Extensions
+	if len(ext.CertificatePolicies) == 0 {
+		ext.CertificatePolicies = pt.ApiPassthrough.Extensions.CertificatePolicies
+	}
+	custom := make([]passthroughCustomExtension, 0, len(ext.CustomExtensions)+len(pt.ApiPassthrough.Extensions.CustomExtensions))
+	custom = append(custom, ext.CustomExtensions...)
+	for _, e := range pt.ApiPassthrough.Extensions.CustomExtensions {
+		overridden := false
+		for _, o := range ext.CustomExtensions {
+			overridden = overridden || o.ObjectIdentifier == e.ObjectIdentifier
+		}
+		if !overridden {
+			custom = append(custom, e)
+		}
+	}
+	ext.CustomExtensions = custom
+	return &merged
+}
+
+// passthroughPolicies converts certificate policies to the pass-through form. ACM PCA supports only CPS qualifiers,
+// other qualifiers are dropped.
+func passthroughPolicies(value []byte) ([]passthroughPolicy, error) {
+	var policies []policyInformation
+	_, err := asn1.Unmarshal(value, &policies)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]passthroughPolicy, 0, len(policies))
+	for _, p := range policies {
+		policy := passthroughPolicy{CertPolicyId: p.PolicyIdentifier.String()}
+		for _, q := range p.Qualifiers {
+			var uri string
+			if !q.PolicyQualifierId.Equal(oidCPSQualifier) {
+				log.Printf("Dropping unsupported qualifier %s of certificate policy %s", q.PolicyQualifierId, policy.CertPolicyId)
+				continue
+			}
+			_, err = asn1.Unmarshal(q.Qualifier.FullBytes, &uri)
+			if err != nil {
+				return nil, err
+			}
+			var pq passthroughPolicyQualifier
+			pq.PolicyQualifierId = "CPS"
+			pq.Qualifier.CpsUri = uri
+			policy.PolicyQualifiers = append(policy.PolicyQualifiers, pq)
+		}
+		result = append(result, policy)
+	}
+	return result, nil
+}
+
+// addTo adds the pass-through fields to the body of the request after the SDK builds it.
+func (p *passthrough) addTo(r *aws.Request) {
+	if p == nil {
+		return
+	}
+	r.Handlers.Build.PushBack(func(r *aws.Request) {
+		if r.Error != nil {
+			return
+		}
+		r.Error = p.addToBody(r)
+	})
+}
+
+func (p *passthrough) addToBody(r *aws.Request) error {
+	body, err := ioutil.ReadAll(r.GetBody())
+	if err != nil {
+		return err
+	}
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(body, &fields)
+	if err != nil {
+		return err
+	}
+	if fields["TemplateArn"], err = json.Marshal(p.TemplateArn); err != nil {
+		return err
+	}
+	if fields["ApiPassthrough"], err = json.Marshal(p.ApiPassthrough); err != nil {
+		return err
+	}
+	body, err = json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	r.SetBufferBody(body)
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"io/ioutil"
+	"testing"
+)
+
+func testPassthroughCSR(t *testing.T) []byte {
+	policies, err := asn1.Marshal([]policyInformation{{
+		PolicyIdentifier: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1},
+		Qualifiers: []policyQualifierInfo{{
+			PolicyQualifierId: oidCPSQualifier,
+			Qualifier:         asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte("https://pki.example.com/cps")},
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "device.example.com"},
+		DNSNames: []string{"device.example.com"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{2, 5, 29, 32}, Value: policies},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}, Value: []byte{0x0c, 0x02, 'o', 'k'}},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 3}, Value: []byte{0x05, 0x00}},
+		},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
+
+func TestCSRPassthrough(t *testing.T) {
+	csr := testPassthroughCSR(t)
+	caArn := "arn:aws-us-gov:acm-pca:us-gov-west-1:123456789012:certificate-authority/a"
+	pt, err := csrPassthrough(csr, common.ZoneConfig{}, caArn)
+	if err != nil || pt != nil {
+		t.Fatalf("zone without pass-through extensions should pass nothing, got %+v: %v", pt, err)
+	}
+
+	zoneConfig := common.ZoneConfig{PassthroughExtensions: []string{"2.5.29.32", "1.3.6.1.4.1.99999.2"}}
+	pt, err = csrPassthrough(csr, zoneConfig, caArn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt.TemplateArn != "arn:aws-us-gov:acm-pca:::template/EndEntityCertificate_APIPassthrough/V1" {
+		t.Errorf("unexpected template %s", pt.TemplateArn)
+	}
+	policies := pt.ApiPassthrough.Extensions.CertificatePolicies
+	if len(policies) != 1 || policies[0].CertPolicyId != "1.3.6.1.4.1.99999.1" ||
+		len(policies[0].PolicyQualifiers) != 1 || policies[0].PolicyQualifiers[0].Qualifier.CpsUri != "https://pki.example.com/cps" {
+		t.Errorf("unexpected policies %+v", policies)
+	}
+	custom := pt.ApiPassthrough.Extensions.CustomExtensions
+	if len(custom) != 1 || custom[0].ObjectIdentifier != "1.3.6.1.4.1.99999.2" || custom[0].Value != "DAJvaw==" {
+		t.Errorf("unexpected custom extensions %+v", custom)
+	}
+}
+
+func TestPassthroughAddTo(t *testing.T) {
+	cfg := defaults.Config()
+	cfg.Region = "us-east-1"
+	cfg.Credentials = aws.AnonymousCredentials
+	req := acmpca.New(cfg).IssueCertificateRequest(&acmpca.IssueCertificateInput{
+		CertificateAuthorityArn: aws.String("arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/a"),
+		Csr:                     []byte("csr"),
+		SigningAlgorithm:        acmpca.SigningAlgorithmSha256withecdsa,
+		Validity:                &acmpca.Validity{Type: acmpca.ValidityPeriodTypeEndDate, Value: aws.Int64(20301231235959)},
+	})
+	pt := &passthrough{TemplateArn: "arn:aws:acm-pca:::template/EndEntityCertificate_APIPassthrough/V1"}
+	pt.ApiPassthrough.Extensions.CustomExtensions = []passthroughCustomExtension{{ObjectIdentifier: "1.3.6.1.4.1.99999.2", Value: "DAJvaw=="}}
+	pt.addTo(req.Request)
+	err := req.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(req.GetBody())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		CertificateAuthorityArn string
+		TemplateArn             string
+		ApiPassthrough          apiPassthrough
+		Validity                struct{ Value int64 }
+	}
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.CertificateAuthorityArn == "" || body.TemplateArn != pt.TemplateArn || len(body.ApiPassthrough.Extensions.CustomExtensions) != 1 {
+		t.Fatalf("pass-through fields missing in body %s", b)
+	}
+	if body.Validity.Value != 20301231235959 {
+		t.Fatalf("validity changed in body %s", b)
+	}
+}
+
+func TestApplyApiPassthrough(t *testing.T) {
+	req, err := newCSRRequest(createCSR("csr.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ap apiPassthrough
+	err = json.Unmarshal([]byte(`{
+		"Subject": {"CommonName": "API.example.com", "Organization": "Example"},
+		"Extensions": {"SubjectAlternativeNames": [
+			{"DnsName": "API.example.com"}, {"DnsName": "other.example.com"},
+			{"IpAddress": "10.0.0.1"}, {"Rfc822Name": "admin@example.com"}, {"UniformResourceIdentifier": "spiffe://example.com/api"}
+		]}}`), &ap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applyApiPassthrough(&req, &ap, common.ZoneConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Subject.CommonName != "api.example.com" || len(req.Subject.Organization) != 1 {
+		t.Errorf("subject should be replaced, got %s", req.Subject)
+	}
+	if len(req.DNSNames) != 2 || req.DNSNames[0] != "api.example.com" || req.DNSNames[1] != "other.example.com" {
+		t.Errorf("DNS names should be replaced, got %v", req.DNSNames)
+	}
+	if len(req.IPAddresses) != 1 || len(req.EmailAddresses) != 1 || len(req.URIs) != 1 {
+		t.Errorf("unexpected names %v %v %v", req.IPAddresses, req.EmailAddresses, req.URIs)
+	}
+
+	ap = apiPassthrough{}
+	err = json.Unmarshal([]byte(`{"Extensions": {"SubjectAlternativeNames": [{"DirectoryName": {"CommonName": "x"}}]}}`), &ap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applyApiPassthrough(&req, &ap, common.ZoneConfig{}) == nil {
+		t.Error("directory names can't be validated and should be rejected")
+	}
+	ap = apiPassthrough{Extensions: passthroughExtensions{CustomExtensions: []passthroughCustomExtension{{ObjectIdentifier: "1.3.6.1.4.1.99999.2", Value: "DAJvaw=="}}}}
+	if applyApiPassthrough(&req, &ap, common.ZoneConfig{}) == nil {
+		t.Error("extension not passed through by the zone should be rejected")
+	}
+	if err = applyApiPassthrough(&req, &ap, common.ZoneConfig{PassthroughExtensions: []string{"1.3.6.1.4.1.99999.2"}}); err != nil {
+		t.Errorf("extension passed through by the zone should be allowed: %s", err)
+	}
+}
+
+func TestWithApiPassthrough(t *testing.T) {
+	caArn := "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/a"
+	zoneConfig := common.ZoneConfig{PassthroughExtensions: []string{"2.5.29.32", "1.3.6.1.4.1.99999.2"}}
+	if withApiPassthrough(nil, nil, zoneConfig, caArn) != nil {
+		t.Error("request without ApiPassthrough should pass nothing")
+	}
+	pt, err := csrPassthrough(testPassthroughCSR(t), zoneConfig, caArn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := &apiPassthrough{
+		Subject: &acmpca.ASN1Subject{CommonName: aws.String("api.example.com")},
+		Extensions: passthroughExtensions{
+			CustomExtensions:        []passthroughCustomExtension{{ObjectIdentifier: "1.3.6.1.4.1.99999.2", Value: "DAJubw=="}},
+			SubjectAlternativeNames: []passthroughGeneralName{{DnsName: aws.String("api.example.com")}},
+		},
+	}
+	merged := withApiPassthrough(pt, ap, zoneConfig, caArn)
+	if merged.TemplateArn != "arn:aws:acm-pca:::template/EndEntityCertificate_APIPassthrough/V1" {
+		t.Errorf("unexpected template %s", merged.TemplateArn)
+	}
+	ext := merged.ApiPassthrough.Extensions
+	if aws.StringValue(merged.ApiPassthrough.Subject.CommonName) != "api.example.com" || len(ext.SubjectAlternativeNames) != 1 {
+		t.Errorf("subject and names of the request should be passed, got %+v", merged.ApiPassthrough)
+	}
+	if len(ext.CertificatePolicies) != 1 {
+		t.Errorf("certificate policies of the CSR should be kept, got %+v", ext.CertificatePolicies)
+	}
+	if len(ext.CustomExtensions) != 1 || ext.CustomExtensions[0].Value != "DAJubw==" {
+		t.Errorf("extension of the request should override the CSR one, got %+v", ext.CustomExtensions)
+	}
+}
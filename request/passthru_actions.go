@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// passThruServices are services of actions which can be forwarded verbatim, by the prefix of their AWS target.
+var passThruServices = map[string]string{
+	"ACMPrivateCA":       "acm-pca",
+	"CertificateManager": "acm",
+}
+
+// passThruActions are AWS targets without a handler in the proxy which are forwarded verbatim, e.g. read-only
+// actions added to ACM after the proxy was deployed. They are keyed by the target without the period, as clients of
+// the proxy send it.
+var passThruActions map[string]string
+
+func loadPassThruActions() {
+	passThruActions = map[string]string{}
+	for _, action := range common.SplitList(os.Getenv("PASSTHRU_ACTIONS")) {
+		i := strings.Index(action, ".")
+		if i < 0 || passThruServices[action[:i]] == "" {
+			log.Printf("Ignoring pass-through action %q, only ACMPrivateCA and CertificateManager actions can be passed through", action)
+			continue
+		}
+		passThruActions[action[:i]+action[i+1:]] = action
+	}
+}
+
+// passThruAction returns the AWS target of an allowed pass-through action. The target is accepted with or without
+// the period.
+func passThruAction(target string) (string, bool) {
+	action, ok := passThruActions[strings.Replace(target, ".", "", 1)]
+	return action, ok
+}
+
+// actionPassThru forwards the request body to the service of the action and returns the service response as is.
+func actionPassThru(ctx context.Context, request events.APIGatewayProxyRequest, action string) (events.APIGatewayProxyResponse, error) {
+	region, err := targetRegion(request, common.ZoneConfig{})
+	if err != nil {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	awsCfg, err := loadAWSConfig(region, "")
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
+	}
+	body := request.Body
+	if strings.TrimSpace(body) == "" {
+		body = "{}"
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-amz-json-1.1")
+	header.Set("X-Amz-Target", action)
+	service := passThruServices[action[:strings.Index(action, ".")]]
+	log.Printf("Passing %s through to %s for %s", action, service, request.RequestContext.Identity.UserArn)
+	status, respBody, err := sendSignedRequest(ctx, awsCfg, service, http.MethodPost, "/", nil, header, []byte(body))
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, action, err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(respBody),
+		StatusCode: status,
+	}, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPassThruActions(t *testing.T) {
+	os.Setenv("PASSTHRU_ACTIONS", "ACMPrivateCA.ListTags, CertificateManager.GetAccountConfiguration,KMS.Decrypt,ListTags")
+	defer func() {
+		os.Unsetenv("PASSTHRU_ACTIONS")
+		loadPassThruActions()
+	}()
+	loadPassThruActions()
+	if len(passThruActions) != 2 {
+		t.Errorf("only ACM and ACM PCA actions should be allowed: %v", passThruActions)
+	}
+	for _, target := range []string{"ACMPrivateCA.ListTags", "ACMPrivateCAListTags"} {
+		if action, ok := passThruAction(target); !ok || action != "ACMPrivateCA.ListTags" {
+			t.Errorf("target %s should be passed through as ACMPrivateCA.ListTags, got %q", target, action)
+		}
+	}
+	if _, ok := passThruAction("CertificateManagerDeleteCertificate"); ok {
+		t.Error("actions not in the list should not be passed through")
+	}
+}
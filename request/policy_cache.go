@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// policyCacheBypassHeader makes requests of policy admins read policies from the database, e.g. to check a policy right
+// after a sync or rollback. The caches are left as they are, so other requests keep being served from them.
+const policyCacheBypassHeader = "X-Venafi-No-Cache"
+
+// policyCacheTTL is how long policies read from the database are kept in memory of the Lambda container, so hot zones
+// don't hit DynamoDB on every request. Zero disables the cache.
+var policyCacheTTL time.Duration
+
+type cachedPolicy struct {
+	policy  endpoint.Policy
+	expires time.Time
+}
+
+var (
+	policyCacheMu sync.Mutex
+	policyCache   = map[string]cachedPolicy{}
+)
+
+// bypassPolicyCache reads policies of the current request from the database instead of the caches.
+var bypassPolicyCache bool
+
+// policyCacheBypassed reports whether the request bypasses the caches. Only policy admins may, so other callers can't
+// send every request to the database.
+func policyCacheBypassed(request events.APIGatewayProxyRequest) bool {
+	return request.Headers[policyCacheBypassHeader] == "true" && principalAllowed(request.RequestContext.Identity.UserArn, policyAdmins)
+}
+
+func loadPolicyCacheTTL() {
+	policyCacheTTL = 0
+	if s := os.Getenv("POLICY_CACHE_TTL"); s != "" {
+		ttl, err := time.ParseDuration(s)
+		if err != nil || ttl < 0 {
+			log.Printf("Invalid POLICY_CACHE_TTL %q, policy cache is disabled", s)
+			return
+		}
+		policyCacheTTL = ttl
+	}
+}
+
+// getPolicy returns the policy of the zone from the cache, the shared cache or the database. Only policies which were
+// found are cached, so zones whose policy isn't synced yet are picked up as soon as it is.
+func getPolicy(zone string) (endpoint.Policy, error) {
+	if bypassPolicyCache {
+		return common.GetPolicy(zone)
+	}
+	now := time.Now()
+	if p, ok := cachedZonePolicy(zone, now); ok {
+		return p, nil
+	}
+	p, ok := common.GetSharedCachedPolicy(zone)
+	var err error
+	if !ok {
+		p, err = common.GetPolicy(zone)
+		if err == nil {
+			common.FillSharedCachedPolicy(zone, p)
+		}
+	}
+	if err == nil && policyCacheTTL > 0 {
+		policyCacheMu.Lock()
+		policyCache[zone] = cachedPolicy{policy: p, expires: now.Add(policyCacheTTL)}
+		policyCacheMu.Unlock()
+	}
+	return p, err
+}
+
+func cachedZonePolicy(zone string, now time.Time) (endpoint.Policy, bool) {
+	policyCacheMu.Lock()
+	defer policyCacheMu.Unlock()
+	c, ok := policyCache[zone]
+	if !ok || !now.Before(c.expires) {
+		return endpoint.Policy{}, false
+	}
+	return c.policy, true
+}
+
+// forgetPolicies drops the cached policies of the zones, or of all zones if none are given.
+func forgetPolicies(zones ...string) {
+	policyCacheMu.Lock()
+	defer policyCacheMu.Unlock()
+	if len(zones) == 0 {
+		policyCache = map[string]cachedPolicy{}
+		return
+	}
+	for _, zone := range zones {
+		delete(policyCache, zone)
+	}
+}
+
+// preloadPolicies reads policies of the zones in PRELOAD_ZONES, or of the default zone, into the cache during cold
+// start, so the first request of the container doesn't wait for the database. Failures only leave the cache cold.
+func preloadPolicies() {
+	if policyCacheTTL == 0 {
+		return
+	}
+	zones := common.SplitList(os.Getenv("PRELOAD_ZONES"))
+	if len(zones) == 0 {
+		zones = []string{defaultZone}
+	}
+	for _, zone := range zones {
+		_, err := getPolicy(zone)
+		if err != nil {
+			log.Printf("Can't preload policy of zone %s: %s", zone, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCachedZonePolicy(t *testing.T) {
+	defer forgetPolicies()
+	now := time.Now()
+	policyCache["Default"] = cachedPolicy{policy: endpoint.Policy{AllowWildcards: true}, expires: now.Add(time.Minute)}
+	policyCache["Expired"] = cachedPolicy{expires: now}
+	if p, ok := cachedZonePolicy("Default", now); !ok || !p.AllowWildcards {
+		t.Error("cached policy should be returned until it expires")
+	}
+	if _, ok := cachedZonePolicy("Expired", now); ok {
+		t.Error("expired policy should not be returned")
+	}
+	forgetPolicies("Default")
+	if _, ok := cachedZonePolicy("Default", now); ok {
+		t.Error("forgotten policy should not be returned")
+	}
+}
+
+func TestGetPolicyBypassesCache(t *testing.T) {
+	common.SetPolicyDir("../fixtures/policies")
+	defer common.SetPolicyDir("")
+	defer forgetPolicies()
+	defer func() { bypassPolicyCache = false }()
+	policyCache["Default"] = cachedPolicy{policy: endpoint.Policy{}, expires: time.Now().Add(time.Minute)}
+	bypassPolicyCache = true
+	p, err := getPolicy("Default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.AllowWildcards {
+		t.Error("bypassing request should read the policy from the store")
+	}
+	if c, ok := cachedZonePolicy("Default", time.Now()); !ok || c.AllowWildcards {
+		t.Error("bypassing request should keep the cached policy of other requests")
+	}
+}
+
+func TestLoadPolicyCacheTTL(t *testing.T) {
+	defer os.Unsetenv("POLICY_CACHE_TTL")
+	os.Setenv("POLICY_CACHE_TTL", "300s")
+	loadPolicyCacheTTL()
+	if policyCacheTTL != 5*time.Minute {
+		t.Errorf("unexpected TTL %s", policyCacheTTL)
+	}
+	os.Setenv("POLICY_CACHE_TTL", "soon")
+	loadPolicyCacheTTL()
+	if policyCacheTTL != 0 {
+		t.Errorf("invalid TTL should disable the cache, got %s", policyCacheTTL)
+	}
+}
+
+func TestPreloadPoliciesWithoutCache(t *testing.T) {
+	policyCacheTTL = 0
+	preloadPolicies()
+	if len(policyCache) != 0 {
+		t.Errorf("policies should not be preloaded without cache, got %v", policyCache)
+	}
+}
+
+func TestGetPolicyFromFiles(t *testing.T) {
+	common.SetPolicyDir("../fixtures/policies")
+	defer common.SetPolicyDir("")
+	defer forgetPolicies()
+	policyCacheTTL = 0
+	p, err := getPolicy("Default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile("../fixtures/events/acmpca-IssueCertificate-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event events.APIGatewayProxyRequest
+	var input acmpca.IssueCertificateInput
+	err = json.Unmarshal(b, &event)
+	if err == nil {
+		err = json.Unmarshal([]byte(event.Body), &input)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := newCSRRequest(input.Csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = validateRequest(p, &req); err != nil {
+		t.Errorf("fixture request should comply with the fixture policy: %s", err)
+	}
+}
+
+func TestPolicyCacheBypassed(t *testing.T) {
+	admin := "arn:aws:iam::123456789012:role/PolicyAdmin"
+	policyAdmins = []string{admin}
+	defer func() { policyAdmins = nil }()
+	var request events.APIGatewayProxyRequest
+	request.Headers = map[string]string{policyCacheBypassHeader: "true"}
+	request.RequestContext.Identity.UserArn = "arn:aws:sts::123456789012:assumed-role/PolicyAdmin/debug"
+	if !policyCacheBypassed(request) {
+		t.Error("policy admins should bypass the cache")
+	}
+	request.RequestContext.Identity.UserArn = "arn:aws:iam::123456789012:user/alice"
+	if policyCacheBypassed(request) {
+		t.Error("other callers should not bypass the cache")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	venafiListPolicyRevisions = "Venafi.ListPolicyRevisions"
+	venafiRollbackPolicy      = "Venafi.RollbackPolicy"
+)
+
+// policyAdmins may roll back policies of zones.
+var policyAdmins []string
+
+type ListPolicyRevisionsInput struct {
+	VenafiZone string
+	MaxResults int64
+}
+
+// PolicyRevisionSummary describes a revision of the policy of a zone, without the policy itself.
+type PolicyRevisionSummary struct {
+	Revision      int64
+	PolicyVersion string
+	SyncedAt      time.Time
+}
+
+type ListPolicyRevisionsOutput struct {
+	Zone      string
+	Revisions []PolicyRevisionSummary
+}
+
+// RollbackPolicyInput rolls a zone back to Revision, or with Unpin returns it to the latest synced revision.
+type RollbackPolicyInput struct {
+	VenafiZone string
+	Revision   int64
+	Unpin      bool
+}
+
+type RollbackPolicyOutput struct {
+	Zone          string
+	Revision      int64
+	PolicyVersion string
+	Pinned        bool
+}
+
+func listPolicyRevisions(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input ListPolicyRevisionsInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiListPolicyRevisions, err))
+	}
+	input.VenafiZone, err = zoneOrDefault(input.VenafiZone)
+	if err != nil {
+		return zoneErrorResponse(err)
+	}
+	revisions, err := common.ListPolicyRevisions(input.VenafiZone, input.MaxResults)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy history from database: %s", err))
+	}
+	output := ListPolicyRevisionsOutput{Zone: input.VenafiZone, Revisions: make([]PolicyRevisionSummary, 0, len(revisions))}
+	for _, r := range revisions {
+		output.Revisions = append(output.Revisions, PolicyRevisionSummary{Revision: r.Revision, PolicyVersion: r.PolicyVersion, SyncedAt: r.SyncedAt})
+	}
+	return jsonResponse(venafiListPolicyRevisions, output)
+}
+
+// rollbackPolicy activates a previous revision of the policy of a zone, e.g. when a bad policy synced from Venafi
+// breaks issuance. The zone stays pinned to the revision until it's unpinned.
+func rollbackPolicy(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	admin := request.RequestContext.Identity.UserArn
+	if !principalAllowed(admin, policyAdmins) {
+		log.Printf("AUDIT: %s is not allowed to roll back policies", admin)
+		return clientError(http.StatusForbidden, "Caller is not allowed to roll back policies")
+	}
+	if common.IsRemotePolicyTable() {
+		return clientError(http.StatusConflict, "Policy table is in another account, policies must be rolled back in the central account")
+	}
+	var input RollbackPolicyInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiRollbackPolicy, err))
+	}
+	if input.VenafiZone == "" || (input.Revision <= 0) == !input.Unpin {
+		return clientError(http.StatusBadRequest, "VenafiZone and either Revision or Unpin are required")
+	}
+	var r common.PolicyRevision
+	if input.Unpin {
+		r, err = common.UnpinPolicy(input.VenafiZone)
+	} else {
+		r, err = common.RollbackPolicy(input.VenafiZone, input.Revision)
+	}
+	if err == common.PolicyRevisionNotFound {
+		return clientError(http.StatusNotFound, fmt.Sprintf("Policy revision of zone %s not found", input.VenafiZone))
+	} else if err == common.PolicyWriteConflict {
+		return clientError(http.StatusConflict, fmt.Sprintf("Policy of zone %s was changed by a concurrent sync, retry the rollback", input.VenafiZone))
+	} else if err != nil {
+		common.Monitor.Record(common.BackendDynamoDB, err)
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to roll back policy: %s", err))
+	}
+	forgetPolicies(input.VenafiZone)
+	log.Printf("AUDIT: %s activated revision %d (%s) of the policy of zone %s, pinned: %t", admin, r.Revision, r.PolicyVersion, input.VenafiZone, !input.Unpin)
+	return jsonResponse(venafiRollbackPolicy, RollbackPolicyOutput{
+		Zone:          input.VenafiZone,
+		Revision:      r.Revision,
+		PolicyVersion: r.PolicyVersion,
+		Pinned:        !input.Unpin,
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"testing"
+)
+
+func TestRollbackPolicyInput(t *testing.T) {
+	admin := "arn:aws:iam::123456789012:role/PolicyAdmin"
+	policyAdmins = []string{admin}
+	defer func() { policyAdmins = nil }()
+	var request events.APIGatewayProxyRequest
+	request.RequestContext.Identity.UserArn = "arn:aws:iam::123456789012:role/Developer"
+	request.Body = `{"VenafiZone": "Default", "Revision": 3}`
+	if resp, _ := rollbackPolicy(request); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("rollback by other principals should be forbidden, got %d", resp.StatusCode)
+	}
+	request.RequestContext.Identity.UserArn = admin
+	for _, body := range []string{
+		`{"VenafiZone": "Default"}`,
+		`{"VenafiZone": "Default", "Revision": 3, "Unpin": true}`,
+		`{"Revision": 3}`,
+	} {
+		request.Body = body
+		if resp, _ := rollbackPolicy(request); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("request %s should be rejected, got %d", body, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"log"
+	"regexp"
+)
+
+// regionHeader allows a caller to send ACM and ACM PCA requests to a region other than the Lambda's own.
+const regionHeader = "X-Venafi-Region"
+
+var regionRegexp = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-\d+$`)
+
+// targetRegion returns the region requested by the caller. The header takes precedence over the zone configuration.
+// Empty string means the Lambda's own region.
+func targetRegion(request events.APIGatewayProxyRequest, zoneConfig common.ZoneConfig) (string, error) {
+	region := request.Headers[regionHeader]
+	if region == "" {
+		region = zoneConfig.Region
+	}
+	if region != "" && !regionRegexp.MatchString(region) {
+		return "", fmt.Errorf("invalid target region %q", region)
+	}
+	return region, nil
+}
+
+// loadAWSConfig loads the default AWS configuration and points it to the region and the role, if they are set.
+func loadAWSConfig(region, roleArn string) (aws.Config, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return cfg, err
+	}
+	if region != "" {
+		log.Printf("Using target region %s", region)
+		cfg.Region = region
+	}
+	if roleArn != "" {
+		log.Printf("Using role %s", roleArn)
+		cfg.Credentials = common.AssumeRoleCredentials(cfg, roleArn)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// renewalHeader marks a request as renewal of the certificate with the given ARN.
+const renewalHeader = "X-Venafi-Renewal-Of"
+
+// renewalPolicy returns the policy which applies to a renewal request, or nil when the request is a new issuance or
+// the zone has no separate renewal rules. Whether a request is a renewal is decided by the inventory: the renewed
+// certificate must have been issued through the proxy in the zone for the caller's account and not be revoked. A
+// renewal may only carry names of the certificate it renews.
+func renewalPolicy(request events.APIGatewayProxyRequest, zone string, zoneConfig common.ZoneConfig, names []string) (*endpoint.Policy, error) {
+	previousArn := strings.TrimSpace(request.Headers[renewalHeader])
+	if previousArn == "" {
+		return nil, nil
+	}
+	previous, err := common.GetInventoryRecord(previousArn)
+	if err == common.CertificateNotFound || err == nil && previous.Source != common.InventorySourceProxy {
+		return nil, fmt.Errorf("renewed certificate %s was not issued through the proxy, request it as a new certificate", previousArn)
+	} else if err != nil {
+		return nil, fmt.Errorf("can't get renewed certificate %s from inventory: %s", previousArn, err)
+	}
+	err = checkRenewedCertificate(previous, zone, request.RequestContext.Identity.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	previousNames := canonicalDNSNames(append([]string{previous.DomainName}, previous.SubjectAlternativeNames...))
+	for _, name := range names {
+		if !stringInSlice(name, previousNames) {
+			return nil, fmt.Errorf("name %s is not in renewed certificate %s, request it as a new certificate", name, previousArn)
+		}
+	}
+	return zoneRenewalPolicy(previousArn, zoneConfig, time.Now())
+}
+
+// checkRenewedCertificate checks the inventory record of a renewed certificate against the renewal request.
+func checkRenewedCertificate(r common.InventoryRecord, zone, account string) error {
+	if r.Zone != zone {
+		return fmt.Errorf("renewed certificate %s was issued in zone %s, not in zone %s", r.CertificateArn, r.Zone, zone)
+	}
+	if account == "" || r.SourceAccount != account && r.AccountID != account {
+		return fmt.Errorf("renewed certificate %s was not requested by account %s", r.CertificateArn, account)
+	}
+	if r.Status == common.InventoryStatusRevoked {
+		return fmt.Errorf("renewed certificate %s is revoked, request it as a new certificate", r.CertificateArn)
+	}
+	return nil
+}
+
+// zoneRenewalPolicy returns the separate renewal policy of the zone, or nil when the zone has none or it expired.
+func zoneRenewalPolicy(renewedArn string, zoneConfig common.ZoneConfig, now time.Time) (*endpoint.Policy, error) {
+	if zoneConfig.RenewalPolicyZone == "" {
+		return nil, nil
+	}
+	if !zoneConfig.RenewalPolicyUntil.IsZero() && now.After(zoneConfig.RenewalPolicyUntil) {
+		log.Printf("Renewal policy %s expired at %s", zoneConfig.RenewalPolicyZone, zoneConfig.RenewalPolicyUntil)
+		return nil, nil
+	}
+	log.Printf("Renewal of %s, using policy %s", renewedArn, zoneConfig.RenewalPolicyZone)
+	p, err := getPolicy(zoneConfig.RenewalPolicyZone)
+	if err != nil {
+		return nil, fmt.Errorf("can't get renewal policy %s: %s", zoneConfig.RenewalPolicyZone, err)
+	}
+	return &p, nil
+}
+
+// VenafiRenewCertificateInput is the ACM RenewCertificate request with the zone of the renewed certificate. Renewals
+// are always validated in the zone the certificate was issued in through the proxy, VenafiZone must match it.
+type VenafiRenewCertificateInput struct {
+	acm.RenewCertificateInput
+	VenafiZone string `json:"VenafiZone"`
+}
+
+// renewACMCertificate forwards the renewal of a private ACM certificate after validating the names of the certificate
+// against the current policy of its zone, so certificates which fell out of policy since issuance aren't renewed.
+func renewACMCertificate(ctx context.Context, cli *acm.Client, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input VenafiRenewCertificateInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, acmRenewCertificate, err))
+	}
+	certificateArn := aws.StringValue(input.CertificateArn)
+	zone, err := inventoryZone(certificateArn)
+	if err != nil {
+		common.Monitor.Record(common.BackendDynamoDB, err)
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get certificate from inventory: %s", err))
+	}
+	if input.VenafiZone != "" {
+		requested, err := resolveZone(request, input.VenafiZone)
+		if err != nil {
+			return zoneErrorResponse(err)
+		}
+		if requested != zone {
+			return clientError(http.StatusForbidden, fmt.Sprintf("certificate %s belongs to zone %s, not %s", certificateArn, zone, requested))
+		}
+	}
+	requestZone = zone
+	policy, err := getPolicy(zone)
+	if err == common.PolicyNotFound {
+		return handlePolicyNotFound(zone)
+	} else if err != nil {
+		common.Monitor.Record(common.BackendDynamoDB, err)
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
+	}
+	zoneConfig, err := common.GetZoneConfig(zone)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+	}
+	renewal, err := zoneRenewalPolicy(certificateArn, zoneConfig, time.Now())
+	if err != nil {
+		return clientError(http.StatusFailedDependency, err.Error())
+	}
+	if renewal != nil {
+		policy = *renewal
+	}
+	bypass, err := useBreakGlassToken(request, zone)
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusForbidden, err.Error())
+	}
+	bypass = auditModeBypass(zone, zoneConfig, bypass)
+
+	described, err := cli.DescribeCertificateRequest(&acm.DescribeCertificateInput{CertificateArn: input.CertificateArn}).Send(ctx)
+	common.Monitor.Record(common.BackendACM, err)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, acmRenewCertificate, err))
+	}
+	req := renewedCertificateRequest(described.Certificate)
+	err = bypass.apply(ruleZoneRules, checkDeniedDomains(zoneConfig, &req))
+	if err != nil {
+		log.Printf("Renewal of %s rejected: %s", certificateArn, err)
+		return clientError(http.StatusForbidden, fmt.Sprintf("certificate %s is not compliant with zone %s anymore: %s", certificateArn, zone, err))
+	}
+	err = bypass.apply(rulePolicy, simpleValidateRequest(policy, req))
+	if err != nil {
+		log.Printf("Renewal of %s rejected: %s", certificateArn, err)
+		return policyViolationResponse(zone, policy, fmt.Sprintf("certificate %s is not compliant with policy %s anymore: %s", certificateArn, zone, err), err)
+	}
+	err = bypass.apply(ruleZoneRules, validateZoneRules(zoneConfig, acmZoneRulesRequest(req, req.Subject.CommonName)))
+	if err != nil {
+		log.Printf("Renewal of %s rejected: %s", certificateArn, err)
+		return clientError(http.StatusForbidden, fmt.Sprintf("certificate %s is not compliant with zone %s anymore: %s", certificateArn, zone, err))
+	}
+
+	log.Printf("Renewing certificate %s of zone %s", certificateArn, zone)
+	resp, err := cli.RenewCertificateRequest(&input.RenewCertificateInput).Send(ctx)
+	common.Monitor.Record(common.BackendACM, err)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf(errNoResponse, acmRenewCertificate, err))
+	}
+	return jsonResponse(acmRenewCertificate, resp.RenewCertificateOutput)
+}
+
+// inventoryZone returns the zone of the certificate in the inventory, the default zone for certificates not issued
+// through the proxy.
+func inventoryZone(certificateArn string) (string, error) {
+	r, err := common.GetInventoryRecord(certificateArn)
+	if err == common.CertificateNotFound || err == nil && r.Zone == "" {
+		return defaultZone, nil
+	} else if err != nil {
+		return "", err
+	}
+	return r.Zone, nil
+}
+
+// renewedCertificateRequest builds the request validated against the policy from names of an existing certificate,
+// as a RequestCertificate of the same names would be.
+func renewedCertificateRequest(detail *acm.CertificateDetail) certificate.Request {
+	var req certificate.Request
+	if detail == nil {
+		return req
+	}
+	req.Subject = normalizeSubject(pkix.Name{CommonName: canonicalDNSName(aws.StringValue(detail.DomainName))})
+	for _, name := range canonicalDNSNames(detail.SubjectAlternativeNames) {
+		if name != req.Subject.CommonName {
+			req.DNSNames = append(req.DNSNames, name)
+		}
+	}
+	return req
+}
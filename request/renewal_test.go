@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"reflect"
+	"testing"
+)
+
+func TestRenewedCertificateRequest(t *testing.T) {
+	req := renewedCertificateRequest(&acm.CertificateDetail{
+		DomainName:              aws.String("WWW.Example.com."),
+		SubjectAlternativeNames: []string{"www.example.com", "api.example.com"},
+	})
+	if req.Subject.CommonName != "www.example.com" {
+		t.Errorf("common name is %q", req.Subject.CommonName)
+	}
+	if !reflect.DeepEqual(req.DNSNames, []string{"api.example.com"}) {
+		t.Errorf("DNS names are %v", req.DNSNames)
+	}
+	if req := renewedCertificateRequest(nil); req.Subject.CommonName != "" || len(req.DNSNames) != 0 {
+		t.Errorf("request without certificate should be empty: %+v", req)
+	}
+}
+
+func TestCheckRenewedCertificate(t *testing.T) {
+	r := common.InventoryRecord{
+		CertificateArn: "arn:aws:acm:us-east-1:111111111111:certificate/a",
+		Zone:           "Default",
+		AccountID:      "111111111111",
+		SourceAccount:  "222222222222",
+		Status:         "ISSUED",
+	}
+	if err := checkRenewedCertificate(r, "Default", "222222222222"); err != nil {
+		t.Errorf("renewal by the requesting account should pass: %s", err)
+	}
+	if err := checkRenewedCertificate(r, "Default", "111111111111"); err != nil {
+		t.Errorf("renewal by the owning account should pass: %s", err)
+	}
+	if checkRenewedCertificate(r, "Other", "222222222222") == nil {
+		t.Error("certificate of another zone should not be renewed")
+	}
+	if checkRenewedCertificate(r, "Default", "333333333333") == nil {
+		t.Error("certificate of another account should not be renewed")
+	}
+	r.Status = common.InventoryStatusRevoked
+	if checkRenewedCertificate(r, "Default", "222222222222") == nil {
+		t.Error("revoked certificate should not be renewed")
+	}
+}
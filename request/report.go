@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const venafiListExpiringCertificates = "Venafi.ListExpiringCertificates"
+
+const defaultExpiringDays = 30
+
+// inventoryAdmins may list and search certificates of all accounts.
+var inventoryAdmins []string
+
+type ListExpiringCertificatesInput struct {
+	// Days is how far ahead to look for expiring certificates, 30 by default.
+	Days       int
+	VenafiZone string
+	// AccountID matches the account owning the certificate or the account which requested it.
+	AccountID string
+}
+
+type ListExpiringCertificatesOutput struct {
+	Certificates []common.InventoryRecord
+}
+
+func listExpiringCertificates(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input ListExpiringCertificatesInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiListExpiringCertificates, err))
+	}
+	if input.Days < 0 {
+		return clientError(http.StatusBadRequest, "Days must not be negative")
+	}
+	if input.Days == 0 {
+		input.Days = defaultExpiringDays
+	}
+	now := time.Now()
+	expiring, err := common.ListInventoryRecordsByExpiry(now, now.AddDate(0, 0, input.Days))
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get inventory from database: %s", err))
+	}
+	identity := request.RequestContext.Identity
+	records := filterInventory(expiring, func(r common.InventoryRecord) bool {
+		return inventoryVisible(r, identity) && isExpiring(r, input, now)
+	})
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].NotAfter.Before(records[j].NotAfter)
+	})
+
+	body, err := json.Marshal(ListExpiringCertificatesOutput{Certificates: records})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+// inventoryVisible reports whether the caller may see the certificate. Like request statuses, certificates are visible
+// to the account owning or requesting them, inventory admins see the certificates of all accounts.
+func inventoryVisible(r common.InventoryRecord, identity events.APIGatewayRequestIdentity) bool {
+	if principalAllowed(identity.UserArn, inventoryAdmins) {
+		return true
+	}
+	return identity.AccountID != "" && (r.AccountID == identity.AccountID || r.SourceAccount == identity.AccountID)
+}
+
+// isExpiring reports whether a proxy-issued certificate expires within the requested days and matches the filters.
+func isExpiring(r common.InventoryRecord, input ListExpiringCertificatesInput, now time.Time) bool {
+	if r.Source != common.InventorySourceProxy || r.NotAfter.IsZero() {
+		return false
+	}
+	if r.NotAfter.Before(now) || r.NotAfter.After(now.AddDate(0, 0, input.Days)) {
+		return false
+	}
+	if input.VenafiZone != "" && r.Zone != input.VenafiZone {
+		return false
+	}
+	if input.AccountID != "" && r.AccountID != input.AccountID && r.SourceAccount != input.AccountID {
+		return false
+	}
+	return true
+}
+
+// recordIssuedCertificate adds a certificate issued through the proxy to the inventory. Inventory is best effort,
+// so errors are only logged.
+func recordIssuedCertificate(r common.InventoryRecord) {
+	a, err := arn.Parse(r.CertificateArn)
+	if err == nil {
+		r.AccountID = a.AccountID
+		r.Region = a.Region
+	}
+	r.Source = common.InventorySourceProxy
+	err = common.SaveInventoryRecord(r)
+	if err != nil {
+		log.Printf("Can't save certificate %s to inventory: %s", r.CertificateArn, err)
+	}
+	queueVenafiImport(r, time.Now())
+}
+
+// recordThumbprint adds the thumbprint of a certificate retrieved through the proxy to its inventory record.
+func recordThumbprint(certificateArn, pemCert string) {
+	thumbprint, err := certificateThumbprint(pemCert)
+	if err != nil {
+		return
+	}
+	err = common.SetInventoryThumbprint(certificateArn, thumbprint)
+	if err != nil && err != common.CertificateNotFound {
+		log.Printf("Can't save thumbprint of certificate %s to inventory: %s", certificateArn, err)
+	}
+}
+
+// issuedPCARecord describes a certificate issued by ACM PCA for the request.
+func issuedPCARecord(certificateArn string, req *certificate.Request, input acmpca.IssueCertificateInput, now time.Time) common.InventoryRecord {
+	names := requestedNames(req)
+	r := common.InventoryRecord{
+		CertificateArn:          certificateArn,
+		SubjectAlternativeNames: req.DNSNames,
+		Status:                  "ISSUED",
+		Type:                    "PRIVATE",
+		NotAfter:                validityEnd(input.Validity, now),
+	}
+	if len(names) > 0 {
+		r.DomainName = names[0]
+	}
+	r.Subject = req.Subject.String()
+	// ACM PCA certificate ARNs are the CA ARN followed by the certificate serial number.
+	if i := strings.LastIndex(certificateArn, "/certificate/"); i >= 0 {
+		r.CertificateAuthorityArn = certificateArn[:i]
+		r.Serial = certificateArn[i+len("/certificate/"):]
+	}
+	return r
+}
+
+// acmIssuedRecord describes a certificate requested from ACM. It's pending until ACM issues it.
+func acmIssuedRecord(certificateArn string, input acm.RequestCertificateInput) common.InventoryRecord {
+	r := common.InventoryRecord{
+		CertificateArn:          certificateArn,
+		DomainName:              aws.StringValue(input.DomainName),
+		Subject:                 normalizeSubject(pkix.Name{CommonName: aws.StringValue(input.DomainName)}).String(),
+		SubjectAlternativeNames: input.SubjectAlternativeNames,
+		Status:                  string(acm.CertificateStatusPendingValidation),
+		Type:                    string(acm.CertificateTypeAmazonIssued),
+	}
+	if input.CertificateAuthorityArn != nil {
+		r.Type = string(acm.CertificateTypePrivate)
+		r.CertificateAuthorityArn = *input.CertificateAuthorityArn
+	}
+	return r
+}
+
+// validityEnd returns the expiration of a certificate issued now with the validity. Zero time means unknown.
+func validityEnd(v *acmpca.Validity, now time.Time) time.Time {
+	if v == nil || v.Value == nil {
+		return time.Time{}
+	}
+	value := *v.Value
+	now = now.UTC()
+	switch v.Type {
+	case acmpca.ValidityPeriodTypeDays:
+		return now.AddDate(0, 0, int(value))
+	case acmpca.ValidityPeriodTypeMonths:
+		return now.AddDate(0, int(value), 0)
+	case acmpca.ValidityPeriodTypeYears:
+		return now.AddDate(int(value), 0, 0)
+	case acmpca.ValidityPeriodTypeAbsolute:
+		return time.Unix(value, 0).UTC()
+	case acmpca.ValidityPeriodTypeEndDate:
+		t, err := time.Parse("20060102150405", fmt.Sprintf("%014d", value))
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"testing"
+	"time"
+)
+
+func TestValidityEnd(t *testing.T) {
+	now := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		validity *acmpca.Validity
+		want     time.Time
+	}{
+		{&acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(10)}, time.Date(2026, 2, 10, 12, 0, 0, 0, time.UTC)},
+		{&acmpca.Validity{Type: acmpca.ValidityPeriodTypeYears, Value: aws.Int64(1)}, time.Date(2027, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{&acmpca.Validity{Type: acmpca.ValidityPeriodTypeAbsolute, Value: aws.Int64(1800000000)}, time.Unix(1800000000, 0).UTC()},
+		{&acmpca.Validity{Type: acmpca.ValidityPeriodTypeEndDate, Value: aws.Int64(20270315000000)}, time.Date(2027, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{nil, time.Time{}},
+	}
+	for i, c := range cases {
+		if got := validityEnd(c.validity, now); !got.Equal(c.want) {
+			t.Errorf("case %d: got %s, want %s", i, got, c.want)
+		}
+	}
+}
+
+func TestIsExpiring(t *testing.T) {
+	now := time.Now()
+	r := common.InventoryRecord{
+		Source:        common.InventorySourceProxy,
+		NotAfter:      now.AddDate(0, 0, 10),
+		Zone:          "Default",
+		AccountID:     "111111111111",
+		SourceAccount: "222222222222",
+	}
+	cases := []struct {
+		input ListExpiringCertificatesInput
+		want  bool
+	}{
+		{ListExpiringCertificatesInput{Days: 30}, true},
+		{ListExpiringCertificatesInput{Days: 5}, false},
+		{ListExpiringCertificatesInput{Days: 30, VenafiZone: "Default"}, true},
+		{ListExpiringCertificatesInput{Days: 30, VenafiZone: "Other"}, false},
+		{ListExpiringCertificatesInput{Days: 30, AccountID: "222222222222"}, true},
+		{ListExpiringCertificatesInput{Days: 30, AccountID: "333333333333"}, false},
+	}
+	for i, c := range cases {
+		if got := isExpiring(r, c.input, now); got != c.want {
+			t.Errorf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+	r.Source = common.InventorySourceDiscovery
+	if isExpiring(r, ListExpiringCertificatesInput{Days: 30}, now) {
+		t.Error("discovered certificates are not reported")
+	}
+}
+
+func TestInventoryVisible(t *testing.T) {
+	defer func(admins []string) { inventoryAdmins = admins }(inventoryAdmins)
+	inventoryAdmins = []string{"arn:aws:iam::999999999999:role/CertAdmin"}
+	r := common.InventoryRecord{AccountID: "111111111111", SourceAccount: "222222222222"}
+	cases := []struct {
+		identity events.APIGatewayRequestIdentity
+		want     bool
+	}{
+		{events.APIGatewayRequestIdentity{AccountID: "111111111111", UserArn: "arn:aws:iam::111111111111:user/owner"}, true},
+		{events.APIGatewayRequestIdentity{AccountID: "222222222222", UserArn: "arn:aws:iam::222222222222:user/requester"}, true},
+		{events.APIGatewayRequestIdentity{AccountID: "333333333333", UserArn: "arn:aws:iam::333333333333:user/other"}, false},
+		{events.APIGatewayRequestIdentity{AccountID: "999999999999", UserArn: "arn:aws:sts::999999999999:assumed-role/CertAdmin/session"}, true},
+		{events.APIGatewayRequestIdentity{}, false},
+	}
+	for i, c := range cases {
+		if got := inventoryVisible(r, c.identity); got != c.want {
+			t.Errorf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"net/http"
+	"time"
+)
+
+const venafiGetRequestStatus = "Venafi.GetRequestStatus"
+
+// requestIDHeader returns the ID under which the request status can be looked up.
+const requestIDHeader = "X-Venafi-Request-Id"
+
+const requestStatusRetention = 90 * 24 * time.Hour
+
+// requestZone is the zone the current request was resolved to, e.g. through a zone mapping, empty before the zone is
+// resolved. The status records it instead of VenafiZone of the body, which the caller chooses.
+var requestZone string
+
+type GetRequestStatusInput struct {
+	RequestID string
+}
+
+// trackRequest persists the outcome of a request and returns the response with the request ID header.
+// Tracking is best effort, a failure to save the status doesn't fail the request.
+func trackRequest(request events.APIGatewayProxyRequest, target string, response events.APIGatewayProxyResponse) events.APIGatewayProxyResponse {
+	requestID := request.RequestContext.RequestID
+	if requestID == "" {
+		return response
+	}
+	status := newRequestStatus(request, target, response, time.Now())
+	err := common.SaveRequestStatus(status)
+	if err != nil {
+		log.Printf("Can't save status of request %s: %s", requestID, err)
+		return response
+	}
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	response.Headers[requestIDHeader] = requestID
+	return response
+}
+
+func newRequestStatus(request events.APIGatewayProxyRequest, target string, response events.APIGatewayProxyResponse, now time.Time) common.RequestStatus {
+	identity := request.RequestContext.Identity
+	status := common.RequestStatus{
+		RequestID:     request.RequestContext.RequestID,
+		Target:        target,
+		CallerArn:     identity.UserArn,
+		SourceAccount: identity.AccountID,
+		StatusCode:    response.StatusCode,
+		CreatedAt:     now.UTC(),
+		TTL:           now.Add(requestStatusRetention).Unix(),
+	}
+	switch {
+	case response.StatusCode == http.StatusAccepted:
+		status.Status = common.RequestQueued
+	case response.StatusCode < 300:
+		status.Status = common.RequestSucceeded
+	case response.StatusCode < 500 && response.StatusCode != http.StatusFailedDependency:
+		status.Status = common.RequestRejected
+	default:
+		status.Status = common.RequestFailed
+	}
+	var requestBody struct {
+		VenafiZone     string
+		CertificateArn string
+	}
+	_ = json.Unmarshal([]byte(request.Body), &requestBody)
+	status.Zone = requestBody.VenafiZone
+	if requestZone != "" {
+		status.Zone = requestZone
+	}
+	var responseBody struct {
+		CertificateArn string
+		Msg            string `json:"msg"`
+	}
+	_ = json.Unmarshal([]byte(response.Body), &responseBody)
+	status.Message = responseBody.Msg
+	status.CertificateArn = responseBody.CertificateArn
+	if status.CertificateArn == "" {
+		status.CertificateArn = requestBody.CertificateArn
+	}
+	return status
+}
+
+func getRequestStatus(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input GetRequestStatusInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiGetRequestStatus, err))
+	}
+	if input.RequestID == "" {
+		return clientError(http.StatusBadRequest, "RequestID is required")
+	}
+	status, err := common.GetRequestStatus(input.RequestID)
+	// Requests of other accounts are reported as not found, so their IDs can't be probed.
+	if err == common.RequestStatusNotFound || (err == nil && status.SourceAccount != request.RequestContext.Identity.AccountID) {
+		return clientError(http.StatusNotFound, fmt.Sprintf("Request %s not found", input.RequestID))
+	} else if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get request status from database: %s", err))
+	}
+	body, err := json.Marshal(status)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
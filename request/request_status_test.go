@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewRequestStatus(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	request.RequestContext.RequestID = "c6af9ac6-7b61-11e6-9a41-93e8deadbeef"
+	request.RequestContext.Identity.AccountID = "123456789012"
+	request.Body = `{"VenafiZone": "Default", "Csr": "LS0t"}`
+
+	rejected, _ := clientError(http.StatusForbidden, "names [a.example.org] don't exist in DNS")
+	status := newRequestStatus(request, acmpcaIssueCertificate, rejected, time.Now())
+	if status.Status != common.RequestRejected || status.Zone != "Default" || status.Message != "names [a.example.org] don't exist in DNS" {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	issued := events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: `{"CertificateArn": "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/a/certificate/b"}`}
+	status = newRequestStatus(request, acmpcaIssueCertificate, issued, time.Now())
+	if status.Status != common.RequestSucceeded || status.CertificateArn == "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	failed, _ := clientError(http.StatusFailedDependency, "Failed to get policy from database")
+	if s := newRequestStatus(request, acmpcaIssueCertificate, failed, time.Now()); s.Status != common.RequestFailed {
+		t.Errorf("dependency failure should be FAILED, got %s", s.Status)
+	}
+
+	requestZone = "Mapped"
+	defer func() { requestZone = "" }()
+	if s := newRequestStatus(request, acmpcaIssueCertificate, rejected, time.Now()); s.Zone != "Mapped" {
+		t.Errorf("status should have the resolved zone, got %s", s.Zone)
+	}
+
+	queued, _ := queuedResponse(request.RequestContext.RequestID)
+	if s := newRequestStatus(request, acmpcaIssueCertificate, queued, time.Now()); s.Status != common.RequestQueued {
+		t.Errorf("queued request should be QUEUED, got %s", s.Status)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// retryQueueURL is the SQS queue of throttled issuance requests. Without it throttled requests fail.
+var retryQueueURL string
+
+// retryQueueDelay postpones the first retry of a queued request, the backend was throttling it a moment ago.
+const retryQueueDelay = 10
+
+// retryQueueMaxReceiveCount is the maxReceiveCount of the redrive policy of the retry queue in template.yml. The worker
+// gives up on a request still throttled on its last delivery.
+const retryQueueMaxReceiveCount = 10
+
+// workerDeadlineMargin is left of the worker invocation after the last retry of a throttled call.
+const workerDeadlineMargin = 2 * time.Second
+
+// queuedIssuance is a validated issuance request waiting in the retry queue. It has either IssueCertificateInput or
+// RequestCertificateInput set, depending on the target.
+type queuedIssuance struct {
+	RequestID     string
+	Target        string
+	Zone          string
+	Region        string
+	RoleArn       string
+	SourceAccount string
+	RequestedBy   string
+	Tags          []acm.Tag
+	// PolicyVersion is the version of the zone policy the request was validated against.
+	PolicyVersion string
+	// BudgetMonth is the month the issuance is counted against in the account budget, empty if it's not counted.
+	BudgetMonth string
+	QueuedAt    time.Time
+
+	IssueCertificateInput   *acmpca.IssueCertificateInput `json:",omitempty"`
+	Passthrough             *passthrough                  `json:",omitempty"`
+	CertificateAuthorityArn string                        `json:",omitempty"`
+	FailoverCAs             []string                      `json:",omitempty"`
+	RequestHash             string                        `json:",omitempty"`
+
+	RequestCertificateInput *acm.RequestCertificateInput `json:",omitempty"`
+}
+
+type QueuedRequestOutput struct {
+	RequestID string
+	Status    string
+}
+
+func loadRetryQueue() {
+	retryQueueURL = os.Getenv("RETRY_QUEUE_URL")
+	loadThrottleRetryBudget()
+}
+
+func retryWorkerMode() bool {
+	return os.Getenv("RETRY_WORKER_MODE") == "true"
+}
+
+func newQueuedIssuance(request events.APIGatewayProxyRequest, target, zone, region, roleArn string, tags []acm.Tag, now time.Time) queuedIssuance {
+	identity := request.RequestContext.Identity
+	return queuedIssuance{
+		RequestID:     request.RequestContext.RequestID,
+		Target:        target,
+		Zone:          zone,
+		Region:        region,
+		RoleArn:       roleArn,
+		SourceAccount: identity.AccountID,
+		RequestedBy:   identity.UserArn,
+		Tags:          tags,
+		QueuedAt:      now.UTC(),
+	}
+}
+
+// queueThrottled pushes a throttled request to the retry queue. It reports false if the request can't be queued and
+// has to fail instead. Requests without ID can't be queued, their callers couldn't look up the outcome.
+func queueThrottled(ctx context.Context, err error, q queuedIssuance) bool {
+	if !isThrottled(err) || retryQueueURL == "" || q.RequestID == "" {
+		return false
+	}
+	body, err := json.Marshal(q)
+	if err != nil {
+		log.Printf("Can't queue request %s: %s", q.RequestID, err)
+		return false
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		log.Printf("Can't load config to queue request %s: %s", q.RequestID, err)
+		return false
+	}
+	_, err = sqs.New(cfg).SendMessageRequest(&sqs.SendMessageInput{
+		QueueUrl:     aws.String(retryQueueURL),
+		MessageBody:  aws.String(string(body)),
+		DelaySeconds: aws.Int64(retryQueueDelay),
+	}).Send(ctx)
+	if err != nil {
+		log.Printf("Can't queue request %s: %s", q.RequestID, err)
+		return false
+	}
+	log.Printf("Request %s is throttled, queued for retry", q.RequestID)
+	return true
+}
+
+// queuedResponse tells the caller to poll Venafi.GetRequestStatus for the outcome of a queued request.
+func queuedResponse(requestID string) (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(QueuedRequestOutput{RequestID: requestID, Status: common.RequestQueued})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusAccepted,
+	}, nil
+}
+
+// RetryWorkerHandler issues requests queued after throttling. Requests were validated when they were received and
+// are not validated again, break-glass tokens they used are single-use.
+func RetryWorkerHandler(ctx context.Context, event events.SQSEvent) error {
+	initHandler()
+	for _, m := range event.Records {
+		var q queuedIssuance
+		err := json.Unmarshal([]byte(m.Body), &q)
+		if err != nil {
+			log.Printf("Dropping invalid message %s: %s", m.MessageId, err)
+			continue
+		}
+		certificateArn, err := issueQueued(ctx, q, workerDeadline(ctx))
+		if isThrottled(err) && !lastDelivery(m) {
+			// SQS delivers the message again after its visibility timeout.
+			log.Printf("Request %s is still throttled: %s", q.RequestID, err)
+			return err
+		}
+		finishQueued(q, certificateArn, err)
+	}
+	return nil
+}
+
+// lastDelivery reports whether SQS moves the message to the dead-letter queue instead of delivering it again, so its
+// request has to be failed now.
+func lastDelivery(m events.SQSMessage) bool {
+	n, err := strconv.Atoi(m.Attributes["ApproximateReceiveCount"])
+	return err == nil && n >= retryQueueMaxReceiveCount
+}
+
+func workerDeadline(ctx context.Context) time.Time {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return time.Now().Add(throttleRetryBudget)
+	}
+	return deadline.Add(-workerDeadlineMargin)
+}
+
+// issueQueued issues the queued request and records the certificate like the request handler does.
+func issueQueued(ctx context.Context, q queuedIssuance, deadline time.Time) (string, error) {
+	awsCfg, err := loadAWSConfig(q.Region, q.RoleArn)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case q.IssueCertificateInput != nil:
+		ca, err := resolveCA(ctx, awsCfg, q.CertificateAuthorityArn)
+		if err != nil {
+			return "", err
+		}
+		resp, issuer, err := issueWithFailover(ctx, awsCfg, q.Region, *q.IssueCertificateInput, q.Passthrough, ca, q.FailoverCAs, deadline)
+		common.Monitor.Record(common.BackendACMPCA, err)
+		if err != nil {
+			return "", err
+		}
+		certificateArn := aws.StringValue(resp.CertificateArn)
+		now := time.Now()
+		saveIssuedRequest(q.RequestHash, certificateArn, issuer, now)
+		req, err := newCSRRequest(q.IssueCertificateInput.Csr)
+		if err != nil {
+			log.Printf("Can't parse certificate request of %s: %s", q.RequestID, err)
+			return certificateArn, nil
+		}
+		record := issuedPCARecord(certificateArn, &req, *q.IssueCertificateInput, now)
+		q.recordIssued(record)
+		return certificateArn, nil
+	case q.RequestCertificateInput != nil:
+		acmCli := acm.New(awsCfg)
+		var resp *acm.RequestCertificateResponse
+		err = retryThrottled(deadline, func() error {
+			resp, err = acmCli.RequestCertificateRequest(q.RequestCertificateInput).Send(ctx)
+			return err
+		})
+		common.Monitor.Record(common.BackendACM, err)
+		if err != nil {
+			return "", err
+		}
+		err = tagCertificate(ctx, acmCli, resp.CertificateArn, q.Tags)
+		if err != nil {
+			log.Printf("Can't tag certificate %s: %s", aws.StringValue(resp.CertificateArn), err)
+		}
+		certificateArn := aws.StringValue(resp.CertificateArn)
+		startDNSValidation(ctx, *q.RequestCertificateInput, certificateArn, q.Region, q.RoleArn)
+		q.recordIssued(acmIssuedRecord(certificateArn, *q.RequestCertificateInput))
+		return certificateArn, nil
+	}
+	return "", fmt.Errorf("queued request %s has no input", q.RequestID)
+}
+
+func (q queuedIssuance) recordIssued(r common.InventoryRecord) {
+	r.Zone = q.Zone
+	r.PolicyVersion = q.PolicyVersion
+	r.SourceAccount = q.SourceAccount
+	r.RequestedBy = q.RequestedBy
+	r.Tags = tagsMap(q.Tags)
+	recordIssuedCertificate(r)
+}
+
+// finishQueued saves the outcome of a queued request. A failed issuance is taken back from the account budget.
+func finishQueued(q queuedIssuance, certificateArn string, err error) {
+	status := common.RequestStatus{
+		RequestID:      q.RequestID,
+		Target:         q.Target,
+		Zone:           q.Zone,
+		CallerArn:      q.RequestedBy,
+		SourceAccount:  q.SourceAccount,
+		Status:         common.RequestSucceeded,
+		StatusCode:     http.StatusOK,
+		CertificateArn: certificateArn,
+		CreatedAt:      q.QueuedAt,
+		TTL:            q.QueuedAt.Add(requestStatusRetention).Unix(),
+	}
+	if err != nil {
+		log.Printf("Queued request %s failed: %s", q.RequestID, err)
+		status.Status = common.RequestFailed
+		status.StatusCode = http.StatusInternalServerError
+		status.Message = fmt.Sprintf("Could not get certificate response: %s", err)
+		if q.BudgetMonth != "" {
+			account := q.SourceAccount
+			if account == "" {
+				account = "unknown"
+			}
+			releaseIssuance(account, q.BudgetMonth)
+		}
+	} else {
+		log.Printf("Queued request %s issued certificate %s", q.RequestID, certificateArn)
+	}
+	err = common.SaveRequestStatus(status)
+	if err != nil {
+		log.Printf("Can't save status of request %s: %s", q.RequestID, err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"strings"
+	"time"
+)
+
+// venafiRevocationRetention is how long the policy Lambda retries applying a revocation to Venafi.
+const venafiRevocationRetention = 7 * 24 * time.Hour
+
+// venafiRevocationReasons maps ACM PCA revocation reasons to Venafi revocation reasons. Reasons Venafi doesn't have
+// are mapped to the closest one.
+var venafiRevocationReasons = map[acmpca.RevocationReason]string{
+	acmpca.RevocationReasonUnspecified:                    "none",
+	acmpca.RevocationReasonKeyCompromise:                  "key-compromise",
+	acmpca.RevocationReasonCertificateAuthorityCompromise: "ca-compromise",
+	acmpca.RevocationReasonAACompromise:                   "ca-compromise",
+	acmpca.RevocationReasonAffiliationChanged:             "affiliation-changed",
+	acmpca.RevocationReasonPrivilegeWithdrawn:             "affiliation-changed",
+	acmpca.RevocationReasonSuperseded:                     "superseded",
+	acmpca.RevocationReasonCessationOfOperation:           "cessation-of-operation",
+}
+
+func venafiRevocationReason(reason acmpca.RevocationReason) string {
+	if r, ok := venafiRevocationReasons[reason]; ok {
+		return r
+	}
+	return "none"
+}
+
+// revokedCertificateArn returns the ACM PCA ARN of the certificate revoked by the input.
+func revokedCertificateArn(input *acmpca.RevokeCertificateInput) string {
+	serial := strings.ToLower(strings.Replace(aws.StringValue(input.CertificateSerial), ":", "", -1))
+	return aws.StringValue(input.CertificateAuthorityArn) + "/certificate/" + serial
+}
+
+// queueVenafiRevocation queues a certificate revoked in ACM PCA for revocation of its Venafi record. It is best
+// effort, the certificate is already revoked in AWS.
+func queueVenafiRevocation(ctx context.Context, cli *acmpca.Client, input *acmpca.RevokeCertificateInput, revokedBy, sourceAccount string, now time.Time) {
+	certificateArn := revokedCertificateArn(input)
+	resp, err := cli.GetCertificateRequest(&acmpca.GetCertificateInput{
+		CertificateArn:          aws.String(certificateArn),
+		CertificateAuthorityArn: input.CertificateAuthorityArn,
+	}).Send(ctx)
+	if err != nil {
+		log.Printf("Can't get revoked certificate %s, Venafi record is not updated: %s", certificateArn, err)
+		return
+	}
+	thumbprint, err := certificateThumbprint(aws.StringValue(resp.Certificate))
+	if err != nil {
+		log.Printf("Revoked certificate %s: %s, Venafi record is not updated", certificateArn, err)
+		return
+	}
+	r := common.VenafiRevocation{
+		CertificateArn: certificateArn,
+		Thumbprint:     thumbprint,
+		Reason:         venafiRevocationReason(input.RevocationReason),
+		AWSReason:      string(input.RevocationReason),
+		Comments:       fmt.Sprintf("Revoked in ACM PCA with reason %s by %s", input.RevocationReason, revokedBy),
+		RevokedBy:      revokedBy,
+		SourceAccount:  sourceAccount,
+		CreatedAt:      now.UTC(),
+		TTL:            now.Add(venafiRevocationRetention).Unix(),
+	}
+	if record, err := common.GetInventoryRecord(certificateArn); err == nil {
+		r.Zone = record.Zone
+	}
+	err = common.SaveVenafiRevocation(r)
+	if err != nil {
+		log.Printf("Can't queue Venafi revocation of %s: %s", certificateArn, err)
+	}
+}
+
+// certificateThumbprint returns the SHA-1 hash of a PEM certificate in upper case hex, as used by Venafi.
+func certificateThumbprint(pemCert string) (string, error) {
+	pemBlock, _ := pem.Decode([]byte(pemCert))
+	if pemBlock == nil {
+		return "", fmt.Errorf("certificate is not PEM encoded")
+	}
+	sum := sha1.Sum(pemBlock.Bytes)
+	return strings.ToUpper(hex.EncodeToString(sum[:])), nil
+}
+
+// markInventoryRevoked sets the inventory status of a certificate revoked through the proxy, so searches and
+// expiration notifications don't wait for the next inventory run. Certificates missing in the inventory are skipped.
+func markInventoryRevoked(certificateArn string) {
+	r, err := common.GetInventoryRecord(certificateArn)
+	if err != nil {
+		if err != common.CertificateNotFound {
+			log.Printf("Can't get certificate %s from inventory: %s", certificateArn, err)
+		}
+		return
+	}
+	if r.Status == common.InventoryStatusRevoked {
+		return
+	}
+	r.Status = common.InventoryStatusRevoked
+	err = common.SaveInventoryRecord(r)
+	if err != nil {
+		log.Printf("Can't update certificate %s in inventory: %s", certificateArn, err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"testing"
+)
+
+func TestVenafiRevocationReason(t *testing.T) {
+	cases := map[acmpca.RevocationReason]string{
+		acmpca.RevocationReasonKeyCompromise:                  "key-compromise",
+		acmpca.RevocationReasonCertificateAuthorityCompromise: "ca-compromise",
+		acmpca.RevocationReasonAACompromise:                   "ca-compromise",
+		acmpca.RevocationReasonSuperseded:                     "superseded",
+		acmpca.RevocationReasonPrivilegeWithdrawn:             "affiliation-changed",
+		acmpca.RevocationReasonUnspecified:                    "none",
+		acmpca.RevocationReason("UNKNOWN"):                    "none",
+	}
+	for reason, want := range cases {
+		if got := venafiRevocationReason(reason); got != want {
+			t.Errorf("venafiRevocationReason(%s) = %s, want %s", reason, got, want)
+		}
+	}
+	for reason, r := range venafiRevocationReasons {
+		if _, ok := tpp.RevocationReasonsMap[r]; !ok {
+			t.Errorf("%s is mapped to %s which is not a Venafi revocation reason", reason, r)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	// oidUPN is the Microsoft user principal name otherName.
+	oidUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// otherNameTag is the context-specific tag of otherName in GeneralName.
+const otherNameTag = 0
+
+// upnSANs returns user principal names from the subject alternative name extension, which crypto/x509 doesn't parse.
+// Other otherName SANs are rejected since the policy has no rules to validate them.
+func upnSANs(extensions []pkix.Extension) ([]string, error) {
+	var upns []string
+	for _, e := range extensions {
+		if !e.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		_, err := asn1.Unmarshal(e.Value, &names)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject alternative name extension: %s", err)
+		}
+		for _, n := range names {
+			if n.Class != asn1.ClassContextSpecific || n.Tag != otherNameTag {
+				continue
+			}
+			var typeID asn1.ObjectIdentifier
+			rest, err := asn1.Unmarshal(n.Bytes, &typeID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid otherName SAN: %s", err)
+			}
+			if !typeID.Equal(oidUPN) {
+				return nil, fmt.Errorf("otherName SAN of type %s is not allowed", typeID)
+			}
+			var value asn1.RawValue
+			_, err = asn1.Unmarshal(rest, &value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UPN SAN: %s", err)
+			}
+			var upn string
+			_, err = asn1.Unmarshal(value.Bytes, &upn)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UPN SAN: %s", err)
+			}
+			upns = append(upns, upn)
+		}
+	}
+	return upns, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"testing"
+)
+
+func testOtherNameCSR(t *testing.T, typeID asn1.ObjectIdentifier, value string) []byte {
+	utf8, err := asn1.MarshalWithParams(value, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeDER, err := asn1.Marshal(typeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicit, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: utf8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sans, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("upn.example.com")},
+		{Class: asn1.ClassContextSpecific, Tag: otherNameTag, IsCompound: true, Bytes: append(typeDER, explicit...)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "upn.example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: oidSubjectAltName, Value: sans}},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
+
+func TestUPNSANs(t *testing.T) {
+	req, err := newCSRRequest(testOtherNameCSR(t, oidUPN, "admin@corp.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.UPNs) != 1 || req.UPNs[0] != "admin@corp.example.com" {
+		t.Fatalf("unexpected UPNs %v", req.UPNs)
+	}
+	if len(req.DNSNames) != 1 || req.DNSNames[0] != "upn.example.com" {
+		t.Errorf("DNS names should be parsed next to UPNs, got %v", req.DNSNames)
+	}
+
+	p := endpoint.Policy{
+		SubjectCNRegexes: []string{`^.*\.example\.com$`},
+		SubjectORegexes:  []string{".*"},
+		SubjectOURegexes: []string{".*"},
+		SubjectSTRegexes: []string{".*"},
+		SubjectLRegexes:  []string{".*"},
+		SubjectCRegexes:  []string{".*"},
+		DnsSanRegExs:     []string{`^.*\.example\.com$`},
+		UpnSanRegExs:     []string{`^.*@corp\.example\.com$`},
+	}
+	if err = validateRequest(p, &req); err != nil {
+		t.Errorf("UPN allowed by policy should pass: %s", err)
+	}
+	p.UpnSanRegExs = []string{`^.*@other\.example\.com$`}
+	if validateRequest(p, &req) == nil {
+		t.Error("UPN outside of policy should be rejected")
+	}
+
+	_, err = newCSRRequest(testOtherNameCSR(t, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 9}, "x"))
+	if err == nil {
+		t.Error("otherName SAN which can't be validated should be rejected")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const venafiSearchCertificates = "Venafi.SearchCertificates"
+
+// SearchCertificatesInput holds search criteria, all given criteria must match.
+type SearchCertificatesInput struct {
+	// Domain matches certificates for the domain or its subdomains.
+	Domain string
+	// SAN matches certificates with the exact subject alternative name.
+	SAN string
+	// Serial and Thumbprint are hex encoded, with or without colons.
+	Serial     string
+	Thumbprint string
+	VenafiZone string
+	// RequestedBy is an IAM user or role ARN which requested the certificate through the proxy.
+	RequestedBy string
+	// IncludeInactive includes expired and revoked certificates.
+	IncludeInactive bool
+}
+
+type SearchCertificatesOutput struct {
+	Certificates []common.InventoryRecord
+}
+
+func searchCertificates(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input SearchCertificatesInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiSearchCertificates, err))
+	}
+	if input.Domain == "" && input.SAN == "" && input.Serial == "" && input.Thumbprint == "" &&
+		input.VenafiZone == "" && input.RequestedBy == "" {
+		return clientError(http.StatusBadRequest, "At least one search criterion is required")
+	}
+	now := time.Now()
+	name := input.Domain
+	if name == "" {
+		name = input.SAN
+	}
+	identity := request.RequestContext.Identity
+	records, err := listInventoryByName(name, func(r common.InventoryRecord) bool {
+		return inventoryVisible(r, identity) && matchesSearch(r, input, now)
+	})
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get inventory from database: %s", err))
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].NotAfter.Before(records[j].NotAfter)
+	})
+
+	body, err := json.Marshal(SearchCertificatesOutput{Certificates: records})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+func matchesSearch(r common.InventoryRecord, input SearchCertificatesInput, now time.Time) bool {
+	if !input.IncludeInactive && (r.Status == common.InventoryStatusRevoked || (!r.NotAfter.IsZero() && r.NotAfter.Before(now))) {
+		return false
+	}
+	if input.Domain != "" && !certificateForDomain(r, input.Domain) {
+		return false
+	}
+	if input.SAN != "" && !containsFold(r.SubjectAlternativeNames, strings.TrimSpace(input.SAN)) {
+		return false
+	}
+	if input.Serial != "" && normalizeHex(r.Serial) != normalizeHex(input.Serial) {
+		return false
+	}
+	if input.Thumbprint != "" && normalizeHex(r.Thumbprint) != normalizeHex(input.Thumbprint) {
+		return false
+	}
+	if input.VenafiZone != "" && r.Zone != input.VenafiZone {
+		return false
+	}
+	if input.RequestedBy != "" && !principalAllowed(r.RequestedBy, []string{input.RequestedBy}) {
+		return false
+	}
+	return true
+}
+
+// listInventoryByName returns inventory records accepted by the filter, using the domain index if the name has a base
+// domain. The filter must reject records without the name.
+func listInventoryByName(name string, filter func(common.InventoryRecord) bool) ([]common.InventoryRecord, error) {
+	if common.BaseDomain(strings.TrimPrefix(strings.TrimSpace(name), "*.")) == "" {
+		return common.ListInventoryRecords(filter)
+	}
+	records, err := common.ListInventoryRecordsByDomain(strings.TrimPrefix(strings.TrimSpace(name), "*."))
+	if err != nil {
+		return nil, err
+	}
+	return filterInventory(records, filter), nil
+}
+
+func filterInventory(records []common.InventoryRecord, filter func(common.InventoryRecord) bool) []common.InventoryRecord {
+	var filtered []common.InventoryRecord
+	for _, r := range records {
+		if filter(r) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// normalizeHex makes serial numbers and thumbprints in different notations comparable.
+func normalizeHex(s string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(s), ":", "", -1))
+}
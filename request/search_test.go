@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"testing"
+	"time"
+)
+
+func TestMatchesSearch(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	r := common.InventoryRecord{
+		DomainName:              "www.example.com",
+		SubjectAlternativeNames: []string{"www.example.com", "example.com"},
+		Serial:                  "6b:6c:f0:fe",
+		Thumbprint:              "A94A8FE5CCB19BA61C4C0873D391E987982FBBD3",
+		Status:                  "ISSUED",
+		NotAfter:                now.AddDate(0, 1, 0),
+		Zone:                    "Default",
+		RequestedBy:             "arn:aws:sts::123456789012:assumed-role/Deployer/build-17",
+	}
+	cases := []struct {
+		name  string
+		input SearchCertificatesInput
+		want  bool
+	}{
+		{"domain", SearchCertificatesInput{Domain: "example.com"}, true},
+		{"other domain", SearchCertificatesInput{Domain: "example.org"}, false},
+		{"SAN", SearchCertificatesInput{SAN: "EXAMPLE.com"}, true},
+		{"SAN is exact", SearchCertificatesInput{SAN: "api.example.com"}, false},
+		{"serial without colons", SearchCertificatesInput{Serial: "6B6CF0FE"}, true},
+		{"thumbprint", SearchCertificatesInput{Thumbprint: "a9:4a:8f:e5:cc:b1:9b:a6:1c:4c:08:73:d3:91:e9:87:98:2f:bb:d3"}, true},
+		{"zone", SearchCertificatesInput{Domain: "example.com", VenafiZone: "Other"}, false},
+		{"requester role", SearchCertificatesInput{RequestedBy: "arn:aws:iam::123456789012:role/Deployer"}, true},
+		{"other requester", SearchCertificatesInput{RequestedBy: "arn:aws:iam::123456789012:role/Admin"}, false},
+	}
+	for _, c := range cases {
+		if got := matchesSearch(r, c.input, now); got != c.want {
+			t.Errorf("%s: matchesSearch() = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	r.Status = common.InventoryStatusRevoked
+	if matchesSearch(r, SearchCertificatesInput{Domain: "example.com"}, now) {
+		t.Error("revoked certificate must not match by default")
+	}
+	if !matchesSearch(r, SearchCertificatesInput{Domain: "example.com", IncludeInactive: true}, now) {
+		t.Error("revoked certificate must match with IncludeInactive")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ram"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"strings"
+	"sync"
+)
+
+const ramCertificateAuthorityType = "acm-pca:CertificateAuthority"
+
+// lambdaAccountID is the account the Lambda runs in. It is resolved once per container.
+var lambdaAccountID struct {
+	sync.Mutex
+	id string
+}
+
+// caInfo describes a private CA referenced by a request.
+type caInfo struct {
+	Arn    arn.ARN
+	Shared bool
+}
+
+// resolveCA parses a CA ARN and detects if the CA belongs to another account, i.e. it is shared via AWS RAM.
+func resolveCA(ctx context.Context, cfg aws.Config, caArn string) (info caInfo, err error) {
+	caArn = strings.TrimSpace(caArn)
+	info.Arn, err = arn.Parse(caArn)
+	if err != nil {
+		return info, fmt.Errorf("invalid certificate authority ARN %q: %s", caArn, err)
+	}
+	if info.Arn.Service != "acm-pca" || !strings.HasPrefix(info.Arn.Resource, "certificate-authority/") {
+		return info, fmt.Errorf("ARN %q is not an ACM PCA certificate authority", caArn)
+	}
+	account, err := configAccountID(ctx, cfg)
+	if err != nil {
+		return info, err
+	}
+	info.Shared = info.Arn.AccountID != account
+	return info, nil
+}
+
+// checkSharedCAEntitlement verifies that a CA owned by another account is shared with the requesting account via AWS RAM.
+func checkSharedCAEntitlement(ctx context.Context, cfg aws.Config, info caInfo) error {
+	if !info.Shared {
+		return nil
+	}
+	// RAM shares are regional, so look up the share in the CA region.
+	cfg = cfg.Copy()
+	cfg.Region = info.Arn.Region
+	resp, err := ram.New(cfg).ListResourcesRequest(&ram.ListResourcesInput{
+		ResourceOwner: ram.ResourceOwnerOtherAccounts,
+		ResourceType:  aws.String(ramCertificateAuthorityType),
+		ResourceArns:  []string{info.Arn.String()},
+	}).Send(ctx)
+	if err != nil {
+		return fmt.Errorf("can't check AWS RAM share for certificate authority %s: %s", info.Arn, err)
+	}
+	for _, r := range resp.Resources {
+		if r.Arn != nil && *r.Arn == info.Arn.String() && r.Status == ram.ResourceStatusAvailable {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate authority %s belongs to account %s and is not shared with this account via AWS RAM", info.Arn, info.Arn.AccountID)
+}
+
+// configAccountID returns the account requests made with the configuration act in.
+func configAccountID(ctx context.Context, cfg aws.Config) (string, error) {
+	if p, ok := cfg.Credentials.(*stscreds.AssumeRoleProvider); ok {
+		roleArn, err := arn.Parse(p.RoleARN)
+		if err != nil {
+			return "", fmt.Errorf("invalid role ARN %q: %s", p.RoleARN, err)
+		}
+		return roleArn.AccountID, nil
+	}
+	return getLambdaAccountID(ctx, cfg)
+}
+
+func getLambdaAccountID(ctx context.Context, cfg aws.Config) (string, error) {
+	lambdaAccountID.Lock()
+	defer lambdaAccountID.Unlock()
+	if lambdaAccountID.id != "" {
+		return lambdaAccountID.id, nil
+	}
+	resp, err := sts.New(cfg).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{}).Send(ctx)
+	if err != nil {
+		return "", fmt.Errorf("can't get Lambda account ID: %s", err)
+	}
+	lambdaAccountID.id = *resp.Account
+	return lambdaAccountID.id, nil
+}
+
+// sharedCAPassThruError returns the error for pass-through targets which AWS RAM does not permit on shared CAs.
+func sharedCAPassThruError(target string, info caInfo) error {
+	return fmt.Errorf("%s is not permitted on certificate authority %s shared via AWS RAM, send it from the owning account %s",
+		target, info.Arn, info.Arn.AccountID)
+}
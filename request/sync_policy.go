@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"log"
+	"net/http"
+)
+
+const venafiSyncPolicy = "Venafi.SyncPolicy"
+
+// policyLambdaName is the policy Lambda invoked to sync policies on demand.
+var policyLambdaName string
+
+// SyncPolicyInput syncs the policy of VenafiZone, or with All the policies of all zones in the database.
+type SyncPolicyInput struct {
+	VenafiZone string
+	All        bool
+}
+
+type SyncPolicyOutput struct {
+	Synced  []string
+	Deleted []string
+}
+
+// syncPolicy reads policies from Venafi right away instead of waiting for the scheduled sync, e.g. after a policy
+// change in Venafi. The request Lambda has no Venafi connection, so the policy Lambda is invoked to sync them.
+func syncPolicy(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	admin := request.RequestContext.Identity.UserArn
+	if !principalAllowed(admin, policyAdmins) {
+		log.Printf("AUDIT: %s is not allowed to sync policies", admin)
+		return clientError(http.StatusForbidden, "Caller is not allowed to sync policies")
+	}
+	if common.IsRemotePolicyTable() {
+		return clientError(http.StatusConflict, "Policy table is in another account, policies must be synced in the central account")
+	}
+	if policyLambdaName == "" {
+		return clientError(http.StatusNotImplemented, "Policy Lambda is not configured, set POLICY_LAMBDA_NAME")
+	}
+	var input SyncPolicyInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiSyncPolicy, err))
+	}
+	if (input.VenafiZone == "") == !input.All {
+		return clientError(http.StatusBadRequest, "Either VenafiZone or All is required")
+	}
+	payload, err := json.Marshal(common.PolicySyncRequest{VenafiZone: input.VenafiZone})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, err.Error())
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Can`t load client config: %v", err))
+	}
+	resp, err := lambda.New(cfg).InvokeRequest(&lambda.InvokeInput{
+		FunctionName: aws.String(policyLambdaName),
+		Payload:      payload,
+	}).Send(ctx)
+	if err != nil {
+		log.Println(err)
+		return clientError(http.StatusBadGateway, fmt.Sprintf("Failed to invoke policy Lambda: %s", err))
+	}
+	if resp.FunctionError != nil {
+		log.Printf("Policy Lambda failed: %s", resp.Payload)
+		return clientError(http.StatusBadGateway, fmt.Sprintf("Policy sync failed: %s", lambdaErrorMessage(resp.Payload)))
+	}
+	var result common.PolicySyncResult
+	err = json.Unmarshal(resp.Payload, &result)
+	if err != nil {
+		return clientError(http.StatusBadGateway, fmt.Sprintf("Unexpected policy Lambda response: %s", err))
+	}
+	if input.All {
+		forgetPolicies()
+	} else {
+		forgetPolicies(input.VenafiZone)
+	}
+	log.Printf("AUDIT: %s synced policies of zones %v, deleted zones %v", admin, result.Synced, result.Deleted)
+	return jsonResponse(venafiSyncPolicy, SyncPolicyOutput{Synced: result.Synced, Deleted: result.Deleted})
+}
+
+// lambdaErrorMessage returns the message of an error returned by a Go Lambda.
+func lambdaErrorMessage(payload []byte) string {
+	var e struct {
+		ErrorMessage string `json:"errorMessage"`
+	}
+	if json.Unmarshal(payload, &e) != nil || e.ErrorMessage == "" {
+		return string(payload)
+	}
+	return e.ErrorMessage
+}
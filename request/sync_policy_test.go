@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"testing"
+)
+
+func TestSyncPolicyInput(t *testing.T) {
+	admin := "arn:aws:iam::123456789012:role/PolicyAdmin"
+	policyAdmins = []string{admin}
+	policyLambdaName = "VenafiCertPolicyLambda"
+	defer func() { policyAdmins, policyLambdaName = nil, "" }()
+	var request events.APIGatewayProxyRequest
+	request.RequestContext.Identity.UserArn = "arn:aws:iam::123456789012:role/Developer"
+	request.Body = `{"VenafiZone": "Default"}`
+	if resp, _ := syncPolicy(context.TODO(), request); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("sync by other principals should be forbidden, got %d", resp.StatusCode)
+	}
+	request.RequestContext.Identity.UserArn = admin
+	for _, body := range []string{`{}`, `{"VenafiZone": "Default", "All": true}`} {
+		request.Body = body
+		if resp, _ := syncPolicy(context.TODO(), request); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("request %s should be rejected, got %d", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestLambdaErrorMessage(t *testing.T) {
+	if msg := lambdaErrorMessage([]byte(`{"errorMessage": "vcert error", "errorType": "errorString"}`)); msg != "vcert error" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if msg := lambdaErrorMessage([]byte(`timeout`)); msg != "timeout" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
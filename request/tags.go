@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"log"
+	"net/http"
+	"os"
+)
+
+const (
+	acmAddTagsToCertificate      = "CertificateManagerAddTagsToCertificate"
+	acmRemoveTagsFromCertificate = "CertificateManagerRemoveTagsFromCertificate"
+	acmListTagsForCertificate    = "CertificateManagerListTagsForCertificate"
+)
+
+// Tags identifying who requested a certificate through the proxy
+const (
+	tagRequestedByArn = "RequestedByArn"
+	tagSourceAccount  = "SourceAccount"
+	tagSourceIP       = "SourceIP"
+)
+
+// zoneTagKey is the key of the tag holding the zone of certificates requested through the proxy, no zone tag is added
+// when it's empty.
+var zoneTagKey = "VenafiZone"
+
+func loadZoneTagKey() {
+	zoneTagKey = "VenafiZone"
+	if k, ok := os.LookupEnv("ZONE_TAG_KEY"); ok {
+		zoneTagKey = k
+	}
+}
+
+// zoneFromTags returns the zone of a request from its zone tag, so ACM automation which already tags certificates with
+// the zone doesn't need to set VenafiZone. The zone is validated like VenafiZone.
+func zoneFromTags(tags []acm.Tag) string {
+	if zoneTagKey == "" {
+		return ""
+	}
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == zoneTagKey {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return ""
+}
+
+// requesterTags returns tags describing the caller of the request and the zone it was validated in, so certificate
+// ownership is discoverable in AWS.
+func requesterTags(request events.APIGatewayProxyRequest, zone string) []acm.Tag {
+	identity := request.RequestContext.Identity
+	var tags []acm.Tag
+	for _, t := range []struct{ key, value string }{
+		{tagRequestedByArn, identity.UserArn},
+		{tagSourceAccount, identity.AccountID},
+		{tagSourceIP, identity.SourceIP},
+		{zoneTagKey, zone},
+	} {
+		if t.key == "" {
+			continue
+		}
+		if t.value != "" {
+			tags = append(tags, acm.Tag{Key: aws.String(t.key), Value: aws.String(t.value)})
+		}
+	}
+	return tags
+}
+
+// mergeTags adds requester tags to the request tags. Request tags with a reserved key are dropped, also if the proxy
+// has no value for it, e.g. for the source IP of requests without one, so callers can't claim another identity.
+func mergeTags(tags, requester []acm.Tag) []acm.Tag {
+	reserved := reservedTagKeys()
+	merged := make([]acm.Tag, 0, len(tags)+len(requester))
+	for _, t := range tags {
+		if !stringInSlice(aws.StringValue(t.Key), reserved) {
+			merged = append(merged, t)
+		}
+	}
+	return append(merged, requester...)
+}
+
+func tagCertificate(ctx context.Context, cli *acm.Client, certificateArn *string, tags []acm.Tag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := cli.AddTagsToCertificateRequest(&acm.AddTagsToCertificateInput{
+		CertificateArn: certificateArn,
+		Tags:           tags,
+	}).Send(ctx)
+	return err
+}
+
+// checkRequiredTags rejects requests missing tags required by the zone. Tags with empty values count as missing.
+func checkRequiredTags(zoneConfig common.ZoneConfig, tags []acm.Tag) error {
+	var missing []string
+	for _, key := range zoneConfig.RequiredTags {
+		found := false
+		for _, t := range tags {
+			if aws.StringValue(t.Key) == key && aws.StringValue(t.Value) != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required tags are missing: %v", missing)
+	}
+	return nil
+}
+
+// tagsMap converts tags to a map for the inventory.
+func tagsMap(tags []acm.Tag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return m
+}
+
+// reservedTagKeys are keys of tags set by the proxy, callers can't change them.
+func reservedTagKeys() []string {
+	keys := []string{tagRequestedByArn, tagSourceAccount, tagSourceIP}
+	if zoneTagKey != "" {
+		keys = append(keys, zoneTagKey)
+	}
+	return keys
+}
+
+// tagsPassThru adds, removes and lists tags of ACM certificates. Tags set by the proxy can't be changed, so callers
+// can't hide who requested a certificate or claim another zone. The inventory follows tag changes, so certificate
+// owners are found by their current tags.
+func tagsPassThru(ctx context.Context, cli *acm.Client, request events.APIGatewayProxyRequest, target string) (events.APIGatewayProxyResponse, error) {
+	var input struct {
+		CertificateArn *string
+		Tags           []acm.Tag
+	}
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, target, err))
+	}
+	var output interface{}
+	switch target {
+	case acmAddTagsToCertificate, acmRemoveTagsFromCertificate:
+		for _, t := range input.Tags {
+			if stringInSlice(aws.StringValue(t.Key), reservedTagKeys()) {
+				return clientError(http.StatusForbidden, fmt.Sprintf("tag %s is set by the proxy and can't be changed", aws.StringValue(t.Key)))
+			}
+		}
+		if target == acmAddTagsToCertificate {
+			resp, err := cli.AddTagsToCertificateRequest(&acm.AddTagsToCertificateInput{CertificateArn: input.CertificateArn, Tags: input.Tags}).Send(ctx)
+			if err != nil {
+				return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, target, err))
+			}
+			output = resp.AddTagsToCertificateOutput
+		} else {
+			resp, err := cli.RemoveTagsFromCertificateRequest(&acm.RemoveTagsFromCertificateInput{CertificateArn: input.CertificateArn, Tags: input.Tags}).Send(ctx)
+			if err != nil {
+				return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, target, err))
+			}
+			output = resp.RemoveTagsFromCertificateOutput
+		}
+		updateInventoryTags(aws.StringValue(input.CertificateArn), input.Tags, target == acmRemoveTagsFromCertificate)
+	case acmListTagsForCertificate:
+		resp, err := cli.ListTagsForCertificateRequest(&acm.ListTagsForCertificateInput{CertificateArn: input.CertificateArn}).Send(ctx)
+		if err != nil {
+			return clientError(acmErrorStatus(err), fmt.Sprintf(errNoResponse, target, err))
+		}
+		output = resp.ListTagsForCertificateOutput
+	}
+	return jsonResponse(target, output)
+}
+
+// updateInventoryTags applies added or removed tags to the inventory record of a certificate. Tags are removed only if
+// the value matches or the removed tag has no value, as ACM does.
+func updateInventoryTags(certificateArn string, tags []acm.Tag, remove bool) {
+	r, err := common.GetInventoryRecord(certificateArn)
+	if err != nil {
+		if err != common.CertificateNotFound {
+			log.Printf("Can't get certificate %s from inventory: %s", certificateArn, err)
+		}
+		return
+	}
+	r.Tags = changeTags(r.Tags, tags, remove)
+	err = common.SaveInventoryRecord(r)
+	if err != nil {
+		log.Printf("Can't update tags of certificate %s in inventory: %s", certificateArn, err)
+	}
+}
+
+func changeTags(m map[string]string, tags []acm.Tag, remove bool) map[string]string {
+	if m == nil {
+		m = make(map[string]string, len(tags))
+	}
+	for _, t := range tags {
+		key := aws.StringValue(t.Key)
+		if !remove {
+			m[key] = aws.StringValue(t.Value)
+		} else if current, ok := m[key]; ok && (t.Value == nil || *t.Value == current) {
+			delete(m, key)
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"strings"
+	"testing"
+)
+
+func TestRequesterTags(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	request.RequestContext.Identity.UserArn = "arn:aws:sts::123456789012:assumed-role/Deployer/pipeline"
+	request.RequestContext.Identity.AccountID = "123456789012"
+	tags := requesterTags(request, "")
+	if len(tags) != 2 {
+		t.Fatalf("expected 2 tags, got %d", len(tags))
+	}
+	if aws.StringValue(tags[0].Key) != tagRequestedByArn || aws.StringValue(tags[0].Value) != request.RequestContext.Identity.UserArn {
+		t.Errorf("unexpected tag %s=%s", aws.StringValue(tags[0].Key), aws.StringValue(tags[0].Value))
+	}
+	if aws.StringValue(tags[1].Key) != tagSourceAccount {
+		t.Errorf("unexpected tag %s", aws.StringValue(tags[1].Key))
+	}
+	tags = requesterTags(request, "Default")
+	if len(tags) != 3 || aws.StringValue(tags[2].Key) != zoneTagKey || aws.StringValue(tags[2].Value) != "Default" {
+		t.Errorf("zone tag is missing: %v", tags)
+	}
+}
+
+func TestZoneFromTags(t *testing.T) {
+	tags := []acm.Tag{
+		{Key: aws.String("Owner"), Value: aws.String("team-a")},
+		{Key: aws.String(zoneTagKey), Value: aws.String("TeamA\\Prod")},
+	}
+	if zone := zoneFromTags(tags); zone != "TeamA\\Prod" {
+		t.Errorf("unexpected zone %q", zone)
+	}
+	if zone := zoneFromTags(tags[:1]); zone != "" {
+		t.Errorf("request without zone tag should have no zone, got %q", zone)
+	}
+	zoneTagKey = ""
+	defer loadZoneTagKey()
+	if zone := zoneFromTags(tags); zone != "" {
+		t.Errorf("zone should not be read from tags without a zone tag key, got %q", zone)
+	}
+}
+
+func TestCheckRequiredTags(t *testing.T) {
+	zoneConfig := common.ZoneConfig{RequiredTags: []string{"CostCenter", "Owner", "DataClassification"}}
+	tags := []acm.Tag{
+		{Key: aws.String("CostCenter"), Value: aws.String("1234")},
+		{Key: aws.String("Owner"), Value: aws.String("")},
+	}
+	err := checkRequiredTags(zoneConfig, tags)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "Owner DataClassification") || strings.Contains(err.Error(), "CostCenter") {
+		t.Errorf("unexpected missing tags: %s", err)
+	}
+	tags[1].Value = aws.String("team-a")
+	tags = append(tags, acm.Tag{Key: aws.String("DataClassification"), Value: aws.String("internal")})
+	if err := checkRequiredTags(zoneConfig, tags); err != nil {
+		t.Error(err)
+	}
+	if err := checkRequiredTags(common.ZoneConfig{}, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	tags := []acm.Tag{
+		{Key: aws.String("Owner"), Value: aws.String("team-a")},
+		{Key: aws.String(tagRequestedByArn), Value: aws.String("arn:aws:iam::123456789012:user/someone-else")},
+	}
+	requester := []acm.Tag{{Key: aws.String(tagRequestedByArn), Value: aws.String("arn:aws:iam::123456789012:user/caller")}}
+	merged := mergeTags(tags, requester)
+	if len(merged) != 2 || aws.StringValue(merged[1].Value) != "arn:aws:iam::123456789012:user/caller" {
+		t.Errorf("requester tags should replace request tags: %v", merged)
+	}
+	tags = append(tags, acm.Tag{Key: aws.String(tagSourceIP), Value: aws.String("203.0.113.7")})
+	merged = mergeTags(tags, requester)
+	if len(merged) != 2 {
+		t.Errorf("reserved tags should be dropped even without a requester value: %v", merged)
+	}
+}
+
+func TestChangeTags(t *testing.T) {
+	m := changeTags(nil, []acm.Tag{{Key: aws.String("Owner"), Value: aws.String("team-a")}}, false)
+	if m["Owner"] != "team-a" {
+		t.Fatalf("tag should be added: %v", m)
+	}
+	m = changeTags(m, []acm.Tag{{Key: aws.String("Owner"), Value: aws.String("team-b")}}, true)
+	if m["Owner"] != "team-a" {
+		t.Errorf("tag with another value should be kept: %v", m)
+	}
+	m = changeTags(m, []acm.Tag{{Key: aws.String("Owner")}}, true)
+	if m != nil {
+		t.Errorf("tag without value should be removed: %v", m)
+	}
+	if !stringInSlice(zoneTagKey, reservedTagKeys()) {
+		t.Error("zone tag should be reserved")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minThrottleDelay = 100 * time.Millisecond
+	maxThrottleDelay = 4 * time.Second
+
+	defaultThrottleRetryBudget = 5 * time.Second
+)
+
+// throttleRetryBudget is how long a request retries throttled ACM and ACM PCA calls before it gives up or is queued.
+// It has to leave room within the Lambda timeout for the rest of the request.
+var throttleRetryBudget = defaultThrottleRetryBudget
+
+// throttleBackoff is the delay before a throttled call is retried. It's shared by requests of the container, so
+// a container under sustained throttling starts its next retries with a longer delay.
+var throttleBackoff = struct {
+	sync.Mutex
+	delay time.Duration
+}{delay: minThrottleDelay}
+
+// throttledError is returned when a call is still throttled and there's no time left to retry it.
+type throttledError struct {
+	err error
+}
+
+func (e throttledError) Error() string {
+	return "request is throttled: " + e.err.Error()
+}
+
+func isThrottled(err error) bool {
+	_, ok := err.(throttledError)
+	return ok
+}
+
+func isThrottlingError(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "ThrottlingException", "Throttling", "TooManyRequestsException", "RequestLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// adaptThrottleDelay doubles the delay after a throttled call and halves it after a successful one.
+func adaptThrottleDelay(throttled bool) time.Duration {
+	throttleBackoff.Lock()
+	defer throttleBackoff.Unlock()
+	if throttled {
+		throttleBackoff.delay *= 2
+		if throttleBackoff.delay > maxThrottleDelay {
+			throttleBackoff.delay = maxThrottleDelay
+		}
+	} else {
+		throttleBackoff.delay /= 2
+		if throttleBackoff.delay < minThrottleDelay {
+			throttleBackoff.delay = minThrottleDelay
+		}
+	}
+	return throttleBackoff.delay
+}
+
+// retryThrottled calls the function until it's not throttled. It returns throttledError if the next retry would
+// happen after the deadline.
+func retryThrottled(deadline time.Time, call func() error) error {
+	for {
+		err := call()
+		if !isThrottlingError(err) {
+			if err == nil {
+				adaptThrottleDelay(false)
+			}
+			return err
+		}
+		delay := adaptThrottleDelay(true)
+		// Jitter spreads retries of concurrent requests.
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if time.Now().Add(delay).After(deadline) {
+			return throttledError{err}
+		}
+		log.Printf("Request throttled, retrying in %s: %s", delay, err)
+		time.Sleep(delay)
+	}
+}
+
+func loadThrottleRetryBudget() {
+	throttleRetryBudget = defaultThrottleRetryBudget
+	if s := os.Getenv("THROTTLE_RETRY_SECONDS"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			log.Printf("Invalid THROTTLE_RETRY_SECONDS %q, using %s", s, defaultThrottleRetryBudget)
+		} else {
+			throttleRetryBudget = time.Duration(v) * time.Second
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsThrottlingError(t *testing.T) {
+	if !isThrottlingError(awserr.New("ThrottlingException", "rate exceeded", nil)) {
+		t.Fatal("ThrottlingException should be throttling")
+	}
+	if isThrottlingError(awserr.New(acmpca.ErrCodeLimitExceededException, "quota exceeded", nil)) {
+		t.Fatal("quota errors should not be retried")
+	}
+}
+
+func TestAdaptThrottleDelay(t *testing.T) {
+	throttleBackoff.delay = minThrottleDelay
+	for i := 0; i < 10; i++ {
+		adaptThrottleDelay(true)
+	}
+	if d := adaptThrottleDelay(true); d != maxThrottleDelay {
+		t.Fatalf("delay should be capped at %s, got %s", maxThrottleDelay, d)
+	}
+	if d := adaptThrottleDelay(false); d != maxThrottleDelay/2 {
+		t.Fatalf("delay should halve after success, got %s", d)
+	}
+	throttleBackoff.delay = minThrottleDelay
+}
+
+func TestRetryThrottled(t *testing.T) {
+	throttleBackoff.delay = minThrottleDelay
+	throttling := awserr.New("ThrottlingException", "rate exceeded", nil)
+	calls := 0
+	err := retryThrottled(time.Now().Add(time.Minute), func() error {
+		calls++
+		if calls < 2 {
+			return throttling
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expected success after a retry, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = retryThrottled(time.Now(), func() error {
+		calls++
+		return throttling
+	})
+	if !isThrottled(err) || calls != 1 {
+		t.Fatalf("expected throttled error without retry past deadline, got %v after %d calls", err, calls)
+	}
+}
+
+func TestQueuedIssuanceRoundTrip(t *testing.T) {
+	q := queuedIssuance{
+		RequestID: "req1",
+		Target:    acmpcaIssueCertificate,
+		IssueCertificateInput: &acmpca.IssueCertificateInput{
+			Csr:              []byte("-----BEGIN CERTIFICATE REQUEST-----"),
+			SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa,
+			Validity:         &acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(30)},
+		},
+		FailoverCAs: []string{"ca2"},
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded queuedIssuance
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(q, decoded) {
+		t.Fatalf("queued request changed in the queue: %+v", decoded)
+	}
+}
+
+func TestLastDelivery(t *testing.T) {
+	cases := map[string]bool{"1": false, "9": false, "10": true, "": false}
+	for count, want := range cases {
+		m := events.SQSMessage{Attributes: map[string]string{"ApproximateReceiveCount": count}}
+		if got := lastDelivery(m); got != want {
+			t.Errorf("receive count %q: got %v, want %v", count, got, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported ticket systems
+const (
+	ticketSystemJira       = "jira"
+	ticketSystemServiceNow = "servicenow"
+)
+
+const defaultDenialTicketThreshold = 5
+
+// denialWindow is the period denials of a requester in a zone are counted in.
+const denialWindow = time.Hour
+
+type ticketSettings struct {
+	// System is "jira" or "servicenow", tickets are disabled when empty.
+	System   string
+	URL      string
+	User     string
+	Password string
+	// Queue is the Jira project key or the ServiceNow assignment group.
+	Queue string
+	// Threshold is the number of denials in the window after which a ticket is opened.
+	Threshold int64
+}
+
+var tickets ticketSettings
+
+var ticketClient = &http.Client{Timeout: 10 * time.Second}
+
+// openDenialTicket counts denials of the requester in the zone and opens a ticket once they exceed the threshold
+// within the window. Only one ticket is opened per window. Tickets are best effort, failures are only logged.
+func openDenialTicket(ctx context.Context, request events.APIGatewayProxyRequest, target string, response events.APIGatewayProxyResponse) {
+	if tickets.System == "" {
+		return
+	}
+	now := time.Now()
+	status := newRequestStatus(request, target, response, now)
+	if status.Status != common.RequestRejected {
+		return
+	}
+	if status.Zone == "" {
+		status.Zone = defaultZone
+	}
+	window := now.UTC().Truncate(denialWindow)
+	key := strings.Join([]string{status.CallerArn, status.Zone, window.Format(time.RFC3339)}, "#")
+	count, err := common.IncrementDenialCount(key, window.Add(2*denialWindow))
+	if err != nil {
+		log.Printf("Can't count denial of request %s: %s", status.RequestID, err)
+		return
+	}
+	if count != tickets.Threshold+1 {
+		return
+	}
+	summary := fmt.Sprintf("Repeated certificate request denials for %s in zone %s", status.CallerArn, status.Zone)
+	description := fmt.Sprintf("%s (account %s) had more than %d certificate requests denied in Venafi zone %s since %s.\n\n"+
+		"Last violation: %s\nLast request ID: %s\n",
+		status.CallerArn, status.SourceAccount, tickets.Threshold, status.Zone, window.Format(time.RFC1123), status.Message, status.RequestID)
+	id, err := createTicket(ctx, tickets, summary, description)
+	if err != nil {
+		log.Printf("Can't open %s ticket about denials of %s: %s", tickets.System, status.CallerArn, err)
+		return
+	}
+	log.Printf("Opened %s ticket %s about denials of %s in zone %s", tickets.System, id, status.CallerArn, status.Zone)
+}
+
+// createTicket opens a ticket and returns its ID.
+func createTicket(ctx context.Context, s ticketSettings, summary, description string) (string, error) {
+	var url string
+	var payload interface{}
+	switch s.System {
+	case ticketSystemJira:
+		url = strings.TrimSuffix(s.URL, "/") + "/rest/api/2/issue"
+		payload = map[string]interface{}{
+			"fields": map[string]interface{}{
+				"project":     map[string]string{"key": s.Queue},
+				"summary":     summary,
+				"description": description,
+				"issuetype":   map[string]string{"name": "Task"},
+			},
+		}
+	case ticketSystemServiceNow:
+		url = strings.TrimSuffix(s.URL, "/") + "/api/now/table/incident"
+		incident := map[string]string{
+			"short_description": summary,
+			"description":       description,
+		}
+		if s.Queue != "" {
+			incident["assignment_group"] = s.Queue
+		}
+		payload = incident
+	default:
+		return "", fmt.Errorf("unknown ticket system %q", s.System)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(s.User, s.Password)
+	resp, err := ticketClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("status %s: %s", resp.Status, respBody)
+	}
+	var created struct {
+		// Jira
+		Key string `json:"key"`
+		// ServiceNow
+		Result struct {
+			Number string `json:"number"`
+		} `json:"result"`
+	}
+	_ = json.Unmarshal(respBody, &created)
+	if created.Key != "" {
+		return created.Key, nil
+	}
+	return created.Result.Number, nil
+}
+
+func loadTicketSettings() {
+	tickets = ticketSettings{
+		System:    strings.ToLower(strings.TrimSpace(os.Getenv("TICKET_SYSTEM"))),
+		URL:       os.Getenv("TICKET_URL"),
+		User:      os.Getenv("TICKET_USER"),
+		Password:  os.Getenv("TICKET_PASSWORD"),
+		Queue:     os.Getenv("TICKET_QUEUE"),
+		Threshold: defaultDenialTicketThreshold,
+	}
+	if v := os.Getenv("DENIAL_TICKET_THRESHOLD"); v != "" {
+		threshold, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || threshold < 0 {
+			log.Printf("Invalid DENIAL_TICKET_THRESHOLD %q, using %d", v, defaultDenialTicketThreshold)
+		} else {
+			tickets.Threshold = threshold
+		}
+	}
+	if tickets.System != "" && tickets.System != ticketSystemJira && tickets.System != ticketSystemServiceNow {
+		log.Printf("Unknown TICKET_SYSTEM %q, tickets are disabled", tickets.System)
+		tickets.System = ""
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateTicket(t *testing.T) {
+	var gotPath, gotUser string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUser, _, _ = r.BasicAuth()
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusCreated)
+		if r.URL.Path == "/rest/api/2/issue" {
+			_, _ = w.Write([]byte(`{"id": "10000", "key": "PKI-24"}`))
+		} else {
+			_, _ = w.Write([]byte(`{"result": {"number": "INC0010002"}}`))
+		}
+	}))
+	defer server.Close()
+
+	s := ticketSettings{System: ticketSystemJira, URL: server.URL + "/", User: "bot", Password: "secret", Queue: "PKI"}
+	id, err := createTicket(context.Background(), s, "summary", "description")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "PKI-24" || gotPath != "/rest/api/2/issue" || gotUser != "bot" {
+		t.Errorf("unexpected Jira ticket %s at %s by %s", id, gotPath, gotUser)
+	}
+	fields := gotBody["fields"].(map[string]interface{})
+	if fields["project"].(map[string]interface{})["key"] != "PKI" || fields["summary"] != "summary" {
+		t.Errorf("unexpected Jira fields %v", fields)
+	}
+
+	s.System = ticketSystemServiceNow
+	id, err = createTicket(context.Background(), s, "summary", "description")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "INC0010002" || gotPath != "/api/now/table/incident" || gotBody["assignment_group"] != "PKI" {
+		t.Errorf("unexpected ServiceNow ticket %s at %s: %v", id, gotPath, gotBody)
+	}
+}
+
+func TestCreateTicketError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	_, err := createTicket(context.Background(), ticketSettings{System: ticketSystemJira, URL: server.URL}, "s", "d")
+	if err == nil {
+		t.Error("error status must fail")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"time"
+)
+
+// trace is the X-Ray trace of the current invocation, nil when the invocation isn't traced.
+var trace *common.Trace
+
+// TracedHandler is the Lambda handler of API requests. It traces the invocation with the X-Ray trace header of the
+// context, so the steps of the request show up as subsegments of the Lambda segment, keeps the deadline of the
+// invocation for waitDeadline and passes the request on to ACMPCAHandler.
+func TracedHandler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	header, _ := ctx.Value("x-amzn-trace-id").(string)
+	trace = common.StartTrace(header)
+	invocationDeadline, _ = ctx.Deadline()
+	defer func() {
+		trace.Close()
+		trace = nil
+		invocationDeadline = time.Time{}
+	}()
+	return ACMPCAHandler(request)
+}
+
+// beginPolicyFetch starts the subsegment of reading the policy of the zone.
+func beginPolicyFetch(zone string) *common.Subsegment {
+	s := trace.BeginSubsegment("GetPolicy", "")
+	s.Annotate("zone", zone)
+	return s
+}
+
+// closePolicyFetch ends the subsegment of reading a policy. Missing policies are a client error, not a fault.
+func closePolicyFetch(s *common.Subsegment, err error) {
+	if err == common.PolicyNotFound {
+		err = nil
+	}
+	s.Close(err)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPolicyFetchSubsegment(t *testing.T) {
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+	defer os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
+	os.Setenv("AWS_XRAY_DAEMON_ADDRESS", daemon.LocalAddr().String())
+	trace = common.StartTrace("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	defer func() {
+		trace.Close()
+		trace = nil
+	}()
+
+	s := beginPolicyFetch("Default")
+	closePolicyFetch(s, common.PolicyNotFound)
+	b := make([]byte, 4096)
+	n, _, err := daemon.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Name        string
+		Fault       bool
+		Annotations map[string]string
+	}
+	err = json.Unmarshal([]byte(strings.SplitN(string(b[:n]), "\n", 2)[1]), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Name != "GetPolicy" || doc.Fault || doc.Annotations["zone"] != "Default" {
+		t.Errorf("missing policy should not be a fault, got %+v", doc)
+	}
+}
+
+func TestTracedHandlerWithoutTrace(t *testing.T) {
+	response, err := TracedHandler(context.Background(), events.APIGatewayProxyRequest{Headers: map[string]string{"X-Amz-Target": "Unknown"}})
+	if err != nil || response.StatusCode != 405 {
+		t.Errorf("unexpected response %+v %v", response, err)
+	}
+	if trace != nil {
+		t.Error("trace should be reset after the invocation")
+	}
+}
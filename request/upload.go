@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const venafiCreateUploadURL = "Venafi.CreateUploadURL"
+
+const (
+	uploadKeyPrefix     = "uploads/"
+	uploadURLExpiration = 15 * time.Minute
+	uploadRetention     = 24 * time.Hour
+	maxUploadSize       = 5 << 20
+	maxUploadRequests   = 100
+	// uploadRequestMargin is left of the worker invocation when an uploaded request is started, more than a request
+	// takes. Uploads are continued in a new invocation once less is left.
+	uploadRequestMargin = 15 * time.Second
+)
+
+// errUploadContinued is returned for uploads whose remaining requests are continued in a new invocation.
+var errUploadContinued = errors.New("upload is continued in a new invocation")
+
+// uploadBucket is the S3 bucket request bodies too large for API Gateway are uploaded to.
+var uploadBucket string
+
+var uploadTargets = []string{acmpcaIssueCertificate, acmRequestCertificate, venafiIssueCertificates}
+
+// uploadHeaders are headers of the upload URL request which apply to the uploaded requests.
+var uploadHeaders = []string{regionHeader, viewerCountryHeader}
+
+type CreateUploadURLInput struct {
+	// Target of the uploaded requests, one of uploadTargets.
+	Target string
+}
+
+type CreateUploadURLOutput struct {
+	UploadID  string
+	UploadURL string
+	ExpiresAt time.Time
+}
+
+// UploadedRequests is the content of an upload: bodies of requests to the upload target.
+type UploadedRequests struct {
+	Requests []json.RawMessage
+}
+
+func uploadWorkerMode() bool {
+	return os.Getenv("UPLOAD_WORKER_MODE") == "true"
+}
+
+// createUploadURL returns a pre-signed URL the caller uploads request bodies to. The upload is processed when it
+// lands in the bucket, the caller follows it with Venafi.GetRequestStatus using the upload ID.
+func createUploadURL(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if uploadBucket == "" {
+		return clientError(http.StatusNotImplemented, "Uploads are not configured")
+	}
+	var input CreateUploadURLInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiCreateUploadURL, err))
+	}
+	if !stringInSlice(input.Target, uploadTargets) {
+		return clientError(http.StatusBadRequest, fmt.Sprintf("Target must be one of %v", uploadTargets))
+	}
+	// The request ID identifies the upload, so the caller can look up its status.
+	uploadID := request.RequestContext.RequestID
+	if uploadID == "" {
+		return clientError(http.StatusBadRequest, "Uploads require a request ID")
+	}
+	identity := request.RequestContext.Identity
+	now := time.Now().UTC()
+	u := common.Upload{
+		UploadID:      uploadID,
+		Target:        input.Target,
+		CallerArn:     identity.UserArn,
+		SourceAccount: identity.AccountID,
+		SourceIP:      identity.SourceIP,
+		CreatedAt:     now,
+		TTL:           now.Add(uploadRetention).Unix(),
+	}
+	for _, h := range uploadHeaders {
+		if v := request.Headers[h]; v != "" {
+			if u.Headers == nil {
+				u.Headers = map[string]string{}
+			}
+			u.Headers[h] = v
+		}
+	}
+	err = common.SaveUpload(u)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to save upload to database: %s", err))
+	}
+
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error loading client: %s", err))
+	}
+	uploadURL, err := s3.New(cfg).PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(uploadBucket),
+		Key:    aws.String(uploadKeyPrefix + uploadID),
+	}).Presign(uploadURLExpiration)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Can't create upload URL: %s", err))
+	}
+	body, err := json.Marshal(CreateUploadURLOutput{UploadID: uploadID, UploadURL: uploadURL, ExpiresAt: now.Add(uploadURLExpiration)})
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	// Accepted marks the upload status as queued until the upload is processed.
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusAccepted,
+	}, nil
+}
+
+// UploadHandler processes request bodies uploaded to the upload bucket. Every request goes through the request
+// handler like a request received by API Gateway, on behalf of the caller who created the upload URL.
+func UploadHandler(ctx context.Context, event events.S3Event) error {
+	initHandler()
+	for _, r := range event.Records {
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil || !strings.HasPrefix(key, uploadKeyPrefix) {
+			log.Printf("Ignoring object %s", r.S3.Object.Key)
+			continue
+		}
+		uploadID := strings.TrimPrefix(key, uploadKeyPrefix)
+		u, err := common.ClaimUpload(uploadID, time.Now())
+		if err == common.UploadNotFound {
+			log.Printf("Ignoring upload %s: %s", uploadID, err)
+			continue
+		} else if err != nil {
+			// The event is retried by Lambda.
+			return err
+		}
+		count, err := processUpload(ctx, r.S3.Bucket.Name, key, r.S3.Object.Size, u)
+		if err == errUploadContinued {
+			log.Printf("Upload %s is continued from request %d", uploadID, count)
+			continue
+		}
+		finishUpload(u, count, err)
+	}
+	return nil
+}
+
+// processUpload issues the uploaded requests and returns the number of requests of the upload. If the invocation runs
+// out of time, the remaining requests are put back into the bucket and errUploadContinued is returned with the index
+// of the first of them.
+func processUpload(ctx context.Context, bucket, key string, size int64, u common.Upload) (int, error) {
+	if size > maxUploadSize {
+		return 0, fmt.Errorf("upload is larger than %d bytes", maxUploadSize)
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return 0, err
+	}
+	cli := s3.New(cfg)
+	resp, err := cli.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}).Send(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("can't get upload: %s", err)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUploadSize+1))
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("can't read upload: %s", err)
+	}
+	// Uploads are processed once, they aren't kept until the bucket lifecycle deletes them.
+	_, err = cli.DeleteObjectRequest(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}).Send(ctx)
+	if err != nil {
+		log.Printf("Can't delete upload %s: %s", u.UploadID, err)
+	}
+
+	requests, err := parseUpload(b)
+	if err != nil {
+		return 0, err
+	}
+	for i, body := range requests.Requests {
+		index := u.Offset + i
+		if outOfUploadTime(ctx, time.Now()) {
+			return index, continueUpload(ctx, cli, bucket, key, u.UploadID, index, requests.Requests[i:])
+		}
+		resp, err := ACMPCAHandler(uploadedRequest(u, index, body))
+		if err != nil {
+			log.Printf("Uploaded request %d of %s failed: %s", index, u.UploadID, err)
+		} else {
+			log.Printf("Uploaded request %d of %s: status %d", index, u.UploadID, resp.StatusCode)
+		}
+	}
+	return u.Offset + len(requests.Requests), nil
+}
+
+// outOfUploadTime reports whether the invocation ends before another uploaded request can be processed.
+func outOfUploadTime(ctx context.Context, now time.Time) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && deadline.Sub(now) < uploadRequestMargin
+}
+
+// continueUpload releases the claim of the upload and puts its remaining requests back into the bucket, so they are
+// processed by the invocation of the new object with their original request IDs.
+func continueUpload(ctx context.Context, cli *s3.Client, bucket, key, uploadID string, offset int, remaining []json.RawMessage) error {
+	b, err := json.Marshal(UploadedRequests{Requests: remaining})
+	if err != nil {
+		return err
+	}
+	err = common.ReleaseUpload(uploadID, offset)
+	if err != nil {
+		return fmt.Errorf("can't continue upload from request %d: %s", offset, err)
+	}
+	_, err = cli.PutObjectRequest(&s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: bytes.NewReader(b)}).Send(ctx)
+	if err != nil {
+		return fmt.Errorf("can't continue upload from request %d: %s", offset, err)
+	}
+	return errUploadContinued
+}
+
+func parseUpload(b []byte) (requests UploadedRequests, err error) {
+	if len(b) > maxUploadSize {
+		return requests, fmt.Errorf("upload is larger than %d bytes", maxUploadSize)
+	}
+	err = json.Unmarshal(b, &requests)
+	if err != nil {
+		return requests, fmt.Errorf("invalid upload: %s", err)
+	}
+	if len(requests.Requests) == 0 {
+		return requests, fmt.Errorf("upload has no requests")
+	}
+	if len(requests.Requests) > maxUploadRequests {
+		return requests, fmt.Errorf("upload has %d requests, at most %d are allowed", len(requests.Requests), maxUploadRequests)
+	}
+	return requests, nil
+}
+
+// uploadedRequest builds the request for the i-th uploaded body. Its ID is the upload ID with the index appended.
+func uploadedRequest(u common.Upload, i int, body []byte) events.APIGatewayProxyRequest {
+	var request events.APIGatewayProxyRequest
+	request.Headers = map[string]string{"X-Amz-Target": u.Target}
+	for h, v := range u.Headers {
+		request.Headers[h] = v
+	}
+	request.Body = string(body)
+	request.RequestContext.RequestID = fmt.Sprintf("%s-%d", u.UploadID, i)
+	request.RequestContext.Identity.AccountID = u.SourceAccount
+	request.RequestContext.Identity.UserArn = u.CallerArn
+	request.RequestContext.Identity.SourceIP = u.SourceIP
+	return request
+}
+
+// finishUpload replaces the queued status of the upload with its outcome.
+func finishUpload(u common.Upload, count int, err error) {
+	status := common.RequestStatus{
+		RequestID:     u.UploadID,
+		Target:        venafiCreateUploadURL,
+		CallerArn:     u.CallerArn,
+		SourceAccount: u.SourceAccount,
+		Status:        common.RequestSucceeded,
+		StatusCode:    http.StatusOK,
+		Message: fmt.Sprintf("Processed %d requests, their request IDs are %s-0 to %s-%d",
+			count, u.UploadID, u.UploadID, count-1),
+		CreatedAt: u.CreatedAt,
+		TTL:       u.CreatedAt.Add(requestStatusRetention).Unix(),
+	}
+	if err != nil {
+		log.Printf("Upload %s rejected: %s", u.UploadID, err)
+		status.Status = common.RequestRejected
+		status.StatusCode = http.StatusBadRequest
+		status.Message = err.Error()
+	}
+	err = common.SaveRequestStatus(status)
+	if err != nil {
+		log.Printf("Can't save status of upload %s: %s", u.UploadID, err)
+	}
+}
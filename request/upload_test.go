@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUpload(t *testing.T) {
+	requests, err := parseUpload([]byte(`{"Requests": [{"Csr": "LS0t"}, {"Csr": "LS0t", "VenafiZone": "Web"}]}`))
+	if err != nil || len(requests.Requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d: %v", len(requests.Requests), err)
+	}
+	if _, err = parseUpload([]byte(`{"Requests": []}`)); err == nil {
+		t.Fatal("empty upload should be rejected")
+	}
+	tooMany := `{"Requests": [` + strings.Repeat(`{},`, maxUploadRequests) + `{}]}`
+	if _, err = parseUpload([]byte(tooMany)); err == nil {
+		t.Fatal("upload over the request limit should be rejected")
+	}
+}
+
+func TestUploadedRequest(t *testing.T) {
+	u := common.Upload{
+		UploadID:      "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+		Target:        acmpcaIssueCertificate,
+		CallerArn:     "arn:aws:iam::123456789012:user/alice",
+		SourceAccount: "123456789012",
+		Headers:       map[string]string{regionHeader: "eu-west-1"},
+	}
+	request := uploadedRequest(u, 3, []byte(`{"Csr": "LS0t"}`))
+	if request.Headers["X-Amz-Target"] != acmpcaIssueCertificate || request.Headers[regionHeader] != "eu-west-1" {
+		t.Fatalf("unexpected headers %v", request.Headers)
+	}
+	if request.RequestContext.RequestID != fmt.Sprintf("%s-3", u.UploadID) {
+		t.Fatalf("unexpected request ID %s", request.RequestContext.RequestID)
+	}
+	if request.RequestContext.Identity.AccountID != u.SourceAccount || request.RequestContext.Identity.UserArn != u.CallerArn {
+		t.Fatalf("uploaded request should have the identity of the uploader: %+v", request.RequestContext.Identity)
+	}
+}
+
+func TestOutOfUploadTime(t *testing.T) {
+	now := time.Now()
+	if outOfUploadTime(context.Background(), now) {
+		t.Error("invocation without deadline should not run out of time")
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Minute))
+	defer cancel()
+	if outOfUploadTime(ctx, now) {
+		t.Error("invocation with a minute left should process another request")
+	}
+	if !outOfUploadTime(ctx, now.Add(time.Minute-uploadRequestMargin/2)) {
+		t.Error("invocation ending before a request is processed should continue the upload")
+	}
+}
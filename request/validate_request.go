@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const venafiValidateCertificateRequest = "Venafi.ValidateCertificateRequest"
+
+// ValidateCertificateRequestInput is a certificate request validated without issuing a certificate. With Csr it's
+// validated like an IssueCertificate request, otherwise DomainName and SubjectAlternativeNames are validated like an
+// ACM RequestCertificate request.
+type ValidateCertificateRequestInput struct {
+	VenafiZone              string
+	Csr                     []byte
+	ApiPassthrough          *apiPassthrough
+	Validity                *acmpca.Validity
+	DomainName              string
+	SubjectAlternativeNames []string
+}
+
+type ValidateCertificateRequestOutput struct {
+	Zone string
+	// Valid is true when all checks passed.
+	Valid  bool
+	Checks []ValidationCheck
+}
+
+// ValidationCheck is the result of validating one field of the request against a policy or zone rule.
+type ValidationCheck struct {
+	Rule    string
+	Field   string
+	Value   string `json:",omitempty"`
+	Passed  bool
+	Message string `json:",omitempty"`
+}
+
+// validateCertificateRequest runs the policy and zone rule validation of a certificate request and reports the result
+// of every check, so requests can be checked before they use issuance quota. Checks which need network lookups, e.g.
+// domain ownership, are not run.
+func validateCertificateRequest(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var input ValidateCertificateRequestInput
+	err := json.Unmarshal([]byte(request.Body), &input)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf(errUnmarshalJson, venafiValidateCertificateRequest, err))
+	}
+	if len(input.Csr) == 0 && input.DomainName == "" {
+		return clientError(http.StatusBadRequest, "Csr or DomainName is required")
+	}
+	input.VenafiZone, err = resolveZone(request, input.VenafiZone)
+	if err != nil {
+		return zoneErrorResponse(err)
+	}
+	policy, err := getPolicy(input.VenafiZone)
+	if err == common.PolicyNotFound {
+		return handlePolicyNotFound(input.VenafiZone)
+	} else if err != nil {
+		common.Monitor.Record(common.BackendDynamoDB, err)
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get policy from database: %s", err))
+	}
+	zoneConfig, err := common.GetZoneConfig(input.VenafiZone)
+	common.Monitor.Record(common.BackendDynamoDB, err)
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+	}
+	output, err := validationReport(policy, zoneConfig, input, time.Now())
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, fmt.Sprintf("Can't parse certificate request: %s", err))
+	}
+	output.Zone = input.VenafiZone
+	return jsonResponse(venafiValidateCertificateRequest, output)
+}
+
+// validationReport validates the request field by field. The error is returned only for requests which can't be
+// parsed.
+func validationReport(p endpoint.Policy, zoneConfig common.ZoneConfig, input ValidateCertificateRequestInput, now time.Time) (ValidateCertificateRequestOutput, error) {
+	var output ValidateCertificateRequestOutput
+	check := func(rule, field, value string, err error) {
+		c := ValidationCheck{Rule: rule, Field: field, Value: value, Passed: err == nil}
+		if err != nil {
+			c.Message = err.Error()
+		}
+		output.Checks = append(output.Checks, c)
+	}
+
+	var req certificate.Request
+	zoneRulesReq := &req
+	if len(input.Csr) > 0 {
+		var err error
+		req, err = newCSRRequest(input.Csr)
+		if err != nil {
+			return output, err
+		}
+		if input.ApiPassthrough != nil {
+			check(ruleZoneRules, "ApiPassthrough", "", applyApiPassthrough(&req, input.ApiPassthrough, zoneConfig))
+		}
+	} else {
+		req.Subject.CommonName = canonicalDNSName(input.DomainName)
+		req.DNSNames = canonicalDNSNames(input.SubjectAlternativeNames)
+		zoneRulesReq = acmZoneRulesRequest(req, req.Subject.CommonName)
+	}
+
+	check(ruleZoneRules, "DeniedDomains", "", checkDeniedDomains(zoneConfig, &req))
+	policyReq, err := wildcardPolicyRequest(p, &req)
+	check(rulePolicy, "Wildcards", "", err)
+	if err != nil {
+		policyReq = &req
+	}
+	check(rulePolicy, "CommonName", req.Subject.CommonName, matchError(policyReq.Subject.CommonName, p.SubjectCNRegexes))
+	for i, name := range req.DNSNames {
+		check(rulePolicy, "DNSName", name, matchError(policyReq.DNSNames[i], p.DnsSanRegExs))
+	}
+	if len(input.Csr) > 0 {
+		for _, ip := range req.IPAddresses {
+			check(rulePolicy, "IPAddress", ip.String(), matchError(ip.String(), p.IpSanRegExs))
+		}
+		for _, email := range req.EmailAddresses {
+			check(rulePolicy, "EmailAddress", email, matchError(email, p.EmailSanRegExs))
+		}
+		for _, uri := range req.URIs {
+			check(rulePolicy, "URI", uri.String(), matchError(uri.String(), p.UriSanRegExs))
+		}
+		for _, upn := range req.UPNs {
+			check(rulePolicy, "UPN", upn, matchError(upn, p.UpnSanRegExs))
+		}
+		for _, c := range subjectComponents(p, &req) {
+			violations := c.violations()
+			if len(violations) == 0 {
+				check(rulePolicy, c.field, strings.Join(c.values, ", "), nil)
+			}
+			for _, v := range violations {
+				check(rulePolicy, v.Field, v.Value, errors.New(v.Message))
+			}
+		}
+		check(rulePolicy, "Key", keyDescription(&req), validateRequestKey(p, &req))
+		check(ruleZoneRules, "CSRSize", "", validateCSRSize(zoneConfig, input.Csr))
+		pt, err := csrPassthrough(input.Csr, zoneConfig, "")
+		if err == nil {
+			pt = withApiPassthrough(pt, input.ApiPassthrough, zoneConfig, "")
+			err = checkExtendedKeyUsages(zoneConfig, input.Csr, pt)
+		}
+		check(ruleZoneRules, "ExtendedKeyUsage", "", err)
+		validity := acmpca.IssueCertificateInput{Validity: input.Validity}
+		check(ruleZoneRules, "Validity", "", checkValidity(zoneConfig, &validity, now))
+	}
+	check(ruleZoneRules, "ZoneRules", "", validateZoneRules(zoneConfig, zoneRulesReq))
+
+	output.Valid = true
+	for _, c := range output.Checks {
+		output.Valid = output.Valid && c.Passed
+	}
+	return output, nil
+}
+
+func matchError(value string, regexs []string) error {
+	if matchAny(value, regexs) {
+		return nil
+	}
+	return fmt.Errorf("%q doesn't match regular expressions %v", value, regexs)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"testing"
+	"time"
+)
+
+func TestValidationReport(t *testing.T) {
+	output, err := validationReport(testValidationPolicy, common.ZoneConfig{}, ValidateCertificateRequestInput{
+		DomainName:              "www.example.com",
+		SubjectAlternativeNames: []string{"api.example.com", "www.example.org"},
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Valid {
+		t.Error("request with a name outside of policy should be invalid")
+	}
+	failed := map[string]bool{}
+	for _, c := range output.Checks {
+		if !c.Passed {
+			failed[c.Field+" "+c.Value] = true
+		}
+	}
+	if len(failed) != 1 || !failed["DNSName www.example.org"] {
+		t.Errorf("only the name outside of policy should fail, got %v", failed)
+	}
+
+	output, err = validationReport(testValidationPolicy, common.ZoneConfig{}, ValidateCertificateRequestInput{Csr: createCSR("csr.example.com")}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !output.Valid {
+		t.Errorf("CSR allowed by policy should be valid, got %+v", output.Checks)
+	}
+	output, err = validationReport(testValidationPolicy, common.ZoneConfig{MaxValidityDays: 30}, ValidateCertificateRequestInput{Csr: createCSR("csr.example.com")}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Valid {
+		t.Error("CSR without validity should be invalid in zone with maximum validity")
+	}
+
+	if _, err = validationReport(testValidationPolicy, common.ZoneConfig{}, ValidateCertificateRequestInput{Csr: []byte("csr")}, time.Now()); err == nil {
+		t.Error("invalid CSR should fail")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// newCSRRequest parses a PEM encoded CSR into a certificate request. The subject is normalized, so semantically
+// identical subjects get the same policy decision regardless of how the CSR was generated.
+func newCSRRequest(csrPEM []byte) (req certificate.Request, err error) {
+	pemBlock, _ := pem.Decode(csrPEM)
+	if pemBlock == nil {
+		return req, fmt.Errorf("CSR is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+	if err != nil {
+		return req, err
+	}
+	req.Subject = normalizeSubject(csr.Subject)
+	req.DNSNames = canonicalDNSNames(csr.DNSNames)
+	req.EmailAddresses = canonicalEmails(csr.EmailAddresses)
+	req.IPAddresses = uniqueIPs(csr.IPAddresses)
+	req.URIs = uniqueURIs(csr.URIs)
+	req.UPNs, err = upnSANs(csr.Extensions)
+	if err != nil {
+		return req, err
+	}
+	err = setRequestKey(&req, csr.PublicKey)
+	return req, err
+}
+
+var whitespaceRegexp = regexp.MustCompile(`\s+`)
+
+func normalizeValue(s string) string {
+	return whitespaceRegexp.ReplaceAllString(strings.TrimSpace(s), " ")
+}
+
+// normalizeValues normalizes values of a multi-valued attribute, drops duplicates and sorts them into canonical order.
+func normalizeValues(values []string, upper bool) []string {
+	if len(values) == 0 {
+		return values
+	}
+	seen := make(map[string]bool, len(values))
+	normalized := make([]string, 0, len(values))
+	for _, v := range values {
+		v = normalizeValue(v)
+		if upper {
+			v = strings.ToUpper(v)
+		}
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		normalized = append(normalized, v)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// normalizeSubject trims whitespace, unifies case of case-insensitive values and orders multi-valued attributes.
+func normalizeSubject(n pkix.Name) pkix.Name {
+	cn := normalizeValue(n.CommonName)
+	// Common name which looks like a domain name is case-insensitive.
+	if !strings.Contains(cn, " ") && strings.Contains(cn, ".") {
+		cn = canonicalDNSName(cn)
+	}
+	return pkix.Name{
+		CommonName:         cn,
+		SerialNumber:       normalizeValue(n.SerialNumber),
+		Country:            normalizeValues(n.Country, true),
+		Organization:       normalizeValues(n.Organization, false),
+		OrganizationalUnit: normalizeValues(n.OrganizationalUnit, false),
+		Locality:           normalizeValues(n.Locality, false),
+		Province:           normalizeValues(n.Province, false),
+		StreetAddress:      normalizeValues(n.StreetAddress, false),
+		PostalCode:         normalizeValues(n.PostalCode, false),
+	}
+}
+
+// canonicalDNSName lowercases a DNS name and strips the trailing dot of a fully qualified name.
+func canonicalDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// canonicalDNSNames canonicalizes DNS names and drops duplicates, keeping the original order.
+func canonicalDNSNames(names []string) []string {
+	return uniqueStrings(names, canonicalDNSName)
+}
+
+// canonicalEmails lowercases the domain part of email addresses and drops duplicates.
+func canonicalEmails(emails []string) []string {
+	return uniqueStrings(emails, func(email string) string {
+		email = strings.TrimSpace(email)
+		at := strings.LastIndex(email, "@")
+		if at < 0 {
+			return email
+		}
+		return email[:at+1] + canonicalDNSName(email[at+1:])
+	})
+}
+
+func uniqueStrings(values []string, canonical func(string) string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		v = canonical(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+// uniqueIPs drops duplicate IP addresses. IPv4 addresses written as IPv4-mapped IPv6 are treated as the same address.
+func uniqueIPs(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	seen := make(map[string]bool, len(ips))
+	unique := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		if seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		unique = append(unique, ip)
+	}
+	return unique
+}
+
+func uniqueURIs(uris []*url.URL) []*url.URL {
+	if len(uris) == 0 {
+		return uris
+	}
+	seen := make(map[string]bool, len(uris))
+	unique := make([]*url.URL, 0, len(uris))
+	for _, u := range uris {
+		if seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		unique = append(unique, u)
+	}
+	return unique
+}
+
+// requestedNames returns DNS names of the request including a domain-like common name.
+func requestedNames(req *certificate.Request) []string {
+	names := req.DNSNames
+	if cn := req.Subject.CommonName; strings.Contains(cn, ".") && !stringInSlice(cn, names) {
+		names = append([]string{cn}, names...)
+	}
+	return names
+}
+
+// validateRequest validates a certificate request built from a CSR against the policy. All violations are returned,
+// not only the first one.
+func validateRequest(p endpoint.Policy, req *certificate.Request) error {
+	var violations policyViolationError
+	if err := validateRequestKey(p, req); err != nil {
+		violations = append(violations, PolicyViolation{
+			Field:   "Key",
+			Value:   keyDescription(req),
+			Allowed: allowedKeys(p.AllowedKeyConfigurations),
+			Message: err.Error(),
+		})
+	}
+	violations = append(violations, nameViolations(p, req)...)
+	violations = append(violations, subjectViolations(p, req)...)
+	// Policy checks these SANs only when the request carries the CSR itself.
+	violations = append(violations, componentViolations("EmailAddress", "email address", req.EmailAddresses, p.EmailSanRegExs)...)
+	ips := make([]string, len(req.IPAddresses))
+	for i, ip := range req.IPAddresses {
+		ips[i] = ip.String()
+	}
+	violations = append(violations, componentViolations("IPAddress", "IP address", ips, p.IpSanRegExs)...)
+	uris := make([]string, len(req.URIs))
+	for i, uri := range req.URIs {
+		uris[i] = uri.String()
+	}
+	violations = append(violations, componentViolations("URI", "URI", uris, p.UriSanRegExs)...)
+	violations = append(violations, componentViolations("UPN", "UPN", req.UPNs, p.UpnSanRegExs)...)
+	return violations.orNil()
+}
+
+// simpleValidateRequest validates the common name and DNS names of a request without a CSR, e.g. of ACM, against the
+// policy. Wildcard names are handled like in validateRequest.
+func simpleValidateRequest(p endpoint.Policy, req certificate.Request) error {
+	return nameViolations(p, &req).orNil()
+}
+
+func matchAny(s string, regexs []string) bool {
+	for _, r := range regexs {
+		matched, err := regexp.MatchString(r, s)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
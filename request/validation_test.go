@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testValidationPolicy = endpoint.Policy{
+	SubjectCNRegexes:         []string{`^.*\.example\.com$`},
+	SubjectORegexes:          []string{`^Venafi Inc\.$`},
+	SubjectOURegexes:         []string{".*"},
+	SubjectSTRegexes:         []string{".*"},
+	SubjectLRegexes:          []string{".*"},
+	SubjectCRegexes:          []string{"^US$"},
+	AllowedKeyConfigurations: []endpoint.AllowedKeyConfiguration{{KeyType: certificate.KeyTypeRSA, KeySizes: []int{2048, 4096}}},
+	DnsSanRegExs:             []string{`^.*\.example\.com$`},
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	n := normalizeSubject(pkix.Name{
+		CommonName:         "  WWW.Example.COM ",
+		Country:            []string{"us", "US "},
+		Organization:       []string{"Venafi   Inc."},
+		OrganizationalUnit: []string{"Ops", " Dev", "Ops"},
+	})
+	expected := pkix.Name{
+		CommonName:         "www.example.com",
+		Country:            []string{"US"},
+		Organization:       []string{"Venafi Inc."},
+		OrganizationalUnit: []string{"Dev", "Ops"},
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Fatalf("unexpected normalized subject: %#v", n)
+	}
+}
+
+func TestValidateCSRRequest(t *testing.T) {
+	req, err := newCSRRequest(createCSR("test.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validateRequest(testValidationPolicy, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = newCSRRequest(createCSR("test.example.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validateRequest(testValidationPolicy, &req)
+	if err == nil {
+		t.Fatal("common name outside of policy should be rejected")
+	}
+}
+
+func TestCanonicalDNSNames(t *testing.T) {
+	names := canonicalDNSNames([]string{"WWW.example.com.", "www.example.com", " api.example.com"})
+	if !reflect.DeepEqual(names, []string{"www.example.com", "api.example.com"}) {
+		t.Fatalf("unexpected canonical names: %v", names)
+	}
+}
+
+func TestUniqueIPs(t *testing.T) {
+	ips := uniqueIPs([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("::ffff:10.0.0.1"), net.ParseIP("2001:db8:0::1")})
+	if len(ips) != 2 || ips[1].String() != "2001:db8::1" {
+		t.Fatalf("unexpected unique IPs: %v", ips)
+	}
+}
+
+func TestSetRequestKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	var req certificate.Request
+	if err := setRequestKey(&req, &ecKey.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if req.KeyType != certificate.KeyTypeECDSA || req.KeyCurve != certificate.EllipticCurveP384 {
+		t.Fatalf("unexpected key: %v %v", req.KeyType, req.KeyCurve)
+	}
+	p224Key, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err := setRequestKey(&req, &p224Key.PublicKey); err == nil {
+		t.Fatal("P-224 curve should be rejected")
+	}
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := setRequestKey(&req, edKey); err == nil {
+		t.Fatal("Ed25519 key should be rejected")
+	}
+	weakKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if err := setRequestKey(&req, &weakKey.PublicKey); err == nil {
+		t.Fatal("1024-bit RSA key should be rejected")
+	}
+}
+
+func TestValidateRequestKey(t *testing.T) {
+	req := certificate.Request{KeyType: certificate.KeyTypeRSA, KeyLength: 3072}
+	err := validateRequestKey(testValidationPolicy, &req)
+	if err == nil || err.Error() != "key RSA 3072 is not allowed by policy, allowed keys are RSA 2048/4096" {
+		t.Errorf("RSA key size outside of policy should be rejected, got %v", err)
+	}
+	req.KeyLength = 4096
+	if err = validateRequestKey(testValidationPolicy, &req); err != nil {
+		t.Errorf("RSA key allowed by policy should pass: %s", err)
+	}
+	req = certificate.Request{KeyType: certificate.KeyTypeECDSA, KeyCurve: certificate.EllipticCurveP256}
+	if validateRequestKey(testValidationPolicy, &req) == nil {
+		t.Error("ECDSA key should be rejected by RSA only policy")
+	}
+	if err = validateRequestKey(endpoint.Policy{}, &req); err != nil {
+		t.Errorf("policy without key configurations should allow any key: %s", err)
+	}
+}
+
+func TestCheckCSRAttributes(t *testing.T) {
+	if err := checkCSRAttributes(createCSR("test.example.com"), nil); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "test.example.com"},
+		Attributes: []pkix.AttributeTypeAndValueSET{{
+			Type:  asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7},
+			Value: [][]pkix.AttributeTypeAndValue{{{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}, Value: "secret"}}},
+		}},
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	if err = checkCSRAttributes(csr, nil); err == nil {
+		t.Fatal("challengePassword attribute should be rejected")
+	}
+	if err = checkCSRAttributes(csr, []string{"1.2.840.113549.1.9.7"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNameInZones(t *testing.T) {
+	zones := []string{"example.com", "corp.example.org"}
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"example.com", true},
+		{"www.example.com.", true},
+		{"*.Example.COM", true},
+		{"badexample.com", false},
+		{"example.org", false},
+		{"a.corp.example.org", true},
+	}
+	for _, c := range cases {
+		if got := nameInZones(c.name, zones); got != c.want {
+			t.Errorf("nameInZones(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPublicHostedZoneNames(t *testing.T) {
+	zones := []route53.HostedZone{
+		{Name: aws.String("example.com.")},
+		{Name: aws.String("internal.example.com."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+		{Name: aws.String("example.org."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+	}
+	if names := publicHostedZoneNames(zones); !reflect.DeepEqual(names, []string{"example.com", "example.org"}) {
+		t.Errorf("private hosted zones should be left out, got %v", names)
+	}
+}
+
+func TestHostedZoneNamesCached(t *testing.T) {
+	now := time.Now()
+	hostedZoneCache["arn:aws:iam::123456789012:role/DNS"] = cachedHostedZones{names: []string{"example.com"}, expires: now.Add(time.Minute)}
+	defer delete(hostedZoneCache, "arn:aws:iam::123456789012:role/DNS")
+	names, err := hostedZoneNames(context.Background(), "arn:aws:iam::123456789012:role/DNS", now)
+	if err != nil || !reflect.DeepEqual(names, []string{"example.com"}) {
+		t.Errorf("cached hosted zones should be returned without listing them, got %v: %v", names, err)
+	}
+}
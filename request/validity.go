@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"log"
+	"time"
+)
+
+// clampValidity shortens validities exceeding the zone maximum to the maximum instead of rejecting the request.
+var clampValidity bool
+
+// checkValidity enforces the maximum validity of the zone on the requested validity. With clampValidity a longer
+// validity is replaced by the maximum in days.
+func checkValidity(zoneConfig common.ZoneConfig, input *acmpca.IssueCertificateInput, now time.Time) error {
+	if zoneConfig.MaxValidityDays == 0 {
+		return nil
+	}
+	end := validityEnd(input.Validity, now)
+	if end.IsZero() {
+		return fmt.Errorf("validity is required, maximum validity in this zone is %d days", zoneConfig.MaxValidityDays)
+	}
+	if !end.After(now.UTC().AddDate(0, 0, zoneConfig.MaxValidityDays)) {
+		return nil
+	}
+	if !clampValidity {
+		return fmt.Errorf("validity until %s exceeds maximum of %d days in this zone", end.Format(time.RFC3339), zoneConfig.MaxValidityDays)
+	}
+	log.Printf("Clamping validity until %s to %d days", end.Format(time.RFC3339), zoneConfig.MaxValidityDays)
+	input.Validity = &acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(int64(zoneConfig.MaxValidityDays))}
+	return nil
+}
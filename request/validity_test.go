@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"testing"
+	"time"
+)
+
+func TestCheckValidity(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	input := acmpca.IssueCertificateInput{Validity: &acmpca.Validity{Type: acmpca.ValidityPeriodTypeYears, Value: aws.Int64(2)}}
+	if err := checkValidity(common.ZoneConfig{}, &input, now); err != nil {
+		t.Errorf("zone without maximum should allow any validity: %s", err)
+	}
+	zoneConfig := common.ZoneConfig{MaxValidityDays: 397}
+	if checkValidity(zoneConfig, &input, now) == nil {
+		t.Error("validity of 2 years should exceed 397 days")
+	}
+	input.Validity = &acmpca.Validity{Type: acmpca.ValidityPeriodTypeDays, Value: aws.Int64(397)}
+	if err := checkValidity(zoneConfig, &input, now); err != nil {
+		t.Errorf("validity of the maximum should be allowed: %s", err)
+	}
+	input.Validity = nil
+	if checkValidity(zoneConfig, &input, now) == nil {
+		t.Error("missing validity should be rejected when the zone has a maximum")
+	}
+
+	clampValidity = true
+	defer func() { clampValidity = false }()
+	input.Validity = &acmpca.Validity{Type: acmpca.ValidityPeriodTypeEndDate, Value: aws.Int64(20300101000000)}
+	if err := checkValidity(zoneConfig, &input, now); err != nil {
+		t.Fatalf("validity should be clamped: %s", err)
+	}
+	if input.Validity.Type != acmpca.ValidityPeriodTypeDays || aws.Int64Value(input.Validity.Value) != 397 {
+		t.Errorf("unexpected clamped validity %+v", input.Validity)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"log"
+	"os"
+	"time"
+)
+
+// venafiImportRetention is how long the policy Lambda waits for a certificate to be issued and imported into Venafi.
+// Public ACM certificates are only issued once their domains are validated.
+const venafiImportRetention = 7 * 24 * time.Hour
+
+// venafiImportEnabled queues certificates issued through the proxy for import into their Venafi zone, so the Venafi
+// inventory, expiry reporting and compliance dashboards include them.
+var venafiImportEnabled bool
+
+func loadVenafiImport() {
+	venafiImportEnabled = os.Getenv("VENAFI_IMPORT") == "true"
+}
+
+// queueVenafiImport queues an issued certificate for import into Venafi by the policy Lambda. It is best effort, the
+// certificate is already issued.
+func queueVenafiImport(r common.InventoryRecord, now time.Time) {
+	if !venafiImportEnabled || r.Zone == "" {
+		return
+	}
+	err := common.SaveVenafiImport(common.VenafiImport{
+		CertificateArn:          r.CertificateArn,
+		Zone:                    r.Zone,
+		AccountID:               r.AccountID,
+		Region:                  r.Region,
+		CertificateAuthorityArn: r.CertificateAuthorityArn,
+		RequestedBy:             r.RequestedBy,
+		CreatedAt:               now.UTC(),
+		TTL:                     now.Add(venafiImportRetention).Unix(),
+	})
+	if err != nil {
+		log.Printf("Can't queue Venafi import of %s: %s", r.CertificateArn, err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const reasonPolicyViolation = "PolicyViolation"
+
+// PolicyViolation is one constraint of the policy which a request violates.
+type PolicyViolation struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	// Allowed are the regular expressions or values allowed by the policy for the field.
+	Allowed []string `json:"allowed,omitempty"`
+	Message string   `json:"message"`
+}
+
+// policyViolationError lists every constraint of the policy violated by a request, so callers can fix all of them at
+// once.
+type policyViolationError []PolicyViolation
+
+func (e policyViolationError) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// orNil returns the violations as an error, or nil if there are none.
+func (e policyViolationError) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// nameViolations checks the common name and DNS names of the request against the policy.
+func nameViolations(p endpoint.Policy, req *certificate.Request) policyViolationError {
+	wildcardReq, err := wildcardPolicyRequest(p, req)
+	if err != nil {
+		return policyViolationError{{Field: "Wildcards", Message: err.Error()}}
+	}
+	var violations policyViolationError
+	if !matchAny(wildcardReq.Subject.CommonName, p.SubjectCNRegexes) {
+		violations = append(violations, PolicyViolation{
+			Field:   "CommonName",
+			Value:   req.Subject.CommonName,
+			Allowed: p.SubjectCNRegexes,
+			Message: fmt.Sprintf("common name %s is not allowed in this policy: %v", req.Subject.CommonName, p.SubjectCNRegexes),
+		})
+	}
+	for i, name := range req.DNSNames {
+		if !matchAny(wildcardReq.DNSNames[i], p.DnsSanRegExs) {
+			violations = append(violations, PolicyViolation{
+				Field:   "DNSName",
+				Value:   name,
+				Allowed: p.DnsSanRegExs,
+				Message: fmt.Sprintf("DNS name %s doesn't match regular expressions: %v", name, p.DnsSanRegExs),
+			})
+		}
+	}
+	return violations
+}
+
+// subjectComponent is a subject attribute of the request with the regular expressions of the policy for it.
+type subjectComponent struct {
+	field       string
+	description string
+	values      []string
+	regexes     []string
+}
+
+func subjectComponents(p endpoint.Policy, req *certificate.Request) []subjectComponent {
+	return []subjectComponent{
+		{"Organization", "organization", req.Subject.Organization, p.SubjectORegexes},
+		{"OrganizationalUnit", "organizational unit", req.Subject.OrganizationalUnit, p.SubjectOURegexes},
+		{"Locality", "locality", req.Subject.Locality, p.SubjectLRegexes},
+		{"Province", "state (province)", req.Subject.Province, p.SubjectSTRegexes},
+		{"Country", "country", req.Subject.Country, p.SubjectCRegexes},
+	}
+}
+
+// subjectViolations checks the subject attributes of the request against the policy.
+func subjectViolations(p endpoint.Policy, req *certificate.Request) policyViolationError {
+	var violations policyViolationError
+	for _, c := range subjectComponents(p, req) {
+		violations = append(violations, c.violations()...)
+	}
+	return violations
+}
+
+// violations checks values of the attribute. Like in the policy, a missing attribute must be allowed by the regular
+// expressions. Attributes locked to values in Venafi must have one of them.
+func (c subjectComponent) violations() policyViolationError {
+	locked, isLocked := lockedValues(c.regexes)
+	if !isLocked {
+		values := c.values
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		return componentViolations(c.field, c.description, values, c.regexes)
+	}
+	if len(c.values) == 0 {
+		return policyViolationError{{
+			Field:   c.field,
+			Allowed: locked,
+			Message: fmt.Sprintf("%s is required, the zone locks it to %s", c.description, strings.Join(locked, ", ")),
+		}}
+	}
+	var violations policyViolationError
+	for _, v := range c.values {
+		if !stringInSlice(v, locked) {
+			violations = append(violations, PolicyViolation{
+				Field:   c.field,
+				Value:   v,
+				Allowed: locked,
+				Message: fmt.Sprintf("%s %s is not allowed, the zone locks it to %s", c.description, v, strings.Join(locked, ", ")),
+			})
+		}
+	}
+	return violations
+}
+
+// lockedValues returns the values of a subject attribute locked in Venafi. Venafi policies express locked values as
+// regular expressions matching exactly the quoted value.
+func lockedValues(regexes []string) ([]string, bool) {
+	if len(regexes) == 0 {
+		return nil, false
+	}
+	values := make([]string, 0, len(regexes))
+	for _, r := range regexes {
+		v, ok := literalRegex(r)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
+
+// literalRegex returns the value matched by a regular expression of the form ^value$ with special characters quoted.
+func literalRegex(r string) (string, bool) {
+	if len(r) < 3 || !strings.HasPrefix(r, "^") || !strings.HasSuffix(r, "$") {
+		return "", false
+	}
+	quoted := r[1 : len(r)-1]
+	var b strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		if quoted[i] == '\\' && i+1 < len(quoted) {
+			i++
+		}
+		b.WriteByte(quoted[i])
+	}
+	v := b.String()
+	return v, regexp.QuoteMeta(v) == quoted
+}
+
+// componentViolations checks every value of an optional component against the regular expressions of the policy.
+func componentViolations(field, description string, values []string, regexs []string) policyViolationError {
+	var violations policyViolationError
+	for _, v := range values {
+		if !matchAny(v, regexs) {
+			violations = append(violations, PolicyViolation{
+				Field:   field,
+				Value:   v,
+				Allowed: regexs,
+				Message: fmt.Sprintf("%s %s doesn't match regular expressions: %v", description, v, regexs),
+			})
+		}
+	}
+	return violations
+}
+
+// policyViolationResponse returns 403 for a request rejected by the policy. Violations of the policy are listed in the
+// body with the zone and the version of the policy, other errors get only the message.
+func policyViolationResponse(zone string, p endpoint.Policy, msg string, err error) (events.APIGatewayProxyResponse, error) {
+	violations, ok := err.(policyViolationError)
+	if !ok {
+		return clientError(http.StatusForbidden, msg)
+	}
+	version, _ := common.PolicyVersion(p)
+	b, _ := json.Marshal(struct {
+		Msg           string            `json:"msg"`
+		Reason        string            `json:"reason"`
+		Zone          string            `json:"zone"`
+		PolicyVersion string            `json:"policyVersion"`
+		Violations    []PolicyViolation `json:"violations"`
+	}{msg, reasonPolicyViolation, zone, version, violations})
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusForbidden,
+		Body:       string(b),
+	}, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"net/http"
+	"testing"
+)
+
+func TestValidateRequestViolations(t *testing.T) {
+	policy := endpoint.Policy{
+		SubjectCNRegexes: []string{`^.*\.example\.com$`},
+		DnsSanRegExs:     []string{`^.*\.example\.com$`},
+		SubjectORegexes:  []string{`.*`},
+		SubjectOURegexes: []string{`.*`},
+		SubjectLRegexes:  []string{`.*`},
+		SubjectSTRegexes: []string{`.*`},
+		SubjectCRegexes:  []string{`^US$`},
+		EmailSanRegExs:   []string{`@example\.com$`},
+	}
+	var req certificate.Request
+	req.Subject.CommonName = "www.example.org"
+	req.Subject.Country = []string{"DE"}
+	req.DNSNames = []string{"www.example.com", "api.example.net"}
+	req.EmailAddresses = []string{"admin@example.com"}
+	err := validateRequest(policy, &req)
+	violations, ok := err.(policyViolationError)
+	if !ok {
+		t.Fatalf("expected policy violations, got %v", err)
+	}
+	fields := make([]string, len(violations))
+	for i, v := range violations {
+		fields[i] = v.Field + "=" + v.Value
+	}
+	expected := []string{"CommonName=www.example.org", "DNSName=api.example.net", "Country=DE"}
+	if len(fields) != len(expected) {
+		t.Fatalf("unexpected violations %v", fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("unexpected violations %v", fields)
+		}
+	}
+
+	resp, _ := policyViolationResponse("Default", policy, err.Error(), err)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var body struct {
+		Reason        string
+		Zone          string
+		PolicyVersion string
+		Violations    []PolicyViolation
+	}
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Reason != reasonPolicyViolation || body.Zone != "Default" || body.PolicyVersion == "" || len(body.Violations) != 3 {
+		t.Errorf("unexpected body %s", resp.Body)
+	}
+	if body.Violations[0].Allowed[0] != `^.*\.example\.com$` {
+		t.Errorf("violation should list allowed regular expressions, got %v", body.Violations[0].Allowed)
+	}
+
+	req.Subject.CommonName = "www.example.com"
+	req.DNSNames = nil
+	req.Subject.Country = []string{"US"}
+	if err := validateRequest(policy, &req); err != nil {
+		t.Errorf("request should be valid: %s", err)
+	}
+}
+
+func TestLockedSubjectViolations(t *testing.T) {
+	policy := endpoint.Policy{
+		SubjectCNRegexes: []string{`.*`},
+		SubjectORegexes:  []string{`^Venafi Inc\.$`},
+		SubjectOURegexes: []string{`^Integration$`, `^Engineering$`},
+		SubjectLRegexes:  []string{`.*`},
+		SubjectSTRegexes: []string{`^Utah$`},
+		SubjectCRegexes:  []string{`^US$`},
+	}
+	if values, ok := lockedValues(policy.SubjectORegexes); !ok || values[0] != "Venafi Inc." {
+		t.Errorf("organization should be locked to Venafi Inc., got %v", values)
+	}
+	if _, ok := lockedValues([]string{`^.*\.example\.com$`}); ok {
+		t.Error("domain regular expression is not a locked value")
+	}
+	var req certificate.Request
+	req.Subject.CommonName = "www.example.com"
+	req.Subject.Organization = []string{"Venafi Inc."}
+	req.Subject.OrganizationalUnit = []string{"Engineering", "Sales"}
+	req.Subject.Country = []string{"US"}
+	violations := subjectViolations(policy, &req)
+	if len(violations) != 2 {
+		t.Fatalf("unexpected violations %v", violations)
+	}
+	if v := violations[0]; v.Field != "OrganizationalUnit" || v.Value != "Sales" || len(v.Allowed) != 2 {
+		t.Errorf("unexpected violation %+v", v)
+	}
+	if v := violations[1]; v.Field != "Province" || v.Message != "state (province) is required, the zone locks it to Utah" {
+		t.Errorf("unexpected violation %+v", v)
+	}
+	d := newPolicyDescription("Default", policy, common.ZoneConfig{})
+	if len(d.LockedSubject) != 4 || d.LockedSubject["Country"][0] != "US" {
+		t.Errorf("unexpected locked subject %v", d.LockedSubject)
+	}
+}
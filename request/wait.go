@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"strings"
+	"time"
+)
+
+const (
+	// waitForCertificateBudget is how long a request with WaitForCertificate polls for the issued certificate when the
+	// deadline of the invocation is unknown.
+	waitForCertificateBudget = 5 * time.Second
+	// waitDeadlineMargin is left of the invocation after polling, to record and return the certificate.
+	waitDeadlineMargin = time.Second
+
+	minCertificatePollDelay = 200 * time.Millisecond
+	maxCertificatePollDelay = 2 * time.Second
+)
+
+// invocationDeadline is the deadline of the current invocation, zero if it's unknown.
+var invocationDeadline time.Time
+
+// waitDeadline returns how long a request started at start polls for its certificate, until shortly before the
+// invocation times out.
+func waitDeadline(start time.Time) time.Time {
+	if invocationDeadline.IsZero() {
+		return start.Add(waitForCertificateBudget)
+	}
+	return invocationDeadline.Add(-waitDeadlineMargin)
+}
+
+// pollIssuedCertificate polls ACM PCA until the certificate is issued or the deadline passes. The client is for the
+// region of the certificate, which differs from the requested one after failover to a CA in another region.
+func pollIssuedCertificate(ctx context.Context, roleArn, certificateArn string, deadline time.Time) (*acmpca.GetCertificateOutput, error) {
+	a, err := arn.Parse(certificateArn)
+	i := strings.Index(certificateArn, "/certificate/")
+	if err != nil || i < 0 {
+		return nil, fmt.Errorf("not an ACM PCA certificate ARN: %s", certificateArn)
+	}
+	cfg, err := loadAWSConfig(a.Region, roleArn)
+	if err != nil {
+		return nil, err
+	}
+	cli := acmpca.New(cfg)
+	input := &acmpca.GetCertificateInput{
+		CertificateArn:          aws.String(certificateArn),
+		CertificateAuthorityArn: aws.String(certificateArn[:i]),
+	}
+	delay := minCertificatePollDelay
+	for {
+		resp, err := cli.GetCertificateRequest(input).Send(ctx)
+		if err == nil {
+			return resp.GetCertificateOutput, nil
+		}
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != acmpca.ErrCodeRequestInProgressException {
+			return nil, err
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("certificate %s is not issued yet", certificateArn)
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxCertificatePollDelay {
+			delay = maxCertificatePollDelay
+		}
+	}
+}
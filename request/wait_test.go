@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPollIssuedCertificate(t *testing.T) {
+	_, err := pollIssuedCertificate(context.Background(), "", "arn:aws:acm:us-east-1:123456789012:certificate/a", time.Now().Add(time.Second))
+	if err == nil {
+		t.Error("ACM certificate ARN should be rejected")
+	}
+}
+
+func TestWaitDeadline(t *testing.T) {
+	defer func() { invocationDeadline = time.Time{} }()
+	start := time.Now()
+	if d := waitDeadline(start); !d.Equal(start.Add(waitForCertificateBudget)) {
+		t.Errorf("without invocation deadline got %s", d.Sub(start))
+	}
+	invocationDeadline = start.Add(10 * time.Second)
+	if d := waitDeadline(start); !d.Equal(start.Add(9 * time.Second)) {
+		t.Errorf("with invocation deadline got %s", d.Sub(start))
+	}
+}
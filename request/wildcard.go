@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"strings"
+)
+
+// wildcardLabel replaces the wildcard label of names matched against policy regular expressions which don't allow
+// wildcards explicitly, so *.prod.example.com is evaluated as a name under prod.example.com.
+const wildcardLabel = "wildcard"
+
+// isWildcardName reports whether the name has a wildcard label.
+func isWildcardName(name string) bool {
+	return strings.Contains(name, "*")
+}
+
+// validWildcardName reports whether the wildcard is the whole leftmost label, e.g. *.example.com but not
+// www*.example.com or www.*.example.com.
+func validWildcardName(name string) bool {
+	return strings.HasPrefix(name, "*.") && !strings.Contains(name[2:], "*")
+}
+
+// wildcardPolicyRequest checks wildcard names of the request against the wildcard setting of the policy and returns
+// the request in which they are replaced by names under their parent domain, unless the policy regular expressions
+// match the wildcard name itself.
+func wildcardPolicyRequest(p endpoint.Policy, req *certificate.Request) (*certificate.Request, error) {
+	names := append([]string{req.Subject.CommonName}, req.DNSNames...)
+	hasWildcard := false
+	for _, name := range names {
+		if !isWildcardName(name) {
+			continue
+		}
+		if !validWildcardName(name) {
+			return nil, fmt.Errorf("invalid wildcard name %s, only the whole leftmost label can be a wildcard", name)
+		}
+		if !p.AllowWildcards {
+			return nil, fmt.Errorf("wildcard name %s is not allowed by policy", name)
+		}
+		hasWildcard = true
+	}
+	if !hasWildcard {
+		return req, nil
+	}
+	r := *req
+	r.Subject.CommonName = policyWildcardName(req.Subject.CommonName, p.SubjectCNRegexes)
+	r.DNSNames = make([]string, len(req.DNSNames))
+	for i, name := range req.DNSNames {
+		r.DNSNames[i] = policyWildcardName(name, p.DnsSanRegExs)
+	}
+	return &r, nil
+}
+
+func policyWildcardName(name string, regexs []string) string {
+	if !isWildcardName(name) || matchAny(name, regexs) {
+		return name
+	}
+	return wildcardLabel + strings.TrimPrefix(name, "*")
+}
+
+// validateWildcardRules applies wildcard rules of the zone on top of the policy.
+func validateWildcardRules(zoneConfig common.ZoneConfig, req *certificate.Request) error {
+	if !zoneConfig.ForbidWildcards && len(zoneConfig.WildcardDomains) == 0 {
+		return nil
+	}
+	domains := canonicalDNSNames(zoneConfig.WildcardDomains)
+	for _, name := range append([]string{req.Subject.CommonName}, req.DNSNames...) {
+		if !isWildcardName(name) {
+			continue
+		}
+		if zoneConfig.ForbidWildcards {
+			return fmt.Errorf("wildcard name %s is not allowed in this zone", name)
+		}
+		if !nameInZones(name, domains) {
+			return fmt.Errorf("wildcard name %s is not allowed in this zone, wildcards are allowed under %v", name, zoneConfig.WildcardDomains)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"testing"
+)
+
+func TestWildcardPolicy(t *testing.T) {
+	p := endpoint.Policy{
+		SubjectCNRegexes: []string{`^[a-z0-9-]+\.prod\.example\.com$`},
+		DnsSanRegExs:     []string{`^[a-z0-9-]+\.prod\.example\.com$`, `^\*\.legacy\.example\.com$`},
+		AllowWildcards:   true,
+	}
+	req := certificate.Request{
+		Subject:  pkix.Name{CommonName: "*.prod.example.com"},
+		DNSNames: []string{"*.prod.example.com", "*.legacy.example.com"},
+	}
+	if err := simpleValidateRequest(p, req); err != nil {
+		t.Errorf("wildcards under allowed domains should pass: %s", err)
+	}
+	req.DNSNames = []string{"*.test.example.com"}
+	if simpleValidateRequest(p, req) == nil {
+		t.Error("wildcard outside of allowed domains should be rejected")
+	}
+	req.DNSNames = []string{"www.*.prod.example.com"}
+	if simpleValidateRequest(p, req) == nil {
+		t.Error("wildcard which is not the leftmost label should be rejected")
+	}
+	req.DNSNames = []string{"*.prod.example.com"}
+	p.AllowWildcards = false
+	if simpleValidateRequest(p, req) == nil {
+		t.Error("wildcard should be rejected when the policy doesn't allow wildcards")
+	}
+}
+
+func TestWildcardRules(t *testing.T) {
+	req := certificate.Request{DNSNames: []string{"www.example.com", "*.prod.example.com"}}
+	if err := validateZoneRules(common.ZoneConfig{WildcardDomains: []string{"Prod.Example.com"}}, &req); err != nil {
+		t.Errorf("wildcard under a wildcard domain should pass: %s", err)
+	}
+	if validateZoneRules(common.ZoneConfig{WildcardDomains: []string{"dev.example.com"}}, &req) == nil {
+		t.Error("wildcard outside of wildcard domains should be rejected")
+	}
+	if validateZoneRules(common.ZoneConfig{ForbidWildcards: true}, &req) == nil {
+		t.Error("wildcard should be rejected in zone forbidding wildcards")
+	}
+}
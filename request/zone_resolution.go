@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+)
+
+// zoneMapping resolves zones of requests from the VenafiZoneMappings table instead of trusting the zone in the body.
+var zoneMapping bool
+
+// strictZone rejects requests which don't specify a zone instead of using the default zone.
+var strictZone bool
+
+var errZoneRequired = errors.New("VenafiZone is required")
+
+// zoneLookupError is a failure to read zone mappings, as opposed to a zone the caller may not use.
+type zoneLookupError struct {
+	error
+}
+
+// callerMappingKeys returns keys the zone mapping of the caller is looked up by, from the most specific.
+func callerMappingKeys(request events.APIGatewayProxyRequest) []string {
+	var keys []string
+	callerArn := request.RequestContext.Identity.UserArn
+	if callerArn != "" {
+		keys = append(keys, callerArn)
+	}
+	if roleArn, ok := sessionRoleArn(callerArn); ok {
+		keys = append(keys, roleArn)
+	}
+	if account := request.RequestContext.Identity.AccountID; account != "" {
+		keys = append(keys, account)
+	}
+	return keys
+}
+
+// resolveZone returns the zone of a request. The requested zone is the zone from the body, it's used as is unless
+// zone mapping is enabled, in which case the zone mapped to the resources of the request (e.g. the CA ARN) or, without
+// such a mapping, to the caller is used and the requested zone must be allowed by the mapping. A zone mapped to a
+// resource must also be allowed by the mapping of the caller, if there is one. The zone is kept as the zone of the
+// request status.
+func resolveZone(request events.APIGatewayProxyRequest, requestedZone string, resourceKeys ...string) (string, error) {
+	zone, err := resolveRequestedZone(request, requestedZone, resourceKeys)
+	if err == nil {
+		requestZone = zone
+	}
+	return zone, err
+}
+
+func resolveRequestedZone(request events.APIGatewayProxyRequest, requestedZone string, resourceKeys []string) (string, error) {
+	if !zoneMapping {
+		return zoneOrDefault(requestedZone)
+	}
+	caller, callerMapped, err := findZoneMapping(callerMappingKeys(request))
+	if err != nil {
+		return "", err
+	}
+	resource, resourceMapped, err := findZoneMapping(resourceKeys)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case resourceMapped:
+		zone, err := zoneOfMapping(resource, requestedZone)
+		if err != nil || !callerMapped {
+			return zone, err
+		}
+		return zone, allowedForCaller(caller, zone)
+	case callerMapped:
+		return zoneOfMapping(caller, requestedZone)
+	}
+	return "", fmt.Errorf("no Venafi zone is mapped to caller %s", request.RequestContext.Identity.UserArn)
+}
+
+// findZoneMapping returns the mapping of the first key which has one.
+func findZoneMapping(keys []string) (common.ZoneMapping, bool, error) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		m, err := common.GetZoneMapping(key)
+		if err == common.ZoneMappingNotFound {
+			continue
+		} else if err != nil {
+			common.Monitor.Record(common.BackendDynamoDB, err)
+			return m, false, zoneLookupError{fmt.Errorf("Failed to get zone mapping from database: %s", err)}
+		}
+		return m, true, nil
+	}
+	return common.ZoneMapping{}, false, nil
+}
+
+// zoneOfMapping returns the zone of the mapping for the requested zone.
+func zoneOfMapping(m common.ZoneMapping, requestedZone string) (string, error) {
+	if requestedZone == "" && len(m.FallbackZones) > 0 {
+		return firstZoneWithPolicy(m.ZoneChain())
+	}
+	return mappedZone(m, requestedZone)
+}
+
+// allowedForCaller rejects a zone mapped to a resource of the request which the mapping of the caller doesn't allow,
+// so callers can't leave their zones by picking a CA mapped to another zone.
+func allowedForCaller(caller common.ZoneMapping, zone string) error {
+	if _, err := mappedZone(caller, zone); err != nil {
+		return fmt.Errorf("zone %s of the certificate authority is not allowed for the caller, the caller's zone is %s", zone, caller.Zone)
+	}
+	return nil
+}
+
+// zoneOrDefault returns the requested zone, or the default zone if none was requested and strict zone mode is off.
+func zoneOrDefault(requestedZone string) (string, error) {
+	if requestedZone != "" {
+		return requestedZone, nil
+	}
+	if strictZone {
+		return "", errZoneRequired
+	}
+	return defaultZone, nil
+}
+
+// firstZoneWithPolicy returns the first of the zones whose policy exists in the database, or the last zone if none
+// does so the request fails as it would for a single zone without policy.
+func firstZoneWithPolicy(zones []string) (string, error) {
+	for _, zone := range zones {
+		_, err := getPolicy(zone)
+		if err == common.PolicyNotFound || err == common.PolicyFoundButEmpty {
+			continue
+		} else if err != nil {
+			common.Monitor.Record(common.BackendDynamoDB, err)
+			return "", zoneLookupError{fmt.Errorf("Failed to get policy from database: %s", err)}
+		}
+		return zone, nil
+	}
+	return zones[len(zones)-1], nil
+}
+
+// mappedZone returns the requested zone if the mapping allows it, or the mapped zone if no zone was requested.
+func mappedZone(m common.ZoneMapping, requestedZone string) (string, error) {
+	if requestedZone == "" {
+		return m.Zone, nil
+	}
+	if stringInSlice(requestedZone, m.ZoneChain()) || stringInSlice(requestedZone, m.AllowedZones) {
+		return requestedZone, nil
+	}
+	return "", fmt.Errorf("zone %s is not allowed for the caller, the caller's zone is %s", requestedZone, m.Zone)
+}
+
+func zoneErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	if _, ok := err.(zoneLookupError); ok {
+		return clientError(http.StatusFailedDependency, err.Error())
+	}
+	if err == errZoneRequired {
+		return clientError(http.StatusBadRequest, err.Error())
+	}
+	return clientError(http.StatusForbidden, err.Error())
+}
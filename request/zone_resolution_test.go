@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"reflect"
+	"testing"
+)
+
+func TestCallerMappingKeys(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	request.RequestContext.Identity.AccountID = "123456789012"
+	request.RequestContext.Identity.UserArn = "arn:aws:sts::123456789012:assumed-role/TeamA/build-1"
+	expected := []string{
+		"arn:aws:sts::123456789012:assumed-role/TeamA/build-1",
+		"arn:aws:iam::123456789012:role/TeamA",
+		"123456789012",
+	}
+	if keys := callerMappingKeys(request); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestAllowedForCaller(t *testing.T) {
+	caller := common.ZoneMapping{MappingKey: "123456789012", Zone: "TeamA\\Prod", AllowedZones: []string{"Devices"}}
+	if err := allowedForCaller(caller, "Devices"); err != nil {
+		t.Errorf("zone of the CA allowed for the caller should pass: %s", err)
+	}
+	if allowedForCaller(caller, "TeamB\\Prod") == nil {
+		t.Error("zone of the CA should not override the caller's mapping")
+	}
+}
+
+func TestMappedZone(t *testing.T) {
+	m := common.ZoneMapping{MappingKey: "123456789012", Zone: "TeamA\\Prod", AllowedZones: []string{"TeamA\\Dev"}}
+	if zone, err := mappedZone(m, ""); err != nil || zone != "TeamA\\Prod" {
+		t.Errorf("request without zone should get the mapped zone, got %s: %v", zone, err)
+	}
+	if zone, err := mappedZone(m, "TeamA\\Dev"); err != nil || zone != "TeamA\\Dev" {
+		t.Errorf("allowed zone should be used, got %s: %v", zone, err)
+	}
+	if _, err := mappedZone(m, "TeamB\\Prod"); err == nil {
+		t.Error("zone of another team should be rejected")
+	}
+}
+
+func TestResolveZoneWithoutMapping(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	if zone, err := resolveZone(request, ""); err != nil || zone != defaultZone {
+		t.Errorf("request without zone should get the default zone, got %s: %v", zone, err)
+	}
+	if zone, err := resolveZone(request, "Other"); err != nil || zone != "Other" {
+		t.Errorf("requested zone should be used, got %s: %v", zone, err)
+	}
+}
+
+func TestResolveZoneStrict(t *testing.T) {
+	strictZone = true
+	defer func() { strictZone = false }()
+	var request events.APIGatewayProxyRequest
+	if _, err := resolveZone(request, ""); err != errZoneRequired {
+		t.Errorf("request without zone should be rejected in strict mode, got %v", err)
+	}
+	if zone, err := resolveZone(request, "Other"); err != nil || zone != "Other" {
+		t.Errorf("requested zone should be used, got %s: %v", zone, err)
+	}
+	if resp, _ := zoneErrorResponse(errZoneRequired); resp.StatusCode != 400 {
+		t.Errorf("missing zone should be a bad request, got %d", resp.StatusCode)
+	}
+}
+
+func TestZoneChain(t *testing.T) {
+	m := common.ZoneMapping{Zone: "TeamA\\Prod", FallbackZones: []string{"TeamA\\Default", "Default"}}
+	if chain := m.ZoneChain(); !reflect.DeepEqual(chain, []string{"TeamA\\Prod", "TeamA\\Default", "Default"}) {
+		t.Errorf("unexpected zone chain %v", chain)
+	}
+	if zone, err := mappedZone(m, "Default"); err != nil || zone != "Default" {
+		t.Errorf("fallback zone should be allowed explicitly, got %s: %v", zone, err)
+	}
+}
+
+func TestRequestCertificateZoneResourceKeys(t *testing.T) {
+	var in VenafiRequestCertificateInput
+	if keys := in.zoneResourceKeys(); len(keys) != 0 {
+		t.Errorf("public certificate request should have no resource keys, got %v", keys)
+	}
+	caArn := "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	in.CertificateAuthorityArn = &caArn
+	if keys := in.zoneResourceKeys(); !reflect.DeepEqual(keys, []string{caArn}) {
+		t.Errorf("private certificate request should be mapped by its CA, got %v", keys)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"net"
+	"strings"
+)
+
+// validateZoneRules checks the request against zone rules which are configured in the proxy rather than in Venafi.
+func validateZoneRules(zoneConfig common.ZoneConfig, req *certificate.Request) error {
+	if zoneConfig.RequireDNSSAN && len(req.DNSNames) == 0 {
+		return fmt.Errorf("at least one DNS name SAN is required in this zone")
+	}
+	if zoneConfig.ForbidCommonName && req.Subject.CommonName != "" {
+		return fmt.Errorf("common name %s is not allowed in this zone, use DNS name SANs instead", req.Subject.CommonName)
+	}
+	if zoneConfig.RequireCommonNameInSANs && req.Subject.CommonName != "" && !commonNameInSANs(req) {
+		return fmt.Errorf("common name %s must also be requested as a SAN in this zone", req.Subject.CommonName)
+	}
+	err := checkNameLimits(zoneConfig, req)
+	if err != nil {
+		return err
+	}
+	err = validateWildcardRules(zoneConfig, req)
+	if err != nil {
+		return err
+	}
+	if len(zoneConfig.AllowedKeyCurves) > 0 && req.KeyType == certificate.KeyTypeECDSA {
+		allowed, err := curveAllowed(req.KeyCurve, zoneConfig.AllowedKeyCurves)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("elliptic curve %s is not allowed in this zone, allowed curves are %v", req.KeyCurve.String(), zoneConfig.AllowedKeyCurves)
+		}
+	}
+	return nil
+}
+
+// checkNameLimits enforces zone limits on the number of SANs and the length of names, so pathological requests are
+// rejected with a clear error instead of an opaque one from ACM PCA.
+func checkNameLimits(zoneConfig common.ZoneConfig, req *certificate.Request) error {
+	sans := append(append([]string{}, req.DNSNames...), req.EmailAddresses...)
+	sans = append(sans, req.UPNs...)
+	for _, ip := range req.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range req.URIs {
+		sans = append(sans, uri.String())
+	}
+	if zoneConfig.MaxSANs > 0 && len(sans) > zoneConfig.MaxSANs {
+		return fmt.Errorf("request has %d SANs, the maximum in this zone is %d", len(sans), zoneConfig.MaxSANs)
+	}
+	if zoneConfig.MaxNameLength > 0 {
+		for _, name := range append([]string{req.Subject.CommonName}, sans...) {
+			if len(name) > zoneConfig.MaxNameLength {
+				shown := name
+				if len(shown) > 64 {
+					shown = shown[:64] + "..."
+				}
+				return fmt.Errorf("name %s is %d characters long, the maximum in this zone is %d", shown, len(name), zoneConfig.MaxNameLength)
+			}
+		}
+	}
+	return nil
+}
+
+// validateCSRSize enforces zone limits on the CSR size, so absurd SAN stuffing is rejected before reaching ACM PCA.
+func validateCSRSize(zoneConfig common.ZoneConfig, csrPEM []byte) error {
+	if zoneConfig.MaxCSRSize == 0 && zoneConfig.MaxExtensionsSize == 0 {
+		return nil
+	}
+	pemBlock, _ := pem.Decode(csrPEM)
+	if pemBlock == nil {
+		return fmt.Errorf("CSR is not PEM encoded")
+	}
+	if zoneConfig.MaxCSRSize > 0 && len(pemBlock.Bytes) > zoneConfig.MaxCSRSize {
+		return fmt.Errorf("CSR size %d bytes exceeds maximum of %d bytes in this zone", len(pemBlock.Bytes), zoneConfig.MaxCSRSize)
+	}
+	if zoneConfig.MaxExtensionsSize > 0 {
+		csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+		if err != nil {
+			return err
+		}
+		size := 0
+		for _, ext := range csr.Extensions {
+			size += len(ext.Value)
+		}
+		if size > zoneConfig.MaxExtensionsSize {
+			return fmt.Errorf("CSR extensions size %d bytes exceeds maximum of %d bytes in this zone", size, zoneConfig.MaxExtensionsSize)
+		}
+	}
+	return nil
+}
+
+// curveAllowed reports whether the curve is one of the allowed curve names. An unknown name is an error rather than
+// vcert's default curve, so a misspelled zone config doesn't allow P256.
+func curveAllowed(curve certificate.EllipticCurve, allowed []string) (bool, error) {
+	for _, name := range allowed {
+		var c certificate.EllipticCurve
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "p256", "p-256":
+			c = certificate.EllipticCurveP256
+		case "p384", "p-384":
+			c = certificate.EllipticCurveP384
+		case "p521", "p-521":
+			c = certificate.EllipticCurveP521
+		default:
+			return false, fmt.Errorf("unknown elliptic curve %q in AllowedKeyCurves of this zone", name)
+		}
+		if c == curve {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// commonNameInSANs reports whether the common name is one of the DNS name, IP address or email SANs of the request.
+func commonNameInSANs(req *certificate.Request) bool {
+	cn := req.Subject.CommonName
+	if stringInSlice(canonicalDNSName(cn), req.DNSNames) || stringInSlice(cn, req.EmailAddresses) {
+		return true
+	}
+	if ip := net.ParseIP(cn); ip != nil {
+		for _, san := range req.IPAddresses {
+			if san.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acmZoneRulesRequest adapts an ACM RequestCertificate request for zone rules. ACM always puts the domain name
+// into the SANs and derives the common name from it, so the certificate doesn't rely on the common name.
+func acmZoneRulesRequest(req certificate.Request, domainName string) *certificate.Request {
+	req.Subject.CommonName = ""
+	req.DNSNames = append([]string{domainName}, req.DNSNames...)
+	return &req
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"net"
+	"testing"
+)
+
+func TestRequireCommonNameInSANs(t *testing.T) {
+	zoneConfig := common.ZoneConfig{RequireCommonNameInSANs: true}
+	req := certificate.Request{
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		DNSNames: []string{"api.example.com"},
+	}
+	if validateZoneRules(zoneConfig, &req) == nil {
+		t.Error("common name missing in SANs should be rejected")
+	}
+	req.DNSNames = append(req.DNSNames, "www.example.com")
+	if err := validateZoneRules(zoneConfig, &req); err != nil {
+		t.Errorf("common name in DNS name SANs should pass: %s", err)
+	}
+	req = certificate.Request{Subject: pkix.Name{CommonName: "10.0.0.1"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}
+	if err := validateZoneRules(zoneConfig, &req); err != nil {
+		t.Errorf("common name in IP address SANs should pass: %s", err)
+	}
+	req = certificate.Request{DNSNames: []string{"www.example.com"}}
+	if err := validateZoneRules(zoneConfig, &req); err != nil {
+		t.Errorf("request without common name should pass: %s", err)
+	}
+}
+
+func TestCheckDeniedDomains(t *testing.T) {
+	zoneConfig := common.ZoneConfig{DeniedDomains: []string{"Example.com", "partner.org"}}
+	var req certificate.Request
+	req.Subject.CommonName = "app.example.net"
+	req.DNSNames = []string{"api.example.net", "shop.partner.org"}
+	if err := checkDeniedDomains(zoneConfig, &req); err == nil || err.Error() != "name shop.partner.org is in a domain denied in this zone" {
+		t.Errorf("name in partner domain should be denied, got %v", err)
+	}
+	req.DNSNames = []string{"*.example.com"}
+	if checkDeniedDomains(zoneConfig, &req) == nil {
+		t.Error("wildcard in denied domain should be denied")
+	}
+	req.DNSNames = []string{"api.example.net", "notexample.com"}
+	if err := checkDeniedDomains(zoneConfig, &req); err != nil {
+		t.Errorf("names outside denied domains should be allowed: %s", err)
+	}
+}
+
+func TestCheckNameLimits(t *testing.T) {
+	zoneConfig := common.ZoneConfig{MaxSANs: 3, MaxNameLength: 20}
+	var req certificate.Request
+	req.Subject.CommonName = "www.example.com"
+	req.DNSNames = []string{"www.example.com", "api.example.com"}
+	req.IPAddresses = []net.IP{net.ParseIP("10.0.0.1")}
+	if err := validateZoneRules(zoneConfig, &req); err != nil {
+		t.Errorf("request within limits should be allowed: %s", err)
+	}
+	req.EmailAddresses = []string{"admin@example.com"}
+	if err := validateZoneRules(zoneConfig, &req); err == nil || err.Error() != "request has 4 SANs, the maximum in this zone is 3" {
+		t.Errorf("request with too many SANs should be rejected, got %v", err)
+	}
+	req.EmailAddresses = nil
+	req.DNSNames[1] = "a-very-long-name.example.com"
+	if err := validateZoneRules(zoneConfig, &req); err == nil {
+		t.Error("request with too long name should be rejected")
+	}
+}
+
+func TestAllowedKeyCurves(t *testing.T) {
+	zoneConfig := common.ZoneConfig{AllowedKeyCurves: []string{"P-384"}}
+	req := certificate.Request{KeyType: certificate.KeyTypeECDSA, KeyCurve: certificate.EllipticCurveP384}
+	if err := validateZoneRules(zoneConfig, &req); err != nil {
+		t.Errorf("allowed curve should pass: %s", err)
+	}
+	req.KeyCurve = certificate.EllipticCurveP256
+	if validateZoneRules(zoneConfig, &req) == nil {
+		t.Error("curve which is not allowed should be rejected")
+	}
+	zoneConfig.AllowedKeyCurves = []string{"P265"}
+	if err := validateZoneRules(zoneConfig, &req); err == nil || err.Error() != `unknown elliptic curve "P265" in AllowedKeyCurves of this zone` {
+		t.Errorf("unknown curve name should not allow P256, got %v", err)
+	}
+}
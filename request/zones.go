@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const venafiListZones = "Venafi.ListZones"
+
+// Enforcement modes of a zone
+const (
+	enforcementEnforce = "enforce"
+	// enforcementAudit logs rule violations without rejecting requests, e.g. while rolling out a new policy.
+	enforcementAudit = "audit"
+)
+
+type ZoneSummary struct {
+	Name            string
+	PolicyVersion   string
+	PolicyRevision  int64
+	LastSync        *time.Time `json:",omitempty"`
+	Synced          bool
+	Pinned          bool
+	EnforcementMode string
+}
+
+type ListZonesOutput struct {
+	Zones []ZoneSummary
+}
+
+// enforcementMode returns the enforcement mode of a zone, zones are enforced by default.
+func enforcementMode(zoneConfig common.ZoneConfig) string {
+	if zoneConfig.EnforcementMode == enforcementAudit {
+		return enforcementAudit
+	}
+	return enforcementEnforce
+}
+
+// auditModeBypass lets requests in a zone in audit mode bypass the zone rules, so their violations are only logged. The
+// Venafi policy and the other checks, e.g. domain ownership, are enforced in every mode.
+func auditModeBypass(zone string, zoneConfig common.ZoneConfig, b bypassRules) bypassRules {
+	if enforcementMode(zoneConfig) != enforcementAudit || b.rules[ruleZoneRules] {
+		return b
+	}
+	rules := make(map[string]bool, len(b.rules)+1)
+	for rule := range b.rules {
+		rules[rule] = true
+	}
+	rules[ruleZoneRules] = true
+	b.rules = rules
+	if b.by == "" {
+		b.by = fmt.Sprintf("audit mode of zone %s", zone)
+	} else {
+		b.by += fmt.Sprintf(" or audit mode of zone %s", zone)
+	}
+	return b
+}
+
+func listZones(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	zones, err := common.ListZones()
+	if err != nil {
+		return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zones from database: %s", err))
+	}
+	output := ListZonesOutput{Zones: make([]ZoneSummary, 0, len(zones))}
+	for _, z := range zones {
+		zoneConfig, err := common.GetZoneConfig(z.Name)
+		if err != nil {
+			return clientError(http.StatusFailedDependency, fmt.Sprintf("Failed to get zone configuration from database: %s", err))
+		}
+		summary := ZoneSummary{
+			Name:            z.Name,
+			PolicyVersion:   z.PolicyVersion,
+			PolicyRevision:  z.PolicyRevision,
+			Synced:          z.Synced,
+			Pinned:          z.Pinned,
+			EnforcementMode: enforcementMode(zoneConfig),
+		}
+		if !z.LastSync.IsZero() {
+			lastSync := z.LastSync
+			summary.LastSync = &lastSync
+		}
+		output.Zones = append(output.Zones, summary)
+	}
+	sort.Slice(output.Zones, func(i, j int) bool {
+		return output.Zones[i].Name < output.Zones[j].Name
+	})
+
+	body, err := json.Marshal(output)
+	if err != nil {
+		return clientError(http.StatusInternalServerError, fmt.Sprintf("Error marshaling response JSON: %s", err))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+	}, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Venafi/aws-private-ca-policy-venafi/common"
+	"testing"
+)
+
+func TestAuditModeBypass(t *testing.T) {
+	violation := fmt.Errorf("violation")
+	b := auditModeBypass("Default", common.ZoneConfig{}, bypassRules{})
+	if b.apply(rulePolicy, violation) == nil {
+		t.Error("enforced zone must not bypass rules")
+	}
+	b = auditModeBypass("Default", common.ZoneConfig{EnforcementMode: enforcementAudit}, bypassRules{})
+	if b.apply(ruleZoneRules, violation) != nil {
+		t.Error("zone rules should only be logged in audit mode")
+	}
+	for _, rule := range []string{rulePolicy, ruleFreeze, ruleOrigin, ruleDNSCheck, ruleOwnership} {
+		if b.apply(rule, violation) == nil {
+			t.Errorf("rule %s must be enforced in audit mode", rule)
+		}
+	}
+	token := bypassRules{by: "break-glass token abcd", rules: map[string]bool{rulePolicy: true}}
+	b = auditModeBypass("Default", common.ZoneConfig{EnforcementMode: enforcementAudit}, token)
+	if b.apply(rulePolicy, violation) != nil || b.apply(ruleZoneRules, violation) != nil {
+		t.Error("audit mode should add to the rules bypassed by a break-glass token")
+	}
+	if len(token.rules) != 1 {
+		t.Error("audit mode must not change the rules of the token")
+	}
+	if enforcementMode(common.ZoneConfig{EnforcementMode: "bogus"}) != enforcementEnforce {
+		t.Error("unknown modes should be enforced")
+	}
+}